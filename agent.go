@@ -31,6 +31,35 @@ type StreamPrinter interface {
 	// ProcessLine parses a single JSON line and prints formatted output.
 	// Returns true if the line was handled, false if skipped/unknown.
 	ProcessLine(line string) bool
+
+	// Usage returns the cost in USD, the tokens and the turns (or steps)
+	// reported by the lines processed so far.
+	Usage() (costUSD float64, tokens int, turns int)
+
+	// Activity returns the number of calls of each tool and the files
+	// edited, in order, reported by the lines processed so far.
+	Activity() (tools map[string]int, filesEdited []string)
+}
+
+// AgentUsage is the usage of agent runs, as reported by their stream
+type AgentUsage struct {
+	CostUSD float64 `yaml:"cost_usd"`
+	Tokens  int     `yaml:"tokens"`
+	Turns   int     `yaml:"turns"`
+}
+
+// AgentActivity is what an agent run did, as reported by its stream: the
+// number of calls of each tool and the files edited
+type AgentActivity struct {
+	Tools       map[string]int
+	FilesEdited []string
+}
+
+// Add accumulates the usage of another agent run
+func (u *AgentUsage) Add(other AgentUsage) {
+	u.CostUSD += other.CostUSD
+	u.Tokens += other.Tokens
+	u.Turns += other.Turns
 }
 
 // ValidAgentTypes contains all valid agent type values
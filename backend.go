@@ -2,9 +2,15 @@ package sbox
 
 import (
 	"fmt"
+	"os/exec"
 	"time"
 )
 
+// execCommand creates the external commands run by the backends and the
+// template builder (docker sandbox, docker/podman build, docker buildx).
+// Tests replace it to run a fake docker executable.
+var execCommand = exec.Command
+
 // BackendType represents the container backend type
 type BackendType string
 
@@ -13,13 +19,15 @@ const (
 	BackendSandbox BackendType = "sandbox"
 	// BackendContainer uses standard Docker containers for execution
 	BackendContainer BackendType = "container"
+	// BackendPodman uses rootless Podman containers for execution
+	BackendPodman BackendType = "podman"
 )
 
 // DefaultBackend is the default backend type when not specified
 const DefaultBackend = BackendSandbox
 
 // ValidBackendTypes contains all valid backend type values
-var ValidBackendTypes = []BackendType{BackendSandbox, BackendContainer}
+var ValidBackendTypes = []BackendType{BackendSandbox, BackendContainer, BackendPodman}
 
 // Capitalize returns a capitalized display name for the backend type.
 func (bt BackendType) Capitalize() string {
@@ -28,6 +36,8 @@ func (bt BackendType) Capitalize() string {
 		return "Sandbox"
 	case BackendContainer:
 		return "Container"
+	case BackendPodman:
+		return "Podman"
 	default:
 		return string(bt)
 	}
@@ -36,7 +46,7 @@ func (bt BackendType) Capitalize() string {
 // ValidateBackend checks if a backend name is valid
 func ValidateBackend(name string) error {
 	switch BackendType(name) {
-	case BackendSandbox, BackendContainer:
+	case BackendSandbox, BackendContainer, BackendPodman:
 		return nil
 	case "":
 		return nil // Empty means use default
@@ -98,6 +108,24 @@ type BackendOptions struct {
 	MaxIterations     int
 	LoopConfirmations int
 
+	// LoopVerify are shell commands run in the sandbox after each loop
+	// iteration, the goal only counts as complete when they all exit 0
+	LoopVerify []string
+
+	// LoopBudget stops the loop once its cost, tokens or duration is reached
+	LoopBudget LoopBudget
+
+	// LoopRunID identifies the loop run, see LoopRun
+	LoopRunID string
+
+	// LoopCheckpoint records the workspace as a git commit after each loop
+	// iteration, see RollbackLoop
+	LoopCheckpoint bool
+
+	// Ports are additional port mappings from the command line (`sbox run -p`),
+	// published along with the project ones
+	Ports []string
+
 	// StartupDelay delays agent startup inside the sandbox.
 	// nil means no delay, 0 means infinite delay, otherwise waits for the duration.
 	StartupDelay *time.Duration
@@ -126,6 +154,10 @@ type Backend interface {
 	// List returns all containers managed by this backend
 	List() ([]ContainerInfo, error)
 
+	// ForwardPorts forwards host ports to ports of the running container by
+	// relaying each connection through an exec, until a listener fails
+	ForwardPorts(workspaceDir string, ports []PortMapping) error
+
 	// Remove removes a container by ID
 	Remove(containerID string) error
 
@@ -135,7 +167,7 @@ type Backend interface {
 
 	// SaveCache saves the agent's state for persistence across recreations.
 	// For sandbox backend, copies to .sbox/<agent>-cache/.
-	// For container backend, this is a no-op (uses named volumes).
+	// For container and podman backends, this is a no-op (uses named volumes).
 	SaveCache(workspaceDir string, agentType AgentType) error
 }
 
@@ -155,6 +187,8 @@ func GetBackend(backendType string, config *Config) (Backend, error) {
 		return NewSandboxBackend(config), nil
 	case BackendContainer:
 		return NewContainerBackend(config), nil
+	case BackendPodman:
+		return NewPodmanBackend(config), nil
 	default:
 		return nil, fmt.Errorf("unknown backend type: %s", backendType)
 	}
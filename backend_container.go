@@ -2,46 +2,74 @@ package sbox
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
+	"io"
+	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 
 	"go.uber.org/zap"
+	"golang.org/x/term"
 )
 
 // DockerSocketEnvVar is the environment variable to override the Docker socket path
 const DockerSocketEnvVar = "SBOX_DOCKER_SOCKET"
 
-// ContainerBackend implements the Backend interface using standard Docker containers
+// ContainerBackend implements the Backend interface using standard Docker containers.
+// It talks to the Docker Engine API directly (see DockerClient).
 type ContainerBackend struct {
 	config *Config
+	client *DockerClient
+
+	// backend is BackendContainer, or BackendPodman when used by PodmanBackend
+	backend BackendType
+
+	// stdin, stdout and stderr are the local streams attached to the container
+	// (os.Stdin, os.Stdout and os.Stderr when nil)
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
 }
 
 // NewContainerBackend creates a new container backend instance
 func NewContainerBackend(config *Config) *ContainerBackend {
-	return &ContainerBackend{config: config}
+	return &ContainerBackend{config: config, backend: BackendContainer}
 }
 
 // Name returns the backend type name
 func (b *ContainerBackend) Name() BackendType {
-	return BackendContainer
+	return b.backend
 }
 
 // volumeName generates a unique volume name for persisting agent config folder
 func (b *ContainerBackend) volumeName(workspaceDir string, agent AgentType) string {
-	absPath, _ := filepath.Abs(workspaceDir)
-	hash := sha256.Sum256([]byte(absPath))
+	// Keyed by project ID, so the volume follows moved workspaces
+	_, id, _ := projectIdentity(b.config.SboxDataDir, workspaceDir)
 	agentName := string(agent)
 	if agentName == "" {
 		agentName = string(DefaultAgent)
 	}
-	return "sbox-" + agentName + "-" + hex.EncodeToString(hash[:])[:12]
+	return "sbox-" + agentName + "-" + id
+}
+
+// docker returns the Engine API client (Docker or Podman), creating it on first use
+func (b *ContainerBackend) docker() (*DockerClient, error) {
+	if b.client == nil {
+		newClient := NewDockerClient
+		if b.backend == BackendPodman {
+			newClient = NewPodmanClient
+		}
+
+		client, err := newClient()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s client: %w", b.backend, err)
+		}
+		b.client = client
+	}
+	return b.client, nil
 }
 
 // containerName generates the container name for a workspace
@@ -70,7 +98,8 @@ func (b *ContainerBackend) Run(opts BackendOptions) error {
 	// Merge project profiles with command-line profiles
 	allProfiles := mergeProfiles(opts.ProjectConfig.Profiles, opts.Profiles)
 
-	zlog.Info("preparing to run Docker container",
+	zlog.Info("preparing to run container",
+		zap.String("backend", string(b.backend)),
 		zap.String("name", containerName),
 		zap.String("workspace", absPath),
 		zap.Strings("profiles", allProfiles))
@@ -80,9 +109,45 @@ func (b *ContainerBackend) Run(opts BackendOptions) error {
 	if opts.SboxFile != nil && opts.SboxFile.Config != nil {
 		sboxFileEnvs = opts.SboxFile.Config.Envs
 	}
-	if err := PrepareSboxDirectory(absPath, opts.Config, opts.Config.Envs, opts.ProjectConfig.Envs, sboxFileEnvs, BackendContainer, agentType, opts); err != nil {
+	secrets, err := PrepareSboxDirectory(absPath, opts.Config, opts.Config.Envs, opts.ProjectConfig.Envs, sboxFileEnvs, b.backend, agentType, opts)
+	if err != nil {
 		return fmt.Errorf("failed to prepare .sbox directory: %w", err)
 	}
+	session := &containerSession{Secrets: secrets}
+
+	// Check resource limits before building anything
+	resources := ResolveResourceLimits(opts.Config, opts.ProjectConfig)
+	if err := resources.Validate(); err != nil {
+		return fmt.Errorf("invalid resources: %w", err)
+	}
+	policy := opts.ProjectConfig.Network
+	if err := policy.Validate(); err != nil {
+		return fmt.Errorf("invalid network policy: %w", err)
+	}
+	if opts.MountDockerSocket && policy.EffectiveMode() != NetworkModeOpen {
+		DefaultUI.Warn("The Docker socket is mounted, the agent can use it to get around the network policy")
+	}
+	ports, err := ResolvePorts(opts.ProjectConfig, opts.Ports)
+	if err != nil {
+		return fmt.Errorf("invalid ports: %w", err)
+	}
+	if len(ports) > 0 && policy.EffectiveMode() != NetworkModeOpen {
+		// Ports can't be published on the none network nor on internal ones
+		DefaultUI.Warn("Ports are not published with the %s network policy, use 'sbox port-forward' to reach them", policy.EffectiveMode())
+		ports = nil
+	}
+	sshSettings := ResolveSSHSettings(opts.Config, opts.ProjectConfig)
+	if err := ValidateSSHMode(sshSettings.Mode); err != nil {
+		return fmt.Errorf("invalid ssh setting: %w", err)
+	}
+	if err := ValidateSSHAllowedKeys(sshSettings.AllowedKeys); err != nil {
+		return fmt.Errorf("invalid ssh_allowed_keys: %w", err)
+	}
+
+	client, err := b.docker()
+	if err != nil {
+		return err
+	}
 
 	// Check if container exists
 	existing, err := b.Find(absPath)
@@ -97,35 +162,65 @@ func (b *ContainerBackend) Run(opts BackendOptions) error {
 		// mismatch, remove the old container and create a new one.
 		needsTTY := opts.Prompt == ""
 		hasTTY := b.containerHasTTY(existing.ID)
-		if needsTTY != hasTTY {
-			zlog.Info("container TTY mode mismatch, recreating",
+
+		// Likewise, the network a container is attached to is fixed at creation
+		network, err := b.prepareNetwork(client, containerName, absPath, existing.Image, policy)
+		if err != nil {
+			return err
+		}
+		networkMatches := b.containerNetworkMatches(existing.ID, network)
+
+		// And so are the published ports, the SSH mounts and the resource limits
+		portsMatch := b.containerPortsMatch(existing.ID, ports)
+		session.SSH = b.prepareSSH(client, sshSettings)
+		sshMatches := b.containerSSHMatches(existing.ID, session.SSH)
+		resourcesMatch := b.containerResourcesMatch(existing.ID, resources)
+
+		if needsTTY != hasTTY || !networkMatches || !portsMatch || !sshMatches || !resourcesMatch {
+			zlog.Info("container TTY mode, network, ports, SSH or resources mismatch, recreating",
 				zap.Bool("needs_tty", needsTTY),
-				zap.Bool("has_tty", hasTTY))
-			DefaultUI.Status("Recreating container '%s' (TTY mode changed)", containerName)
+				zap.Bool("has_tty", hasTTY),
+				zap.Bool("network_matches", networkMatches),
+				zap.Bool("ports_match", portsMatch),
+				zap.Bool("ssh_matches", sshMatches),
+				zap.Bool("resources_match", resourcesMatch))
+			reason := "TTY mode changed"
+			if !networkMatches {
+				reason = "network policy changed"
+			} else if !portsMatch {
+				reason = "published ports changed"
+			} else if !sshMatches {
+				reason = "SSH setting changed"
+			} else if !resourcesMatch {
+				reason = "resource limits changed"
+			}
+			DefaultUI.Status("Recreating container '%s' (%s)", containerName, reason)
 
 			// Stop if running, then remove
 			if existing.Status == "running" {
-				stopCmd := exec.Command("docker", "stop", existing.ID)
-				_ = stopCmd.Run()
+				_ = client.StopContainer(existing.ID)
 			}
-			rmCmd := exec.Command("docker", "rm", existing.ID)
-			_ = rmCmd.Run()
+			_ = client.RemoveContainer(existing.ID, false)
 			// Fall through to create a new container below
 		} else {
 			// Container exists with matching TTY mode - reuse it
 			if existing.Status == "running" {
 				DefaultUI.Status("Attaching to running container '%s'", containerName)
-				return b.attachContainer(containerName, opts)
+				return b.runSession(client, existing.ID, hasTTY, session, opts, false)
 			}
 			// Container exists but not running - start it
 			DefaultUI.Status("Starting existing container '%s'", containerName)
-			return b.startContainer(containerName, opts)
+			return b.runSession(client, existing.ID, hasTTY, session, opts, true)
 		}
 	}
 
 	// Container doesn't exist - create and run it
 	// Build custom template
-	builder := NewTemplateBuilder(opts.Config, allProfiles, agentType)
+	builder, err := NewWorkspaceTemplateBuilder(opts.Config, opts.WorkspaceDir, opts.SboxFile, allProfiles, agentType)
+	if err != nil {
+		return err
+	}
+	builder.Backend = b.backend
 	templateImage, err := builder.Build(opts.ForceRebuild)
 	if err != nil {
 		return fmt.Errorf("failed to build custom template: %w", err)
@@ -139,79 +234,93 @@ func (b *ContainerBackend) Run(opts BackendOptions) error {
 
 	// Ensure volume exists for agent config persistence
 	volumeName := b.volumeName(absPath, agentType)
-	if err := b.ensureVolume(volumeName); err != nil {
+	if err := b.ensureVolume(client, volumeName); err != nil {
 		return fmt.Errorf("failed to create persistence volume: %w", err)
 	}
 
-	// Build docker run command
-	args := b.buildRunArgs(containerName, absPath, templateImage, volumeName, agentType, opts)
-
-	zlog.Debug("executing docker command",
-		zap.String("cmd", "docker"),
-		zap.Strings("args", args))
+	network, err := b.prepareNetwork(client, containerName, absPath, templateImage, policy)
+	if err != nil {
+		return err
+	}
 
-	DefaultUI.Status("Starting container '%s'", containerName)
+	// Build container configuration
+	config := b.buildContainerConfig(absPath, templateImage, volumeName, agentType, opts)
+	if err := resources.ApplyToHostConfig(&config.HostConfig); err != nil {
+		return fmt.Errorf("invalid resources: %w", err)
+	}
+	network.apply(config)
+	session.SSH = b.prepareSSH(client, sshSettings)
+	session.SSH.apply(config)
+	config.ExposedPorts, config.HostConfig.PortBindings = dockerPortBindings(ports)
 
-	cmd := exec.Command("docker", args...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	zlog.Debug("creating container",
+		zap.String("name", containerName),
+		zap.String("image", config.Image),
+		zap.Strings("binds", config.HostConfig.Binds),
+		zap.Strings("resources", resources.Describe()),
+		zap.String("network", config.HostConfig.NetworkMode),
+		zap.Any("ports", config.HostConfig.PortBindings),
+		zap.Bool("tty", config.Tty))
+
+	containerID, err := client.CreateContainer(containerName, config)
+	if err != nil {
+		return fmt.Errorf("docker create failed: %w", err)
+	}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("docker run failed: %w", err)
+	DefaultUI.Status("Starting container '%s'", containerName)
+	if err := b.runSession(client, containerID, config.Tty, session, opts, true); err != nil {
+		return err
 	}
 
-	zlog.Info("Docker container exited successfully")
+	zlog.Info("container exited successfully")
 	return nil
 }
 
-// buildRunArgs constructs the docker run command arguments
-func (b *ContainerBackend) buildRunArgs(containerName, workspaceDir, image, volumeName string, agentType AgentType, opts BackendOptions) []string {
-	var args []string
-	if opts.Prompt != "" {
-		// Non-interactive mode (prompt/loop): no TTY allocation.
-		// A TTY would mangle the stream-json output that the entrypoint parses.
-		args = []string{"run", "--name", containerName}
-	} else {
-		args = []string{"run", "-it", "--name", containerName}
+// buildContainerConfig constructs the container configuration (the equivalent
+// of `docker run [-it] -v ... -w ... -e ... <image>`)
+func (b *ContainerBackend) buildContainerConfig(workspaceDir, image, volumeName string, agentType AgentType, opts BackendOptions) *DockerContainerConfig {
+	// Non-interactive mode (prompt/loop): no TTY allocation and no stdin.
+	// A TTY would mangle the stream-json output that the entrypoint parses.
+	interactive := opts.Prompt == ""
+	config := &DockerContainerConfig{
+		Image:        image,
+		WorkingDir:   workspaceDir,
+		Tty:          interactive,
+		OpenStdin:    interactive,
+		StdinOnce:    interactive,
+		AttachStdin:  interactive,
+		AttachStdout: true,
+		AttachStderr: true,
 	}
 
 	// Mount workspace directory
-	args = append(args, "-v", fmt.Sprintf("%s:%s", workspaceDir, workspaceDir))
+	binds := []string{fmt.Sprintf("%s:%s", workspaceDir, workspaceDir)}
 
 	// Mount persistence volume for agent config folder
 	spec := GetAgentSpec(agentType)
 	configDir := spec.ConfigDirName()
-	args = append(args, "-v", fmt.Sprintf("%s:/home/agent/%s", volumeName, configDir))
-
-	// Set working directory
-	args = append(args, "-w", workspaceDir)
+	binds = append(binds, fmt.Sprintf("%s:/home/agent/%s", volumeName, configDir))
 
 	// Set workspace env var (used by entrypoint)
-	args = append(args, "-e", fmt.Sprintf("WORKSPACE_DIR=%s", workspaceDir))
+	config.Env = append(config.Env, fmt.Sprintf("WORKSPACE_DIR=%s", workspaceDir))
 
 	// Forward terminal capabilities from host to preserve colors and ANSI links
 	if term := os.Getenv("TERM"); term != "" {
-		args = append(args, "-e", "TERM="+term)
+		config.Env = append(config.Env, "TERM="+term)
 	}
 	if colorterm := os.Getenv("COLORTERM"); colorterm != "" {
-		args = append(args, "-e", "COLORTERM="+colorterm)
-	}
-
-	// Mount SSH directory if it exists (read-only)
-	homeDir, err := os.UserHomeDir()
-	if err == nil {
-		sshPath := filepath.Join(homeDir, ".ssh")
-		if _, err := os.Stat(sshPath); err == nil {
-			args = append(args, "-v", fmt.Sprintf("%s:/home/agent/.ssh:ro", sshPath))
-		}
+		config.Env = append(config.Env, "COLORTERM="+colorterm)
 	}
 
-	// Mount Docker socket if requested
+	// Mount Docker socket if requested. With Podman, its Docker-compatible
+	// socket is mounted at the same location.
 	if opts.MountDockerSocket {
 		socketPath := getDockerSocketPath()
+		if b.backend == BackendPodman {
+			socketPath = getPodmanSocketPath()
+		}
 		if socketPath != "" {
-			args = append(args, "-v", fmt.Sprintf("%s:/var/run/docker.sock", socketPath))
+			binds = append(binds, fmt.Sprintf("%s:/var/run/docker.sock", socketPath))
 			zlog.Debug("mounting docker socket", zap.String("host_path", socketPath))
 		} else {
 			zlog.Warn("docker socket requested but no socket found")
@@ -239,78 +348,144 @@ func (b *ContainerBackend) buildRunArgs(containerName, workspaceDir, image, volu
 		if readOnly {
 			mountSpec += ":ro"
 		}
-		args = append(args, "-v", mountSpec)
+		binds = append(binds, mountSpec)
 	}
 
-	// Add the image
-	args = append(args, image)
+	config.HostConfig.Binds = binds
+
+	if b.backend == BackendPodman {
+		config.HostConfig.UsernsMode = podmanUsernsMode()
+		config.HostConfig.SecurityOpt = []string{"label=disable"}
+	}
 
-	return args
+	return config
+}
+
+// containerSession is what a session needs besides the container itself
+type containerSession struct {
+	// Secrets are the secret env vars (KEY=value) delivered to the entrypoint
+	// when the container starts
+	Secrets []string
+
+	// SSH is the SSH access of the container, nil when unknown
+	SSH *containerSSH
+}
+
+// deliverSecrets writes the secret env vars in the tmpfs file the entrypoint
+// waits for (the equivalent of `docker exec -i <container> sbox receive-secrets`)
+func (b *ContainerBackend) deliverSecrets(client *DockerClient, containerID string, secrets []string) error {
+	execID, err := client.CreateExec(containerID, receiveSecretEnvsCommand(), false, true)
+	if err != nil {
+		return fmt.Errorf("docker exec failed: %w", err)
+	}
+
+	hijacked, err := client.StartExec(execID, false)
+	if err != nil {
+		return fmt.Errorf("docker exec failed: %w", err)
+	}
+
+	var output bytes.Buffer
+	err = runDockerSession(hijacked, dockerSessionOptions{
+		Stdin:  true,
+		In:     strings.NewReader(secretEnvsPayload(secrets)),
+		Stdout: &output,
+		Stderr: &output,
+	})
+	if err != nil {
+		return fmt.Errorf("docker exec failed: %w", err)
+	}
+
+	if exitCode, err := client.InspectExec(execID); err == nil && exitCode > 0 {
+		return fmt.Errorf("receive-secrets exited with code %d: %s", exitCode, strings.TrimSpace(output.String()))
+	}
+	return nil
 }
 
 // ensureVolume creates a Docker volume if it doesn't exist
-func (b *ContainerBackend) ensureVolume(volumeName string) error {
+func (b *ContainerBackend) ensureVolume(client *DockerClient, volumeName string) error {
 	// Check if volume exists
-	cmd := exec.Command("docker", "volume", "inspect", volumeName)
-	if err := cmd.Run(); err == nil {
-		// Volume already exists
+	exists, err := client.VolumeExists(volumeName)
+	if err != nil {
+		return fmt.Errorf("docker volume inspect failed: %w", err)
+	}
+	if exists {
 		zlog.Debug("volume already exists", zap.String("volume", volumeName))
 		return nil
 	}
 
 	// Create volume
 	zlog.Info("creating persistence volume", zap.String("volume", volumeName))
-	cmd = exec.Command("docker", "volume", "create", volumeName)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("docker volume create failed: %w (stderr: %s)", err, stderr.String())
+	if err := client.CreateVolume(volumeName); err != nil {
+		return fmt.Errorf("docker volume create failed: %w", err)
 	}
 
 	return nil
 }
 
-// attachContainer attaches to a running container
-func (b *ContainerBackend) attachContainer(containerName string, opts BackendOptions) error {
-	args := []string{"attach"}
-	if opts.Prompt != "" {
-		// Non-interactive mode: don't attach stdin (no --sig-proxy=false needed,
-		// we still want signals forwarded)
-		args = append(args, "--no-stdin")
+// runSession attaches the terminal to a container, optionally starting it
+// first, and returns once the container output ends (the equivalent of
+// `docker attach` / `docker start -a[i]`). In prompt/loop mode stdin is not
+// attached and Ctrl+C is forwarded to the container as a signal. When starting
+// the container, the session secrets are delivered to its entrypoint, and the
+// host SSH agent is relayed for the duration of the session when needed.
+func (b *ContainerBackend) runSession(client *DockerClient, containerID string, tty bool, session *containerSession, opts BackendOptions, start bool) error {
+	interactive := opts.Prompt == ""
+
+	// Attach before starting so no output is lost
+	conn, err := client.AttachContainer(containerID, interactive)
+	if err != nil {
+		return fmt.Errorf("docker attach failed: %w", err)
 	}
-	args = append(args, containerName)
 
-	cmd := exec.Command("docker", args...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	if start {
+		if err := client.StartContainer(containerID); err != nil {
+			conn.Close()
+			return fmt.Errorf("docker start failed: %w", err)
+		}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("docker attach failed: %w", err)
+		if len(session.Secrets) > 0 {
+			if err := b.deliverSecrets(client, containerID, session.Secrets); err != nil {
+				conn.Close()
+				_ = client.StopContainer(containerID)
+				return fmt.Errorf("failed to deliver secret environment variables: %w", err)
+			}
+		}
 	}
-	return nil
-}
 
-// startContainer starts a stopped container
-func (b *ContainerBackend) startContainer(containerName string, opts BackendOptions) error {
-	args := []string{"start"}
-	if opts.Prompt != "" {
-		// Non-interactive mode: attach stdout/stderr but no interactive TTY
-		args = append(args, "-a")
-	} else {
-		// Interactive mode: attach + interactive
-		args = append(args, "-ai")
+	if session.SSH != nil && session.SSH.Relay {
+		stop, err := b.startSSHAgentRelay(client, containerID, session.SSH.Settings.AllowedKeys)
+		if err != nil {
+			DefaultUI.Warn("Failed to forward the SSH agent: %v", err)
+		} else {
+			defer stop()
+		}
 	}
-	args = append(args, containerName)
 
-	cmd := exec.Command("docker", args...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	err = runDockerSession(conn, dockerSessionOptions{
+		Tty:   tty,
+		Stdin: interactive,
+		Resize: func(height, width uint16) error {
+			return client.ResizeContainer(containerID, height, width)
+		},
+		Signal: func(signal string) error {
+			return client.KillContainer(containerID, signal)
+		},
+		In:     b.stdin,
+		Stdout: b.stdout,
+		Stderr: b.stderr,
+	})
+	if err != nil {
+		return err
+	}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("docker start failed: %w", err)
+	// Report a failing exit like the docker CLI does. A container still
+	// running here means the user detached from it.
+	info, err := client.InspectContainer(containerID)
+	if err != nil || info == nil || info.State.Running {
+		return nil
+	}
+	if info.State.ExitCode != 0 {
+		return fmt.Errorf("container exited with code %d", info.State.ExitCode)
 	}
 	return nil
 }
@@ -341,15 +516,40 @@ func (b *ContainerBackend) Shell(workspaceDir string) error {
 		zap.String("container_name", info.Name),
 		zap.String("workspace", absPath))
 
-	cmd := exec.Command("docker", "exec", "-it", info.ID, "bash")
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	client, err := b.docker()
+	if err != nil {
+		return err
+	}
+
+	tty := term.IsTerminal(int(os.Stdin.Fd()))
+	execID, err := client.CreateExec(info.ID, []string{"bash"}, tty, true)
+	if err != nil {
+		return fmt.Errorf("docker exec failed: %w", err)
+	}
+
+	conn, err := client.StartExec(execID, tty)
+	if err != nil {
+		return fmt.Errorf("docker exec failed: %w", err)
+	}
 
-	if err := cmd.Run(); err != nil {
+	err = runDockerSession(conn, dockerSessionOptions{
+		Tty:   tty,
+		Stdin: true,
+		Resize: func(height, width uint16) error {
+			return client.ResizeExec(execID, height, width)
+		},
+		In:     b.stdin,
+		Stdout: b.stdout,
+		Stderr: b.stderr,
+	})
+	if err != nil {
 		return fmt.Errorf("docker exec failed: %w", err)
 	}
 
+	if exitCode, err := client.InspectExec(execID); err == nil && exitCode > 0 {
+		return fmt.Errorf("shell exited with code %d", exitCode)
+	}
+
 	return nil
 }
 
@@ -383,7 +583,10 @@ func (b *ContainerBackend) Stop(workspaceDir string, remove bool) (*ContainerInf
 		return nil, nil
 	}
 
-	var stderr bytes.Buffer
+	client, err := b.docker()
+	if err != nil {
+		return nil, err
+	}
 
 	// Only stop if the container is running
 	if info.Status == "running" {
@@ -393,11 +596,8 @@ func (b *ContainerBackend) Stop(workspaceDir string, remove bool) (*ContainerInf
 			zap.String("workspace", absPath))
 
 		// Stop the container
-		stopCmd := exec.Command("docker", "stop", info.ID)
-		stopCmd.Stderr = &stderr
-
-		if err := stopCmd.Run(); err != nil {
-			return nil, fmt.Errorf("docker stop failed: %w (stderr: %s)", err, stderr.String())
+		if err := client.StopContainer(info.ID); err != nil {
+			return nil, fmt.Errorf("docker stop failed: %w", err)
 		}
 
 		zlog.Info("container stopped",
@@ -415,11 +615,8 @@ func (b *ContainerBackend) Stop(workspaceDir string, remove bool) (*ContainerInf
 			zap.String("container_id", info.ID),
 			zap.String("container_name", info.Name))
 
-		rmCmd := exec.Command("docker", "rm", info.ID)
-		rmCmd.Stderr = &stderr
-
-		if err := rmCmd.Run(); err != nil {
-			return nil, fmt.Errorf("docker rm failed: %w (stderr: %s)", err, stderr.String())
+		if err := client.RemoveContainer(info.ID, false); err != nil {
+			return nil, fmt.Errorf("docker rm failed: %w", err)
 		}
 
 		zlog.Info("container removed",
@@ -427,6 +624,11 @@ func (b *ContainerBackend) Stop(workspaceDir string, remove bool) (*ContainerInf
 			zap.String("container_name", info.Name))
 	}
 
+	// Stop (or remove) the network proxy sidecar along with the container
+	if err := b.removeNetworkProxy(client, info.Name, remove); err != nil {
+		zlog.Warn("failed to clean up network proxy", zap.Error(err))
+	}
+
 	return info, nil
 }
 
@@ -453,45 +655,33 @@ func (b *ContainerBackend) Find(workspaceDir string) (*ContainerInfo, error) {
 		return nil, err
 	}
 
-	// Use docker ps -a to find the container by name
-	cmd := exec.Command("docker", "ps", "-a", "--filter", fmt.Sprintf("name=^%s$", containerName), "--format", "{{json .}}")
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		zlog.Debug("docker ps command failed",
-			zap.Error(err),
-			zap.String("stderr", stderr.String()))
-		return nil, nil // Container likely doesn't exist
-	}
-
-	output := strings.TrimSpace(stdout.String())
-	if output == "" {
-		return nil, nil // No container found
+	client, err := b.docker()
+	if err != nil {
+		return nil, err
 	}
 
-	// Parse the JSON output
-	var containerData struct {
-		ID     string `json:"ID"`
-		Names  string `json:"Names"`
-		Image  string `json:"Image"`
-		State  string `json:"State"`
-		Status string `json:"Status"`
+	// List all containers (any state) to find the container by name
+	containers, err := client.ListContainers(true, fmt.Sprintf("^/?%s$", containerName))
+	if err != nil {
+		zlog.Debug("docker container list failed", zap.Error(err))
+		return nil, nil // Container likely doesn't exist
 	}
 
-	if err := json.Unmarshal([]byte(output), &containerData); err != nil {
-		return nil, fmt.Errorf("failed to parse docker ps output: %w", err)
+	for _, container := range containers {
+		if container.Name() != containerName {
+			continue
+		}
+		return &ContainerInfo{
+			ID:        shortContainerID(container.ID),
+			Name:      container.Name(),
+			Status:    container.State,
+			Image:     container.Image,
+			Workspace: absPath,
+			Backend:   b.backend,
+		}, nil
 	}
 
-	return &ContainerInfo{
-		ID:        containerData.ID,
-		Name:      containerData.Names,
-		Status:    containerData.State,
-		Image:     containerData.Image,
-		Workspace: absPath,
-		Backend:   BackendContainer,
-	}, nil
+	return nil, nil // No container found
 }
 
 // FindRunning returns container info only if running
@@ -518,49 +708,34 @@ func (b *ContainerBackend) FindRunning(workspaceDir string) (*ContainerInfo, err
 
 // List returns all containers managed by this backend
 func (b *ContainerBackend) List() ([]ContainerInfo, error) {
-	// List all containers with the sbox- prefix (covers both claude and opencode)
-	cmd := exec.Command("docker", "ps", "-a", "--filter", "name=^sbox-", "--format", "{{json .}}")
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	client, err := b.docker()
+	if err != nil {
+		return nil, err
+	}
 
-	if err := cmd.Run(); err != nil {
-		zlog.Debug("docker ps command failed",
-			zap.Error(err),
-			zap.String("stderr", stderr.String()))
-		return nil, fmt.Errorf("docker ps failed: %w", err)
+	// List all containers with the sbox- prefix (covers both claude and opencode)
+	containers, err := client.ListContainers(true, "^/?sbox-")
+	if err != nil {
+		return nil, fmt.Errorf("docker container list failed: %w", err)
 	}
 
 	var infos []ContainerInfo
-	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
-	for _, line := range lines {
-		if line == "" {
-			continue
-		}
-
-		var containerData struct {
-			ID     string `json:"ID"`
-			Names  string `json:"Names"`
-			Image  string `json:"Image"`
-			State  string `json:"State"`
-			Status string `json:"Status"`
-		}
-
-		if err := json.Unmarshal([]byte(line), &containerData); err != nil {
-			zlog.Debug("failed to parse container data", zap.String("line", line), zap.Error(err))
+	for _, container := range containers {
+		// Network proxy sidecars belong to a sandbox container, they are not sandboxes
+		if strings.HasSuffix(container.Name(), proxyNameSuffix) {
 			continue
 		}
 
 		// Try to extract workspace from container mounts
-		workspace := b.getContainerWorkspace(containerData.ID)
+		workspace := b.getContainerWorkspace(client, container.ID)
 
 		infos = append(infos, ContainerInfo{
-			ID:        containerData.ID,
-			Name:      containerData.Names,
-			Status:    containerData.State,
-			Image:     containerData.Image,
+			ID:        shortContainerID(container.ID),
+			Name:      container.Name(),
+			Status:    container.State,
+			Image:     container.Image,
 			Workspace: workspace,
-			Backend:   BackendContainer,
+			Backend:   b.backend,
 		})
 	}
 
@@ -569,58 +744,195 @@ func (b *ContainerBackend) List() ([]ContainerInfo, error) {
 
 // containerHasTTY checks if a container was created with TTY enabled.
 func (b *ContainerBackend) containerHasTTY(containerID string) bool {
-	cmd := exec.Command("docker", "inspect", containerID, "--format", "{{.Config.Tty}}")
-	var stdout bytes.Buffer
-	cmd.Stdout = &stdout
+	client, err := b.docker()
+	if err != nil {
+		return false
+	}
 
-	if err := cmd.Run(); err != nil {
+	container, err := client.InspectContainer(containerID)
+	if err != nil || container == nil {
 		return false
 	}
 
-	return strings.TrimSpace(stdout.String()) == "true"
+	return container.Config.Tty
 }
 
-// getContainerWorkspace inspects a container to find its workspace mount
-func (b *ContainerBackend) getContainerWorkspace(containerID string) string {
-	cmd := exec.Command("docker", "inspect", containerID, "--format", "{{range .Mounts}}{{if eq .Type \"bind\"}}{{.Source}}:{{.Destination}}\n{{end}}{{end}}")
-	var stdout bytes.Buffer
-	cmd.Stdout = &stdout
+// containerNetworkMatches checks if a container was created for the given network setup
+func (b *ContainerBackend) containerNetworkMatches(containerID string, network *containerNetwork) bool {
+	client, err := b.docker()
+	if err != nil {
+		return false
+	}
+
+	container, err := client.InspectContainer(containerID)
+	if err != nil || container == nil {
+		return false
+	}
+
+	return network.matches(container)
+}
+
+// containerSSHMatches checks if a container was created for the given SSH setup
+func (b *ContainerBackend) containerSSHMatches(containerID string, ssh *containerSSH) bool {
+	client, err := b.docker()
+	if err != nil {
+		return false
+	}
 
-	if err := cmd.Run(); err != nil {
+	container, err := client.InspectContainer(containerID)
+	if err != nil || container == nil {
+		return false
+	}
+
+	return ssh.matches(container)
+}
+
+// containerPortsMatch checks whether an existing container publishes all the
+// given ports. Extra ones, such as `-p` ports of an earlier session, are kept
+// rather than recreating the container when a later run doesn't ask for them.
+func (b *ContainerBackend) containerPortsMatch(containerID string, ports []PortMapping) bool {
+	client, err := b.docker()
+	if err != nil {
+		return false
+	}
+
+	container, err := client.InspectContainer(containerID)
+	if err != nil || container == nil {
+		return false
+	}
+
+	_, bindings := dockerPortBindings(ports)
+	for port, wanted := range bindings {
+		published := container.HostConfig.PortBindings[port]
+		for _, binding := range wanted {
+			if !slices.Contains(published, binding) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// containerResourcesMatch checks whether an existing container was created with the given resource limits
+func (b *ContainerBackend) containerResourcesMatch(containerID string, resources *ResourceLimits) bool {
+	client, err := b.docker()
+	if err != nil {
+		return false
+	}
+
+	container, err := client.InspectContainer(containerID)
+	if err != nil || container == nil {
+		return false
+	}
+
+	var want DockerHostConfig
+	if err := resources.ApplyToHostConfig(&want); err != nil {
+		return false
+	}
+	return dockerResourcesMatch(container.HostConfig.DockerResources, container.HostConfig.ShmSize, want.DockerResources, want.ShmSize)
+}
+
+// ForwardPorts forwards host ports to the running container. Each connection
+// is relayed by `sbox port-relay` run through an exec, so ports can be added
+// without recreating the container.
+func (b *ContainerBackend) ForwardPorts(workspaceDir string, ports []PortMapping) error {
+	absPath, err := filepath.Abs(workspaceDir)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	info, err := b.FindRunning(absPath)
+	if err != nil {
+		return fmt.Errorf("failed to find running container: %w", err)
+	}
+	if info == nil {
+		return fmt.Errorf("no container is running for workspace: %s\nStart a container first with: sbox run", absPath)
+	}
+
+	client, err := b.docker()
+	if err != nil {
+		return err
+	}
+
+	return servePortForwards(ports, func(conn net.Conn, port int) error {
+		return b.relayPort(client, info.ID, conn, port)
+	})
+}
+
+// relayPort pipes conn to a port of the container (the equivalent of
+// `docker exec -i <container> sbox port-relay <port>`)
+func (b *ContainerBackend) relayPort(client *DockerClient, containerID string, conn net.Conn, port int) error {
+	execID, err := client.CreateExec(containerID, portRelayCommand(port), false, true)
+	if err != nil {
+		return fmt.Errorf("docker exec failed: %w", err)
+	}
+
+	hijacked, err := client.StartExec(execID, false)
+	if err != nil {
+		return fmt.Errorf("docker exec failed: %w", err)
+	}
+
+	var stderr bytes.Buffer
+	err = runDockerSession(hijacked, dockerSessionOptions{
+		Stdin:  true,
+		In:     conn,
+		Stdout: conn,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return fmt.Errorf("docker exec failed: %w", err)
+	}
+
+	if exitCode, err := client.InspectExec(execID); err == nil && exitCode > 0 {
+		return fmt.Errorf("port relay exited with code %d: %s", exitCode, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// getContainerWorkspace inspects a container to find its workspace mount
+func (b *ContainerBackend) getContainerWorkspace(client *DockerClient, containerID string) string {
+	container, err := client.InspectContainer(containerID)
+	if err != nil || container == nil {
 		return ""
 	}
 
 	// Look for a bind mount where source == destination (workspace pattern)
-	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
-	for _, line := range lines {
-		parts := strings.Split(line, ":")
-		if len(parts) == 2 && parts[0] == parts[1] && strings.HasPrefix(parts[0], "/") {
-			return parts[0]
+	for _, mount := range container.Mounts {
+		if mount.Type == "bind" && mount.Source == mount.Destination && strings.HasPrefix(mount.Source, "/") {
+			return mount.Source
 		}
 	}
 
 	return ""
 }
 
+// shortContainerID truncates a container ID to the 12 characters shown by the docker CLI
+func shortContainerID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
 // Remove removes a container by ID
 func (b *ContainerBackend) Remove(containerID string) error {
-	zlog.Info("removing docker container",
+	zlog.Info("removing container",
 		zap.String("container_id", containerID))
 
+	client, err := b.docker()
+	if err != nil {
+		return err
+	}
+
 	// First try to stop if running
-	stopCmd := exec.Command("docker", "stop", containerID)
-	_ = stopCmd.Run() // Ignore error - container might not be running
+	_ = client.StopContainer(containerID) // Ignore error - container might not be running
 
 	// Remove the container
-	cmd := exec.Command("docker", "rm", containerID)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("docker rm failed: %w (stderr: %s)", err, stderr.String())
+	if err := client.RemoveContainer(containerID, false); err != nil {
+		return fmt.Errorf("docker rm failed: %w", err)
 	}
 
-	zlog.Info("docker container removed",
+	zlog.Info("container removed",
 		zap.String("container_id", containerID))
 	return nil
 }
@@ -631,12 +943,13 @@ func (b *ContainerBackend) RemoveVolume(workspaceDir string, agentType AgentType
 
 	zlog.Info("removing persistence volume", zap.String("volume", volumeName))
 
-	cmd := exec.Command("docker", "volume", "rm", volumeName)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
+	client, err := b.docker()
+	if err != nil {
+		return err
+	}
 
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("docker volume rm failed: %w (stderr: %s)", err, stderr.String())
+	if err := client.RemoveVolume(volumeName); err != nil {
+		return fmt.Errorf("docker volume rm failed: %w", err)
 	}
 
 	return nil
@@ -662,21 +975,20 @@ func (b *ContainerBackend) Cleanup(workspaceDir string) error {
 		agentType = DefaultAgent
 	}
 
-	// Remove .sbox directory
-	sboxDir := filepath.Join(absPath, ".sbox")
-	if err := os.RemoveAll(sboxDir); err != nil {
-		zlog.Warn("failed to remove .sbox directory", zap.String("path", sboxDir), zap.Error(err))
-		// Continue to remove volume
-	} else {
-		zlog.Info(".sbox directory removed", zap.String("path", sboxDir))
-	}
-
-	// Remove persistence volume
-	if err := b.RemoveVolume(workspaceDir, agentType); err != nil {
+	// Remove persistence volume, first: its name comes from the project ID
+	// recorded in .sbox
+	if err := b.RemoveVolume(absPath, agentType); err != nil {
 		zlog.Warn("failed to remove persistence volume", zap.Error(err))
 		// Non-fatal - volume might not exist
 	}
 
+	// Remove .sbox directory, keeping the project ID
+	if err := removeWorkspaceSboxDir(absPath); err != nil {
+		zlog.Warn("failed to remove .sbox directory", zap.String("workspace", absPath), zap.Error(err))
+	} else {
+		zlog.Info(".sbox directory removed", zap.String("workspace", absPath))
+	}
+
 	return nil
 }
 
@@ -690,7 +1002,8 @@ func (b *ContainerBackend) SaveCache(workspaceDir string, agentType AgentType) e
 // getDockerSocketPath returns the Docker socket path to mount.
 // Priority:
 //  1. SBOX_DOCKER_SOCKET environment variable (explicit override)
-//  2. Platform-specific default paths
+//  2. DOCKER_HOST environment variable, when it points to a unix socket
+//  3. Platform-specific default paths
 func getDockerSocketPath() string {
 	// Check for explicit override
 	if envPath := os.Getenv(DockerSocketEnvVar); envPath != "" {
@@ -700,6 +1013,18 @@ func getDockerSocketPath() string {
 		zlog.Warn("SBOX_DOCKER_SOCKET path does not exist", zap.String("path", envPath))
 	}
 
+	if socketPath, ok := strings.CutPrefix(os.Getenv(DockerHostEnvVar), "unix://"); ok {
+		if _, err := os.Stat(socketPath); err == nil {
+			return socketPath
+		}
+		zlog.Warn("DOCKER_HOST socket does not exist", zap.String("path", socketPath))
+	}
+
+	return defaultDockerSocketPath()
+}
+
+// defaultDockerSocketPath returns the first existing platform-specific Docker socket path
+func defaultDockerSocketPath() string {
 	// Platform-specific defaults
 	var candidates []string
 	switch runtime.GOOS {
@@ -0,0 +1,138 @@
+package sbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// PodmanSocketEnvVar is the environment variable to override the Podman API socket path
+const PodmanSocketEnvVar = "SBOX_PODMAN_SOCKET"
+
+// PodmanHostEnvVar is Podman's standard environment variable selecting the Podman service
+const PodmanHostEnvVar = "CONTAINER_HOST"
+
+// Agent user IDs in sbox template images (the `agent` user of the base templates)
+const (
+	AgentUserID  = 1000
+	AgentGroupID = 1000
+)
+
+// PodmanBackend implements the Backend interface using rootless Podman containers.
+//
+// It shares the container backend implementation, talking to Podman's
+// Docker-compatible API socket, with the differences rootless Podman needs:
+//   - the host user is mapped to the container's agent user (keep-id user
+//     namespace) so files written to the workspace are owned by the host user
+//     instead of a subordinate UID
+//   - SELinux labeling is disabled for the container so the workspace bind
+//     mount is usable without relabeling host files
+//   - template images are built with `podman build` into Podman's image store
+type PodmanBackend struct {
+	*ContainerBackend
+}
+
+// NewPodmanBackend creates a new podman backend instance
+func NewPodmanBackend(config *Config) *PodmanBackend {
+	return &PodmanBackend{
+		ContainerBackend: &ContainerBackend{config: config, backend: BackendPodman},
+	}
+}
+
+// podmanUsernsMode returns the user namespace mode mapping the host user to the agent user
+func podmanUsernsMode() string {
+	return fmt.Sprintf("keep-id:uid=%d,gid=%d", AgentUserID, AgentGroupID)
+}
+
+// ResolvePodmanHost returns the Podman service address to use.
+// Priority:
+//  1. SBOX_PODMAN_SOCKET environment variable (socket path)
+//  2. CONTAINER_HOST environment variable (unix:// or tcp://)
+//  3. Platform-specific default socket paths
+func ResolvePodmanHost() (string, error) {
+	if socketPath := os.Getenv(PodmanSocketEnvVar); socketPath != "" {
+		return "unix://" + socketPath, nil
+	}
+	if host := os.Getenv(PodmanHostEnvVar); host != "" {
+		return host, nil
+	}
+	if socketPath := defaultPodmanSocketPath(); socketPath != "" {
+		return "unix://" + socketPath, nil
+	}
+	return "", fmt.Errorf("no Podman socket found; start it with 'systemctl --user enable --now podman.socket' or set %s", PodmanSocketEnvVar)
+}
+
+// NewPodmanClient creates an API client for the Podman service selected by ResolvePodmanHost
+func NewPodmanClient() (*DockerClient, error) {
+	host, err := ResolvePodmanHost()
+	if err != nil {
+		return nil, err
+	}
+	return NewDockerClientWithHost(host)
+}
+
+// getPodmanSocketPath returns the Podman socket path to mount, following the
+// same priority as ResolvePodmanHost but only for unix sockets
+func getPodmanSocketPath() string {
+	if envPath := os.Getenv(PodmanSocketEnvVar); envPath != "" {
+		if _, err := os.Stat(envPath); err == nil {
+			return envPath
+		}
+		zlog.Warn("SBOX_PODMAN_SOCKET path does not exist", zap.String("path", envPath))
+	}
+
+	if socketPath, ok := strings.CutPrefix(os.Getenv(PodmanHostEnvVar), "unix://"); ok {
+		if _, err := os.Stat(socketPath); err == nil {
+			return socketPath
+		}
+		zlog.Warn("CONTAINER_HOST socket does not exist", zap.String("path", socketPath))
+	}
+
+	return defaultPodmanSocketPath()
+}
+
+// defaultPodmanSocketPath returns the first existing platform-specific Podman socket path
+func defaultPodmanSocketPath() string {
+	var candidates []string
+	switch runtime.GOOS {
+	case "darwin":
+		// macOS: the podman machine forwards its API socket to the host
+		homeDir, _ := os.UserHomeDir()
+		if homeDir != "" {
+			candidates = append(candidates, filepath.Join(homeDir, ".local", "share", "containers", "podman", "machine", "podman.sock"))
+		}
+	default:
+		// Rootless socket first, then the rootful system socket
+		if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+			candidates = append(candidates, filepath.Join(runtimeDir, "podman", "podman.sock"))
+		}
+		candidates = append(candidates, fmt.Sprintf("/run/user/%d/podman/podman.sock", os.Getuid()))
+		candidates = append(candidates, "/run/podman/podman.sock")
+	}
+
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// qualifyImageRef prefixes short image names with docker.io. Podman refuses
+// (or prompts for) unqualified names depending on its short-name mode, while
+// Docker implicitly resolves them against Docker Hub.
+func qualifyImageRef(ref string) string {
+	first, _, hasSlash := strings.Cut(ref, "/")
+	if hasSlash && (strings.ContainsAny(first, ".:") || first == "localhost") {
+		return ref
+	}
+	if !hasSlash {
+		return "docker.io/library/" + ref
+	}
+	return "docker.io/" + ref
+}
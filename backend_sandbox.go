@@ -3,10 +3,12 @@ package sbox
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -59,10 +61,18 @@ func (b *SandboxBackend) Run(opts BackendOptions) error {
 	if opts.SboxFile != nil && opts.SboxFile.Config != nil {
 		sboxFileEnvs = opts.SboxFile.Config.Envs
 	}
-	if err := PrepareSboxDirectory(opts.WorkspaceDir, opts.Config, opts.Config.Envs, opts.ProjectConfig.Envs, sboxFileEnvs, BackendSandbox, agentType, opts); err != nil {
+	secrets, err := PrepareSboxDirectory(opts.WorkspaceDir, opts.Config, opts.Config.Envs, opts.ProjectConfig.Envs, sboxFileEnvs, BackendSandbox, agentType, opts)
+	if err != nil {
 		return fmt.Errorf("failed to prepare .sbox directory: %w", err)
 	}
 
+	// docker sandbox has no port publishing, ports are reached through exec relays
+	if ports, err := ResolvePorts(opts.ProjectConfig, opts.Ports); err != nil {
+		return fmt.Errorf("invalid ports: %w", err)
+	} else if len(ports) > 0 {
+		DefaultUI.Warn("Ports can't be published by the sandbox backend, use 'sbox port-forward' to reach them")
+	}
+
 	// Check if sandbox exists
 	existingSandbox, err := FindDockerSandboxByName(sandboxName)
 	if err != nil {
@@ -73,12 +83,24 @@ func (b *SandboxBackend) Run(opts BackendOptions) error {
 	if existingSandbox == nil {
 		// Build custom template only when creating a new sandbox
 		// Template is now always required for sbox entrypoint
-		builder := NewTemplateBuilder(opts.Config, allProfiles, agentType)
+		builder, err := NewWorkspaceTemplateBuilder(opts.Config, opts.WorkspaceDir, opts.SboxFile, allProfiles, agentType)
+		if err != nil {
+			return err
+		}
 		templateImage, err := builder.Build(opts.ForceRebuild)
 		if err != nil {
 			return fmt.Errorf("failed to build custom template: %w", err)
 		}
 
+		// docker sandbox create has no resource options, the sandbox VM is
+		// sized by Docker Desktop
+		if resources := ResolveResourceLimits(opts.Config, opts.ProjectConfig); !resources.IsEmpty() {
+			DefaultUI.Warn("Resource limits (%s) are not supported by the sandbox backend and are ignored, use the container or podman backend to enforce them", strings.Join(resources.Describe(), ", "))
+		}
+		if policy := opts.ProjectConfig.Network; policy.EffectiveMode() != NetworkModeOpen {
+			DefaultUI.Warn("The network policy (%s) is not enforced by the sandbox backend, use the container or podman backend to enforce it", policy.Describe())
+		}
+
 		DefaultUI.Status("Creating sandbox '%s'", sandboxName)
 		zlog.Info("sandbox does not exist, creating",
 			zap.String("name", sandboxName),
@@ -102,6 +124,30 @@ func (b *SandboxBackend) Run(opts BackendOptions) error {
 			zap.String("status", existingSandbox.Status))
 	}
 
+	// Background work tied to the sandbox session, stopped when it ends
+	done := make(chan struct{})
+	defer close(done)
+
+	// The sandbox VM can't mount host paths, so SSH is only available through
+	// the forwarded agent
+	sshSettings := ResolveSSHSettings(opts.Config, opts.ProjectConfig)
+	if err := ValidateSSHMode(sshSettings.Mode); err != nil {
+		return fmt.Errorf("invalid ssh setting: %w", err)
+	}
+	if err := ValidateSSHAllowedKeys(sshSettings.AllowedKeys); err != nil {
+		return fmt.Errorf("invalid ssh_allowed_keys: %w", err)
+	}
+	switch sshSettings.Mode {
+	case SSHModeKeys:
+		DefaultUI.Warn("SSH keys can't be mounted by the sandbox backend, use 'ssh: agent' to forward the SSH agent instead")
+	case SSHModeAgent:
+		if HostSSHAgentSocket() == "" {
+			DefaultUI.Warn("No SSH agent is running on the host (SSH_AUTH_SOCK), SSH is not available in the sandbox")
+		} else {
+			go b.relaySSHAgentWhileRunning(opts.WorkspaceDir, sshSettings.AllowedKeys, done)
+		}
+	}
+
 	DefaultUI.Status("Starting sandbox '%s'", sandboxName)
 
 	// Build run command: docker sandbox [--debug] run <name>
@@ -118,14 +164,38 @@ func (b *SandboxBackend) Run(opts BackendOptions) error {
 		zap.Bool("debug", opts.Debug))
 
 	// Execute docker sandbox run
-	cmd := exec.Command("docker", args...)
+	cmd := execCommand("docker", args...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	if err := cmd.Run(); err != nil {
+	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("docker sandbox failed: %w", err)
 	}
+	exited := make(chan struct{})
+	var runErr error
+	go func() {
+		runErr = cmd.Wait()
+		close(exited)
+	}()
+
+	// Secrets are kept out of .sbox/env and sent to the entrypoint once the
+	// sandbox runs. The session is ended if they can't be.
+	if len(secrets) > 0 {
+		if err := b.deliverSecretsWhenRunning(opts.WorkspaceDir, secrets, exited); err != nil {
+			if _, stopErr := b.Stop(opts.WorkspaceDir, false); stopErr != nil {
+				zlog.Debug("failed to stop sandbox", zap.Error(stopErr))
+			}
+			_ = cmd.Process.Kill()
+			<-exited
+			return fmt.Errorf("failed to deliver secret environment variables: %w", err)
+		}
+	}
+
+	<-exited
+	if runErr != nil {
+		return fmt.Errorf("docker sandbox failed: %w", runErr)
+	}
 
 	zlog.Info("Docker sandbox exited successfully")
 	return nil
@@ -158,7 +228,7 @@ func (b *SandboxBackend) Shell(workspaceDir string) error {
 		zap.String("workspace", absPath))
 
 	// Execute docker sandbox exec -it <sandbox> bash
-	cmd := exec.Command("docker", "sandbox", "exec", "-it", info.ID, "bash")
+	cmd := execCommand("docker", "sandbox", "exec", "-it", info.ID, "bash")
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -170,6 +240,156 @@ func (b *SandboxBackend) Shell(workspaceDir string) error {
 	return nil
 }
 
+// ForwardPorts forwards host ports to the running sandbox, relaying each
+// connection through `docker sandbox exec -i <sandbox> sbox port-relay <port>`,
+// as docker sandbox can't publish ports
+func (b *SandboxBackend) ForwardPorts(workspaceDir string, ports []PortMapping) error {
+	absPath, err := filepath.Abs(workspaceDir)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	info, err := b.FindRunning(absPath)
+	if err != nil {
+		return fmt.Errorf("failed to find running sandbox: %w", err)
+	}
+	if info == nil {
+		return fmt.Errorf("no sandbox is running for workspace: %s\nStart a sandbox first with: sbox run", absPath)
+	}
+
+	return servePortForwards(ports, func(conn net.Conn, port int) error {
+		return b.relayPort(info.ID, conn, port)
+	})
+}
+
+// relayPort pipes conn to a port of the sandbox
+func (b *SandboxBackend) relayPort(sandboxID string, conn net.Conn, port int) error {
+	args := append([]string{"sandbox", "exec", "-i", sandboxID}, portRelayCommand(port)...)
+	cmd := execCommand("docker", args...)
+	cmd.Stdout = conn
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	// Copy stdin ourselves, exec would otherwise wait for conn to be closed
+	// before returning
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+	go func() {
+		_, _ = io.Copy(stdin, conn)
+		stdin.Close()
+	}()
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("docker sandbox exec failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// waitRunning polls until the sandbox of a workspace runs and returns its ID,
+// or "" once done is closed or timeout fires (a nil timeout never does)
+func (b *SandboxBackend) waitRunning(workspaceDir string, done <-chan struct{}, timeout <-chan time.Time) string {
+	ticker := time.NewTicker(sandboxPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return ""
+		case <-timeout:
+			return ""
+		case <-ticker.C:
+		}
+
+		if info, err := b.FindRunning(workspaceDir); err == nil && info != nil {
+			return info.ID
+		}
+	}
+}
+
+// sandboxPollInterval is how often the sandbox state is checked while
+// `docker sandbox run` starts it
+var sandboxPollInterval = time.Second
+
+// relaySSHAgentWhileRunning relays the host SSH agent to the sandbox of a
+// workspace once it runs, until done is closed. The relay is started again
+// if the sandbox restarts.
+func (b *SandboxBackend) relaySSHAgentWhileRunning(workspaceDir string, allowedKeys []string, done <-chan struct{}) {
+	for {
+		sandboxID := b.waitRunning(workspaceDir, done, nil)
+		if sandboxID == "" {
+			return
+		}
+		if err := b.relaySSHAgent(sandboxID, allowedKeys, done); err != nil {
+			zlog.Debug("ssh agent relay ended", zap.Error(err))
+		}
+	}
+}
+
+// deliverSecretsWhenRunning sends the secret env vars to the entrypoint of
+// the sandbox of a workspace once it runs, through
+// `docker sandbox exec -i <sandbox> sbox receive-secrets`. Fails when the
+// sandbox exits or doesn't run within the entrypoint wait.
+func (b *SandboxBackend) deliverSecretsWhenRunning(workspaceDir string, secrets []string, exited <-chan struct{}) error {
+	sandboxID := b.waitRunning(workspaceDir, exited, time.After(secretEnvWaitTimeout))
+	if sandboxID == "" {
+		select {
+		case <-exited:
+			return fmt.Errorf("the sandbox exited before they were delivered")
+		default:
+			return fmt.Errorf("the sandbox was not running after %s", secretEnvWaitTimeout)
+		}
+	}
+
+	args := append([]string{"sandbox", "exec", "-i", sandboxID}, receiveSecretEnvsCommand()...)
+	cmd := execCommand("docker", args...)
+	cmd.Stdin = strings.NewReader(secretEnvsPayload(secrets))
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("docker sandbox exec failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// relaySSHAgent runs `docker sandbox exec -i <sandbox> sbox ssh-agent-relay`
+// and answers its requests with the host agent, until the relay exits or
+// done is closed
+func (b *SandboxBackend) relaySSHAgent(sandboxID string, allowedKeys []string, done <-chan struct{}) error {
+	args := append([]string{"sandbox", "exec", "-i", sandboxID}, sshAgentRelayCommand()...)
+	cmd := execCommand("docker", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("docker sandbox exec failed: %w", err)
+	}
+
+	// Closing stdin stops the relay inside the sandbox
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-done:
+			stdin.Close()
+		case <-stopped:
+		}
+	}()
+
+	serveErr := serveSSHAgentRelay(stdout, stdin, allowedKeys)
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("docker sandbox exec failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return serveErr
+}
+
 // Stop stops the sandbox, optionally removing it
 func (b *SandboxBackend) Stop(workspaceDir string, remove bool) (*ContainerInfo, error) {
 	absPath, err := filepath.Abs(workspaceDir)
@@ -211,7 +431,7 @@ func (b *SandboxBackend) Stop(workspaceDir string, remove bool) (*ContainerInfo,
 			zap.String("workspace", absPath))
 
 		// Stop the sandbox using docker sandbox stop
-		stopCmd := exec.Command("docker", "sandbox", "stop", info.ID)
+		stopCmd := execCommand("docker", "sandbox", "stop", info.ID)
 		stopCmd.Stderr = &stderr
 
 		if err := stopCmd.Run(); err != nil {
@@ -233,7 +453,7 @@ func (b *SandboxBackend) Stop(workspaceDir string, remove bool) (*ContainerInfo,
 			zap.String("sandbox_id", info.ID),
 			zap.String("sandbox_name", info.Name))
 
-		rmCmd := exec.Command("docker", "sandbox", "rm", info.ID)
+		rmCmd := execCommand("docker", "sandbox", "rm", info.ID)
 		rmCmd.Stderr = &stderr
 
 		if err := rmCmd.Run(); err != nil {
@@ -325,13 +545,12 @@ func (b *SandboxBackend) Cleanup(workspaceDir string) error {
 		return fmt.Errorf("failed to get absolute path: %w", err)
 	}
 
-	// Remove .sbox directory
-	sboxDir := filepath.Join(absPath, ".sbox")
-	if err := os.RemoveAll(sboxDir); err != nil {
-		return fmt.Errorf("failed to remove .sbox directory: %w", err)
+	// Remove .sbox directory, keeping the project ID
+	if err := removeWorkspaceSboxDir(absPath); err != nil {
+		return err
 	}
 
-	zlog.Info(".sbox directory removed", zap.String("path", sboxDir))
+	zlog.Info(".sbox directory removed", zap.String("workspace", absPath))
 	return nil
 }
 
@@ -49,6 +49,22 @@ type streamEvent struct {
 	NumTurns     int     `json:"num_turns,omitempty"`
 	TotalCostUSD float64 `json:"total_cost_usd,omitempty"`
 	IsError      bool    `json:"is_error,omitempty"`
+
+	// Token usage of the run, for result events
+	Usage *tokenUsage `json:"usage,omitempty"`
+}
+
+// tokenUsage is the token count of a result event.
+type tokenUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+}
+
+// total returns all the tokens processed, cached ones included.
+func (u *tokenUsage) total() int {
+	return u.InputTokens + u.OutputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
 }
 
 type streamMessage struct {
@@ -84,7 +100,8 @@ type toolUseResult struct {
 	// For file reads
 	File *fileResult `json:"file,omitempty"`
 
-	// For edits
+	// For edits and writes
+	FilePath        string       `json:"filePath,omitempty"`
 	StructuredPatch []patchEntry `json:"structuredPatch,omitempty"`
 }
 
@@ -140,6 +157,15 @@ type StreamPrinter struct {
 	md        *glamour.TermRenderer
 	lastPrint string // tracks what was last printed: "tool", "result", "text", "thinking"
 	lastTool  string // tracks the last tool name for result formatting
+
+	// Accumulated usage stats across result events
+	totalCost   float64
+	totalTokens int
+	totalTurns  int
+
+	// Tool calls and edited files (from structuredPatch results)
+	tools       map[string]int
+	filesEdited []string
 }
 
 // newStreamStyle returns a glamour style customized for sbox stream output.
@@ -266,6 +292,7 @@ func (p *StreamPrinter) handleAssistant(event *streamEvent) bool {
 			}
 			p.printToolUse(block)
 			p.lastTool = block.Name
+			p.recordTool(block.Name)
 			p.lastPrint = "tool"
 			printed = true
 
@@ -502,6 +529,9 @@ func (p *StreamPrinter) handleUser(event *streamEvent) bool {
 
 func (p *StreamPrinter) handleToolResult(event *streamEvent) bool {
 	r := event.ToolUseResult
+	if r.FilePath != "" && r.StructuredPatch != nil {
+		p.recordFileEdited(r.FilePath)
+	}
 
 	// Edit results with structured patch — show diff
 	if len(r.StructuredPatch) > 0 {
@@ -600,6 +630,12 @@ func (p *StreamPrinter) printEditResult(patches []patchEntry) {
 }
 
 func (p *StreamPrinter) handleResult(event *streamEvent) bool {
+	p.totalCost += event.TotalCostUSD
+	p.totalTurns += event.NumTurns
+	if event.Usage != nil {
+		p.totalTokens += event.Usage.total()
+	}
+
 	if p.lastPrint == "tool" || p.lastPrint == "result" {
 		fmt.Fprintln(p.w)
 	}
@@ -613,6 +649,34 @@ func (p *StreamPrinter) handleResult(event *streamEvent) bool {
 	return true
 }
 
+// Activity returns the number of calls of each tool and the files edited, in
+// order, of the events processed so far.
+func (p *StreamPrinter) Activity() (map[string]int, []string) {
+	return p.tools, p.filesEdited
+}
+
+func (p *StreamPrinter) recordTool(name string) {
+	if p.tools == nil {
+		p.tools = make(map[string]int)
+	}
+	p.tools[name]++
+}
+
+func (p *StreamPrinter) recordFileEdited(path string) {
+	for _, edited := range p.filesEdited {
+		if edited == path {
+			return
+		}
+	}
+	p.filesEdited = append(p.filesEdited, path)
+}
+
+// Usage returns the cost in USD, the tokens and the turns of the result
+// events processed so far.
+func (p *StreamPrinter) Usage() (float64, int, int) {
+	return p.totalCost, p.totalTokens, p.totalTurns
+}
+
 func truncate(s string, max int) string {
 	if len(s) <= max {
 		return s
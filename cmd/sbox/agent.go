@@ -58,17 +58,16 @@ func agentSetE(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	// Load config
-	config, err := sbox.LoadConfig()
+	store, err := sbox.NewConfigStore()
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	// Set default agent
-	config.DefaultAgent = agentName
-
-	// Save config
-	if err := sbox.SaveConfig(config); err != nil {
+	err = store.UpdateConfig(func(config *sbox.Config) error {
+		config.DefaultAgent = agentName
+		return nil
+	})
+	if err != nil {
 		return fmt.Errorf("failed to save config: %w", err)
 	}
 
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
@@ -9,6 +11,7 @@ import (
 	"github.com/spf13/pflag"
 	. "github.com/streamingfast/cli"
 	"github.com/streamingfast/sbox"
+	"golang.org/x/term"
 )
 
 var AuthCommand = Command(authE,
@@ -17,31 +20,64 @@ var AuthCommand = Command(authE,
 	Description(`
 		Configures the Anthropic API key used by all sandbox sessions.
 
-		Without flags, prompts for the API key and stores it in the
-		global configuration as an environment variable passed to all sandboxes.
+		Without flags, prompts for the API key and stores it in the encrypted
+		sbox vault (see 'sbox secret --help'), from which it is passed to all
+		sandboxes as ANTHROPIC_API_KEY. Enter a secret reference (e.g.
+		secret://pass/anthropic/api-key) instead of the key to have it resolved
+		each time a sandbox starts (see 'sbox env add --help').
 
-		With --status, shows whether an API key is configured.
-		With --logout, removes the stored API key.
+		With --provider, prompts for the variables of a model provider
+		(anthropic, bedrock, vertex, openai-compatible or custom) and stores
+		them as a named credential set (--name, defaulting to the provider).
+		Secret values go to the sbox vault, the others to the global config.
+		The first set becomes the default one (or use --default), and projects
+		select another in sbox.yaml:
+
+		  credentials: bedrock-prod
+
+		The variables of the selected set are passed to the sandbox unless an
+		sbox env sets them.
+
+		With --status, shows the API key and credential sets, and the one
+		active for the current project.
+		With --logout, removes the stored API key, or the credential set given
+		by --name.
 	`),
 	Flags(func(flags *pflag.FlagSet) {
 		flags.Bool("status", false, "Show authentication status")
-		flags.Bool("logout", false, "Remove stored API key")
+		flags.Bool("logout", false, "Remove stored API key (or the --name credential set)")
+		flags.String("provider", "", "Configure a credential set for a provider: "+strings.Join(sbox.ValidCredentialProviders, ", "))
+		flags.String("name", "", "Name of the credential set (default: the provider name)")
+		flags.Bool("default", false, "Make the credential set the default for all projects")
 	}),
 )
 
+// authEnvName is the environment variable holding the API key
+const authEnvName = "ANTHROPIC_API_KEY"
+
 // authE handles authentication management
 func authE(cmd *cobra.Command, args []string) error {
 	showStatus, _ := cmd.Flags().GetBool("status")
 	logout, _ := cmd.Flags().GetBool("logout")
+	provider, _ := cmd.Flags().GetString("provider")
+	name, _ := cmd.Flags().GetString("name")
 
 	if showStatus {
 		return authStatus(cmd)
 	}
 
 	if logout {
+		if name != "" {
+			return authLogoutCredentials(cmd, name)
+		}
 		return authLogout(cmd)
 	}
 
+	if provider != "" {
+		makeDefault, _ := cmd.Flags().GetBool("default")
+		return authCredentials(cmd, provider, name, makeDefault)
+	}
+
 	return authLogin(cmd)
 }
 
@@ -52,11 +88,23 @@ func authStatus(cmd *cobra.Command) error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
+	if err := printAPIKeyStatus(cmd, config); err != nil {
+		return err
+	}
+	return printCredentialSetsStatus(cmd, config)
+}
+
+// printAPIKeyStatus prints how ANTHROPIC_API_KEY is configured
+func printAPIKeyStatus(cmd *cobra.Command, config *sbox.Config) error {
 	for _, env := range config.Envs {
-		if sbox.EnvName(env) == "ANTHROPIC_API_KEY" {
-			if strings.Contains(env, "=") {
+		if sbox.EnvName(env) == authEnvName {
+			if value, ok := strings.CutPrefix(env, authEnvName+"="); ok && sbox.IsSecretRef(value) {
+				cmd.Println("Status: Configured (secret reference)")
+				cmd.Printf("ANTHROPIC_API_KEY will be resolved from %s at launch time.\n", value)
+			} else if strings.Contains(env, "=") {
 				cmd.Println("Status: Configured")
-				cmd.Println("ANTHROPIC_API_KEY is set in global config and will be passed to all sandboxes.")
+				cmd.Println("ANTHROPIC_API_KEY is set in plaintext in the global config and will be passed to all sandboxes.")
+				cmd.Println("Run 'sbox auth --logout' then 'sbox auth' to move it to the encrypted sbox vault.")
 			} else {
 				cmd.Println("Status: Configured (passthrough from host)")
 				cmd.Println("ANTHROPIC_API_KEY will be resolved from host environment at launch time.")
@@ -65,25 +113,268 @@ func authStatus(cmd *cobra.Command) error {
 		}
 	}
 
+	if sbox.VaultExists(config) {
+		vault, err := sbox.OpenVault(config)
+		if err != nil {
+			return fmt.Errorf("failed to open secret vault: %w", err)
+		}
+		if _, ok := vault.Get(sbox.VaultGlobalScope, authEnvName); ok {
+			cmd.Println("Status: Configured")
+			cmd.Println("ANTHROPIC_API_KEY is stored in the encrypted sbox vault and will be passed to all sandboxes.")
+			return nil
+		}
+	}
+
 	cmd.Println("Status: Not configured")
-	cmd.Println("Run 'sbox auth' to configure your API key.")
+	if len(config.Credentials) == 0 {
+		cmd.Println("Run 'sbox auth' to configure your API key, or 'sbox auth --provider <provider>' for another provider.")
+	}
+	return nil
+}
+
+// printCredentialSetsStatus lists the credential sets and the one active for
+// the current project
+func printCredentialSetsStatus(cmd *cobra.Command, config *sbox.Config) error {
+	if len(config.Credentials) == 0 {
+		return nil
+	}
+
+	cmd.Println()
+	cmd.Println("Credential sets:")
+	for _, name := range config.CredentialSetNames() {
+		marker := ""
+		if name == config.DefaultCredentials {
+			marker = "  [default]"
+		}
+		cmd.Printf("  %s: %s%s\n", name, config.Credentials[name].Describe(), marker)
+	}
+
+	workspaceDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+	projectConfig, err := loadMergedProjectConfig(workspaceDir)
+	if err != nil {
+		return err
+	}
+
+	cmd.Println()
+	if active := sbox.ResolveCredentialSetName(config, projectConfig); active != "" {
+		cmd.Printf("Active for this project: %s\n", active)
+	} else {
+		cmd.Println("Active for this project: none (set 'credentials:' in sbox.yaml or use 'sbox auth --provider <provider> --default')")
+	}
+	return nil
+}
+
+// loadMergedProjectConfig loads the project config of a workspace merged with
+// its sbox.yaml file
+func loadMergedProjectConfig(workspaceDir string) (*sbox.ProjectConfig, error) {
+	projectConfig, _, err := sbox.GetProjectConfig(workspaceDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load project config: %w", err)
+	}
+	sboxFile, err := sbox.FindSboxFile(workspaceDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sbox.yaml file: %w", err)
+	}
+	return sbox.MergeProjectConfig(projectConfig, sboxFile)
+}
+
+// authCredentials prompts for the variables of a provider and stores them as
+// a named credential set
+func authCredentials(cmd *cobra.Command, provider, name string, makeDefault bool) error {
+	fields, err := sbox.CredentialFields(provider)
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		name = provider
+	}
+	if err := sbox.ValidateCredentialSetName(name); err != nil {
+		return err
+	}
+
+	config, err := sbox.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	vault, err := sbox.OpenVault(config)
+	if err != nil {
+		return fmt.Errorf("failed to open secret vault: %w", err)
+	}
+
+	if _, exists := config.Credentials[name]; exists {
+		cmd.Printf("Replacing credential set '%s'.\n", name)
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	var values []string
+	if provider == sbox.CredentialProviderCustom {
+		cmd.Println("Enter the variables as NAME=VALUE, one per line, and an empty line to finish.")
+		cmd.Println("Variables whose name contains KEY, TOKEN, SECRET, ... are stored in the sbox vault.")
+		for {
+			line, err := promptLine(cmd, reader, "> ")
+			if err == io.EOF || (err == nil && line == "") {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if err := sbox.ValidateEnvSpec(line); err != nil || !strings.Contains(line, "=") {
+				cmd.Println("Expected NAME=VALUE.")
+				continue
+			}
+			values = append(values, line)
+		}
+	} else {
+		cmd.Printf("Configuring the '%s' credential set (%s).\n", name, provider)
+		for _, field := range fields {
+			value, err := promptCredentialField(cmd, reader, field)
+			if err != nil {
+				return err
+			}
+			if value != "" {
+				values = append(values, field.Name+"="+value)
+			}
+		}
+	}
+	if len(values) == 0 {
+		return fmt.Errorf("no variables entered, credential set not saved")
+	}
+
+	if err := sbox.StoreCredentialSet(config, vault, name, provider, values, makeDefault); err != nil {
+		return err
+	}
+
+	cmd.Println()
+	cmd.Printf("Credential set '%s' saved.\n", name)
+	if config.DefaultCredentials == name {
+		cmd.Println("It is the default credential set, used by projects that don't select one.")
+	} else {
+		cmd.Printf("Select it in a project with 'credentials: %s' in sbox.yaml.\n", name)
+	}
+	return nil
+}
+
+// promptCredentialField prompts for a credential variable until a valid value
+// is entered. Fixed values are not prompted for.
+func promptCredentialField(cmd *cobra.Command, reader *bufio.Reader, field sbox.CredentialField) (string, error) {
+	if field.Value != "" {
+		return field.Value, nil
+	}
+
+	prompt := fmt.Sprintf("%s - %s", field.Name, field.Description)
+	if field.Default != "" {
+		prompt += fmt.Sprintf(" [%s]", field.Default)
+	}
+	prompt += ": "
+
+	for {
+		var value string
+		var err error
+		if field.Secret && term.IsTerminal(int(os.Stdin.Fd())) {
+			cmd.Print(prompt)
+			var secret []byte
+			secret, err = term.ReadPassword(int(os.Stdin.Fd()))
+			cmd.Println()
+			value = strings.TrimSpace(string(secret))
+		} else {
+			value, err = promptLine(cmd, reader, prompt)
+		}
+		if err == io.EOF {
+			if field.Required && field.Default == "" {
+				return "", fmt.Errorf("failed to read input: no value entered for %s", field.Name)
+			}
+			return field.Default, nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if value == "" {
+			value = field.Default
+		}
+		if value != "" || !field.Required {
+			return value, nil
+		}
+		cmd.Printf("%s is required.\n", field.Name)
+	}
+}
+
+// promptLine prints a prompt and reads a trimmed line. Returns io.EOF once
+// the input is exhausted.
+func promptLine(cmd *cobra.Command, reader *bufio.Reader, prompt string) (string, error) {
+	cmd.Print(prompt)
+	line, err := reader.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read input: %w", err)
+	}
+	return strings.TrimSpace(line), err
+}
+
+// authLogoutCredentials removes a credential set
+func authLogoutCredentials(cmd *cobra.Command, name string) error {
+	config, err := sbox.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	vault, err := sbox.OpenVault(config)
+	if err != nil {
+		return fmt.Errorf("failed to open secret vault: %w", err)
+	}
+
+	removed, err := sbox.RemoveCredentialSet(config, vault, name)
+	if err != nil {
+		return err
+	}
+	if !removed {
+		cmd.Printf("No credential set named '%s'.\n", name)
+		return nil
+	}
+
+	cmd.Printf("Credential set '%s' removed.\n", name)
 	return nil
 }
 
 // authLogout removes the stored API key
 func authLogout(cmd *cobra.Command) error {
-	config, err := sbox.LoadConfig()
+	store, err := sbox.NewConfigStore()
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
 	found := false
-	var remaining []string
-	for _, env := range config.Envs {
-		if sbox.EnvName(env) == "ANTHROPIC_API_KEY" {
+	err = store.UpdateConfig(func(config *sbox.Config) error {
+		var remaining []string
+		for _, env := range config.Envs {
+			if sbox.EnvName(env) == authEnvName {
+				found = true
+			} else {
+				remaining = append(remaining, env)
+			}
+		}
+		config.Envs = remaining
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	config := store.Config()
+
+	if sbox.VaultExists(config) {
+		vault, err := sbox.OpenVault(config)
+		if err != nil {
+			return fmt.Errorf("failed to open secret vault: %w", err)
+		}
+		if vault.Remove(sbox.VaultGlobalScope, authEnvName) {
 			found = true
-		} else {
-			remaining = append(remaining, env)
+			if err := vault.Save(); err != nil {
+				return err
+			}
 		}
 	}
 
@@ -92,11 +383,6 @@ func authLogout(cmd *cobra.Command) error {
 		return nil
 	}
 
-	config.Envs = remaining
-	if err := sbox.SaveConfig(config); err != nil {
-		return fmt.Errorf("failed to save config: %w", err)
-	}
-
 	// Clean up legacy credentials file if it exists
 	credentialsPath := sbox.GetCredentialsPath(config)
 	if err := os.Remove(credentialsPath); err != nil && !os.IsNotExist(err) {
@@ -106,26 +392,36 @@ func authLogout(cmd *cobra.Command) error {
 		cmd.Printf("Warning: failed to remove legacy token file: %v\n", err)
 	}
 
-	cmd.Println("API key removed from global config.")
+	cmd.Println("API key removed.")
 	return nil
 }
 
 // authLogin configures the API key
 func authLogin(cmd *cobra.Command) error {
-	config, err := sbox.LoadConfig()
+	store, err := sbox.NewConfigStore()
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
+	config := store.Config()
 
 	// Check if already configured
 	for _, env := range config.Envs {
-		if sbox.EnvName(env) == "ANTHROPIC_API_KEY" {
+		if sbox.EnvName(env) == authEnvName {
 			cmd.Println("API key is already configured. Use 'sbox auth --logout' first to reconfigure.")
 			return nil
 		}
 	}
 
-	cmd.Println("Enter your Anthropic API key (starts with sk-ant-):")
+	vault, err := sbox.OpenVault(config)
+	if err != nil {
+		return fmt.Errorf("failed to open secret vault: %w", err)
+	}
+	if _, ok := vault.Get(sbox.VaultGlobalScope, authEnvName); ok {
+		cmd.Println("API key is already configured. Use 'sbox auth --logout' first to reconfigure.")
+		return nil
+	}
+
+	cmd.Println("Enter your Anthropic API key (starts with sk-ant-) or a secret:// reference:")
 	cmd.Print("> ")
 
 	var apiKey string
@@ -138,10 +434,23 @@ func authLogin(cmd *cobra.Command) error {
 		return fmt.Errorf("API key cannot be empty")
 	}
 
-	// Store as global env
-	config.Envs = append(config.Envs, "ANTHROPIC_API_KEY="+apiKey)
-	if err := sbox.SaveConfig(config); err != nil {
-		return fmt.Errorf("failed to save config: %w", err)
+	if sbox.IsSecretRef(apiKey) {
+		// References hold no secret, store them as a global env
+		if err := sbox.ValidateEnvSpec(authEnvName + "=" + apiKey); err != nil {
+			return err
+		}
+		err := store.UpdateConfig(func(config *sbox.Config) error {
+			config.Envs = append(config.Envs, authEnvName+"="+apiKey)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to save config: %w", err)
+		}
+	} else {
+		vault.Set(sbox.VaultGlobalScope, authEnvName, apiKey)
+		if err := vault.Save(); err != nil {
+			return err
+		}
 	}
 
 	cmd.Println()
@@ -21,7 +21,7 @@ var BackendGroup = Group("backend", "Manage container backend configuration",
 		"Set the default backend globally",
 		Description(`
 			Sets the default container backend for all new sbox sessions.
-			Valid values: sandbox, container
+			Valid values: sandbox, container, podman
 
 			This can be overridden:
 			  - Per-project with sbox.yaml
@@ -58,17 +58,16 @@ func backendSetE(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	// Load config
-	config, err := sbox.LoadConfig()
+	store, err := sbox.NewConfigStore()
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	// Set default backend
-	config.DefaultBackend = backendName
-
-	// Save config
-	if err := sbox.SaveConfig(config); err != nil {
+	err = store.UpdateConfig(func(config *sbox.Config) error {
+		config.DefaultBackend = backendName
+		return nil
+	})
+	if err != nil {
 		return fmt.Errorf("failed to save config: %w", err)
 	}
 
@@ -14,6 +14,7 @@ import (
 // and resolving the backend that appears in shell, stop, and info commands.
 type WorkspaceContext struct {
 	WorkspaceDir  string
+	Store         *sbox.ConfigStore
 	Config        *sbox.Config
 	ProjectConfig *sbox.ProjectConfig
 	SboxFile      *sbox.SboxFileLocation
@@ -30,12 +31,13 @@ func LoadWorkspaceContext(cmd *cobra.Command) (*WorkspaceContext, error) {
 		return nil, err
 	}
 
-	config, err := sbox.LoadConfig()
+	store, err := sbox.NewConfigStore()
 	if err != nil {
 		return nil, fmt.Errorf("failed to load config: %w", err)
 	}
+	config := store.Config()
 
-	projectConfig, _, err := sbox.GetProjectConfig(workspaceDir)
+	projectConfig, _, err := store.ProjectConfig(workspaceDir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load project config: %w", err)
 	}
@@ -60,6 +62,7 @@ func LoadWorkspaceContext(cmd *cobra.Command) (*WorkspaceContext, error) {
 
 	return &WorkspaceContext{
 		WorkspaceDir:  workspaceDir,
+		Store:         store,
 		Config:        config,
 		ProjectConfig: projectConfig,
 		SboxFile:      sboxFile,
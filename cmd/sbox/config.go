@@ -2,28 +2,81 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	. "github.com/streamingfast/cli"
 	"github.com/streamingfast/sbox"
 )
 
-var ConfigCommand = Command(configE,
-	"config [key] [value]",
-	"View or edit configuration settings",
+var ConfigGroup = Group("config [key] [value]", "View or edit configuration settings",
 	Description(`
 		Without arguments, displays the current configuration.
 		With a key, displays that setting's value.
 		With key and value, sets the configuration option.
 	`),
+	Execute(configE),
+	RangeArgs(0, 2),
+	Command(configValidateE,
+		"validate [path]",
+		"Validate a sbox.yaml file or the global config",
+		Description(`
+			Checks a sbox.yaml file (the one found from the current directory by
+			default, or the one in the given directory) or the global config.yaml:
+			unknown fields, invalid values and unknown profiles are reported with
+			their line number.
+		`),
+		MaximumNArgs(1),
+	),
+	Command(configMigrateE,
+		"migrate [path]",
+		"Upgrade a sbox.yaml file or the global config to the current format",
+		Description(`
+			Rewrites a sbox.yaml file (the one found from the current directory
+			by default, or the one in the given directory) or the global
+			config.yaml in the current format version, keeping a backup of the
+			original in <file>.v<version>.bak.
+
+			Loading an older file only upgrades it in memory: sbox never
+			rewrites a sbox.yaml file by itself. The global and project configs
+			are written in the current format the next time sbox saves them.
+		`),
+		MaximumNArgs(1),
+	),
+	Command(configSchemaE,
+		"schema",
+		"Print the JSON Schema of sbox.yaml",
+		Description(`
+			Prints the JSON Schema of sbox.yaml, for editor completion and
+			validation.
+		`),
+		NoArgs(),
+	),
+	Command(configExplainE,
+		"explain",
+		"Show the effective sbox.yaml settings and the file each comes from",
+		Description(`
+			Prints the layers of the sbox.yaml file found from the workspace
+			(inherited and extended files) and each effective value with the
+			file it came from.
+		`),
+		NoArgs(),
+		Flags(func(flags *pflag.FlagSet) {
+			flags.StringP("workspace", "w", "", "Workspace directory (default: current directory)")
+		}),
+	),
 )
 
 // configE views or edits configuration
 func configE(cmd *cobra.Command, args []string) error {
-	config, err := sbox.LoadConfig()
+	store, err := sbox.NewConfigStore()
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
+	config := store.Config()
 
 	if len(args) == 0 {
 		// Show all configuration
@@ -34,6 +87,13 @@ func configE(cmd *cobra.Command, args []string) error {
 		cmd.Printf("  default_backend: %s\n", configValueOrDefault(config.DefaultBackend, string(sbox.DefaultBackend)))
 		cmd.Printf("  default_agent: %s\n", configValueOrDefault(config.DefaultAgent, string(sbox.DefaultAgent)))
 		cmd.Printf("  default_profiles: %v\n", config.DefaultProfiles)
+		cmd.Printf("  ssh: %s\n", configValueOrDefault(config.SSH, sbox.DefaultSSHMode))
+		if len(config.SSHAllowedKeys) > 0 {
+			cmd.Printf("  ssh_allowed_keys: %v\n", config.SSHAllowedKeys)
+		}
+		if !config.Resources.IsEmpty() {
+			cmd.Printf("  resources: %s\n", strings.Join(config.Resources.Describe(), ", "))
+		}
 		return nil
 	}
 
@@ -54,6 +114,10 @@ func configE(cmd *cobra.Command, args []string) error {
 			cmd.Println(configValueOrDefault(config.DefaultAgent, string(sbox.DefaultAgent)))
 		case "default_profiles":
 			cmd.Printf("%v\n", config.DefaultProfiles)
+		case "ssh":
+			cmd.Println(configValueOrDefault(config.SSH, sbox.DefaultSSHMode))
+		case "ssh_allowed_keys":
+			cmd.Printf("%v\n", config.SSHAllowedKeys)
 		default:
 			return fmt.Errorf("unknown config key: %s", key)
 		}
@@ -62,30 +126,49 @@ func configE(cmd *cobra.Command, args []string) error {
 
 	// Set value
 	value := args[1]
-	switch key {
-	case "claude_home":
-		config.ClaudeHome = value
-	case "docker_socket":
-		if value != "auto" && value != "always" && value != "never" {
-			return fmt.Errorf("docker_socket must be one of: auto, always, never")
-		}
-		config.DockerSocket = value
-	case "default_backend":
-		if err := sbox.ValidateBackend(value); err != nil {
-			return err
-		}
-		config.DefaultBackend = value
-	case "default_agent":
-		if err := sbox.ValidateAgent(value); err != nil {
-			return err
+	err = store.UpdateConfig(func(config *sbox.Config) error {
+		switch key {
+		case "claude_home":
+			config.ClaudeHome = value
+		case "docker_socket":
+			if err := sbox.ValidateDockerSocket(value); err != nil {
+				return err
+			}
+			config.DockerSocket = value
+		case "default_backend":
+			if err := sbox.ValidateBackend(value); err != nil {
+				return err
+			}
+			config.DefaultBackend = value
+		case "default_agent":
+			if err := sbox.ValidateAgent(value); err != nil {
+				return err
+			}
+			config.DefaultAgent = value
+		case "ssh":
+			if err := sbox.ValidateSSHMode(value); err != nil {
+				return err
+			}
+			config.SSH = value
+		case "ssh_allowed_keys":
+			// Comma-separated fingerprints, empty to expose all keys
+			var keys []string
+			for _, key := range strings.Split(value, ",") {
+				if key = strings.TrimSpace(key); key != "" {
+					keys = append(keys, key)
+				}
+			}
+			if err := sbox.ValidateSSHAllowedKeys(keys); err != nil {
+				return err
+			}
+			config.SSHAllowedKeys = keys
+		default:
+			return fmt.Errorf("cannot set config key: %s (read-only or unknown)", key)
 		}
-		config.DefaultAgent = value
-	default:
-		return fmt.Errorf("cannot set config key: %s (read-only or unknown)", key)
-	}
-
-	if err := sbox.SaveConfig(config); err != nil {
-		return fmt.Errorf("failed to save config: %w", err)
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	cmd.Printf("Set %s = %s\n", key, value)
@@ -98,3 +181,140 @@ func configValueOrDefault(value, defaultValue string) string {
 	}
 	return value
 }
+
+// configValidateE validates a sbox.yaml file, or the global config.yaml
+func configValidateE(cmd *cobra.Command, args []string) error {
+	config, err := sbox.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	path, err := configFilePath(args)
+	if err != nil {
+		return err
+	}
+
+	if err := sbox.ValidateConfigFile(config, path); err != nil {
+		return err
+	}
+
+	cmd.Printf("%s is valid\n", path)
+	return nil
+}
+
+// configMigrateE upgrades a sbox.yaml file, or the global config.yaml, to the
+// current format
+func configMigrateE(cmd *cobra.Command, args []string) error {
+	config, err := sbox.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	path, err := configFilePath(args)
+	if err != nil {
+		return err
+	}
+	if path, err = filepath.Abs(path); err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	kind := sbox.ConfigKindSboxFile
+	if path == sbox.GlobalConfigPath(config) {
+		kind = sbox.ConfigKindGlobal
+	}
+
+	from, backupPath, err := sbox.MigrateConfigFile(kind, path)
+	if err != nil {
+		return err
+	}
+	if backupPath == "" {
+		cmd.Printf("%s needs no changes\n", path)
+		return nil
+	}
+
+	cmd.Printf("Upgraded %s from version %d to %d (backup: %s)\n", path, from, sbox.CurrentConfigVersion(kind), backupPath)
+	return nil
+}
+
+// configFilePath returns the config file given as argument (a directory
+// meaning its sbox.yaml), or the sbox.yaml found from the current directory
+func configFilePath(args []string) (string, error) {
+	if len(args) > 0 {
+		path := args[0]
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			path = filepath.Join(path, sbox.SboxFileName)
+		}
+		return path, nil
+	}
+
+	workspaceDir, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current directory: %w", err)
+	}
+	sboxFile, err := sbox.FindSboxFile(workspaceDir)
+	if err != nil {
+		return "", err
+	}
+	if sboxFile == nil {
+		return "", fmt.Errorf("no %s file found in %s or its parents", sbox.SboxFileName, workspaceDir)
+	}
+	return sboxFile.Path, nil
+}
+
+// configSchemaE prints the JSON Schema of sbox.yaml
+func configSchemaE(cmd *cobra.Command, args []string) error {
+	cmd.Print(sbox.SboxFileSchemaJSON)
+	return nil
+}
+
+// configExplainE prints the effective sbox.yaml values with their file
+func configExplainE(cmd *cobra.Command, args []string) error {
+	workspaceDir, err := getWorkspaceDir(cmd)
+	if err != nil {
+		return err
+	}
+	if workspaceDir, err = filepath.Abs(workspaceDir); err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	sboxFile, err := sbox.FindSboxFile(workspaceDir)
+	if err != nil {
+		return err
+	}
+	if sboxFile == nil {
+		cmd.Printf("No %s file found in %s or its parents.\n", sbox.SboxFileName, workspaceDir)
+		return nil
+	}
+
+	displayPath := func(path string) string {
+		if rel, err := filepath.Rel(workspaceDir, path); err == nil {
+			return rel
+		}
+		return path
+	}
+
+	cmd.Println("Layers (lowest precedence first):")
+	for i, layer := range sboxFile.Layers {
+		cmd.Printf("  %d. %s\n", i+1, displayPath(layer))
+	}
+	cmd.Println()
+
+	values := sboxFile.Explain()
+	if len(values) == 0 {
+		cmd.Println("No settings.")
+		return nil
+	}
+
+	cmd.Println("Effective settings:")
+	for i, value := range values {
+		if value.List {
+			if i == 0 || values[i-1].Field != value.Field {
+				cmd.Printf("  %s:\n", value.Field)
+			}
+			cmd.Printf("    - %s  [%s]\n", value.Value, displayPath(value.Source))
+			continue
+		}
+		cmd.Printf("  %s: %s  [%s]\n", value.Field, value.Value, displayPath(value.Source))
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"slices"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -19,6 +20,17 @@ var EnvGroup = Group("env", "Manage environment variables for the sandbox",
 	Command(envAddE,
 		"add <envs...>",
 		"Add environment variables (NAME for host passthrough, NAME=VALUE for explicit)",
+		Description(`
+			Adds environment variables passed to the sandbox.
+
+			The value can be a secret reference, resolved on the host each time
+			the sandbox starts and never written to the sbox config:
+			  - secret://pass/<path>      first line of 'pass show <path>'
+			  - secret://file/<path>      content of a file (~ is expanded)
+			  - secret://cmd/<command>    output of a shell command
+
+			Example: sbox env add GITHUB_TOKEN=secret://pass/github/token
+		`),
 		MinimumNArgs(1),
 		Flags(func(flags *pflag.FlagSet) {
 			flags.Bool("global", false, "Add to global config (shared across all projects)")
@@ -41,12 +53,13 @@ func envListE(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to get current directory: %w", err)
 	}
 
-	config, err := sbox.LoadConfig()
+	store, err := sbox.NewConfigStore()
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
+	config := store.Config()
 
-	projectConfig, _, err := sbox.GetProjectConfig(workspaceDir)
+	projectConfig, _, err := store.ProjectConfig(workspaceDir)
 	if err != nil {
 		return fmt.Errorf("failed to load project config: %w", err)
 	}
@@ -57,13 +70,29 @@ func envListE(cmd *cobra.Command, args []string) error {
 	}
 
 	var sboxEnvs []string
+	secretEnvs := sbox.ResolveSecretEnvs(config, projectConfig)
 	if sboxFile != nil && sboxFile.Config != nil {
 		sboxEnvs = sboxFile.Config.Envs
+		secretEnvs = append(secretEnvs, sboxFile.Config.SecretEnvs...)
 	}
 
 	_, resolved := sbox.MergeEnvs(config.Envs, projectConfig.Envs, sboxEnvs)
+	sboxFile.LabelEnvSources(resolved)
+
+	// Vault secrets fill in the variables the configs don't set
+	vaultEnvs, err := sbox.LoadVaultEnvs(config, workspaceDir)
+	if err != nil {
+		return fmt.Errorf("failed to load secret vault: %w", err)
+	}
+	var vaultNames []string
+	for _, env := range vaultEnvs {
+		name := sbox.EnvName(env)
+		if !slices.ContainsFunc(resolved, func(r sbox.ResolvedEnv) bool { return sbox.EnvName(r.Spec) == name }) {
+			vaultNames = append(vaultNames, name)
+		}
+	}
 
-	if len(resolved) == 0 {
+	if len(resolved) == 0 && len(vaultNames) == 0 {
 		cmd.Println("No environment variables configured.")
 		cmd.Println("Use 'sbox env add NAME=VALUE' or 'sbox env add --global NAME' to add one.")
 		return nil
@@ -71,7 +100,10 @@ func envListE(cmd *cobra.Command, args []string) error {
 
 	cmd.Println("Environment variables:")
 	cmd.Println()
-	printResolvedEnvs(cmd, resolved, "  ")
+	printResolvedEnvs(cmd, resolved, secretEnvs, "  ")
+	for _, name := range vaultNames {
+		cmd.Printf("  %s  (secret, from the sbox vault)  [vault]\n", name)
+	}
 
 	return nil
 }
@@ -87,33 +119,22 @@ func envAddE(cmd *cobra.Command, args []string) error {
 }
 
 func envAddGlobal(cmd *cobra.Command, args []string) error {
-	config, err := sbox.LoadConfig()
-	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
-	}
-
-	envMap := make(map[string]int)
-	for i, e := range config.Envs {
-		envMap[sbox.EnvName(e)] = i
-	}
-
 	for _, arg := range args {
-		name := sbox.EnvName(arg)
-		if name == "" {
-			return fmt.Errorf("invalid environment variable: %q", arg)
+		if err := sbox.ValidateEnvSpec(arg); err != nil {
+			return err
 		}
+	}
 
-		if idx, exists := envMap[name]; exists {
-			config.Envs[idx] = arg
-			cmd.Printf("Updated '%s' (global)\n", name)
-		} else {
-			config.Envs = append(config.Envs, arg)
-			envMap[name] = len(config.Envs) - 1
-			cmd.Printf("Added '%s' (global)\n", name)
-		}
+	store, err := sbox.NewConfigStore()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	if err := sbox.SaveConfig(config); err != nil {
+	err = store.UpdateConfig(func(config *sbox.Config) error {
+		config.Envs = addEnvs(cmd, config.Envs, args, "global")
+		return nil
+	})
+	if err != nil {
 		return fmt.Errorf("failed to save config: %w", err)
 	}
 
@@ -122,43 +143,53 @@ func envAddGlobal(cmd *cobra.Command, args []string) error {
 }
 
 func envAddProject(cmd *cobra.Command, args []string) error {
+	for _, arg := range args {
+		if err := sbox.ValidateEnvSpec(arg); err != nil {
+			return err
+		}
+	}
+
 	workspaceDir, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("failed to get current directory: %w", err)
 	}
 
-	projectConfig, _, err := sbox.GetProjectConfig(workspaceDir)
+	store, err := sbox.NewConfigStore()
 	if err != nil {
-		return fmt.Errorf("failed to load project config: %w", err)
+		return fmt.Errorf("failed to load config: %w", err)
 	}
 
+	_, err = store.UpdateProjectConfig(workspaceDir, func(projectConfig *sbox.ProjectConfig) error {
+		projectConfig.Envs = addEnvs(cmd, projectConfig.Envs, args, "project")
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save project config: %w", err)
+	}
+
+	cmd.Println("Environment changes will take effect on next 'sbox run' (no --recreate needed).")
+	return nil
+}
+
+// addEnvs adds or updates env specs by name, reporting each change
+func addEnvs(cmd *cobra.Command, envs []string, specs []string, scope string) []string {
 	envMap := make(map[string]int)
-	for i, e := range projectConfig.Envs {
+	for i, e := range envs {
 		envMap[sbox.EnvName(e)] = i
 	}
 
-	for _, arg := range args {
-		name := sbox.EnvName(arg)
-		if name == "" {
-			return fmt.Errorf("invalid environment variable: %q", arg)
-		}
-
+	for _, spec := range specs {
+		name := sbox.EnvName(spec)
 		if idx, exists := envMap[name]; exists {
-			projectConfig.Envs[idx] = arg
-			cmd.Printf("Updated '%s' (project)\n", name)
+			envs[idx] = spec
+			cmd.Printf("Updated '%s' (%s)\n", name, scope)
 		} else {
-			projectConfig.Envs = append(projectConfig.Envs, arg)
-			envMap[name] = len(projectConfig.Envs) - 1
-			cmd.Printf("Added '%s' (project)\n", name)
+			envs = append(envs, spec)
+			envMap[name] = len(envs) - 1
+			cmd.Printf("Added '%s' (%s)\n", name, scope)
 		}
 	}
-
-	if err := sbox.SaveProjectConfig(workspaceDir, projectConfig); err != nil {
-		return fmt.Errorf("failed to save project config: %w", err)
-	}
-
-	cmd.Println("Environment changes will take effect on next 'sbox run' (no --recreate needed).")
-	return nil
+	return envs
 }
 
 // envRemoveE removes environment variables from the current project or global config
@@ -172,25 +203,18 @@ func envRemoveE(cmd *cobra.Command, args []string) error {
 }
 
 func envRemoveGlobal(cmd *cobra.Command, args []string) error {
-	config, err := sbox.LoadConfig()
+	store, err := sbox.NewConfigStore()
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	removeSet := make(map[string]bool)
-	for _, name := range args {
-		removeSet[name] = true
-	}
-
-	var kept []string
 	removed := 0
-	for _, env := range config.Envs {
-		if removeSet[sbox.EnvName(env)] {
-			cmd.Printf("Removed '%s' (global)\n", sbox.EnvName(env))
-			removed++
-		} else {
-			kept = append(kept, env)
-		}
+	err = store.UpdateConfig(func(config *sbox.Config) error {
+		config.Envs, removed = removeEnvs(cmd, config.Envs, args, "global")
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
 	}
 
 	if removed == 0 {
@@ -198,12 +222,6 @@ func envRemoveGlobal(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	config.Envs = kept
-
-	if err := sbox.SaveConfig(config); err != nil {
-		return fmt.Errorf("failed to save config: %w", err)
-	}
-
 	cmd.Println("Environment changes will take effect on next 'sbox run' (no --recreate needed).")
 	return nil
 }
@@ -214,56 +232,70 @@ func envRemoveProject(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to get current directory: %w", err)
 	}
 
-	projectConfig, _, err := sbox.GetProjectConfig(workspaceDir)
+	store, err := sbox.NewConfigStore()
 	if err != nil {
-		return fmt.Errorf("failed to load project config: %w", err)
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	removed := 0
+	_, err = store.UpdateProjectConfig(workspaceDir, func(projectConfig *sbox.ProjectConfig) error {
+		projectConfig.Envs, removed = removeEnvs(cmd, projectConfig.Envs, args, "project")
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save project config: %w", err)
+	}
+
+	if removed == 0 {
+		cmd.Println("No matching project environment variables found.")
+		return nil
 	}
 
+	cmd.Println("Environment changes will take effect on next 'sbox run' (no --recreate needed).")
+	return nil
+}
+
+// removeEnvs removes env specs by name, reporting each removal. Returns the
+// kept specs and the number of removed ones.
+func removeEnvs(cmd *cobra.Command, envs []string, names []string, scope string) ([]string, int) {
 	removeSet := make(map[string]bool)
-	for _, name := range args {
+	for _, name := range names {
 		removeSet[name] = true
 	}
 
 	var kept []string
 	removed := 0
-	for _, env := range projectConfig.Envs {
+	for _, env := range envs {
 		if removeSet[sbox.EnvName(env)] {
-			cmd.Printf("Removed '%s' (project)\n", sbox.EnvName(env))
+			cmd.Printf("Removed '%s' (%s)\n", sbox.EnvName(env), scope)
 			removed++
 		} else {
 			kept = append(kept, env)
 		}
 	}
-
-	if removed == 0 {
-		cmd.Println("No matching project environment variables found.")
-		return nil
-	}
-
-	projectConfig.Envs = kept
-
-	if err := sbox.SaveProjectConfig(workspaceDir, projectConfig); err != nil {
-		return fmt.Errorf("failed to save project config: %w", err)
-	}
-
-	cmd.Println("Environment changes will take effect on next 'sbox run' (no --recreate needed).")
-	return nil
+	return kept, removed
 }
 
 // printResolvedEnvs prints resolved environment variables with source tags and host resolution hints.
 // The prefix is prepended to each line for indentation.
-// Sensitive values (containing KEY, TOKEN, SECRET, etc.) are masked by default.
-func printResolvedEnvs(cmd *cobra.Command, resolved []sbox.ResolvedEnv, prefix string) {
+// Sensitive values (containing KEY, TOKEN, SECRET, etc. or listed in secretEnvs) are masked by default,
+// secret:// references are shown as-is since they are only resolved at launch.
+func printResolvedEnvs(cmd *cobra.Command, resolved []sbox.ResolvedEnv, secretEnvs []string, prefix string) {
 	hasPassthrough := false
 	hasUnset := false
 
 	for _, r := range resolved {
 		name := sbox.EnvName(r.Spec)
 		sourceTag := fmt.Sprintf("  [%s]", r.Source)
-		isSensitive := sbox.IsSensitiveEnvName(name)
+		isSensitive := sbox.IsSecretEnv(name, secretEnvs)
 
 		if strings.Contains(r.Spec, "=") {
 			value := r.Spec[len(name)+1:]
+			if sbox.IsSecretRef(value) {
+				cmd.Printf("%s%s=%s  (secret, resolved at launch)%s\n", prefix, name, value, sourceTag)
+				continue
+			}
+
 			displayValue := value
 			if isSensitive {
 				displayValue = sbox.MaskEnvValue(value)
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -19,10 +20,14 @@ var InfoCommand = Command(infoE,
 
 		Without flags, shows the current project's status including:
 		- Workspace path and hash
-		- Backend type (sandbox or container)
+		- Backend type (sandbox, container or podman)
 		- Running status (stopped/running with container info)
 		- Configured profiles
 		- Additional volumes
+		- Resource limits
+		- Network policy and recently denied requests
+		- Published ports
+		- Active credential set
 		- Docker socket setting
 
 		With --all, lists all known projects that have been used with sbox.
@@ -110,15 +115,25 @@ func infoCurrentProject(cmd *cobra.Command) error {
 	// Show merged envs with sources
 	{
 		var sboxEnvs []string
-		if sboxFile, err := sbox.FindSboxFile(workspaceDir); err == nil && sboxFile != nil && sboxFile.Config != nil {
+		secretEnvs := sbox.ResolveSecretEnvs(config, project.Config)
+		if sboxFile != nil && sboxFile.Config != nil {
 			sboxEnvs = sboxFile.Config.Envs
+			secretEnvs = append(secretEnvs, sboxFile.Config.SecretEnvs...)
 		}
 		_, resolved := sbox.MergeEnvs(config.Envs, project.Config.Envs, sboxEnvs)
+		sboxFile.LabelEnvSources(resolved)
 		if len(resolved) > 0 {
 			cmd.Printf("  Envs:\n")
-			printResolvedEnvs(cmd, resolved, "    ")
+			printResolvedEnvs(cmd, resolved, secretEnvs, "    ")
 		}
 	}
+	if mergedConfig, err := sbox.MergeProjectConfig(project.Config, sboxFile); err == nil {
+		printResourceLimits(cmd, sbox.ResolveResourceLimits(config, mergedConfig), backendType, "  ")
+		printNetworkPolicy(cmd, workspaceDir, mergedConfig.Network, backendType, "  ")
+		printPorts(cmd, mergedConfig.Ports, backendType, "  ")
+		printSSH(cmd, sbox.ResolveSSHSettings(config, mergedConfig), backendType, "  ")
+		printCredentials(cmd, config, mergedConfig, "  ")
+	}
 	if project.Config.DockerSocket != "" {
 		cmd.Printf("  Docker:   %s\n", project.Config.DockerSocket)
 	}
@@ -164,7 +179,7 @@ func infoAllProjects(cmd *cobra.Command) error {
 			pathDisplay = "(unknown path - legacy project)"
 		} else {
 			// Check if workspace path still exists
-			if _, err := os.Stat(workspacePath); os.IsNotExist(err) {
+			if project.Missing {
 				pathDisplay += " (path not found)"
 			}
 		}
@@ -179,6 +194,9 @@ func infoAllProjects(cmd *cobra.Command) error {
 
 		cmd.Printf("  %s\n", pathDisplay)
 		cmd.Printf("    Hash:    %s\n", project.Hash)
+		if project.MovedTo != "" {
+			cmd.Printf("    Moved:   likely to %s, run 'sbox project move --force %s %s'\n", project.MovedTo, workspacePath, project.MovedTo)
+		}
 		cmd.Printf("    Backend: %s\n", projectBackendType)
 
 		if workspacePath != "" {
@@ -204,7 +222,15 @@ func infoAllProjects(cmd *cobra.Command) error {
 			_, resolved := sbox.MergeEnvs(globalConfig.Envs, project.Config.Envs, nil)
 			if len(resolved) > 0 {
 				cmd.Printf("    Envs:\n")
-				printResolvedEnvs(cmd, resolved, "      ")
+				printResolvedEnvs(cmd, resolved, sbox.ResolveSecretEnvs(globalConfig, project.Config), "      ")
+			}
+		}
+		if globalConfig != nil {
+			if mergedConfig, err := sbox.MergeProjectConfig(project.Config, projectSboxFile); err == nil {
+				printResourceLimits(cmd, sbox.ResolveResourceLimits(globalConfig, mergedConfig), projectBackendType, "    ")
+				printNetworkPolicy(cmd, workspacePath, mergedConfig.Network, projectBackendType, "    ")
+				printPorts(cmd, mergedConfig.Ports, projectBackendType, "    ")
+				printSSH(cmd, sbox.ResolveSSHSettings(globalConfig, mergedConfig), projectBackendType, "    ")
 			}
 		}
 		if project.Config.DockerSocket != "" {
@@ -280,6 +306,79 @@ func printContainerStatus(cmd *cobra.Command, workspaceDir string, containerName
 	cmd.Printf("%s  Status: not created\n", prefix)
 }
 
+// printResourceLimits prints the resource limits, if any are set
+func printResourceLimits(cmd *cobra.Command, resources *sbox.ResourceLimits, backendType sbox.BackendType, prefix string) {
+	if resources.IsEmpty() {
+		return
+	}
+
+	note := ""
+	if backendType == sbox.BackendSandbox {
+		note = " (not enforced by the sandbox backend)"
+	}
+	cmd.Printf("%sResources: %s%s\n", prefix, strings.Join(resources.Describe(), ", "), note)
+}
+
+// printPorts prints the published ports, if any
+func printPorts(cmd *cobra.Command, ports []string, backendType sbox.BackendType, prefix string) {
+	if len(ports) == 0 {
+		return
+	}
+
+	note := ""
+	if backendType == sbox.BackendSandbox {
+		note = " (not published by the sandbox backend, use 'sbox port-forward')"
+	}
+	cmd.Printf("%sPorts:     %s%s\n", prefix, strings.Join(ports, ", "), note)
+}
+
+// printSSH prints the SSH access mode
+func printSSH(cmd *cobra.Command, settings sbox.SSHSettings, backendType sbox.BackendType, prefix string) {
+	note := ""
+	if backendType == sbox.BackendSandbox && settings.Mode == sbox.SSHModeKeys {
+		note = " (not supported by the sandbox backend)"
+	}
+	cmd.Printf("%sSSH:       %s%s\n", prefix, settings.Describe(), note)
+}
+
+// printCredentials prints the credential set used by the project, if any
+func printCredentials(cmd *cobra.Command, config *sbox.Config, projectConfig *sbox.ProjectConfig, prefix string) {
+	name := sbox.ResolveCredentialSetName(config, projectConfig)
+	if name == "" {
+		return
+	}
+
+	set, ok := config.Credentials[name]
+	if !ok {
+		cmd.Printf("%sCredentials: %s (not configured, run 'sbox auth --provider <provider> --name %s')\n", prefix, name, name)
+		return
+	}
+	cmd.Printf("%sCredentials: %s - %s\n", prefix, name, set.Describe())
+}
+
+// printNetworkPolicy prints the network policy, if any, and the last denied requests
+func printNetworkPolicy(cmd *cobra.Command, workspaceDir string, policy *sbox.NetworkPolicy, backendType sbox.BackendType, prefix string) {
+	if policy.EffectiveMode() != sbox.NetworkModeOpen {
+		note := ""
+		if backendType == sbox.BackendSandbox {
+			note = " (not enforced by the sandbox backend)"
+		}
+		cmd.Printf("%sNetwork:   %s%s\n", prefix, policy.Describe(), note)
+	}
+
+	if workspaceDir == "" {
+		return
+	}
+	count, last, err := sbox.ReadNetworkLog(workspaceDir, 5)
+	if err != nil || count == 0 {
+		return
+	}
+	cmd.Printf("%s  Denied requests: %d (see .sbox/%s)\n", prefix, count, sbox.NetworkLogFileName)
+	for _, line := range last {
+		cmd.Printf("%s    %s\n", prefix, line)
+	}
+}
+
 // printSandboxCommands prints the docker sandbox create and run commands.
 func printSandboxCommands(cmd *cobra.Command, opts sbox.SandboxOptions, prefix string) {
 	commands, err := sbox.BuildSandboxCommands(opts)
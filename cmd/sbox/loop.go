@@ -2,9 +2,11 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/cobra"
@@ -37,49 +39,133 @@ var LoopCommand = Command(loopE,
 
 		The loop stops only after the agent confirms completion twice in a row,
 		ensuring the goal is truly achieved.
+
+		With --verify (repeatable, or 'loop.verify' in sbox.yaml), the given
+		commands run in the sandbox from the workspace root after each
+		iteration, e.g. sbox loop --verify "go test ./..." "fix the tests".
+		A completion only counts when every command exits 0, and the output of
+		the failing ones is fed back to the agent in the next iteration.
+
+		Budgets bound the whole loop: --max-cost (in USD), --max-tokens and
+		--max-duration (e.g. 2h). Cost and tokens are the ones reported by the
+		agent. They are checked between iterations, the loop stops cleanly
+		before the next iteration once one is reached.
+
+		Each loop run gets an ID, its state (prompt, settings, iterations,
+		completion streak, usage) being kept in .sbox/loops/<id>/run.yaml and
+		updated after each iteration. 'sbox loop --resume [id]' continues a
+		run that didn't complete (the last one by default) where it stopped,
+		e.g. after Ctrl+C, with the same settings: only the flags given
+		explicitly change them, e.g. a higher --max-cost. The settings are
+		read back from the sbox data directory, where the agent can't change
+		them. The raw agent stream
+		of each iteration is saved in iter-<n>.jsonl, summarized with the run
+		by report.md and report.json, shown by 'sbox loop report'.
+
+		With --checkpoint (the workspace must be a git repository), the
+		workspace is recorded after each iteration as a git commit referenced
+		by refs/sbox/loop/<id>/<n>, without touching the branch or the index,
+		checkpoint 0 being the workspace before the first iteration. Restore
+		one with 'sbox loop rollback <n>'.
 	`),
 	MaximumNArgs(1),
+	Command(loopRollbackE,
+		"rollback <n>",
+		"Restore the workspace to its checkpoint after a loop iteration",
+		Description(`
+			Restores the working tree of the workspace to the checkpoint
+			recorded after iteration <n> of a 'sbox loop --checkpoint' run (the
+			last run by default), 0 being the workspace before the first
+			iteration. Files are restored to their content at the checkpoint
+			and the ones created since are removed. The branch, the index and
+			the .sbox directory are left untouched.
+
+			The workspace before the rollback is kept as a commit referenced by
+			refs/sbox/loop/<id>/before-rollback.
+		`),
+		ExactArgs(1),
+		Flags(func(flags *pflag.FlagSet) {
+			flags.String("run", "", "ID of the loop run (default: the last one)")
+			flags.StringP("workspace", "w", "", "Workspace directory (default: current directory)")
+		}),
+	),
+	Command(loopReportE,
+		"report",
+		"Show the report of a loop run",
+		Description(`
+			Prints the report of a loop run (the last one by default): its
+			status and usage, then for each iteration its duration, turns,
+			tokens, cost, tools used, files edited, verification outcome and
+			the content of the completion file written by the agent.
+
+			The report is kept up to date in .sbox/loops/<id>/report.md and
+			report.json, next to the raw agent stream of each iteration
+			(iter-<n>.jsonl).
+		`),
+		NoArgs(),
+		Flags(func(flags *pflag.FlagSet) {
+			flags.String("run", "", "ID of the loop run (default: the last one)")
+			flags.Bool("json", false, "Print the report as JSON")
+			flags.StringP("workspace", "w", "", "Workspace directory (default: current directory)")
+		}),
+	),
 	Flags(func(flags *pflag.FlagSet) {
 		flags.Bool("docker-socket", false, "Mount Docker socket into sandbox/container")
-		flags.StringSlice("profile", nil, "Additional profiles to use for this session")
+		flags.StringSlice("profile", nil, "Additional profiles to use for this session (e.g. go@1.23.2)")
 		flags.Bool("recreate", false, "Force rebuild of custom template image and recreate sandbox/container (pulls latest base image)")
 		flags.StringP("workspace", "w", "", "Workspace directory (default: current directory)")
 		flags.Bool("debug", false, "Enable debug mode for docker commands")
-		flags.String("backend", "", "Backend type: 'sandbox' (default) or 'container'")
+		flags.String("backend", "", "Backend type: 'sandbox' (default), 'container' or 'podman'")
 		flags.String("agent", "", "Agent type: 'claude' (default) or 'opencode'")
 		flags.Int("max-iterations", 0, "Maximum number of loop iterations (0 = unlimited)")
 		flags.Int("confirmations", 0, "Number of consecutive goal completions required (default: 2, override via sbox.yaml or global config)")
+		flags.Float64("max-cost", 0, "Stop the loop once the agent cost reaches this amount in USD (0 = unlimited)")
+		flags.Int("max-tokens", 0, "Stop the loop once the agent tokens reach this count (0 = unlimited)")
+		flags.Duration("max-duration", 0, "Stop the loop once it has run for this duration, e.g. 2h (0 = unlimited)")
+		flags.Bool("resume", false, "Resume the loop run of the ID given in place of the prompt, the last one by default")
+		flags.Bool("checkpoint", false, "Record the workspace as a git commit after each iteration, restored by 'sbox loop rollback'")
+		flags.StringArray("verify", nil, "Command that must exit 0 for the goal to be complete, run in the sandbox after each iteration (repeatable, overrides loop.verify of sbox.yaml)")
 	}),
 )
 
 func loopE(cmd *cobra.Command, args []string) error {
-	userPrompt, err := resolveLoopPrompt(args)
+	workspaceDir, err := loopWorkspaceDir(cmd)
 	if err != nil {
 		return err
 	}
 
-	zlog.Debug("starting sbox loop command", zap.String("prompt", userPrompt))
-
-	workspaceDir, err := cmd.Flags().GetString("workspace")
+	store, err := sbox.NewConfigStore()
 	if err != nil {
-		return fmt.Errorf("failed to get workspace flag: %w", err)
+		return fmt.Errorf("failed to load config: %w", err)
 	}
-	if workspaceDir == "" {
-		workspaceDir, err = os.Getwd()
+	config := store.Config()
+
+	resume, _ := cmd.Flags().GetBool("resume")
+	var run *sbox.LoopRun
+	if resume {
+		run, err = loadResumedLoopRun(config.SboxDataDir, workspaceDir, args)
 		if err != nil {
-			return fmt.Errorf("failed to get current directory: %w", err)
+			return err
 		}
+	} else {
+		userPrompt, err := resolveLoopPrompt(args)
+		if err != nil {
+			return err
+		}
+		run = sbox.NewLoopRun()
+		run.Prompt = userPrompt
 	}
 
-	config, err := sbox.LoadConfig()
-	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
-	}
+	zlog.Debug("starting sbox loop command", zap.String("run_id", run.ID), zap.String("prompt", run.Prompt), zap.Bool("resume", resume))
 
-	projectConfig, _, err := sbox.GetProjectConfig(workspaceDir)
+	projectConfig, _, err := store.ProjectConfig(workspaceDir)
 	if err != nil {
 		return fmt.Errorf("failed to load project config: %w", err)
 	}
+	projectConfig, err = followProjectMove(store, workspaceDir, projectConfig)
+	if err != nil {
+		return err
+	}
 
 	sboxFile, err := sbox.FindSboxFile(workspaceDir)
 	if err != nil {
@@ -96,8 +182,22 @@ func loopE(cmd *cobra.Command, args []string) error {
 	debug, _ := cmd.Flags().GetBool("debug")
 	backendFlag, _ := cmd.Flags().GetString("backend")
 	agentFlag, _ := cmd.Flags().GetString("agent")
-	maxIterations, _ := cmd.Flags().GetInt("max-iterations")
-	confirmationsFlag, _ := cmd.Flags().GetInt("confirmations")
+	if err := applyLoopFlags(cmd, run, sboxFile, config, resume); err != nil {
+		return err
+	}
+	if run.Checkpointing {
+		if err := sbox.ValidateLoopCheckpoint(workspaceDir); err != nil {
+			return err
+		}
+	}
+
+	// A resumed run keeps its backend and agent
+	if resume && backendFlag == "" {
+		backendFlag = run.Backend
+	}
+	if resume && agentFlag == "" {
+		agentFlag = run.Agent
+	}
 
 	if backendFlag != "" {
 		if err := sbox.ValidateBackend(backendFlag); err != nil {
@@ -115,6 +215,8 @@ func loopE(cmd *cobra.Command, args []string) error {
 
 	projectConfig.Backend = string(backendType)
 	projectConfig.Agent = string(agentType)
+	run.Backend = string(backendType)
+	run.Agent = string(agentType)
 
 	backend, err := sbox.GetBackend(string(backendType), config)
 	if err != nil {
@@ -122,20 +224,11 @@ func loopE(cmd *cobra.Command, args []string) error {
 	}
 
 	// Generate sandbox name (same logic as run command)
-	needsRegeneration := projectConfig.SandboxName == ""
-	if !needsRegeneration && projectConfig.SandboxName != "" {
-		expectedPrefix := "sbox-" + string(agentType) + "-"
-		if !strings.HasPrefix(projectConfig.SandboxName, expectedPrefix) {
-			needsRegeneration = true
-		}
-	}
-	if needsRegeneration {
-		sandboxName, err := sbox.GenerateSandboxName(workspaceDir, agentType)
-		if err != nil {
-			return fmt.Errorf("failed to generate sandbox name: %w", err)
-		}
-		projectConfig.SandboxName = sandboxName
+	sandboxName, err := sbox.GenerateSandboxName(workspaceDir, agentType)
+	if err != nil {
+		return fmt.Errorf("failed to generate sandbox name: %w", err)
 	}
+	projectConfig.SandboxName = sandboxName
 
 	if recreate {
 		existing, err := backend.Find(workspaceDir)
@@ -155,21 +248,46 @@ func loopE(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	if err := sbox.SaveProjectConfig(workspaceDir, projectConfig); err != nil {
+	_, err = store.UpdateProjectConfig(workspaceDir, func(saved *sbox.ProjectConfig) error {
+		saved.Backend = projectConfig.Backend
+		saved.Agent = projectConfig.Agent
+		return nil
+	})
+	if err != nil {
 		zlog.Warn("failed to save project config", zap.Error(err))
 	}
 
-	// Resolve loop confirmations: CLI flag > sbox.yaml > global config > default (2)
-	loopConfirmations := sbox.ResolveLoopConfirmations(confirmationsFlag, sboxFile, config)
+	if err := sbox.SaveLoopSettings(config.SboxDataDir, workspaceDir, run); err != nil {
+		return err
+	}
+	if err := run.Save(workspaceDir); err != nil {
+		return err
+	}
 
 	ui := sbox.DefaultUI
+	ui.Label("Run", run.ID)
+	if resume {
+		ui.Label("Resuming", fmt.Sprintf("after iteration %d", run.Iteration))
+	}
 	ui.Label("Backend", string(backend.Name()))
-	ui.Label("Goal", userPrompt)
-	if maxIterations > 0 {
-		ui.Label("Max iterations", fmt.Sprintf("%d", maxIterations))
+	ui.Label("Goal", run.Prompt)
+	if run.MaxIterations > 0 {
+		ui.Label("Max iterations", fmt.Sprintf("%d", run.MaxIterations))
 	}
-	if loopConfirmations != 2 {
-		ui.Label("Confirmations", fmt.Sprintf("%d", loopConfirmations))
+	if run.Budget.MaxCostUSD > 0 {
+		ui.Label("Max cost", fmt.Sprintf("$%.2f", run.Budget.MaxCostUSD))
+	}
+	if run.Budget.MaxTokens > 0 {
+		ui.Label("Max tokens", fmt.Sprintf("%d", run.Budget.MaxTokens))
+	}
+	if run.Budget.MaxDuration > 0 {
+		ui.Label("Max duration", run.Budget.MaxDuration.String())
+	}
+	if run.Confirmations != 2 {
+		ui.Label("Confirmations", fmt.Sprintf("%d", run.Confirmations))
+	}
+	for _, check := range run.Verify {
+		ui.Label("Verify", check)
 	}
 
 	// The entrypoint handles all loop iterations internally — sandbox stays warm.
@@ -182,12 +300,19 @@ func loopE(cmd *cobra.Command, args []string) error {
 		Config:            config,
 		ProjectConfig:     projectConfig,
 		SboxFile:          sboxFile,
-		Prompt:            userPrompt,
+		Prompt:            run.Prompt,
 		LoopMode:          true,
-		MaxIterations:     maxIterations,
-		LoopConfirmations: loopConfirmations,
+		MaxIterations:     run.MaxIterations,
+		LoopConfirmations: run.Confirmations,
+		LoopVerify:        run.Verify,
+		LoopBudget:        run.Budget,
+		LoopRunID:         run.ID,
+		LoopCheckpoint:    run.Checkpointing,
 	}
 
+	// Warn when sbox.lock no longer matches the configured profiles
+	sbox.CheckLockFile(opts)
+
 	runErr := backend.Run(opts)
 
 	// In loop mode the sandbox should not keep running after the loop ends
@@ -198,9 +323,189 @@ func loopE(cmd *cobra.Command, args []string) error {
 		zlog.Warn("failed to stop sandbox after loop", zap.Error(err))
 	}
 
+	finishLoopRun(workspaceDir, run.ID)
 	return runErr
 }
 
+// applyLoopFlags sets the settings of a loop run from the flags. A resumed run
+// keeps its settings, except the ones of the flags given explicitly.
+func applyLoopFlags(cmd *cobra.Command, run *sbox.LoopRun, sboxFile *sbox.SboxFileLocation, config *sbox.Config, resume bool) error {
+	flags := cmd.Flags()
+	given := func(name string) bool {
+		return !resume || flags.Changed(name)
+	}
+
+	if given("max-iterations") {
+		run.MaxIterations, _ = flags.GetInt("max-iterations")
+	}
+	if given("confirmations") {
+		// CLI flag > sbox.yaml > global config > default (2)
+		confirmations, _ := flags.GetInt("confirmations")
+		run.Confirmations = sbox.ResolveLoopConfirmations(confirmations, sboxFile, config)
+	}
+	if given("max-cost") {
+		run.Budget.MaxCostUSD, _ = flags.GetFloat64("max-cost")
+	}
+	if given("max-tokens") {
+		run.Budget.MaxTokens, _ = flags.GetInt("max-tokens")
+	}
+	if given("max-duration") {
+		run.Budget.MaxDuration, _ = flags.GetDuration("max-duration")
+	}
+	if run.Budget.MaxCostUSD < 0 || run.Budget.MaxTokens < 0 || run.Budget.MaxDuration < 0 {
+		return fmt.Errorf("--max-cost, --max-tokens and --max-duration cannot be negative")
+	}
+	if given("checkpoint") {
+		run.Checkpointing, _ = flags.GetBool("checkpoint")
+	}
+	if given("verify") {
+		verifyFlag, _ := flags.GetStringArray("verify")
+		for _, check := range verifyFlag {
+			if strings.TrimSpace(check) == "" {
+				return fmt.Errorf("--verify command cannot be empty")
+			}
+		}
+		run.Verify = sbox.ResolveLoopVerify(verifyFlag, sboxFile)
+	}
+	return nil
+}
+
+// loadResumedLoopRun loads the loop run to resume: the one of the ID given as
+// argument, the last one by default. Its progress comes from the workspace,
+// its settings from the sbox data directory, the agent being able to change
+// the former.
+func loadResumedLoopRun(dataDir, workspaceDir string, args []string) (*sbox.LoopRun, error) {
+	var run *sbox.LoopRun
+	var err error
+	if len(args) > 0 {
+		run, err = sbox.LoadLoopRun(workspaceDir, args[0])
+	} else {
+		run, err = sbox.LatestLoopRun(workspaceDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := sbox.LoadLoopSettings(dataDir, workspaceDir, run.ID)
+	if err != nil {
+		return nil, err
+	}
+	run.LoopSettings = *settings
+
+	if err := run.Resume(); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+// finishLoopRun marks a loop run stopped before the entrypoint recorded how
+// it ended (Ctrl+C, host or sandbox failure) as interrupted, and tells how to
+// resume a run that didn't complete
+func finishLoopRun(workspaceDir, runID string) {
+	run, err := sbox.LoadLoopRun(workspaceDir, runID)
+	if err != nil {
+		zlog.Warn("failed to load loop run", zap.Error(err))
+		return
+	}
+
+	if run.Status == sbox.LoopStatusRunning {
+		run.Finish(sbox.LoopStatusInterrupted)
+		if err := run.Save(workspaceDir); err != nil {
+			zlog.Warn("failed to save loop run", zap.Error(err))
+		}
+		if _, err := sbox.WriteLoopReport(workspaceDir, run); err != nil {
+			zlog.Warn("failed to write loop report", zap.Error(err))
+		}
+	}
+	if run.Status != sbox.LoopStatusCompleted {
+		sbox.DefaultUI.Status("Loop run %s stopped (%s) after %d iterations, continue it with 'sbox loop --resume %s'", run.ID, run.Status, run.Iteration, run.ID)
+	}
+}
+
+// loopRollbackE restores the workspace to a loop checkpoint
+func loopRollbackE(cmd *cobra.Command, args []string) error {
+	iteration, err := strconv.Atoi(args[0])
+	if err != nil || iteration < 0 {
+		return fmt.Errorf("invalid iteration %q", args[0])
+	}
+
+	workspaceDir, err := loopWorkspaceDir(cmd)
+	if err != nil {
+		return err
+	}
+
+	run, err := loadLoopRun(cmd, workspaceDir)
+	if err != nil {
+		return err
+	}
+
+	backupRef, err := sbox.RollbackLoop(workspaceDir, run, iteration)
+	if err != nil {
+		return err
+	}
+
+	checkpoint := run.Checkpoint(iteration)
+	cmd.Printf("Restored the workspace to checkpoint %d of loop run %s (%s)\n", iteration, run.ID, checkpoint.ShortCommit())
+	cmd.Printf("The previous state is kept as %s\n", backupRef)
+	return nil
+}
+
+// loopReportE prints the report of a loop run
+func loopReportE(cmd *cobra.Command, args []string) error {
+	workspaceDir, err := loopWorkspaceDir(cmd)
+	if err != nil {
+		return err
+	}
+
+	run, err := loadLoopRun(cmd, workspaceDir)
+	if err != nil {
+		return err
+	}
+
+	// Regenerated, the run may have been interrupted before the entrypoint
+	// wrote it
+	report, err := sbox.WriteLoopReport(workspaceDir, run)
+	if err != nil {
+		return err
+	}
+
+	if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal loop report: %w", err)
+		}
+		cmd.Println(string(data))
+		return nil
+	}
+
+	cmd.Print(report.Markdown())
+	return nil
+}
+
+// loopWorkspaceDir returns the workspace of the --workspace flag, the current
+// directory by default
+func loopWorkspaceDir(cmd *cobra.Command) (string, error) {
+	workspaceDir, _ := cmd.Flags().GetString("workspace")
+	if workspaceDir != "" {
+		return workspaceDir, nil
+	}
+
+	workspaceDir, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current directory: %w", err)
+	}
+	return workspaceDir, nil
+}
+
+// loadLoopRun loads the loop run of the --run flag, the last one by default
+func loadLoopRun(cmd *cobra.Command, workspaceDir string) (*sbox.LoopRun, error) {
+	runID, _ := cmd.Flags().GetString("run")
+	if runID != "" {
+		return sbox.LoadLoopRun(workspaceDir, runID)
+	}
+	return sbox.LatestLoopRun(workspaceDir)
+}
+
 // resolveLoopPrompt gets the prompt from args, stdin, or interactively.
 func resolveLoopPrompt(args []string) (string, error) {
 	// 1. From argument
@@ -37,15 +37,22 @@ func main() {
 		LoopCommand,
 		ProfileGroup,
 		EnvGroup,
+		SecretGroup,
 		AgentGroup,
 		BackendGroup,
-		ConfigCommand,
+		ConfigGroup,
 		CleanCommand,
 		ShellCommand,
+		PortForwardCommand,
 		AuthCommand,
 		InfoCommand,
+		ProjectGroup,
 		StopCommand,
 		EntrypointCommand,
+		NetworkProxyCommand,
+		PortRelayCommand,
+		SSHAgentRelayCommand,
+		ReceiveSecretsCommand,
 
 		OnCommandError(func(err error) {
 			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	. "github.com/streamingfast/cli"
+	"github.com/streamingfast/sbox"
+)
+
+var NetworkProxyCommand = Command(networkProxyE,
+	"network-proxy",
+	"Internal command: network policy proxy (not for direct use)",
+	Description(`
+		This command runs in the network proxy sidecar container that sbox starts
+		for projects with an allowlist network policy. It serves an HTTP(S) proxy
+		and a DNS forwarder only letting through allowed destinations, and logs
+		denied requests.
+
+		Do not run this command directly - it is started automatically by the
+		container and podman backends.
+	`),
+	Flags(func(flags *pflag.FlagSet) {
+		flags.String("listen", fmt.Sprintf(":%d", sbox.NetworkProxyPort), "HTTP(S) proxy listen address")
+		flags.String("dns", ":53", "DNS listen address (empty to disable)")
+		flags.String("dns-upstream", "", "DNS resolver to forward allowed queries to (default: from /etc/resolv.conf)")
+		flags.String("policy", "", "Network policy file, reloaded when it changes")
+		flags.String("log", "", "File denied requests are appended to")
+	}),
+)
+
+// networkProxyE runs the network policy proxy
+func networkProxyE(cmd *cobra.Command, args []string) error {
+	policyPath, _ := cmd.Flags().GetString("policy")
+	if policyPath == "" {
+		return fmt.Errorf("--policy is required")
+	}
+
+	listen, _ := cmd.Flags().GetString("listen")
+	dns, _ := cmd.Flags().GetString("dns")
+	dnsUpstream, _ := cmd.Flags().GetString("dns-upstream")
+	logPath, _ := cmd.Flags().GetString("log")
+
+	return sbox.RunNetworkProxy(sbox.NetworkProxyOptions{
+		HTTPAddr:    listen,
+		DNSAddr:     dns,
+		DNSUpstream: dnsUpstream,
+		PolicyPath:  policyPath,
+		LogPath:     logPath,
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	. "github.com/streamingfast/cli"
+	"github.com/streamingfast/sbox"
+	"go.uber.org/zap"
+)
+
+var PortForwardCommand = Command(portForwardE,
+	"port-forward <[host_ip:][host_port:]container_port>...",
+	"Forward host ports to the running sandbox or container",
+	Description(`
+		Forwards host ports to ports of the running sandbox or container of the
+		current project, without recreating it. Runs until interrupted (Ctrl+C).
+
+		Each connection is relayed through 'docker exec' (container and podman
+		backends) or 'docker sandbox exec' (sandbox backend), so this works with
+		every backend and network policy. The server inside the sandbox may
+		listen on localhost.
+
+		Ports use the 'docker run -p' format, a single port forwards the same
+		host port. Host ports listen on 127.0.0.1 unless a host address is given.
+
+		Examples:
+		- sbox port-forward 5173: reach a vite dev server of the sandbox on localhost:5173
+		- sbox port-forward 8080:80 9000: forward host port 8080 to port 80, and 9000 to 9000
+
+		To publish ports when the container is created instead, use the 'ports:'
+		list of sbox.yaml or 'sbox run -p'.
+	`),
+	MinimumNArgs(1),
+	Flags(func(flags *pflag.FlagSet) {
+		flags.StringP("workspace", "w", "", "Workspace directory (default: current directory)")
+	}),
+)
+
+// portForwardE forwards host ports to the running sandbox/container
+func portForwardE(cmd *cobra.Command, args []string) error {
+	ports, err := sbox.ParsePortMappings(args)
+	if err != nil {
+		return err
+	}
+
+	ctx, err := LoadWorkspaceContext(cmd)
+	if err != nil {
+		return err
+	}
+
+	zlog.Debug("forwarding ports",
+		zap.String("backend", string(ctx.BackendType)),
+		zap.Strings("ports", args))
+
+	return ctx.Backend.ForwardPorts(ctx.WorkspaceDir, ports)
+}
+
+var PortRelayCommand = Command(portRelayE,
+	"port-relay <port>",
+	"Internal command: port forward relay (not for direct use)",
+	Description(`
+		This command runs inside the sandbox for each connection forwarded by
+		'sbox port-forward'. It connects to the given local port and pipes the
+		connection to its stdin and stdout.
+
+		Do not run this command directly - it is started automatically through
+		docker exec.
+	`),
+	ExactArgs(1),
+)
+
+// portRelayE pipes stdin/stdout to a local port
+func portRelayE(cmd *cobra.Command, args []string) error {
+	port, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("invalid port %q", args[0])
+	}
+	return sbox.RunPortRelay(port, os.Stdin, os.Stdout)
+}
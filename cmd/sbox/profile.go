@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	. "github.com/streamingfast/cli"
 	"github.com/streamingfast/sbox"
 )
@@ -18,12 +19,36 @@ var ProfileGroup = Group("profile", "Manage development profiles",
 		"add <profiles...>",
 		"Add profiles to the current project",
 		MinimumNArgs(1),
+		Description(`
+			Add profiles to the current project.
+
+			Profiles accepting parameters can be pinned with name@version or
+			name:key=value, for example 'go@1.23.2' or 'rust:toolchain=nightly'.
+			Adding a profile that is already present replaces its parameters.
+		`),
 	),
 	Command(profileRemoveE,
 		"remove <profiles...>",
 		"Remove profiles from the current project",
 		MinimumNArgs(1),
 	),
+	Command(profileLockE,
+		"lock",
+		"Pin template images to digests in sbox.lock",
+		ExactArgs(0),
+		Flags(func(flags *pflag.FlagSet) {
+			flags.StringP("workspace", "w", "", "Workspace directory (default: current directory)")
+		}),
+		Description(`
+			Resolve every base image and COPY --from image referenced by the
+			generated template Dockerfile to its digest and write them to
+			sbox.lock, next to sbox.yaml (or at the workspace root).
+
+			Commit sbox.lock so every teammate builds the same template image.
+			'sbox run' warns when the lock no longer matches the configured
+			profiles; run this command again to refresh it.
+		`),
+	),
 )
 
 // profileListE lists available and installed profiles
@@ -40,24 +65,36 @@ func profileListE(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to load project config: %w", err)
 	}
 
+	registry, err := loadProfileRegistry(workspaceDir)
+	if err != nil {
+		return err
+	}
+
 	// Create a set of installed profiles for quick lookup
 	installedSet := make(map[string]bool)
 	for _, p := range projectConfig.Profiles {
-		installedSet[p] = true
+		installedSet[sbox.ProfileRefName(p)] = true
 	}
 
 	cmd.Println("Available profiles:")
 	cmd.Println()
 
 	// List all profiles with their status
-	for _, name := range sbox.ListProfiles() {
-		profile, _ := sbox.GetProfile(name)
+	for _, name := range registry.List() {
+		profile, _ := registry.Get(name)
 		status := "[ ]"
 		if installedSet[name] {
 			status = "[✓]"
 		}
-		cmd.Printf("  %s %s\n", status, name)
+		if profile.Source != sbox.ProfileSourceBuiltin {
+			cmd.Printf("  %s %s (%s: %s)\n", status, name, profile.Source, profile.Path)
+		} else {
+			cmd.Printf("  %s %s\n", status, name)
+		}
 		cmd.Printf("      %s\n", profile.Description)
+		for _, param := range profile.Params {
+			cmd.Printf("      - %s=%s\n", param.Name, param.Default)
+		}
 	}
 
 	if len(projectConfig.Profiles) > 0 {
@@ -70,51 +107,71 @@ func profileListE(cmd *cobra.Command, args []string) error {
 
 // profileAddE adds profiles to the current project
 func profileAddE(cmd *cobra.Command, args []string) error {
-	// Validate all profiles exist first
-	for _, profileName := range args {
-		if _, ok := sbox.GetProfile(profileName); !ok {
-			return fmt.Errorf("unknown profile: %s\nAvailable profiles: %v", profileName, sbox.ListProfiles())
-		}
-	}
-
 	// Get workspace directory
 	workspaceDir, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("failed to get current directory: %w", err)
 	}
 
-	// Load project config
-	projectConfig, _, err := sbox.GetProjectConfig(workspaceDir)
+	registry, err := loadProfileRegistry(workspaceDir)
 	if err != nil {
-		return fmt.Errorf("failed to load project config: %w", err)
+		return err
 	}
 
-	// Create a set of existing profiles for quick lookup
-	existingSet := make(map[string]bool)
-	for _, p := range projectConfig.Profiles {
-		existingSet[p] = true
+	// Validate all profiles and their parameters first
+	for _, arg := range args {
+		ref, err := sbox.ParseProfileRef(arg)
+		if err != nil {
+			return err
+		}
+		profile, ok := registry.Get(ref.Name)
+		if !ok {
+			return fmt.Errorf("unknown profile: %s\nAvailable profiles: %v", ref.Name, registry.List())
+		}
+		if _, err := profile.ResolveParams(ref.Params); err != nil {
+			return err
+		}
+	}
+
+	store, err := sbox.NewConfigStore()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	// Add each profile
 	added := 0
-	for _, profileName := range args {
-		if existingSet[profileName] {
-			cmd.Printf("Profile '%s' is already added to this project\n", profileName)
-			continue
+	_, err = store.UpdateProjectConfig(workspaceDir, func(projectConfig *sbox.ProjectConfig) error {
+		// Index existing profiles by name for quick lookup
+		existingIndex := make(map[string]int)
+		for i, p := range projectConfig.Profiles {
+			existingIndex[sbox.ProfileRefName(p)] = i
 		}
-		projectConfig.Profiles = append(projectConfig.Profiles, profileName)
-		existingSet[profileName] = true
-		cmd.Printf("Added profile '%s' to project\n", profileName)
-		added++
-	}
 
-	if added == 0 {
+		// Add each profile
+		for _, profileRef := range args {
+			name := sbox.ProfileRefName(profileRef)
+			if i, ok := existingIndex[name]; ok {
+				if projectConfig.Profiles[i] == profileRef {
+					cmd.Printf("Profile '%s' is already added to this project\n", profileRef)
+					continue
+				}
+				cmd.Printf("Updated profile '%s' to '%s'\n", projectConfig.Profiles[i], profileRef)
+				projectConfig.Profiles[i] = profileRef
+				added++
+				continue
+			}
+			projectConfig.Profiles = append(projectConfig.Profiles, profileRef)
+			existingIndex[name] = len(projectConfig.Profiles) - 1
+			cmd.Printf("Added profile '%s' to project\n", profileRef)
+			added++
+		}
 		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save project config: %w", err)
 	}
 
-	// Save project config
-	if err := sbox.SaveProjectConfig(workspaceDir, projectConfig); err != nil {
-		return fmt.Errorf("failed to save project config: %w", err)
+	if added == 0 {
+		return nil
 	}
 
 	cmd.Println("Run 'sbox run --recreate' to rebuild and recreate the sandbox with the new profiles")
@@ -129,10 +186,9 @@ func profileRemoveE(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to get current directory: %w", err)
 	}
 
-	// Load project config
-	projectConfig, _, err := sbox.GetProjectConfig(workspaceDir)
+	store, err := sbox.NewConfigStore()
 	if err != nil {
-		return fmt.Errorf("failed to load project config: %w", err)
+		return fmt.Errorf("failed to load config: %w", err)
 	}
 
 	// Create a set of profiles to remove
@@ -141,16 +197,23 @@ func profileRemoveE(cmd *cobra.Command, args []string) error {
 		removeSet[name] = true
 	}
 
-	// Find and remove profiles
-	var kept []string
 	removed := 0
-	for _, p := range projectConfig.Profiles {
-		if removeSet[p] {
-			cmd.Printf("Removed profile '%s' from project\n", p)
-			removed++
-		} else {
-			kept = append(kept, p)
+	_, err = store.UpdateProjectConfig(workspaceDir, func(projectConfig *sbox.ProjectConfig) error {
+		// Find and remove profiles
+		var kept []string
+		for _, p := range projectConfig.Profiles {
+			if removeSet[p] || removeSet[sbox.ProfileRefName(p)] {
+				cmd.Printf("Removed profile '%s' from project\n", p)
+				removed++
+			} else {
+				kept = append(kept, p)
+			}
 		}
+		projectConfig.Profiles = kept
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save project config: %w", err)
 	}
 
 	if removed == 0 {
@@ -158,13 +221,60 @@ func profileRemoveE(cmd *cobra.Command, args []string) error {
 		return nil
 	}
 
-	projectConfig.Profiles = kept
+	cmd.Println("Run 'sbox run --recreate' to rebuild and recreate the sandbox without these profiles")
+	return nil
+}
+
+// loadProfileRegistry loads built-in and user-defined profiles available to the workspace
+func loadProfileRegistry(workspaceDir string) (*sbox.ProfileRegistry, error) {
+	config, err := sbox.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
 
-	// Save project config
-	if err := sbox.SaveProjectConfig(workspaceDir, projectConfig); err != nil {
-		return fmt.Errorf("failed to save project config: %w", err)
+	sboxFile, err := sbox.FindSboxFile(workspaceDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load sbox.yaml file: %w", err)
 	}
 
-	cmd.Println("Run 'sbox run --recreate' to rebuild and recreate the sandbox without these profiles")
+	registry, err := sbox.LoadProfileRegistry(config, workspaceDir, sboxFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load profiles: %w", err)
+	}
+
+	return registry, nil
+}
+
+// profileLockE resolves template images to digests and writes sbox.lock
+func profileLockE(cmd *cobra.Command, args []string) error {
+	ctx, err := LoadWorkspaceContext(cmd)
+	if err != nil {
+		return err
+	}
+
+	builder, err := sbox.NewWorkspaceTemplateBuilder(ctx.Config, ctx.WorkspaceDir, ctx.SboxFile, ctx.ProjectConfig.Profiles, ctx.AgentType)
+	if err != nil {
+		return err
+	}
+
+	ui := sbox.DefaultUI
+	lock, err := sbox.GenerateLockFile(builder, func(ref string) (string, error) {
+		ui.Status("Resolving %s", ref)
+		return sbox.ResolveImageDigest(ref)
+	})
+	if err != nil {
+		return err
+	}
+
+	lockPath, err := sbox.LockFilePath(ctx.WorkspaceDir, ctx.SboxFile)
+	if err != nil {
+		return err
+	}
+	if err := sbox.SaveLockFile(lockPath, lock); err != nil {
+		return err
+	}
+
+	ui.Success("Wrote %s (%d images pinned)", lockPath, len(lock.Images))
+	cmd.Println("Run 'sbox run --recreate' to rebuild the sandbox with the pinned images")
 	return nil
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	. "github.com/streamingfast/cli"
+	"github.com/streamingfast/sbox"
+)
+
+var ProjectGroup = Group("project", "Manage known projects",
+	Command(projectMoveE,
+		"move <old> <new>",
+		"Move a project to a moved or renamed workspace",
+		Description(`
+			Moves the project known at <old> to the workspace at <new>, keeping
+			its configuration, persistence volumes and secrets.
+
+			A project is identified by the ID recorded in .sbox/project-id, so a
+			workspace moved with its .sbox directory is followed automatically by
+			the next 'sbox run'. Use this command for workspaces moved without it
+			(e.g. a fresh clone), listed as moved by 'sbox info --all'.
+
+			The sandbox or container created for <old> is removed and recreated
+			by the next 'sbox run'. A running one, or a project already created
+			at <new> by an earlier 'sbox run' there, is only removed with --force.
+		`),
+		ExactArgs(2),
+		Flags(func(flags *pflag.FlagSet) {
+			flags.Bool("force", false, "Remove the running sandbox/container of <old> and any project already at <new>")
+		}),
+	),
+)
+
+// projectMoveE moves a project to another workspace path
+func projectMoveE(cmd *cobra.Command, args []string) error {
+	force, _ := cmd.Flags().GetBool("force")
+
+	store, err := sbox.NewConfigStore()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	projectConfig, removed, err := store.MoveProject(args[0], args[1], force)
+	if err != nil {
+		return err
+	}
+
+	if removed != nil {
+		cmd.Printf("Removed %s (%s) of the old path\n", removed.Name, removed.ID)
+	}
+	cmd.Printf("Moved project to %s\n", projectConfig.WorkspacePath)
+	cmd.Println("Run 'sbox run' there to recreate its sandbox")
+	return nil
+}
+
+// followProjectMove moves the project of a workspace moved with its project
+// ID, so its stale sandbox is recreated, and returns its reloaded config
+func followProjectMove(store *sbox.ConfigStore, workspaceDir string, projectConfig *sbox.ProjectConfig) (*sbox.ProjectConfig, error) {
+	movedFrom := sbox.ProjectMovedFrom(workspaceDir, projectConfig)
+	if movedFrom == "" {
+		return projectConfig, nil
+	}
+
+	projectConfig, removed, err := store.MoveProject(movedFrom, workspaceDir, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to move project from %s: %w", movedFrom, err)
+	}
+
+	sbox.DefaultUI.Status("Project moved from %s", movedFrom)
+	if removed != nil {
+		sbox.DefaultUI.Status("Removed %s (%s) of the old path", removed.Name, removed.ID)
+	}
+	return projectConfig, nil
+}
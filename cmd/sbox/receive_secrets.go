@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	. "github.com/streamingfast/cli"
+	"github.com/streamingfast/sbox"
+)
+
+var ReceiveSecretsCommand = Command(receiveSecretsE,
+	"receive-secrets",
+	"Internal command: secret env vars delivery (not for direct use)",
+	Description(`
+		This command runs inside the sandbox when it starts. It reads the secret
+		environment variables sent by sbox on its stdin and stores them in a
+		tmpfs file, which the entrypoint loads and removes. Secrets are never
+		written to the workspace .sbox/env file.
+
+		Do not run this command directly - it is started automatically through
+		docker exec.
+	`),
+	Flags(func(flags *pflag.FlagSet) {
+		flags.String("file", sbox.SecretEnvFile, "File the secrets are written to")
+	}),
+)
+
+// receiveSecretsE stores the secrets read from stdin
+func receiveSecretsE(cmd *cobra.Command, args []string) error {
+	path, _ := cmd.Flags().GetString("file")
+	return sbox.RunReceiveSecrets(path, os.Stdin)
+}
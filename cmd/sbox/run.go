@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"os"
-	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -22,6 +21,7 @@ var RunCommand = Command(runE,
 		- Persistent credentials across sessions
 		- Optional Docker socket access
 		- Custom profiles for additional tool installations (Go, Rust, etc.)
+		- Published ports from sbox.yaml 'ports:' and -p (container and podman backends)
 
 		Backend types:
 		- sandbox (default): Uses Docker sandbox MicroVM for enhanced isolation
@@ -33,13 +33,14 @@ var RunCommand = Command(runE,
 	`),
 	Flags(func(flags *pflag.FlagSet) {
 		flags.Bool("docker-socket", false, "Mount Docker socket into sandbox/container")
-		flags.StringSlice("profile", nil, "Additional profiles to use for this session")
+		flags.StringSlice("profile", nil, "Additional profiles to use for this session (e.g. go@1.23.2)")
 		flags.Bool("recreate", false, "Force rebuild of custom template image and recreate sandbox/container (pulls latest base image)")
 		flags.StringP("workspace", "w", "", "Workspace directory (default: current directory)")
 		flags.Bool("debug", false, "Enable debug mode for docker commands")
-		flags.String("backend", "", "Backend type: 'sandbox' (default) or 'container'")
+		flags.String("backend", "", "Backend type: 'sandbox' (default), 'container' or 'podman'")
 		flags.String("agent", "", "Agent type: 'claude' (default) or 'opencode'")
 		flags.Duration("startup-delay", -1, "Delay agent startup inside the sandbox (0 = wait forever, e.g. 30s, 5m)")
+		flags.StringArrayP("publish", "p", nil, "Publish a container port on the host, [host_ip:][host_port:]container_port[/protocol] (container and podman backends, repeatable)")
 	}),
 )
 
@@ -60,16 +61,21 @@ func runE(cmd *cobra.Command, args []string) error {
 	}
 
 	// Load global configuration
-	config, err := sbox.LoadConfig()
+	store, err := sbox.NewConfigStore()
 	if err != nil {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
+	config := store.Config()
 
 	// Load project configuration
-	projectConfig, _, err := sbox.GetProjectConfig(workspaceDir)
+	projectConfig, _, err := store.ProjectConfig(workspaceDir)
 	if err != nil {
 		return fmt.Errorf("failed to load project config: %w", err)
 	}
+	projectConfig, err = followProjectMove(store, workspaceDir, projectConfig)
+	if err != nil {
+		return err
+	}
 
 	// Find and merge sbox.yaml file configuration
 	sboxFile, err := sbox.FindSboxFile(workspaceDir)
@@ -131,6 +137,14 @@ func runE(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to get startup-delay flag: %w", err)
 	}
 
+	publish, err := cmd.Flags().GetStringArray("publish")
+	if err != nil {
+		return fmt.Errorf("failed to get publish flag: %w", err)
+	}
+	if _, err := sbox.ParsePortMappings(publish); err != nil {
+		return err
+	}
+
 	// Resolve which backend to use (CLI > sbox.yaml > project > global > default)
 	backendType := sbox.ResolveBackendType(backendFlag, sboxFile, projectConfig, config)
 	zlog.Debug("resolved backend type", zap.String("backend", string(backendType)))
@@ -149,28 +163,14 @@ func runE(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to get backend: %w", err)
 	}
 
-	// Generate sandbox name first (needed for lookup)
-	// Regenerate if empty OR if the agent has changed (detected by checking if the name contains the current agent)
-	needsRegeneration := projectConfig.SandboxName == ""
-	if !needsRegeneration && projectConfig.SandboxName != "" {
-		// Check if the sandbox name matches the current agent
-		expectedPrefix := "sbox-" + string(agentType) + "-"
-		if !strings.HasPrefix(projectConfig.SandboxName, expectedPrefix) {
-			zlog.Info("agent changed, regenerating sandbox name",
-				zap.String("old_name", projectConfig.SandboxName),
-				zap.String("agent", string(agentType)))
-			needsRegeneration = true
-		}
-	}
-
-	if needsRegeneration {
-		sandboxName, err := sbox.GenerateSandboxName(workspaceDir, agentType)
-		if err != nil {
-			return fmt.Errorf("failed to generate sandbox name: %w", err)
-		}
-		projectConfig.SandboxName = sandboxName
-		zlog.Debug("generated sandbox name", zap.String("name", sandboxName))
+	// Generate sandbox name first (needed for lookup), it is derived from the
+	// workspace and the agent
+	sandboxName, err := sbox.GenerateSandboxName(workspaceDir, agentType)
+	if err != nil {
+		return fmt.Errorf("failed to generate sandbox name: %w", err)
 	}
+	projectConfig.SandboxName = sandboxName
+	zlog.Debug("generated sandbox name", zap.String("name", sandboxName))
 
 	if recreate {
 		// Remove existing container/sandbox so a fresh one is created
@@ -200,7 +200,13 @@ func runE(cmd *cobra.Command, args []string) error {
 
 	// Save project config to register this project (for sbox info)
 	// This must happen BEFORE running the sandbox so other terminals can see it
-	if err := sbox.SaveProjectConfig(workspaceDir, projectConfig); err != nil {
+	// Only the resolved settings are written, the sbox.yaml ones stay in sbox.yaml
+	_, err = store.UpdateProjectConfig(workspaceDir, func(saved *sbox.ProjectConfig) error {
+		saved.Backend = projectConfig.Backend
+		saved.Agent = projectConfig.Agent
+		return nil
+	})
+	if err != nil {
 		zlog.Warn("failed to save project config", zap.Error(err))
 		// Non-fatal: continue running sandbox even if we can't save config
 	}
@@ -215,6 +221,7 @@ func runE(cmd *cobra.Command, args []string) error {
 		Config:            config,
 		ProjectConfig:     projectConfig,
 		SboxFile:          sboxFile,
+		Ports:             publish,
 	}
 
 	// -1 is the default (unset), any other value means the flag was provided
@@ -222,6 +229,9 @@ func runE(cmd *cobra.Command, args []string) error {
 		opts.StartupDelay = &startupDelay
 	}
 
+	// Warn when sbox.lock no longer matches the configured profiles
+	sbox.CheckLockFile(opts)
+
 	// Run using the selected backend
 	sbox.DefaultUI.Label("Backend", string(backend.Name()))
 	return backend.Run(opts)
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	. "github.com/streamingfast/cli"
+	"github.com/streamingfast/sbox"
+	"golang.org/x/term"
+)
+
+var SecretGroup = Group("secret", "Manage secrets stored in the encrypted sbox vault",
+	Command(secretSetE,
+		"set <name> [value]",
+		"Store a secret, passed to the sandbox as an environment variable",
+		Description(`
+			Stores a secret in the encrypted vault of the sbox data directory.
+			Vault secrets are passed to the sandbox as environment variables
+			when no sbox env sets the same name, and are always treated as
+			secrets (never written to .sbox/env).
+
+			Without a value, the secret is prompted for (hidden) or read from
+			stdin, which keeps it out of the shell history.
+
+			The vault is encrypted with a random key stored next to it
+			(vault.key). Set SBOX_VAULT_PASSPHRASE when the vault is first
+			created to protect it with a passphrase instead.
+		`),
+		RangeArgs(1, 2),
+		Flags(func(flags *pflag.FlagSet) {
+			flags.Bool("global", false, "Store for all projects instead of the current one")
+		}),
+	),
+	Command(secretGetE,
+		"get <name>",
+		"Print a secret",
+		Description(`
+			Prints a secret of the current project, falling back to the global
+			secrets (only the global ones with --global).
+		`),
+		ExactArgs(1),
+		Flags(func(flags *pflag.FlagSet) {
+			flags.Bool("global", false, "Only look at the global secrets")
+		}),
+	),
+	Command(secretListE,
+		"list",
+		"List the secrets available to this project",
+	),
+	Command(secretRemoveE,
+		"rm <names...>",
+		"Remove secrets",
+		MinimumNArgs(1),
+		Flags(func(flags *pflag.FlagSet) {
+			flags.Bool("global", false, "Remove global secrets instead of the current project ones")
+		}),
+	),
+)
+
+// secretScope returns the vault scope targeted by the --global flag
+func secretScope(cmd *cobra.Command) (scope string, label string, err error) {
+	if global, _ := cmd.Flags().GetBool("global"); global {
+		return sbox.VaultGlobalScope, "global", nil
+	}
+
+	workspaceDir, err := os.Getwd()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get current directory: %w", err)
+	}
+	scope, err = sbox.VaultProjectScope(workspaceDir)
+	if err != nil {
+		return "", "", err
+	}
+	return scope, "project", nil
+}
+
+// openVault loads the config and opens its vault
+func openVault() (*sbox.Vault, error) {
+	config, err := sbox.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	vault, err := sbox.OpenVault(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open secret vault: %w", err)
+	}
+	return vault, nil
+}
+
+// readSecretValue prompts for a secret on a terminal, or reads it from stdin
+func readSecretValue(cmd *cobra.Command, name string) (string, error) {
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		cmd.PrintErrf("Value of %s: ", name)
+		value, err := term.ReadPassword(int(os.Stdin.Fd()))
+		cmd.PrintErrln()
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret: %w", err)
+		}
+		return string(value), nil
+	}
+
+	value, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret: %w", err)
+	}
+	return strings.TrimRight(string(value), "\r\n"), nil
+}
+
+func secretSetE(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	if sbox.EnvName(name) != name || name == "" {
+		return fmt.Errorf("invalid secret name %q", name)
+	}
+
+	scope, label, err := secretScope(cmd)
+	if err != nil {
+		return err
+	}
+
+	var value string
+	if len(args) == 2 {
+		value = args[1]
+	} else if value, err = readSecretValue(cmd, name); err != nil {
+		return err
+	}
+	if value == "" {
+		return fmt.Errorf("secret value cannot be empty")
+	}
+
+	vault, err := openVault()
+	if err != nil {
+		return err
+	}
+
+	_, exists := vault.Get(scope, name)
+	vault.Set(scope, name, value)
+	if err := vault.Save(); err != nil {
+		return err
+	}
+
+	if exists {
+		cmd.Printf("Updated secret '%s' (%s)\n", name, label)
+	} else {
+		cmd.Printf("Stored secret '%s' (%s)\n", name, label)
+	}
+	return nil
+}
+
+func secretGetE(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	scope, _, err := secretScope(cmd)
+	if err != nil {
+		return err
+	}
+
+	vault, err := openVault()
+	if err != nil {
+		return err
+	}
+
+	value, ok := vault.Get(scope, name)
+	if !ok {
+		value, ok = vault.Get(sbox.VaultGlobalScope, name)
+	}
+	if !ok {
+		return fmt.Errorf("secret %q not found", name)
+	}
+
+	cmd.Println(value)
+	return nil
+}
+
+func secretListE(cmd *cobra.Command, args []string) error {
+	workspaceDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+	projectScope, err := sbox.VaultProjectScope(workspaceDir)
+	if err != nil {
+		return err
+	}
+
+	vault, err := openVault()
+	if err != nil {
+		return err
+	}
+
+	projectNames := vault.Names(projectScope)
+	globalNames := vault.Names(sbox.VaultGlobalScope)
+	if len(projectNames) == 0 && len(globalNames) == 0 {
+		cmd.Println("No secrets stored.")
+		cmd.Println("Use 'sbox secret set NAME' or 'sbox secret set --global NAME' to add one.")
+		return nil
+	}
+
+	cmd.Println("Secrets:")
+	cmd.Println()
+	for _, name := range projectNames {
+		cmd.Printf("  %s  [project]\n", name)
+	}
+	for _, name := range globalNames {
+		if _, overridden := vault.Get(projectScope, name); overridden {
+			cmd.Printf("  %s  [global, overridden by project]\n", name)
+		} else {
+			cmd.Printf("  %s  [global]\n", name)
+		}
+	}
+	return nil
+}
+
+func secretRemoveE(cmd *cobra.Command, args []string) error {
+	scope, label, err := secretScope(cmd)
+	if err != nil {
+		return err
+	}
+
+	vault, err := openVault()
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+	for _, name := range args {
+		if vault.Remove(scope, name) {
+			cmd.Printf("Removed secret '%s' (%s)\n", name, label)
+			removed++
+		}
+	}
+
+	if removed == 0 {
+		cmd.Printf("No matching %s secrets found.\n", label)
+		return nil
+	}
+	return vault.Save()
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	. "github.com/streamingfast/cli"
+	"github.com/streamingfast/sbox"
+)
+
+var SSHAgentRelayCommand = Command(sshAgentRelayE,
+	"ssh-agent-relay",
+	"Internal command: SSH agent relay (not for direct use)",
+	Description(`
+		This command runs inside the sandbox when the host SSH agent can't be
+		mounted directly (Docker Desktop, sandbox VMs, filtered keys). It serves
+		an SSH agent socket and relays every request to sbox on the host through
+		its stdin and stdout.
+
+		Do not run this command directly - it is started automatically through
+		docker exec.
+	`),
+	Flags(func(flags *pflag.FlagSet) {
+		flags.String("socket", sbox.SSHAgentSocketPath, "Path of the SSH agent socket to serve")
+	}),
+)
+
+// sshAgentRelayE serves the SSH agent socket, relaying requests to stdout
+func sshAgentRelayE(cmd *cobra.Command, args []string) error {
+	socketPath, _ := cmd.Flags().GetString("socket")
+	return sbox.RunSSHAgentRelay(socketPath, os.Stdin, os.Stdout)
+}
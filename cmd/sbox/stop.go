@@ -54,11 +54,16 @@ func stopE(cmd *cobra.Command, args []string) error {
 
 	// --all asks for confirmation
 	if removeAll {
+		resource := "Docker " + string(ctx.Backend.Name())
 		extraInfo := ""
-		if ctx.BackendType == sbox.BackendContainer {
+		switch ctx.BackendType {
+		case sbox.BackendContainer:
+			extraInfo = " and persistence volume"
+		case sbox.BackendPodman:
+			resource = "Podman container"
 			extraInfo = " and persistence volume"
 		}
-		answeredYes, _ := AskConfirmation("This will remove the Docker %s%s AND all project configuration for %s. Continue?", ctx.Backend.Name(), extraInfo, ctx.WorkspaceDir)
+		answeredYes, _ := AskConfirmation("This will remove the %s%s AND all project configuration for %s. Continue?", resource, extraInfo, ctx.WorkspaceDir)
 		if !answeredYes {
 			cmd.Println("Aborted.")
 			return nil
@@ -1,11 +1,11 @@
 package sbox
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"go.uber.org/zap"
@@ -14,6 +14,9 @@ import (
 
 // Config holds global configuration for sbox
 type Config struct {
+	// Version is the config format version (see CurrentConfigVersion)
+	Version int `yaml:"version,omitempty"`
+
 	// ClaudeHome is the path to Claude's home directory (default: ~/.claude)
 	ClaudeHome string `yaml:"claude_home"`
 
@@ -34,7 +37,7 @@ type Config struct {
 	// Project-specific envs override these
 	Envs []string `yaml:"envs"`
 
-	// DefaultBackend is the default backend type: "sandbox" (default) or "container"
+	// DefaultBackend is the default backend type: "sandbox" (default), "container" or "podman"
 	// Can be overridden per-project via sbox.yaml or project config
 	DefaultBackend string `yaml:"default_backend"`
 
@@ -45,17 +48,51 @@ type Config struct {
 	// LoopConfirmations is the number of consecutive goal completions required
 	// before `sbox loop` considers the goal truly achieved. Default: 2.
 	LoopConfirmations int `yaml:"loop_confirmations"`
+
+	// Resources are the default resource limits (cpus, memory, pids, ...) of
+	// all sandboxes. Project settings override them limit by limit.
+	Resources *ResourceLimits `yaml:"resources,omitempty"`
+
+	// SSH controls SSH access in sandboxes: "agent" (default, forwards the
+	// host SSH agent), "keys" (mounts ~/.ssh read-only) or "none"
+	SSH string `yaml:"ssh,omitempty"`
+
+	// SSHAllowedKeys restricts the keys exposed by the forwarded SSH agent
+	// to these SHA256 fingerprints (as shown by `ssh-add -l`)
+	SSHAllowedKeys []string `yaml:"ssh_allowed_keys,omitempty"`
+
+	// SecretEnvs are the names of environment variables holding secrets, on
+	// top of the ones detected by name (KEY, TOKEN, ...). Secrets are never
+	// written to the workspace.
+	SecretEnvs []string `yaml:"secret_envs,omitempty"`
+
+	// Credentials are the named credential sets configured with
+	// `sbox auth --provider`, their secrets are stored in the sbox vault
+	Credentials map[string]*CredentialSet `yaml:"credentials,omitempty"`
+
+	// DefaultCredentials is the credential set used by projects that don't
+	// select one
+	DefaultCredentials string `yaml:"default_credentials,omitempty"`
 }
 
 // ProjectConfig holds per-project configuration settings
 type ProjectConfig struct {
+	// Version is the config format version (see CurrentConfigVersion)
+	Version int `yaml:"version,omitempty"`
+
 	// WorkspacePath is the absolute path to the project workspace
 	// This is stored to allow listing projects by path
 	WorkspacePath string `yaml:"workspace_path"`
 
-	// SandboxName is the name used for the docker sandbox
+	// Fingerprint identifies the git repository of the workspace (hash of
+	// its root commits), to spot workspaces moved without their project ID
+	Fingerprint string `yaml:"fingerprint,omitempty"`
+
+	// SandboxName is the name used for the docker sandbox, derived from the
+	// workspace on each run and no longer saved (removed from version 1
+	// project configs by their migration)
 	// Format: "sbox-claude-<basename of workspace>"
-	SandboxName string `yaml:"sandbox_name"`
+	SandboxName string `yaml:"-"`
 
 	// Profiles are the active profiles for this project
 	Profiles []string `yaml:"profiles"`
@@ -80,11 +117,40 @@ type ProjectConfig struct {
 	// Agent overrides the default agent for this project
 	// Values: "claude", "opencode", or empty to use default
 	Agent string `yaml:"agent"`
+
+	// Resources are the resource limits for this project, overriding the
+	// global ones limit by limit
+	Resources *ResourceLimits `yaml:"resources,omitempty"`
+
+	// Network is the outbound network policy for this project
+	Network *NetworkPolicy `yaml:"network,omitempty"`
+
+	// Ports are the container ports published on the host
+	// Format: "[host_ip:][host_port:]container_port[/protocol]"
+	Ports []string `yaml:"ports,omitempty"`
+
+	// SSH overrides the global ssh setting for this project
+	// Values: "agent", "keys", "none", or empty to use global setting
+	SSH string `yaml:"ssh,omitempty"`
+
+	// SSHAllowedKeys overrides the global ssh_allowed_keys setting
+	SSHAllowedKeys []string `yaml:"ssh_allowed_keys,omitempty"`
+
+	// SecretEnvs are the names of environment variables holding secrets, added
+	// to the global ones
+	SecretEnvs []string `yaml:"secret_envs,omitempty"`
+
+	// Credentials overrides the global default credential set for this project
+	Credentials string `yaml:"credentials,omitempty"`
 }
 
 // SboxFileConfig represents the configuration from a sbox.yaml file
 // This is the user-facing format that gets loaded from disk
 type SboxFileConfig struct {
+	// Version is the sbox.yaml format version (see CurrentConfigVersion),
+	// a file without version being in the format of version 1
+	Version int `yaml:"version,omitempty"`
+
 	// Profiles to auto-enable for this project
 	Profiles []string `yaml:"profiles"`
 
@@ -98,7 +164,11 @@ type SboxFileConfig struct {
 	// Envs are environment variables to pass to the sandbox
 	Envs []string `yaml:"envs"`
 
-	// Backend specifies the container backend: "sandbox" (default) or "container"
+	// EnvFiles are .env files (NAME=VALUE lines) whose variables are passed to
+	// the sandbox, relative to the sbox.yaml file location. Envs override them.
+	EnvFiles []string `yaml:"env_files,omitempty"`
+
+	// Backend specifies the container backend: "sandbox" (default), "container" or "podman"
 	Backend string `yaml:"backend"`
 
 	// Agent specifies the AI agent to run: "claude" (default) or "opencode"
@@ -107,6 +177,49 @@ type SboxFileConfig struct {
 	// LoopConfirmations overrides the number of consecutive goal completions
 	// required before `sbox loop` considers the goal truly achieved.
 	LoopConfirmations int `yaml:"loop_confirmations"`
+
+	// Loop holds the other `sbox loop` settings (verification commands)
+	Loop *LoopConfig `yaml:"loop,omitempty"`
+
+	// ProfilesDir is a directory of user-defined profile files for this project.
+	// Relative paths are resolved against the sbox.yaml file location.
+	ProfilesDir string `yaml:"profiles_dir"`
+
+	// Resources limits the sandbox resources (cpus, memory, memory_swap,
+	// pids_limit, shm_size, ulimits), overriding project and global limits
+	Resources *ResourceLimits `yaml:"resources,omitempty"`
+
+	// Network is the outbound network policy: mode "open" (default), "none"
+	// or "allowlist" with the allowed domains and CIDRs
+	Network *NetworkPolicy `yaml:"network,omitempty"`
+
+	// Ports are the container ports published on the host (container and
+	// podman backends), e.g. "3000" or "127.0.0.1:8080:80"
+	Ports []string `yaml:"ports,omitempty"`
+
+	// SSH controls SSH access: "agent" (default, forwards the host SSH agent,
+	// keys never enter the sandbox), "keys" (mounts ~/.ssh read-only) or "none"
+	SSH string `yaml:"ssh,omitempty"`
+
+	// SSHAllowedKeys restricts the keys exposed by the forwarded SSH agent to
+	// these SHA256 fingerprints (as shown by `ssh-add -l`)
+	SSHAllowedKeys []string `yaml:"ssh_allowed_keys,omitempty"`
+
+	// SecretEnvs marks environment variables as secrets when their name
+	// doesn't already look sensitive (KEY, TOKEN, SECRET, ...)
+	SecretEnvs []string `yaml:"secret_envs,omitempty"`
+
+	// Credentials selects the credential set (configured with
+	// `sbox auth --provider`) used by this project, e.g. "bedrock-prod"
+	Credentials string `yaml:"credentials,omitempty"`
+
+	// Extends is a sbox.yaml file (path relative to this file) or the name of
+	// a preset in ~/.config/sbox/presets this file is layered over
+	Extends string `yaml:"extends,omitempty"`
+
+	// Inherit layers this file over the closest sbox.yaml file above it, up to
+	// the repository root
+	Inherit bool `yaml:"inherit,omitempty"`
 }
 
 // SboxFileLocation contains info about a loaded sbox.yaml file
@@ -117,8 +230,18 @@ type SboxFileLocation struct {
 	// Dir is the directory containing the sbox.yaml file
 	Dir string
 
-	// Config is the parsed configuration
+	// Config is the parsed configuration, merged with its layers
 	Config *SboxFileConfig
+
+	// EnvFileSources maps the variables loaded from env_files to their file
+	EnvFileSources map[string]string
+
+	// Layers are the sbox.yaml files merged into Config, lowest precedence
+	// first, ending with Path
+	Layers []string
+
+	// origins maps the effective values to the file they came from (see Explain)
+	origins map[string]string
 }
 
 // LoadConfig loads the global sbox configuration from ~/.config/sbox/config.yaml
@@ -130,11 +253,22 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("failed to get user home directory: %w", err)
 	}
 
+	return loadConfigFile(filepath.Join(homeDir, ".config", "sbox"))
+}
+
+// loadConfigFile loads the config.yaml file of a sbox data directory over
+// the default configuration
+func loadConfigFile(dataDir string) (*Config, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user home directory: %w", err)
+	}
+
 	// Default configuration - use XDG-style ~/.config/sbox
 	config := &Config{
 		ClaudeHome:      filepath.Join(homeDir, ".claude"),
 		OpenCodeHome:    filepath.Join(homeDir, ".config", "opencode"),
-		SboxDataDir:     filepath.Join(homeDir, ".config", "sbox"),
+		SboxDataDir:     dataDir,
 		DockerSocket:    "auto",
 		DefaultProfiles: []string{},
 	}
@@ -157,10 +291,14 @@ func LoadConfig() (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
-	// Parse YAML
+	// Upgrade files written by older sbox versions, then parse YAML
+	if data, err = migrateConfigData(ConfigKindGlobal, configPath, data); err != nil {
+		return nil, err
+	}
 	if err := yaml.Unmarshal(data, config); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
+	config.Version = CurrentConfigVersion(ConfigKindGlobal)
 
 	// Ensure paths are absolute and expanded
 	config.ClaudeHome = expandPath(config.ClaudeHome)
@@ -176,7 +314,8 @@ func LoadConfig() (*Config, error) {
 	return config, nil
 }
 
-// SaveConfig saves the global configuration to ~/.sbox/config.yaml
+// SaveConfig saves the global configuration to ~/.sbox/config.yaml. Prefer
+// ConfigStore.UpdateConfig, which doesn't lose concurrent changes.
 func SaveConfig(config *Config) error {
 	configPath := filepath.Join(config.SboxDataDir, "config.yaml")
 
@@ -186,13 +325,18 @@ func SaveConfig(config *Config) error {
 	}
 
 	// Serialize to YAML
+	config.Version = CurrentConfigVersion(ConfigKindGlobal)
 	data, err := yaml.Marshal(config)
 	if err != nil {
 		return fmt.Errorf("failed to serialize config: %w", err)
 	}
 
-	// Write to file
-	if err := os.WriteFile(configPath, data, 0644); err != nil {
+	if err := backupOutdatedConfig(ConfigKindGlobal, configPath); err != nil {
+		return err
+	}
+
+	// Write atomically, so concurrent readers never see a partial file
+	if err := writeFileAtomic(configPath, data, 0644); err != nil {
 		return fmt.Errorf("failed to write config file: %w", err)
 	}
 
@@ -205,81 +349,128 @@ func SaveConfig(config *Config) error {
 // Loads from ~/.sbox/projects/<hash>/config.yaml if exists
 // Returns defaults otherwise
 func GetProjectConfig(workspacePath string) (*ProjectConfig, string, error) {
-	// Convert to absolute path
-	absPath, err := filepath.Abs(workspacePath)
+	// Load global config to get data directory
+	globalConfig, err := LoadConfig()
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to get absolute path: %w", err)
+		return nil, "", fmt.Errorf("failed to load global config: %w", err)
 	}
 
-	// Compute project hash (first 12 chars of SHA256)
-	hash := sha256.Sum256([]byte(absPath))
-	projectHash := hex.EncodeToString(hash[:])[:12]
+	return loadProjectConfig(globalConfig.SboxDataDir, workspacePath)
+}
 
-	// Default project config
-	projectConfig := &ProjectConfig{
-		Profiles: []string{},
+// projectConfigPath returns the path of the config of a project in the
+// sbox data directory
+func projectConfigPath(dataDir, workspacePath string) (string, error) {
+	_, id, err := projectIdentity(dataDir, workspacePath)
+	if err != nil {
+		return "", err
 	}
+	return filepath.Join(dataDir, "projects", id, "config.yaml"), nil
+}
 
-	// Load global config to get data directory
-	globalConfig, err := LoadConfig()
+// loadProjectConfig loads the configuration of a project from the sbox data
+// directory, see GetProjectConfig
+func loadProjectConfig(dataDir, workspacePath string) (*ProjectConfig, string, error) {
+	absPath, projectHash, err := projectIdentity(dataDir, workspacePath)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to load global config: %w", err)
+		return nil, "", err
+	}
+
+	projectConfig, err := loadProjectConfigByID(dataDir, projectHash)
+	if err != nil {
+		return nil, "", err
+	}
+
+	zlog.Debug("loaded project config",
+		zap.String("workspace", absPath),
+		zap.String("project_hash", projectHash),
+		zap.Strings("profiles", projectConfig.Profiles))
+
+	return projectConfig, projectHash, nil
+}
+
+// loadProjectConfigByID loads the configuration of a project by ID, returning
+// defaults when the project has none
+func loadProjectConfigByID(dataDir, projectHash string) (*ProjectConfig, error) {
+	// Default project config
+	projectConfig := &ProjectConfig{
+		Profiles: []string{},
 	}
 
 	// Try to load project config
-	projectDir := filepath.Join(globalConfig.SboxDataDir, "projects", projectHash)
-	configPath := filepath.Join(projectDir, "config.yaml")
+	configPath := filepath.Join(dataDir, "projects", projectHash, "config.yaml")
 
 	data, err := os.ReadFile(configPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			// Project config doesn't exist, return defaults
 			zlog.Debug("no project config found, using defaults",
-				zap.String("workspace", absPath),
 				zap.String("project_hash", projectHash),
 				zap.String("config_path", configPath))
-			return projectConfig, projectHash, nil
+			return projectConfig, nil
 		}
-		return nil, "", fmt.Errorf("failed to read project config: %w", err)
+		return nil, fmt.Errorf("failed to read project config: %w", err)
 	}
 
-	// Parse YAML
+	// Upgrade files written by older sbox versions, then parse YAML
+	if data, err = migrateConfigData(ConfigKindProject, configPath, data); err != nil {
+		return nil, err
+	}
 	if err := yaml.Unmarshal(data, projectConfig); err != nil {
-		return nil, "", fmt.Errorf("failed to parse project config: %w", err)
+		return nil, fmt.Errorf("failed to parse project config: %w", err)
 	}
+	projectConfig.Version = CurrentConfigVersion(ConfigKindProject)
 
-	zlog.Debug("loaded project config",
-		zap.String("workspace", absPath),
-		zap.String("project_hash", projectHash),
-		zap.String("config_path", configPath),
-		zap.Strings("profiles", projectConfig.Profiles))
-
-	return projectConfig, projectHash, nil
+	return projectConfig, nil
 }
 
-// SaveProjectConfig saves the per-project configuration
+// SaveProjectConfig saves the per-project configuration. Prefer
+// ConfigStore.UpdateProjectConfig, which doesn't lose concurrent changes.
 func SaveProjectConfig(workspacePath string, projectConfig *ProjectConfig) error {
-	// Convert to absolute path
-	absPath, err := filepath.Abs(workspacePath)
+	// Load global config to get data directory
+	globalConfig, err := LoadConfig()
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path: %w", err)
+		return fmt.Errorf("failed to load global config: %w", err)
 	}
 
-	// Ensure workspace path is stored in the config
-	projectConfig.WorkspacePath = absPath
-
-	// Compute project hash
-	hash := sha256.Sum256([]byte(absPath))
-	projectHash := hex.EncodeToString(hash[:])[:12]
+	return saveProjectConfig(globalConfig.SboxDataDir, workspacePath, projectConfig)
+}
 
-	// Load global config to get data directory
-	globalConfig, err := LoadConfig()
+// saveProjectConfig writes the configuration of a project to the sbox data
+// directory, see SaveProjectConfig. The project ID is recorded in the
+// workspace, so the project is found again after a move.
+func saveProjectConfig(dataDir, workspacePath string, projectConfig *ProjectConfig) error {
+	absPath, projectHash, err := projectIdentity(dataDir, workspacePath)
 	if err != nil {
-		return fmt.Errorf("failed to load global config: %w", err)
+		return err
+	}
+
+	// A moved project keeps its recorded path until it is moved with its
+	// sandbox, see MoveProject
+	workspacePathFor := absPath
+	if movedFrom := ProjectMovedFrom(absPath, projectConfig); movedFrom != "" {
+		workspacePathFor = movedFrom
+	}
+
+	if readProjectID(absPath) != projectHash {
+		if err := writeProjectID(absPath, projectHash); err != nil {
+			return err
+		}
+	}
+
+	return saveProjectConfigByID(dataDir, projectHash, workspacePathFor, projectConfig)
+}
+
+// saveProjectConfigByID writes the configuration of a project by ID
+func saveProjectConfigByID(dataDir, projectHash, absPath string, projectConfig *ProjectConfig) error {
+	// Ensure workspace path is stored in the config
+	projectConfig.WorkspacePath = absPath
+	if projectConfig.Fingerprint == "" {
+		projectConfig.Fingerprint = workspaceFingerprint(absPath)
 	}
 
 	// Ensure project directory exists
-	projectDir := filepath.Join(globalConfig.SboxDataDir, "projects", projectHash)
+	projectDir := filepath.Join(dataDir, "projects", projectHash)
 	if err := os.MkdirAll(projectDir, 0755); err != nil {
 		return fmt.Errorf("failed to create project directory: %w", err)
 	}
@@ -287,13 +478,18 @@ func SaveProjectConfig(workspacePath string, projectConfig *ProjectConfig) error
 	configPath := filepath.Join(projectDir, "config.yaml")
 
 	// Serialize to YAML
+	projectConfig.Version = CurrentConfigVersion(ConfigKindProject)
 	data, err := yaml.Marshal(projectConfig)
 	if err != nil {
 		return fmt.Errorf("failed to serialize project config: %w", err)
 	}
 
-	// Write to file
-	if err := os.WriteFile(configPath, data, 0644); err != nil {
+	if err := backupOutdatedConfig(ConfigKindProject, configPath); err != nil {
+		return err
+	}
+
+	// Write atomically, so concurrent readers never see a partial file
+	if err := writeFileAtomic(configPath, data, 0644); err != nil {
 		return fmt.Errorf("failed to write project config file: %w", err)
 	}
 
@@ -306,22 +502,19 @@ func SaveProjectConfig(workspacePath string, projectConfig *ProjectConfig) error
 }
 
 // RemoveProjectData removes all stored data for a project.
-// This includes the project config and any cached files.
+// This includes the project config and any cached files. The project ID
+// recorded in the workspace is removed last, once the data it leads to is gone.
 func RemoveProjectData(workspacePath string) error {
-	// Convert to absolute path
-	absPath, err := filepath.Abs(workspacePath)
+	// Load global config to get data directory
+	globalConfig, err := LoadConfig()
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path: %w", err)
+		return fmt.Errorf("failed to load global config: %w", err)
 	}
 
 	// Compute project hash
-	hash := sha256.Sum256([]byte(absPath))
-	projectHash := hex.EncodeToString(hash[:])[:12]
-
-	// Load global config to get data directory
-	globalConfig, err := LoadConfig()
+	absPath, projectHash, err := projectIdentity(globalConfig.SboxDataDir, workspacePath)
 	if err != nil {
-		return fmt.Errorf("failed to load global config: %w", err)
+		return err
 	}
 
 	// Remove project directory
@@ -336,6 +529,13 @@ func RemoveProjectData(workspacePath string) error {
 		return fmt.Errorf("failed to remove project directory: %w", err)
 	}
 
+	if readProjectID(absPath) == projectHash {
+		if err := os.Remove(filepath.Join(absPath, ".sbox", ProjectIDFileName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove project id: %w", err)
+		}
+		_ = os.Remove(filepath.Join(absPath, ".sbox"))
+	}
+
 	zlog.Info("removed project data",
 		zap.String("workspace", absPath),
 		zap.String("project_hash", projectHash))
@@ -359,6 +559,13 @@ type ProjectInfo struct {
 
 	// ContainerID is the container ID if the sandbox is running
 	ContainerID string
+
+	// Missing indicates the workspace path no longer exists
+	Missing bool
+
+	// MovedTo is the path of another known project from the same git
+	// repository when the workspace is missing, where it was likely moved
+	MovedTo string
 }
 
 // ListProjects returns information about all known projects
@@ -368,7 +575,12 @@ func ListProjects() ([]ProjectInfo, error) {
 		return nil, fmt.Errorf("failed to load global config: %w", err)
 	}
 
-	projectsDir := filepath.Join(globalConfig.SboxDataDir, "projects")
+	return listProjects(globalConfig.SboxDataDir)
+}
+
+// listProjects returns the projects of a sbox data directory
+func listProjects(dataDir string) ([]ProjectInfo, error) {
+	projectsDir := filepath.Join(dataDir, "projects")
 
 	// Check if projects directory exists
 	if _, err := os.Stat(projectsDir); os.IsNotExist(err) {
@@ -410,10 +622,29 @@ func ListProjects() ([]ProjectInfo, error) {
 			WorkspacePath: config.WorkspacePath,
 			Config:        &config,
 		}
+		if config.WorkspacePath != "" {
+			if _, err := os.Stat(config.WorkspacePath); os.IsNotExist(err) {
+				info.Missing = true
+			}
+		}
 
 		projects = append(projects, info)
 	}
 
+	// Projects of missing workspaces were likely moved to a project of the
+	// same repository registered since
+	for i := range projects {
+		if !projects[i].Missing || projects[i].Config.Fingerprint == "" {
+			continue
+		}
+		for _, other := range projects {
+			if !other.Missing && other.WorkspacePath != "" && other.Config.Fingerprint == projects[i].Config.Fingerprint {
+				projects[i].MovedTo = other.WorkspacePath
+				break
+			}
+		}
+	}
+
 	return projects, nil
 }
 
@@ -434,27 +665,21 @@ func FindSboxFile(startDir string) (*SboxFileLocation, error) {
 		info, err := os.Stat(sboxPath)
 		if err == nil && !info.IsDir() {
 			// Found sbox.yaml file (and it's not a directory)
-			data, err := os.ReadFile(sboxPath)
+			location, err := LoadSboxFile(sboxPath)
 			if err != nil {
-				return nil, fmt.Errorf("failed to read %s file: %w", SboxFileName, err)
-			}
-
-			var config SboxFileConfig
-			if err := yaml.Unmarshal(data, &config); err != nil {
-				return nil, fmt.Errorf("failed to parse %s file: %w", SboxFileName, err)
+				return nil, err
 			}
+			config := location.Config
 
 			zlog.Debug("found sbox.yaml file",
 				zap.String("path", sboxPath),
 				zap.Strings("profiles", config.Profiles),
 				zap.Strings("volumes", config.Volumes),
+				zap.Strings("env_files", config.EnvFiles),
+				zap.Strings("layers", location.Layers),
 				zap.String("docker_socket", config.DockerSocket))
 
-			return &SboxFileLocation{
-				Path:   sboxPath,
-				Dir:    currentDir,
-				Config: &config,
-			}, nil
+			return location, nil
 		}
 
 		// Move to parent directory
@@ -522,12 +747,19 @@ func MergeProjectConfig(projectConfig *ProjectConfig, sboxFile *SboxFileLocation
 
 	sboxConfig := sboxFile.Config
 	merged := &ProjectConfig{
-		Profiles:     projectConfig.Profiles,
-		Volumes:      projectConfig.Volumes,
-		DockerSocket: projectConfig.DockerSocket,
-		Envs:         projectConfig.Envs,
-		Backend:      projectConfig.Backend,
-		Agent:        projectConfig.Agent,
+		Profiles:       projectConfig.Profiles,
+		Volumes:        projectConfig.Volumes,
+		DockerSocket:   projectConfig.DockerSocket,
+		Envs:           projectConfig.Envs,
+		Backend:        projectConfig.Backend,
+		Agent:          projectConfig.Agent,
+		Resources:      projectConfig.Resources,
+		Network:        projectConfig.Network,
+		Ports:          projectConfig.Ports,
+		SSH:            projectConfig.SSH,
+		SSHAllowedKeys: projectConfig.SSHAllowedKeys,
+		SecretEnvs:     projectConfig.SecretEnvs,
+		Credentials:    projectConfig.Credentials,
 	}
 
 	// Merge profiles (combine both lists, removing duplicates)
@@ -576,6 +808,13 @@ func MergeProjectConfig(projectConfig *ProjectConfig, sboxFile *SboxFileLocation
 		}
 	}
 
+	// Merge secret env names (combine, removing duplicates)
+	for _, name := range sboxConfig.SecretEnvs {
+		if !slices.Contains(merged.SecretEnvs, name) {
+			merged.SecretEnvs = append(merged.SecretEnvs, name)
+		}
+	}
+
 	// Override docker_socket if set in sbox.yaml file
 	if sboxConfig.DockerSocket != "" {
 		merged.DockerSocket = sboxConfig.DockerSocket
@@ -591,13 +830,66 @@ func MergeProjectConfig(projectConfig *ProjectConfig, sboxFile *SboxFileLocation
 		merged.Agent = sboxConfig.Agent
 	}
 
+	// Merge resource limits (sbox.yaml limits override project ones)
+	if sboxConfig.Resources != nil {
+		if err := sboxConfig.Resources.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid resources in sbox.yaml file: %w", err)
+		}
+		merged.Resources = MergeResourceLimits(merged.Resources, sboxConfig.Resources)
+	}
+
+	// Override network policy if set in sbox.yaml file
+	if sboxConfig.Network != nil {
+		if err := sboxConfig.Network.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid network policy in sbox.yaml file: %w", err)
+		}
+		merged.Network = sboxConfig.Network
+	}
+
+	// Merge ports (combine, dedup by spec)
+	if _, err := ParsePortMappings(sboxConfig.Ports); err != nil {
+		return nil, fmt.Errorf("invalid ports in sbox.yaml file: %w", err)
+	}
+	for _, port := range sboxConfig.Ports {
+		if !slices.Contains(merged.Ports, port) {
+			merged.Ports = append(merged.Ports, port)
+		}
+	}
+
+	// Override SSH settings if set in sbox.yaml file
+	if err := ValidateSSHMode(sboxConfig.SSH); err != nil {
+		return nil, fmt.Errorf("invalid ssh setting in sbox.yaml file: %w", err)
+	}
+	if err := ValidateSSHAllowedKeys(sboxConfig.SSHAllowedKeys); err != nil {
+		return nil, fmt.Errorf("invalid ssh_allowed_keys in sbox.yaml file: %w", err)
+	}
+	if sboxConfig.SSH != "" {
+		merged.SSH = sboxConfig.SSH
+	}
+	if len(sboxConfig.SSHAllowedKeys) > 0 {
+		merged.SSHAllowedKeys = sboxConfig.SSHAllowedKeys
+	}
+
+	// Override the credential set if set in sbox.yaml file
+	if err := ValidateCredentialSetName(sboxConfig.Credentials); err != nil {
+		return nil, fmt.Errorf("invalid credentials in sbox.yaml file: %w", err)
+	}
+	if sboxConfig.Credentials != "" {
+		merged.Credentials = sboxConfig.Credentials
+	}
+
 	zlog.Debug("merged project config with sbox.yaml file",
 		zap.Strings("profiles", merged.Profiles),
 		zap.Strings("volumes", merged.Volumes),
 		zap.Strings("envs", MaskSensitiveEnvs(merged.Envs)),
 		zap.String("docker_socket", merged.DockerSocket),
 		zap.String("backend", merged.Backend),
-		zap.String("agent", merged.Agent))
+		zap.String("agent", merged.Agent),
+		zap.Strings("resources", merged.Resources.Describe()),
+		zap.String("network", merged.Network.Describe()),
+		zap.Strings("ports", merged.Ports),
+		zap.String("ssh", merged.SSH),
+		zap.String("credentials", merged.Credentials))
 
 	return merged, nil
 }
@@ -737,12 +1029,13 @@ func MaskEnvValue(value string) string {
 }
 
 // MaskSensitiveEnvs returns a copy of envs with sensitive values masked.
-// Used for safe logging of env vars.
+// Used for safe logging of env vars. secret:// references are kept as-is,
+// they only tell where the secret is fetched from at launch.
 func MaskSensitiveEnvs(envs []string) []string {
 	result := make([]string, len(envs))
 	for i, spec := range envs {
 		name := EnvName(spec)
-		if IsSensitiveEnvName(name) && strings.Contains(spec, "=") {
+		if IsSensitiveEnvName(name) && strings.Contains(spec, "=") && !IsSecretRef(spec[len(name)+1:]) {
 			value := spec[len(name)+1:]
 			result[i] = name + "=" + MaskEnvValue(value)
 		} else {
@@ -0,0 +1,270 @@
+package sbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigKind identifies a configuration format in the migration registry
+type ConfigKind string
+
+const (
+	// ConfigKindGlobal is the global ~/.config/sbox/config.yaml
+	ConfigKindGlobal ConfigKind = "config"
+
+	// ConfigKindProject is the per-project config.yaml of the sbox data directory
+	ConfigKindProject ConfigKind = "project config"
+
+	// ConfigKindSboxFile is the sbox.yaml file of a repository
+	ConfigKindSboxFile ConfigKind = "sbox.yaml"
+)
+
+// ConfigMigration upgrades a configuration document from version From to
+// From+1. Migrate edits the root mapping of the document in place, so the
+// comments of the file are kept, and reports whether it changed anything.
+type ConfigMigration struct {
+	Kind        ConfigKind
+	From        int
+	Description string
+	Migrate     func(root *yaml.Node) (changed bool, err error)
+}
+
+// configMigrations are the migrations of every format, the current version
+// of a format being the version its last migration upgrades to. Files
+// without a version are version 0, the format before versioning.
+var configMigrations = []ConfigMigration{
+	{Kind: ConfigKindGlobal, From: 0, Description: "add version", Migrate: addConfigVersion},
+	{Kind: ConfigKindProject, From: 0, Description: "add version", Migrate: addConfigVersion},
+	{Kind: ConfigKindProject, From: 1, Description: "remove sandbox_name", Migrate: removeSandboxName},
+	{Kind: ConfigKindSboxFile, From: 0, Description: "add version", Migrate: addConfigVersion},
+}
+
+// addConfigVersion is the migration of unversioned files, whose format is
+// the one of version 1
+func addConfigVersion(root *yaml.Node) (bool, error) {
+	return false, nil
+}
+
+// removeSandboxName drops the sandbox name saved by older versions in the
+// project configs: it is derived from the workspace and agent on each run
+// (see GenerateSandboxName), a saved one only went stale once the workspace
+// was renamed
+func removeSandboxName(root *yaml.Node) (bool, error) {
+	return removeYAMLMappingKey(root, "sandbox_name"), nil
+}
+
+// CurrentConfigVersion returns the version of a configuration format written
+// by this sbox binary
+func CurrentConfigVersion(kind ConfigKind) int {
+	version := 0
+	for _, migration := range configMigrations {
+		if migration.Kind == kind && migration.From+1 > version {
+			version = migration.From + 1
+		}
+	}
+	return version
+}
+
+// findConfigMigration returns the migration of a format from a version
+func findConfigMigration(kind ConfigKind, from int) (ConfigMigration, bool) {
+	for _, migration := range configMigrations {
+		if migration.Kind == kind && migration.From == from {
+			return migration, true
+		}
+	}
+	return ConfigMigration{}, false
+}
+
+// migrateConfigData upgrades data, the content of the configuration file at
+// path, to the current version of its format in memory and returns the
+// upgraded content. The file itself is left untouched: the global and project
+// configs are written in the current version on their next save, after a
+// backup (see backupOutdatedConfig), sbox.yaml files by 'sbox config migrate'
+// (see MigrateConfigFile). Files only missing
+// the new version number are returned as-is. Files newer than the running
+// sbox binary are refused.
+func migrateConfigData(kind ConfigKind, path string, data []byte) ([]byte, error) {
+	migrated, _, err := upgradeConfigData(kind, path, data)
+	return migrated, err
+}
+
+// MigrateConfigFile upgrades the configuration file at path to the current
+// version of its format. When a migration changes its content, the file is
+// backed up to <path>.v<version>.bak and rewritten in place. Returns the
+// version the file had and the backup path, empty when the file was left
+// untouched.
+func MigrateConfigFile(kind ConfigKind, path string) (from int, backupPath string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	migrated, from, err := upgradeConfigData(kind, path, data)
+	if err != nil {
+		return 0, "", err
+	}
+	if bytes.Equal(migrated, data) {
+		return from, "", nil
+	}
+
+	backupPath = fmt.Sprintf("%s.v%d.bak", path, from)
+	if err := writeFileAtomic(backupPath, data, 0600); err != nil {
+		return 0, "", fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+	if err := writeFileAtomic(path, migrated, 0644); err != nil {
+		return 0, "", fmt.Errorf("failed to write migrated %s: %w", path, err)
+	}
+	return from, backupPath, nil
+}
+
+// backupOutdatedConfig backs up the configuration file at path to
+// <path>.v<version>.bak, as MigrateConfigFile does, when it is in an older
+// version of its format: saving it in the current version rewrites it
+// entirely. Called before each save, so only the first one backs it up.
+func backupOutdatedConfig(kind ConfigKind, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	version, err := configDocumentVersion(&document, path)
+	if err != nil {
+		return err
+	}
+	if version >= CurrentConfigVersion(kind) {
+		return nil
+	}
+
+	backupPath := fmt.Sprintf("%s.v%d.bak", path, version)
+	if err := writeFileAtomic(backupPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+	zlog.Info("backed up config before saving it in the current version",
+		zap.String("kind", string(kind)),
+		zap.String("path", path),
+		zap.String("backup", backupPath))
+	return nil
+}
+
+// configDocumentVersion returns the version of a parsed configuration file,
+// 0 when it has none
+func configDocumentVersion(document *yaml.Node, path string) (int, error) {
+	if document.Kind != yaml.DocumentNode || len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return 0, nil
+	}
+
+	versionNode := yamlMappingValue(document.Content[0], "version")
+	if versionNode == nil {
+		return 0, nil
+	}
+	version, err := strconv.Atoi(versionNode.Value)
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("invalid version %q in %s, expected a positive number", versionNode.Value, path)
+	}
+	return version, nil
+}
+
+// upgradeConfigData is migrateConfigData, also returning the version data had
+func upgradeConfigData(kind ConfigKind, path string, data []byte) ([]byte, int, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, 0, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if document.Kind != yaml.DocumentNode || len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+		return data, 0, nil
+	}
+	root := document.Content[0]
+
+	version, err := configDocumentVersion(&document, path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	current := CurrentConfigVersion(kind)
+	if version > current {
+		return nil, 0, fmt.Errorf("%s %s has version %d, newer than version %d supported by this sbox binary; please update sbox", kind, path, version, current)
+	}
+	if version == current {
+		return data, version, nil
+	}
+
+	changed := false
+	for from := version; from < current; from++ {
+		migration, ok := findConfigMigration(kind, from)
+		if !ok {
+			return nil, 0, fmt.Errorf("no migration of %s from version %d", kind, from)
+		}
+		migrationChanged, err := migration.Migrate(root)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to migrate %s %s from version %d (%s): %w", kind, path, from, migration.Description, err)
+		}
+		changed = changed || migrationChanged
+
+		zlog.Debug("migrated config",
+			zap.String("kind", string(kind)),
+			zap.String("path", path),
+			zap.Int("from", from),
+			zap.String("migration", migration.Description))
+	}
+
+	// Version-only upgrades keep the file (and its line numbers) as-is
+	if !changed {
+		return data, version, nil
+	}
+
+	setYAMLMappingValue(root, "version", strconv.Itoa(current))
+
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&document); err != nil {
+		return nil, 0, fmt.Errorf("failed to serialize migrated %s: %w", path, err)
+	}
+	return buffer.Bytes(), version, nil
+}
+
+// yamlMappingValue returns the value node of a key of a mapping
+func yamlMappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// removeYAMLMappingKey removes a key of a mapping, reporting whether it was there
+func removeYAMLMappingKey(mapping *yaml.Node, key string) bool {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content = append(mapping.Content[:i], mapping.Content[i+2:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// setYAMLMappingValue sets a scalar value of a mapping, adding the key first
+// when missing
+func setYAMLMappingValue(mapping *yaml.Node, key, value string) {
+	if node := yamlMappingValue(mapping, key); node != nil {
+		node.Kind, node.Tag, node.Value, node.Content = yaml.ScalarNode, "", value, nil
+		return
+	}
+	mapping.Content = append([]*yaml.Node{
+		{Kind: yaml.ScalarNode, Value: key},
+		{Kind: yaml.ScalarNode, Value: value},
+	}, mapping.Content...)
+}
@@ -0,0 +1,146 @@
+package sbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"go.uber.org/zap"
+)
+
+// ConfigStore gives access to the global config, loaded once, and to the
+// project configs of its data directory. Updates are read-modify-write
+// cycles done under an advisory lock on the file, so sbox commands running
+// in other terminals don't lose each other's changes.
+type ConfigStore struct {
+	config *Config
+}
+
+// NewConfigStore loads the global config
+func NewConfigStore() (*ConfigStore, error) {
+	config, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &ConfigStore{config: config}, nil
+}
+
+// Config returns the global config. It is refreshed in place by UpdateConfig.
+func (s *ConfigStore) Config() *Config {
+	return s.config
+}
+
+// ProjectConfig loads the configuration of a project, see GetProjectConfig
+func (s *ConfigStore) ProjectConfig(workspacePath string) (*ProjectConfig, string, error) {
+	return loadProjectConfig(s.config.SboxDataDir, workspacePath)
+}
+
+// UpdateConfig applies update to the global config as currently on disk and
+// saves it, holding the config lock. The config returned by Config is
+// refreshed with the result.
+func (s *ConfigStore) UpdateConfig(update func(config *Config) error) error {
+	configPath := filepath.Join(s.config.SboxDataDir, "config.yaml")
+	unlock, err := lockFile(configPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	config, err := loadConfigFile(s.config.SboxDataDir)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if err := update(config); err != nil {
+		return err
+	}
+	if err := SaveConfig(config); err != nil {
+		return err
+	}
+
+	*s.config = *config
+	return nil
+}
+
+// UpdateProjectConfig applies update to the configuration of a project as
+// currently on disk and saves it, holding the project config lock. Returns
+// the saved config.
+func (s *ConfigStore) UpdateProjectConfig(workspacePath string, update func(projectConfig *ProjectConfig) error) (*ProjectConfig, error) {
+	configPath, err := projectConfigPath(s.config.SboxDataDir, workspacePath)
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := lockFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	projectConfig, _, err := loadProjectConfig(s.config.SboxDataDir, workspacePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load project config: %w", err)
+	}
+	if err := update(projectConfig); err != nil {
+		return nil, err
+	}
+	if err := saveProjectConfig(s.config.SboxDataDir, workspacePath, projectConfig); err != nil {
+		return nil, err
+	}
+	return projectConfig, nil
+}
+
+// lockFile takes an exclusive advisory lock on path, through a <path>.lock
+// file, waiting for other sbox processes holding it. Returns the function
+// releasing the lock.
+func lockFile(path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	lockPath := path + ".lock"
+	file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file: %w", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+
+	zlog.Debug("locked file", zap.String("path", lockPath))
+	return func() {
+		syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+		file.Close()
+	}, nil
+}
+
+// writeFileAtomic writes a file through a temporary file renamed over it, so
+// readers never see a partial file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
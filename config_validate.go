@@ -0,0 +1,487 @@
+package sbox
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DockerSocketModes lists the accepted docker_socket values
+var DockerSocketModes = []string{"auto", "always", "never"}
+
+// ValidateDockerSocket checks a docker_socket value, empty meaning the default
+func ValidateDockerSocket(value string) error {
+	switch value {
+	case "", "auto", "always", "never":
+		return nil
+	default:
+		return fmt.Errorf("invalid docker_socket %q, valid values: %v", value, DockerSocketModes)
+	}
+}
+
+// ConfigIssue is a problem found in a configuration file
+type ConfigIssue struct {
+	// Line is the line of the offending value, 0 when unknown
+	Line int
+
+	// Field is the path of the offending field, e.g. "volumes[1]"
+	Field string
+
+	// Message describes the problem
+	Message string
+}
+
+func (i ConfigIssue) String() string {
+	if i.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", i.Line, i.Field, i.Message)
+	}
+	return fmt.Sprintf("%s: %s", i.Field, i.Message)
+}
+
+// ConfigValidationError lists the issues found in a configuration file
+type ConfigValidationError struct {
+	Path   string
+	Issues []ConfigIssue
+}
+
+func (e *ConfigValidationError) Error() string {
+	lines := make([]string, 0, len(e.Issues)+1)
+	lines = append(lines, fmt.Sprintf("invalid configuration %s:", e.Path))
+	for _, issue := range e.Issues {
+		lines = append(lines, "  "+issue.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// configIssues collects the issues of a configuration document, locating the
+// offending values in it
+type configIssues struct {
+	document *yaml.Node
+	issues   []ConfigIssue
+}
+
+// add records an issue of a top-level field, or of its index-th item when
+// index >= 0
+func (c *configIssues) add(field string, index int, err error) {
+	if err == nil {
+		return
+	}
+	node := yamlFieldNode(c.document, field)
+	path := field
+	if index >= 0 {
+		path = fmt.Sprintf("%s[%d]", field, index)
+		if node != nil && node.Kind == yaml.SequenceNode && index < len(node.Content) {
+			node = node.Content[index]
+		}
+	}
+
+	issue := ConfigIssue{Field: path, Message: err.Error()}
+	if node != nil {
+		issue.Line = node.Line
+	}
+	c.issues = append(c.issues, issue)
+}
+
+// err returns the issues as a ConfigValidationError, nil when there are none
+func (c *configIssues) err(path string) error {
+	if len(c.issues) == 0 {
+		return nil
+	}
+	sort.SliceStable(c.issues, func(i, j int) bool { return c.issues[i].Line < c.issues[j].Line })
+	return &ConfigValidationError{Path: path, Issues: c.issues}
+}
+
+// decodeConfigDocument parses a YAML configuration, expanding ${VAR}
+// references when interpolate is set, and decodes it into out. Returns the
+// unknown fields as issues, to which the value checks are then added.
+func decodeConfigDocument(path string, data []byte, interpolate bool, out any) (*configIssues, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	issues := &configIssues{document: &document}
+	if document.Kind == 0 {
+		return issues, nil
+	}
+
+	if interpolate {
+		if err := interpolateYAMLNode(&document, os.LookupEnv); err != nil {
+			return nil, fmt.Errorf("failed to interpolate %s: %w", path, err)
+		}
+	}
+
+	if err := document.Decode(out); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	issues.issues = checkKnownFields(&document, reflect.TypeOf(out).Elem(), "")
+	return issues, nil
+}
+
+var yamlUnmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+// checkKnownFields reports the mapping keys of node that don't match a yaml
+// field of t, recursing into nested structs, slices and maps
+func checkKnownFields(node *yaml.Node, t reflect.Type, path string) []ConfigIssue {
+	for node.Kind == yaml.DocumentNode || node.Kind == yaml.AliasNode {
+		if node.Kind == yaml.AliasNode {
+			node = node.Alias
+		} else if len(node.Content) > 0 {
+			node = node.Content[0]
+		} else {
+			return nil
+		}
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(yamlUnmarshalerType) {
+		return nil
+	}
+
+	var issues []ConfigIssue
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			fieldPath := joinFieldPath(path, key.Value)
+			field, ok := fields[key.Value]
+			if !ok {
+				message := "unknown field"
+				if suggestion := closestField(key.Value, fields); suggestion != "" {
+					message += fmt.Sprintf(" (did you mean %q?)", suggestion)
+				}
+				issues = append(issues, ConfigIssue{Line: key.Line, Field: fieldPath, Message: message})
+				continue
+			}
+			issues = append(issues, checkKnownFields(value, field.Type, fieldPath)...)
+		}
+
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return nil
+		}
+		for i, item := range node.Content {
+			issues = append(issues, checkKnownFields(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			issues = append(issues, checkKnownFields(node.Content[i+1], t.Elem(), joinFieldPath(path, node.Content[i].Value))...)
+		}
+	}
+	return issues
+}
+
+// yamlFields returns the fields of a struct by yaml name, including the
+// fields of inlined structs
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("yaml")
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		if strings.Contains(options, "inline") {
+			for inlineName, inlineField := range yamlFields(field.Type) {
+				fields[inlineName] = inlineField
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+// closestField returns the known field name closest to an unknown one, if
+// it is close enough to be a typo
+func closestField(name string, fields map[string]reflect.StructField) string {
+	best, bestDistance := "", 3
+	for candidate := range fields {
+		distance := editDistance(name, candidate)
+		if distance < bestDistance || (distance == bestDistance && candidate < best) {
+			best, bestDistance = candidate, distance
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
+
+func joinFieldPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// yamlFieldNode returns the value node of a top-level field of a document
+func yamlFieldNode(document *yaml.Node, field string) *yaml.Node {
+	if document == nil || document.Kind != yaml.DocumentNode || len(document.Content) == 0 {
+		return nil
+	}
+	node := document.Content[0]
+	for _, name := range strings.Split(field, ".") {
+		if node == nil || node.Kind != yaml.MappingNode {
+			return nil
+		}
+		node = yamlMappingValue(node, name)
+	}
+	return node
+}
+
+// validateEnvSpecs checks env specs with validate and their variable names
+func validateEnvSpecs(issues *configIssues, field string, specs []string, validate func(spec string) error) {
+	for i, spec := range specs {
+		if !isEnvVarName(EnvName(spec)) {
+			issues.add(field, i, fmt.Errorf("invalid environment variable name %q", EnvName(spec)))
+			continue
+		}
+		issues.add(field, i, validate(spec))
+	}
+}
+
+// validateEnvNames checks a list of variable names
+func validateEnvNames(issues *configIssues, field string, names []string) {
+	for i, name := range names {
+		if !isEnvVarName(name) {
+			issues.add(field, i, fmt.Errorf("invalid environment variable name %q", name))
+		}
+	}
+}
+
+// validatePorts checks each port mapping, then that no host port is used twice
+func validatePorts(issues *configIssues, field string, ports []string) {
+	valid := true
+	for i, port := range ports {
+		if _, err := ParsePortMapping(port); err != nil {
+			issues.add(field, i, err)
+			valid = false
+		}
+	}
+	if valid {
+		_, err := ParsePortMappings(ports)
+		issues.add(field, -1, err)
+	}
+}
+
+// validateSSHAllowedKeys checks each key fingerprint
+func validateSSHAllowedKeys(issues *configIssues, field string, fingerprints []string) {
+	for i, fingerprint := range fingerprints {
+		issues.add(field, i, ValidateSSHAllowedKeys([]string{fingerprint}))
+	}
+}
+
+// validateProfileRefs checks the syntax of profile references, and that they
+// name known profiles with valid parameters when registry is set
+func validateProfileRefs(issues *configIssues, field string, refs []string, registry *ProfileRegistry) {
+	for i, ref := range refs {
+		parsed, err := ParseProfileRef(ref)
+		if err != nil {
+			issues.add(field, i, err)
+			continue
+		}
+		if registry == nil {
+			continue
+		}
+		profile, ok := registry.Get(parsed.Name)
+		if !ok {
+			issues.add(field, i, fmt.Errorf("unknown profile %q (available: %s)", parsed.Name, strings.Join(registry.List(), ", ")))
+			continue
+		}
+		if _, err := profile.ResolveParams(parsed.Params); err != nil {
+			issues.add(field, i, err)
+		}
+	}
+}
+
+// validateSboxFileConfig checks the values of a sbox.yaml file
+func validateSboxFileConfig(issues *configIssues, config *SboxFileConfig) {
+	validateProfileRefs(issues, "profiles", config.Profiles, nil)
+	for i, volume := range config.Volumes {
+		_, _, _, err := ParseVolumeSpec(volume)
+		issues.add("volumes", i, err)
+	}
+	issues.add("docker_socket", -1, ValidateDockerSocket(config.DockerSocket))
+	validateEnvSpecs(issues, "envs", config.Envs, ValidateSboxFileEnvSpec)
+	issues.add("backend", -1, ValidateBackend(config.Backend))
+	issues.add("agent", -1, ValidateAgent(config.Agent))
+	if config.LoopConfirmations < 0 {
+		issues.add("loop_confirmations", -1, fmt.Errorf("must be positive"))
+	}
+	if config.Loop != nil {
+		for i, check := range config.Loop.Verify {
+			if strings.TrimSpace(check) == "" {
+				issues.add("loop.verify", i, fmt.Errorf("empty verification command"))
+			}
+		}
+	}
+	issues.add("resources", -1, config.Resources.Validate())
+	issues.add("network", -1, config.Network.Validate())
+	validatePorts(issues, "ports", config.Ports)
+	issues.add("ssh", -1, ValidateSSHMode(config.SSH))
+	validateSSHAllowedKeys(issues, "ssh_allowed_keys", config.SSHAllowedKeys)
+	validateEnvNames(issues, "secret_envs", config.SecretEnvs)
+	issues.add("credentials", -1, ValidateCredentialSetName(config.Credentials))
+}
+
+// validateGlobalConfig checks the values of the global config file
+func validateGlobalConfig(issues *configIssues, config *Config) {
+	issues.add("docker_socket", -1, ValidateDockerSocket(config.DockerSocket))
+	validateProfileRefs(issues, "default_profiles", config.DefaultProfiles, nil)
+	validateEnvSpecs(issues, "envs", config.Envs, ValidateEnvSpec)
+	issues.add("default_backend", -1, ValidateBackend(config.DefaultBackend))
+	issues.add("default_agent", -1, ValidateAgent(config.DefaultAgent))
+	if config.LoopConfirmations < 0 {
+		issues.add("loop_confirmations", -1, fmt.Errorf("must be positive"))
+	}
+	issues.add("resources", -1, config.Resources.Validate())
+	issues.add("ssh", -1, ValidateSSHMode(config.SSH))
+	validateSSHAllowedKeys(issues, "ssh_allowed_keys", config.SSHAllowedKeys)
+	validateEnvNames(issues, "secret_envs", config.SecretEnvs)
+	for _, name := range config.CredentialSetNames() {
+		if err := ValidateCredentialSetName(name); err != nil {
+			issues.add("credentials", -1, err)
+		} else if err := ValidateCredentialProvider(config.Credentials[name].Provider); err != nil {
+			issues.add("credentials", -1, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	issues.add("default_credentials", -1, ValidateCredentialSetName(config.DefaultCredentials))
+}
+
+// loadSboxFile loads and validates a single sbox.yaml file: values are
+// interpolated, unknown fields are rejected and every value is checked, the
+// issues being returned as a *ConfigValidationError. Its env_files are then
+// loaded. Returns the parsed document to locate values in it.
+func loadSboxFile(path string) (*SboxFileLocation, *yaml.Node, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s file: %w", SboxFileName, err)
+	}
+	migrated, err := migrateConfigData(ConfigKindSboxFile, absPath, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(migrated, data) {
+		DefaultUI.Warn("%s uses an older format, run 'sbox config migrate %s' to upgrade it", absPath, absPath)
+	}
+	data = migrated
+
+	var config SboxFileConfig
+	issues, err := decodeConfigDocument(absPath, data, true, &config)
+	if err != nil {
+		return nil, nil, err
+	}
+	validateSboxFileConfig(issues, &config)
+	if err := issues.err(absPath); err != nil {
+		return nil, nil, err
+	}
+
+	config.Version = CurrentConfigVersion(ConfigKindSboxFile)
+
+	location := &SboxFileLocation{
+		Path:   absPath,
+		Dir:    filepath.Dir(absPath),
+		Config: &config,
+	}
+	if err := loadEnvFiles(location); err != nil {
+		return nil, nil, fmt.Errorf("invalid env_files in %s file: %w", SboxFileName, err)
+	}
+	return location, issues.document, nil
+}
+
+// ValidateConfigFile validates a configuration file: the global config file
+// when path is GlobalConfigPath, a sbox.yaml file otherwise. The layers of a
+// sbox.yaml file are loaded as well and, unlike loading, its profiles are
+// also checked against the profiles available to its directory.
+func ValidateConfigFile(config *Config, path string) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	if absPath == GlobalConfigPath(config) {
+		data, err := os.ReadFile(absPath)
+		if err != nil {
+			return fmt.Errorf("failed to read config file: %w", err)
+		}
+		if data, err = migrateConfigData(ConfigKindGlobal, absPath, data); err != nil {
+			return err
+		}
+		var globalConfig Config
+		issues, err := decodeConfigDocument(absPath, data, false, &globalConfig)
+		if err != nil {
+			return err
+		}
+		validateGlobalConfig(issues, &globalConfig)
+		return issues.err(absPath)
+	}
+
+	location, document, err := loadSboxFile(absPath)
+	if err != nil {
+		return err
+	}
+
+	// Profiles are available from the profiles_dir of any layer
+	layered, err := LoadSboxFile(absPath)
+	if err != nil {
+		return err
+	}
+	registry, err := LoadProfileRegistry(config, layered.Dir, layered)
+	if err != nil {
+		return fmt.Errorf("failed to load profiles: %w", err)
+	}
+
+	issues := &configIssues{document: document}
+	validateProfileRefs(issues, "profiles", location.Config.Profiles, registry)
+	return issues.err(absPath)
+}
+
+// GlobalConfigPath returns the path of the global config file
+func GlobalConfigPath(config *Config) string {
+	return filepath.Join(config.SboxDataDir, "config.yaml")
+}
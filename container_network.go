@@ -0,0 +1,234 @@
+package sbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Suffixes of the per-workspace resources backing an allowlist network policy
+const (
+	networkNameSuffix = "-net"
+	proxyNameSuffix   = "-netproxy"
+)
+
+// networkPolicyMountDir is where the proxy sidecar mounts (read-only) the
+// network policy directory
+const networkPolicyMountDir = "/etc/sbox/network"
+
+// containerNetwork is how a sandbox container is attached to the network for
+// a given policy
+type containerNetwork struct {
+	// Mode is the host config NetworkMode ("" for the engine default)
+	Mode string
+
+	// DNS are the DNS servers of the container
+	DNS []string
+
+	// Env are the proxy environment variables of the container
+	Env []string
+}
+
+// matches reports whether an existing container was created for this network setup
+func (n *containerNetwork) matches(container *DockerContainer) bool {
+	mode := container.HostConfig.NetworkMode
+	if n.Mode == "" {
+		return mode != "none" && !strings.HasSuffix(mode, networkNameSuffix)
+	}
+	return mode == n.Mode && slices.Equal(container.HostConfig.Dns, n.DNS)
+}
+
+// apply sets the network fields of a container configuration
+func (n *containerNetwork) apply(config *DockerContainerConfig) {
+	config.HostConfig.NetworkMode = n.Mode
+	config.HostConfig.Dns = n.DNS
+	config.Env = append(config.Env, n.Env...)
+}
+
+// prepareNetwork sets up what the network policy needs and returns how to
+// attach the sandbox container. For allowlist policies, this is an internal
+// network (no route outside) shared with a proxy sidecar, the only container
+// also connected to the default network. The sidecar runs `sbox network-proxy`
+// from the template image.
+func (b *ContainerBackend) prepareNetwork(client *DockerClient, containerName, workspaceDir, image string, policy *NetworkPolicy) (*containerNetwork, error) {
+	mode := policy.EffectiveMode()
+	if mode != NetworkModeAllowlist {
+		// Clean up the sidecar of a previous allowlist policy. The network stays
+		// while the old sandbox container still uses it, until `sbox stop --rm`.
+		if err := b.removeNetworkProxy(client, containerName, true); err != nil {
+			zlog.Debug("failed to remove previous network proxy", zap.Error(err))
+		}
+	}
+
+	switch mode {
+	case NetworkModeNone:
+		return &containerNetwork{Mode: "none"}, nil
+
+	case NetworkModeAllowlist:
+		policyDir, err := NetworkPolicyDir(b.config.SboxDataDir, workspaceDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve network policy directory: %w", err)
+		}
+		if err := WriteNetworkPolicy(policyDir, policy); err != nil {
+			return nil, err
+		}
+		// Earlier versions wrote the policy in the workspace, where the agent can edit it
+		if err := os.Remove(filepath.Join(workspaceDir, ".sbox", NetworkPolicyFileName)); err != nil && !os.IsNotExist(err) {
+			zlog.Debug("failed to remove legacy network policy", zap.Error(err))
+		}
+
+		networkName := containerName + networkNameSuffix
+		proxyIP, err := b.ensureNetworkProxy(client, containerName, workspaceDir, policyDir, image)
+		if err != nil {
+			return nil, fmt.Errorf("failed to start network proxy: %w", err)
+		}
+
+		proxyURL := fmt.Sprintf("http://%s:%d", NetworkProxyAlias, NetworkProxyPort)
+		noProxy := "localhost,127.0.0.1,::1"
+		return &containerNetwork{
+			Mode: networkName,
+			DNS:  []string{proxyIP},
+			Env: []string{
+				"HTTP_PROXY=" + proxyURL, "HTTPS_PROXY=" + proxyURL, "NO_PROXY=" + noProxy,
+				"http_proxy=" + proxyURL, "https_proxy=" + proxyURL, "no_proxy=" + noProxy,
+			},
+		}, nil
+	}
+
+	return &containerNetwork{}, nil
+}
+
+// ensureNetworkProxy creates the internal network and the proxy sidecar if
+// needed, starts the sidecar and returns its address on the internal network.
+// The sidecar reads the policy from policyDir, mounted read-only.
+func (b *ContainerBackend) ensureNetworkProxy(client *DockerClient, containerName, workspaceDir, policyDir, image string) (string, error) {
+	networkName := containerName + networkNameSuffix
+	proxyName := containerName + proxyNameSuffix
+
+	network, err := client.InspectNetwork(networkName)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect network %s: %w", networkName, err)
+	}
+	if network == nil {
+		zlog.Info("creating internal network", zap.String("network", networkName))
+		if _, err := client.CreateNetwork(networkName, true); err != nil {
+			return "", fmt.Errorf("failed to create network %s: %w", networkName, err)
+		}
+	}
+
+	proxy, err := client.InspectContainer(proxyName)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect %s: %w", proxyName, err)
+	}
+	if proxy != nil && !proxyMountsPolicyDir(proxy, policyDir) {
+		// Created by an earlier version, reading the policy from the workspace
+		zlog.Info("recreating network proxy reading a stale policy", zap.String("name", proxyName))
+		if err := client.RemoveContainer(proxy.ID, true); err != nil {
+			return "", fmt.Errorf("failed to remove %s: %w", proxyName, err)
+		}
+		proxy = nil
+	}
+	if proxy == nil {
+		zlog.Info("creating network proxy", zap.String("name", proxyName), zap.String("image", image))
+
+		sboxDir := filepath.Join(workspaceDir, ".sbox")
+		config := &DockerContainerConfig{
+			Image: image,
+			Cmd: []string{
+				"sbox", "network-proxy",
+				"--listen", fmt.Sprintf(":%d", NetworkProxyPort),
+				"--dns", ":53",
+				"--policy", networkPolicyMountDir + "/" + NetworkPolicyFileName,
+				"--log", "/sbox/" + NetworkLogFileName,
+			},
+		}
+		config.HostConfig.Binds = []string{
+			policyDir + ":" + networkPolicyMountDir + ":ro",
+			sboxDir + ":/sbox",
+		}
+		if b.backend == BackendPodman {
+			// Rootless Podman maps the container root to the host user, so the
+			// proxy can bind port 53 and the network log is owned by the host user
+			config.User = "root"
+			config.HostConfig.SecurityOpt = []string{"label=disable"}
+		}
+
+		id, err := client.CreateContainer(proxyName, config)
+		if err != nil {
+			return "", fmt.Errorf("failed to create %s: %w", proxyName, err)
+		}
+		if err := client.ConnectNetwork(networkName, id, []string{NetworkProxyAlias}); err != nil {
+			_ = client.RemoveContainer(id, true)
+			return "", fmt.Errorf("failed to connect %s to %s: %w", proxyName, networkName, err)
+		}
+	}
+
+	if err := client.StartContainer(proxyName); err != nil {
+		return "", fmt.Errorf("failed to start %s: %w", proxyName, err)
+	}
+
+	proxy, err = client.InspectContainer(proxyName)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect %s: %w", proxyName, err)
+	}
+	if proxy == nil {
+		return "", fmt.Errorf("%s disappeared after start", proxyName)
+	}
+	endpoint, ok := proxy.NetworkSettings.Networks[networkName]
+	if !ok || endpoint.IPAddress == "" {
+		return "", fmt.Errorf("%s has no address on %s", proxyName, networkName)
+	}
+	return endpoint.IPAddress, nil
+}
+
+// proxyMountsPolicyDir reports whether a proxy sidecar mounts the policy
+// directory read-only
+func proxyMountsPolicyDir(proxy *DockerContainer, policyDir string) bool {
+	for _, mount := range proxy.Mounts {
+		if mount.Destination == networkPolicyMountDir {
+			return mount.Source == policyDir && !mount.RW
+		}
+	}
+	return false
+}
+
+// removeNetworkProxy stops the proxy sidecar of a sandbox container, also
+// removing it and the internal network when remove is true. Missing resources
+// are ignored.
+func (b *ContainerBackend) removeNetworkProxy(client *DockerClient, containerName string, remove bool) error {
+	proxyName := containerName + proxyNameSuffix
+	proxy, err := client.InspectContainer(proxyName)
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s: %w", proxyName, err)
+	}
+	if proxy != nil {
+		if err := client.StopContainer(proxyName); err != nil {
+			return fmt.Errorf("failed to stop %s: %w", proxyName, err)
+		}
+		if remove {
+			if err := client.RemoveContainer(proxyName, true); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", proxyName, err)
+			}
+		}
+	}
+	if !remove {
+		return nil
+	}
+
+	// The network can only be removed once no container uses it anymore
+	networkName := containerName + networkNameSuffix
+	network, err := client.InspectNetwork(networkName)
+	if err != nil {
+		return fmt.Errorf("failed to inspect network %s: %w", networkName, err)
+	}
+	if network != nil {
+		if err := client.RemoveNetwork(networkName); err != nil {
+			return fmt.Errorf("failed to remove network %s: %w", networkName, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package sbox
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// sshKeysPath is where the host ~/.ssh directory is mounted in "keys" mode
+const sshKeysPath = "/home/agent/.ssh"
+
+// containerSSH is how a sandbox container gets SSH access for given settings
+type containerSSH struct {
+	// Settings are the resolved SSH settings
+	Settings SSHSettings
+
+	// Bind is the host config bind giving SSH access ("" when there is none):
+	// ~/.ssh in keys mode, or the host agent socket when it can be mounted
+	// directly
+	Bind string
+
+	// Relay is true when the host agent is relayed to the container through
+	// `sbox ssh-agent-relay`, because its socket can't be mounted directly
+	Relay bool
+}
+
+// matches reports whether an existing container was created for this SSH setup
+func (s *containerSSH) matches(container *DockerContainer) bool {
+	var bind string
+	for _, mount := range container.Mounts {
+		if mount.Destination == sshKeysPath || mount.Destination == SSHAgentSocketPath {
+			bind = mount.Source + ":" + mount.Destination
+			if !mount.RW {
+				bind += ":ro"
+			}
+		}
+	}
+	forwarded := slices.Contains(container.Config.Env, "SSH_AUTH_SOCK="+SSHAgentSocketPath)
+	return bind == s.Bind && forwarded == (s.Settings.Mode == SSHModeAgent)
+}
+
+// apply sets the SSH fields of a container configuration
+func (s *containerSSH) apply(config *DockerContainerConfig) {
+	if s.Bind != "" {
+		config.HostConfig.Binds = append(config.HostConfig.Binds, s.Bind)
+	}
+	if s.Settings.Mode == SSHModeAgent {
+		config.Env = append(config.Env, "SSH_AUTH_SOCK="+SSHAgentSocketPath)
+	}
+}
+
+// prepareSSH decides how the container gets SSH access. The host agent socket
+// is mounted directly when the engine runs on this machine and the agent user
+// can open it (rootless Podman maps the host user to it), otherwise it is
+// relayed, as with Docker Desktop VMs. Filtering the keys needs the relay too.
+func (b *ContainerBackend) prepareSSH(client *DockerClient, settings SSHSettings) *containerSSH {
+	access := &containerSSH{Settings: settings}
+
+	switch settings.Mode {
+	case SSHModeKeys:
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			break
+		}
+		sshPath := filepath.Join(homeDir, ".ssh")
+		if _, err := os.Stat(sshPath); err == nil {
+			access.Bind = fmt.Sprintf("%s:%s:ro", sshPath, sshKeysPath)
+		}
+
+	case SSHModeAgent:
+		socket := HostSSHAgentSocket()
+		if socket == "" {
+			DefaultUI.Warn("No SSH agent is running on the host (SSH_AUTH_SOCK), SSH is not available in the sandbox")
+			access.Settings.Mode = SSHModeNone
+			break
+		}
+
+		if len(settings.AllowedKeys) == 0 && b.canMountSSHAgent(client) {
+			access.Bind = fmt.Sprintf("%s:%s", socket, SSHAgentSocketPath)
+		} else {
+			access.Relay = true
+		}
+	}
+
+	zlog.Debug("prepared SSH access",
+		zap.String("mode", access.Settings.Mode),
+		zap.String("bind", access.Bind),
+		zap.Bool("relay", access.Relay))
+	return access
+}
+
+// canMountSSHAgent reports whether the host agent socket can be bind mounted
+// and used by the agent user of the container
+func (b *ContainerBackend) canMountSSHAgent(client *DockerClient) bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	if b.backend == BackendPodman {
+		return true
+	}
+
+	info, err := client.Info()
+	if err != nil || strings.Contains(info.OperatingSystem, "Docker Desktop") {
+		return false
+	}
+	return os.Getuid() == AgentUserID
+}
+
+// startSSHAgentRelay serves the host SSH agent inside a running container
+// through `sbox ssh-agent-relay` (the equivalent of
+// `docker exec -i <container> sbox ssh-agent-relay`). Returns a function
+// stopping the relay.
+func (b *ContainerBackend) startSSHAgentRelay(client *DockerClient, containerID string, allowedKeys []string) (func(), error) {
+	execID, err := client.CreateExec(containerID, sshAgentRelayCommand(), false, true)
+	if err != nil {
+		return nil, fmt.Errorf("docker exec failed: %w", err)
+	}
+
+	hijacked, err := client.StartExec(execID, false)
+	if err != nil {
+		return nil, fmt.Errorf("docker exec failed: %w", err)
+	}
+
+	// Requests come out of the relay stdout, responses go in its stdin
+	requestsReader, requestsWriter := io.Pipe()
+	responsesReader, responsesWriter := io.Pipe()
+
+	go func() {
+		var stderr bytes.Buffer
+		err := runDockerSession(hijacked, dockerSessionOptions{
+			Stdin:  true,
+			In:     responsesReader,
+			Stdout: requestsWriter,
+			Stderr: &stderr,
+		})
+		requestsWriter.Close()
+		if err != nil {
+			zlog.Debug("ssh agent relay session ended", zap.Error(err))
+		}
+		if exitCode, err := client.InspectExec(execID); err == nil && exitCode > 0 {
+			zlog.Warn("ssh agent relay failed", zap.Int("exit_code", exitCode), zap.String("stderr", strings.TrimSpace(stderr.String())))
+		}
+	}()
+
+	go func() {
+		if err := serveSSHAgentRelay(requestsReader, responsesWriter, allowedKeys); err != nil {
+			zlog.Warn("ssh agent relay failed", zap.Error(err))
+		}
+		responsesWriter.Close()
+	}()
+
+	return func() {
+		responsesWriter.Close()
+		hijacked.Close()
+	}, nil
+}
@@ -0,0 +1,307 @@
+package sbox
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Credential providers, selecting the variables a credential set holds
+const (
+	// CredentialProviderAnthropic authenticates with an Anthropic API key
+	CredentialProviderAnthropic = "anthropic"
+
+	// CredentialProviderBedrock routes Claude Code through Amazon Bedrock
+	CredentialProviderBedrock = "bedrock"
+
+	// CredentialProviderVertex routes Claude Code through Google Vertex AI
+	CredentialProviderVertex = "vertex"
+
+	// CredentialProviderOpenAICompatible points agents at an OpenAI-compatible
+	// gateway, typically used with OpenCode
+	CredentialProviderOpenAICompatible = "openai-compatible"
+
+	// CredentialProviderCustom holds arbitrary variables
+	CredentialProviderCustom = "custom"
+)
+
+// ValidCredentialProviders lists the accepted credential providers
+var ValidCredentialProviders = []string{
+	CredentialProviderAnthropic,
+	CredentialProviderBedrock,
+	CredentialProviderVertex,
+	CredentialProviderOpenAICompatible,
+	CredentialProviderCustom,
+}
+
+// CredentialSet is a named set of environment variables authenticating the
+// agent with a model provider. Secret values are kept in the sbox vault
+// (see CredentialVaultScope), only their names are in the config.
+type CredentialSet struct {
+	// Provider is one of ValidCredentialProviders
+	Provider string `yaml:"provider"`
+
+	// Envs are the non-secret variables (NAME=VALUE): region, base URL, ...
+	Envs []string `yaml:"envs,omitempty"`
+
+	// Secrets are the names of the variables stored in the vault
+	Secrets []string `yaml:"secrets,omitempty"`
+}
+
+// CredentialField is a variable of a credential provider
+type CredentialField struct {
+	// Name is the environment variable name
+	Name string
+
+	// Description is shown when prompting for the value
+	Description string
+
+	// Value is a fixed value, never prompted for
+	Value string
+
+	// Default is proposed when prompting
+	Default string
+
+	// Required fields can't be left empty
+	Required bool
+
+	// Secret values are stored in the vault
+	Secret bool
+}
+
+// modelOverrideFields are the Claude Code model overrides, shared by the
+// Anthropic, Bedrock and Vertex providers
+var modelOverrideFields = []CredentialField{
+	{Name: "ANTHROPIC_MODEL", Description: "Model override (optional)"},
+	{Name: "ANTHROPIC_SMALL_FAST_MODEL", Description: "Small/fast model override (optional)"},
+}
+
+// credentialProviderFields are the variables of each provider, custom aside
+var credentialProviderFields = map[string][]CredentialField{
+	CredentialProviderAnthropic: append([]CredentialField{
+		{Name: "ANTHROPIC_API_KEY", Description: "Anthropic API key (starts with sk-ant-)", Required: true, Secret: true},
+		{Name: "ANTHROPIC_BASE_URL", Description: "API base URL (optional)"},
+	}, modelOverrideFields...),
+
+	CredentialProviderBedrock: append([]CredentialField{
+		{Name: "CLAUDE_CODE_USE_BEDROCK", Value: "1"},
+		{Name: "AWS_REGION", Description: "AWS region", Default: "us-east-1", Required: true},
+		{Name: "AWS_ACCESS_KEY_ID", Description: "AWS access key ID (optional with a Bedrock API key)", Secret: true},
+		{Name: "AWS_SECRET_ACCESS_KEY", Description: "AWS secret access key (optional with a Bedrock API key)", Secret: true},
+		{Name: "AWS_SESSION_TOKEN", Description: "AWS session token (optional)", Secret: true},
+		{Name: "AWS_BEARER_TOKEN_BEDROCK", Description: "Bedrock API key (optional with access keys)", Secret: true},
+		{Name: "ANTHROPIC_BEDROCK_BASE_URL", Description: "Bedrock endpoint or gateway URL (optional)"},
+	}, modelOverrideFields...),
+
+	CredentialProviderVertex: append([]CredentialField{
+		{Name: "CLAUDE_CODE_USE_VERTEX", Value: "1"},
+		{Name: "CLOUD_ML_REGION", Description: "Vertex AI region", Default: "us-east5", Required: true},
+		{Name: "ANTHROPIC_VERTEX_PROJECT_ID", Description: "Google Cloud project ID", Required: true},
+		{Name: "ANTHROPIC_VERTEX_BASE_URL", Description: "Vertex endpoint or gateway URL (optional)"},
+	}, modelOverrideFields...),
+
+	CredentialProviderOpenAICompatible: {
+		{Name: "OPENAI_BASE_URL", Description: "Gateway base URL (e.g. https://llm.example.com/v1)", Required: true},
+		{Name: "OPENAI_API_KEY", Description: "Gateway API key", Required: true, Secret: true},
+		{Name: "OPENAI_MODEL", Description: "Model override (optional)"},
+	},
+}
+
+// credentialSetNameRegex matches valid credential set names
+var credentialSetNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// ValidateCredentialProvider checks a credential provider name
+func ValidateCredentialProvider(provider string) error {
+	if !slices.Contains(ValidCredentialProviders, provider) {
+		return fmt.Errorf("invalid credential provider %q, valid values: %v", provider, ValidCredentialProviders)
+	}
+	return nil
+}
+
+// ValidateCredentialSetName checks a credential set name, empty meaning none
+func ValidateCredentialSetName(name string) error {
+	if name != "" && !credentialSetNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid credential set name %q, expected letters, digits, '.', '_' or '-'", name)
+	}
+	return nil
+}
+
+// CredentialFields returns the variables of a provider. The custom provider
+// has none, its variables are free-form.
+func CredentialFields(provider string) ([]CredentialField, error) {
+	if err := ValidateCredentialProvider(provider); err != nil {
+		return nil, err
+	}
+	return credentialProviderFields[provider], nil
+}
+
+// CredentialVaultScope is the vault scope of the secrets of a credential set
+func CredentialVaultScope(name string) string {
+	return "credentials:" + name
+}
+
+// ResolveCredentialSetName returns the credential set used by a project: the
+// project one (which includes sbox.yaml once merged with MergeProjectConfig),
+// then the global default. Empty when none is selected.
+func ResolveCredentialSetName(config *Config, projectConfig *ProjectConfig) string {
+	if projectConfig != nil && projectConfig.Credentials != "" {
+		return projectConfig.Credentials
+	}
+	if config != nil {
+		return config.DefaultCredentials
+	}
+	return ""
+}
+
+// CredentialSetNames returns the names of the configured credential sets, sorted
+func (c *Config) CredentialSetNames() []string {
+	names := make([]string, 0, len(c.Credentials))
+	for name := range c.Credentials {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// StoreCredentialSet stores a credential set from its NAME=VALUE variables,
+// replacing any set with the same name. Secret variables (the secret fields
+// of the provider, or sensitive names for the custom provider) go to the
+// vault, the others to the config. The set becomes the global default when
+// makeDefault is true or there is no default yet. Saves both the vault and the
+// config, refreshing config with the saved one.
+func StoreCredentialSet(config *Config, vault *Vault, name, provider string, values []string, makeDefault bool) error {
+	if err := ValidateCredentialSetName(name); err != nil {
+		return err
+	}
+	if name == "" {
+		return fmt.Errorf("credential set name cannot be empty")
+	}
+	fields, err := CredentialFields(provider)
+	if err != nil {
+		return err
+	}
+
+	isSecret := func(envName string) bool {
+		if provider == CredentialProviderCustom {
+			return IsSensitiveEnvName(envName)
+		}
+		index := slices.IndexFunc(fields, func(field CredentialField) bool { return field.Name == envName })
+		return index >= 0 && fields[index].Secret
+	}
+
+	scope := CredentialVaultScope(name)
+	for _, secretName := range vault.Names(scope) {
+		vault.Remove(scope, secretName)
+	}
+
+	set := &CredentialSet{Provider: provider}
+	for _, value := range values {
+		envName := EnvName(value)
+		if envName == "" || !strings.Contains(value, "=") {
+			return fmt.Errorf("invalid credential variable %q, expected NAME=VALUE", value)
+		}
+		if isSecret(envName) {
+			vault.Set(scope, envName, value[len(envName)+1:])
+			set.Secrets = append(set.Secrets, envName)
+		} else {
+			set.Envs = append(set.Envs, value)
+		}
+	}
+
+	if len(set.Secrets) > 0 || VaultExists(config) {
+		if err := vault.Save(); err != nil {
+			return err
+		}
+	}
+
+	store := &ConfigStore{config: config}
+	err = store.UpdateConfig(func(config *Config) error {
+		if config.Credentials == nil {
+			config.Credentials = map[string]*CredentialSet{}
+		}
+		config.Credentials[name] = set
+		if makeDefault || config.DefaultCredentials == "" {
+			config.DefaultCredentials = name
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	return nil
+}
+
+// RemoveCredentialSet removes a credential set and its secrets, clearing the
+// global default if it was the default. Reports whether the set existed.
+func RemoveCredentialSet(config *Config, vault *Vault, name string) (bool, error) {
+	if _, ok := config.Credentials[name]; !ok {
+		return false, nil
+	}
+
+	scope := CredentialVaultScope(name)
+	if names := vault.Names(scope); len(names) > 0 {
+		for _, secretName := range names {
+			vault.Remove(scope, secretName)
+		}
+		if err := vault.Save(); err != nil {
+			return false, err
+		}
+	}
+
+	store := &ConfigStore{config: config}
+	err := store.UpdateConfig(func(config *Config) error {
+		delete(config.Credentials, name)
+		if config.DefaultCredentials == name {
+			config.DefaultCredentials = ""
+		}
+		return nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to save config: %w", err)
+	}
+	return true, nil
+}
+
+// LoadCredentialEnvs returns the KEY=value variables of a credential set, and
+// the names of the secret ones. The vault is only opened when the set has
+// secrets.
+func LoadCredentialEnvs(config *Config, name string) (envs []string, secretNames []string, err error) {
+	set, ok := config.Credentials[name]
+	if !ok {
+		return nil, nil, fmt.Errorf("credential set %q is not configured, run 'sbox auth --provider <provider> --name %s'", name, name)
+	}
+
+	envs = append(envs, set.Envs...)
+	if len(set.Secrets) == 0 {
+		return envs, nil, nil
+	}
+
+	vault, err := OpenVault(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open secret vault: %w", err)
+	}
+	scope := CredentialVaultScope(name)
+	for _, secretName := range set.Secrets {
+		value, ok := vault.Get(scope, secretName)
+		if !ok {
+			return nil, nil, fmt.Errorf("secret %s of credential set %q is missing from the vault", secretName, name)
+		}
+		envs = append(envs, secretName+"="+value)
+	}
+	return envs, set.Secrets, nil
+}
+
+// Describe returns a one-line summary of a credential set, without secrets
+func (s *CredentialSet) Describe() string {
+	var names []string
+	for _, env := range s.Envs {
+		names = append(names, EnvName(env))
+	}
+	names = append(names, s.Secrets...)
+	if len(names) == 0 {
+		return s.Provider
+	}
+	return fmt.Sprintf("%s (%s)", s.Provider, strings.Join(names, ", "))
+}
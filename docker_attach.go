@@ -0,0 +1,204 @@
+package sbox
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"go.uber.org/zap"
+	"golang.org/x/term"
+)
+
+// dockerSessionOptions configures how the local terminal is wired to a
+// hijacked attach or exec connection
+type dockerSessionOptions struct {
+	// Tty is true when the remote side allocated a TTY (raw stream instead of
+	// multiplexed stdout/stderr frames)
+	Tty bool
+
+	// Stdin forwards the local stdin to the remote side
+	Stdin bool
+
+	// Resize is called with the local terminal size on start and on SIGWINCH
+	Resize func(height, width uint16) error
+
+	// Signal forwards SIGINT/SIGTERM to the remote side when there is no TTY
+	// (with a TTY, Ctrl+C is sent as a regular keystroke)
+	Signal func(signal string) error
+
+	// In is the local input when Stdin is set. When nil, os.Stdin is used and
+	// put in raw mode if it is a terminal.
+	In io.Reader
+
+	// Stdout and Stderr receive the remote output (os.Stdout and os.Stderr when nil)
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// runDockerSession pipes the local stdio to conn until the remote output ends
+func runDockerSession(conn *DockerHijackedConn, opts dockerSessionOptions) error {
+	defer conn.Close()
+
+	in := opts.In
+	stdinFd := int(os.Stdin.Fd())
+	if in == nil && opts.Tty && term.IsTerminal(stdinFd) {
+		state, err := term.MakeRaw(stdinFd)
+		if err != nil {
+			zlog.Debug("failed to set terminal raw mode", zap.Error(err))
+		} else {
+			defer term.Restore(stdinFd, state)
+		}
+
+		if opts.Resize != nil {
+			stop := monitorTerminalSize(stdinFd, opts.Resize)
+			defer stop()
+		}
+	}
+
+	if !opts.Tty && opts.Signal != nil {
+		stop := forwardSignals(opts.Signal)
+		defer stop()
+	}
+
+	if in == nil {
+		in = os.Stdin
+	}
+	if opts.Stdin {
+		go func() {
+			if _, err := io.Copy(conn, in); err != nil && !isClosedConnError(err) {
+				zlog.Debug("stdin copy ended", zap.Error(err))
+			}
+			_ = conn.CloseWrite()
+		}()
+	}
+
+	stdout, stderr := opts.Stdout, opts.Stderr
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+
+	var err error
+	if opts.Tty {
+		_, err = io.Copy(stdout, conn.Reader)
+	} else {
+		err = demuxDockerStream(conn.Reader, stdout, stderr)
+	}
+	if err != nil && !isClosedConnError(err) {
+		return fmt.Errorf("failed to read container output: %w", err)
+	}
+	return nil
+}
+
+// monitorTerminalSize sends the terminal size now and whenever it changes.
+// Returns a function stopping the monitoring.
+func monitorTerminalSize(fd int, resize func(height, width uint16) error) func() {
+	sendSize := func() {
+		width, height, err := term.GetSize(fd)
+		if err != nil {
+			return
+		}
+		if err := resize(uint16(height), uint16(width)); err != nil {
+			zlog.Debug("failed to resize remote terminal", zap.Error(err))
+		}
+	}
+	sendSize()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGWINCH)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-sigCh:
+				sendSize()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigCh)
+		close(done)
+	}
+}
+
+// forwardSignals relays SIGINT and SIGTERM through send, like `docker run --sig-proxy`.
+// Returns a function restoring the default signal handling.
+func forwardSignals(send func(signal string) error) func() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-sigCh:
+				name := "SIGTERM"
+				if sig == syscall.SIGINT {
+					name = "SIGINT"
+				}
+				if err := send(name); err != nil {
+					zlog.Debug("failed to forward signal", zap.String("signal", name), zap.Error(err))
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(sigCh)
+		close(done)
+	}
+}
+
+// KillContainer sends a signal (e.g. "SIGINT") to a container
+func (c *DockerClient) KillContainer(id string, signal string) error {
+	return c.doNoContent(http.MethodPost, "/containers/"+url.PathEscape(id)+"/kill", url.Values{"signal": {signal}})
+}
+
+// demuxDockerStream splits a multiplexed attach/exec stream into stdout and
+// stderr. Each frame has an 8 bytes header: stream type (0: stdin, 1: stdout,
+// 2: stderr, 3: system error), 3 bytes padding and the big-endian payload size.
+func demuxDockerStream(r io.Reader, stdout, stderr io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		switch header[0] {
+		case 0, 1:
+			if _, err := io.CopyN(stdout, r, size); err != nil {
+				return err
+			}
+		case 2:
+			if _, err := io.CopyN(stderr, r, size); err != nil {
+				return err
+			}
+		case 3:
+			message, _ := io.ReadAll(io.LimitReader(r, size))
+			return fmt.Errorf("docker stream error: %s", message)
+		default:
+			return fmt.Errorf("invalid docker stream type %d", header[0])
+		}
+	}
+}
+
+func isClosedConnError(err error) bool {
+	return errors.Is(err, net.ErrClosed) || errors.Is(err, io.ErrClosedPipe)
+}
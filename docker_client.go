@@ -0,0 +1,807 @@
+package sbox
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// DockerAPIVersion is the Engine API version used by DockerClient. 1.41 is
+// supported by Docker 20.10+ and by Podman's Docker-compatible API.
+const DockerAPIVersion = "1.41"
+
+// DockerHostEnvVar is the standard environment variable selecting the Docker daemon
+const DockerHostEnvVar = "DOCKER_HOST"
+
+// DockerContextEnvVar is the standard environment variable selecting the
+// Docker context, overriding the one of 'docker context use'
+const DockerContextEnvVar = "DOCKER_CONTEXT"
+
+// DockerClient talks to the Docker Engine API over its Unix socket (or plain
+// TCP when DOCKER_HOST says so). It covers container, volume, image and exec
+// operations; `docker sandbox` and `docker build` (BuildKit) still go through
+// the docker CLI since they have no Engine API equivalent.
+type DockerClient struct {
+	// Host is the daemon address, e.g. "unix:///var/run/docker.sock"
+	Host string
+
+	network string
+	address string
+	http    *http.Client
+}
+
+// DockerAPIError is returned when the Engine API answers with an error status
+type DockerAPIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *DockerAPIError) Error() string {
+	return fmt.Sprintf("docker API error (status %d): %s", e.StatusCode, e.Message)
+}
+
+// IsDockerNotFound reports whether err is an Engine API "not found" error
+func IsDockerNotFound(err error) bool {
+	var apiErr *DockerAPIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+// ResolveDockerHost returns the Docker daemon address to use, the one the
+// docker CLI would use.
+// Priority:
+//  1. SBOX_DOCKER_SOCKET environment variable (socket path)
+//  2. DOCKER_HOST environment variable (unix:// or tcp://)
+//  3. The current Docker context: DOCKER_CONTEXT environment variable, or the
+//     one selected by 'docker context use'
+//  4. Platform-specific default socket paths
+func ResolveDockerHost() (string, error) {
+	if socketPath := os.Getenv(DockerSocketEnvVar); socketPath != "" {
+		return "unix://" + socketPath, nil
+	}
+	if host := os.Getenv(DockerHostEnvVar); host != "" {
+		tls := os.Getenv("DOCKER_TLS_VERIFY") != "" || os.Getenv("DOCKER_CERT_PATH") != ""
+		if err := checkDockerHost(host, tls); err != nil {
+			return "", fmt.Errorf("unsupported %s: %w", DockerHostEnvVar, err)
+		}
+		return host, nil
+	}
+	if name := currentDockerContext(); name != "" && name != "default" {
+		host, tls, err := dockerContextEndpoint(name)
+		if err != nil {
+			return "", err
+		}
+		if err := checkDockerHost(host, tls); err != nil {
+			return "", fmt.Errorf("unsupported Docker context %q: %w", name, err)
+		}
+		zlog.Debug("using docker context", zap.String("context", name), zap.String("host", host))
+		return host, nil
+	}
+	if socketPath := defaultDockerSocketPath(); socketPath != "" {
+		return "unix://" + socketPath, nil
+	}
+	return "", fmt.Errorf("no Docker socket found; set %s or %s", DockerHostEnvVar, DockerSocketEnvVar)
+}
+
+// checkDockerHost rejects the daemon addresses DockerClient can't talk to
+// with an explanation: ssh:// ones and tcp:// ones secured with TLS
+func checkDockerHost(host string, tls bool) error {
+	if target, ok := strings.CutPrefix(host, "ssh://"); ok {
+		return fmt.Errorf("%s: ssh:// hosts are not supported, forward the remote Docker socket instead, e.g. with 'ssh -nNT -L /tmp/docker.sock:/var/run/docker.sock %s', and set %s=unix:///tmp/docker.sock", host, target, DockerHostEnvVar)
+	}
+	if strings.HasPrefix(host, "tcp://") && tls {
+		return fmt.Errorf("%s: TLS connections to the Docker daemon are not supported, use its Unix socket or a plain tcp:// host (set %s to the socket path)", host, DockerSocketEnvVar)
+	}
+	return nil
+}
+
+// dockerConfigDir returns the docker CLI configuration directory
+func dockerConfigDir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(homeDir, ".docker")
+}
+
+// currentDockerContext returns the name of the current Docker context, empty
+// for the default one
+func currentDockerContext() string {
+	if name := os.Getenv(DockerContextEnvVar); name != "" {
+		return name
+	}
+
+	configDir := dockerConfigDir()
+	if configDir == "" {
+		return ""
+	}
+	data, err := os.ReadFile(filepath.Join(configDir, "config.json"))
+	if err != nil {
+		return ""
+	}
+	var config struct {
+		CurrentContext string `json:"currentContext"`
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		zlog.Debug("failed to parse docker config", zap.Error(err))
+		return ""
+	}
+	return config.CurrentContext
+}
+
+// dockerContextInfo is the subset of a Docker context used by sbox, as
+// stored in its meta.json file and printed by 'docker context inspect'
+type dockerContextInfo struct {
+	Name      string `json:"Name"`
+	Endpoints struct {
+		Docker struct {
+			Host string `json:"Host"`
+		} `json:"docker"`
+	} `json:"Endpoints"`
+	TLSMaterial map[string][]string `json:"TLSMaterial"`
+}
+
+// dockerContextEndpoint returns the daemon address of a Docker context and
+// whether it is reached over TLS. The context is read from the docker CLI
+// context store, ~/.docker/contexts, 'docker context inspect' being the
+// fallback.
+func dockerContextEndpoint(name string) (string, bool, error) {
+	if configDir := dockerConfigDir(); configDir != "" {
+		digest := sha256.Sum256([]byte(name))
+		id := hex.EncodeToString(digest[:])
+
+		data, err := os.ReadFile(filepath.Join(configDir, "contexts", "meta", id, "meta.json"))
+		if err == nil {
+			var info dockerContextInfo
+			if err := json.Unmarshal(data, &info); err != nil {
+				return "", false, fmt.Errorf("failed to parse Docker context %q: %w", name, err)
+			}
+			if info.Endpoints.Docker.Host == "" {
+				return "", false, fmt.Errorf("Docker context %q has no Docker endpoint", name)
+			}
+			_, err := os.Stat(filepath.Join(configDir, "contexts", "tls", id, "docker"))
+			return info.Endpoints.Docker.Host, err == nil, nil
+		}
+		zlog.Debug("docker context not found in the context store, inspecting it", zap.String("context", name), zap.Error(err))
+	}
+
+	var stderr bytes.Buffer
+	cmd := execCommand("docker", "context", "inspect", name)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", false, fmt.Errorf("failed to resolve Docker context %q: %w: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var infos []dockerContextInfo
+	if err := json.Unmarshal(output, &infos); err != nil || len(infos) != 1 {
+		return "", false, fmt.Errorf("failed to parse Docker context %q: unexpected 'docker context inspect' output", name)
+	}
+	if infos[0].Endpoints.Docker.Host == "" {
+		return "", false, fmt.Errorf("Docker context %q has no Docker endpoint", name)
+	}
+	return infos[0].Endpoints.Docker.Host, len(infos[0].TLSMaterial["docker"]) > 0, nil
+}
+
+// NewDockerClient creates a client for the daemon selected by ResolveDockerHost.
+// No connection is made until the first request.
+func NewDockerClient() (*DockerClient, error) {
+	host, err := ResolveDockerHost()
+	if err != nil {
+		return nil, err
+	}
+	return NewDockerClientWithHost(host)
+}
+
+// NewDockerClientWithHost creates a client for the given daemon address
+func NewDockerClientWithHost(host string) (*DockerClient, error) {
+	network, address, ok := strings.Cut(host, "://")
+	if !ok || address == "" {
+		return nil, fmt.Errorf("invalid docker host %q", host)
+	}
+
+	switch network {
+	case "unix", "tcp":
+	default:
+		return nil, fmt.Errorf("unsupported docker host %q: only unix:// and tcp:// are supported", host)
+	}
+
+	client := &DockerClient{Host: host, network: network, address: address}
+	client.http = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, address)
+			},
+		},
+	}
+
+	zlog.Debug("created docker client", zap.String("host", host))
+	return client, nil
+}
+
+// Docker API payloads. Only the fields sbox uses are declared.
+
+// DockerInfo is the subset of GET /info used by sbox
+type DockerInfo struct {
+	Architecture    string `json:"Architecture"`
+	ServerVersion   string `json:"ServerVersion"`
+	OperatingSystem string `json:"OperatingSystem"`
+}
+
+// DockerContainerSummary is an entry of GET /containers/json
+type DockerContainerSummary struct {
+	ID     string   `json:"Id"`
+	Names  []string `json:"Names"`
+	Image  string   `json:"Image"`
+	State  string   `json:"State"`
+	Status string   `json:"Status"`
+}
+
+// Name returns the primary container name without its leading slash
+func (c DockerContainerSummary) Name() string {
+	if len(c.Names) == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(c.Names[0], "/")
+}
+
+// DockerMount describes a container mount
+type DockerMount struct {
+	Type        string `json:"Type"`
+	Source      string `json:"Source"`
+	Destination string `json:"Destination"`
+	RW          bool   `json:"RW"`
+}
+
+// DockerContainer is the subset of GET /containers/{id}/json used by sbox
+type DockerContainer struct {
+	ID    string `json:"Id"`
+	Name  string `json:"Name"`
+	Image string `json:"Image"`
+	State struct {
+		Status   string `json:"Status"`
+		Running  bool   `json:"Running"`
+		ExitCode int    `json:"ExitCode"`
+	} `json:"State"`
+	Config struct {
+		Tty   bool     `json:"Tty"`
+		Image string   `json:"Image"`
+		Env   []string `json:"Env"`
+	} `json:"Config"`
+	HostConfig struct {
+		NetworkMode  string                         `json:"NetworkMode"`
+		Dns          []string                       `json:"Dns"`
+		PortBindings map[string][]DockerPortBinding `json:"PortBindings"`
+		ShmSize      int64                          `json:"ShmSize"`
+
+		DockerResources
+	} `json:"HostConfig"`
+	NetworkSettings struct {
+		Networks map[string]DockerEndpointSettings `json:"Networks"`
+	} `json:"NetworkSettings"`
+	Mounts []DockerMount `json:"Mounts"`
+}
+
+// DockerHostConfig is the subset of the container host configuration used by sbox
+type DockerHostConfig struct {
+	Binds       []string `json:"Binds,omitempty"`
+	UsernsMode  string   `json:"UsernsMode,omitempty"`
+	SecurityOpt []string `json:"SecurityOpt,omitempty"`
+	ShmSize     int64    `json:"ShmSize,omitempty"`
+	NetworkMode string   `json:"NetworkMode,omitempty"`
+	Dns         []string `json:"Dns,omitempty"`
+
+	PortBindings map[string][]DockerPortBinding `json:"PortBindings,omitempty"`
+
+	DockerResources
+}
+
+// DockerResources are the resource limits of a container's host config
+type DockerResources struct {
+	NanoCpus   int64          `json:"NanoCpus,omitempty"`
+	Memory     int64          `json:"Memory,omitempty"`
+	MemorySwap int64          `json:"MemorySwap,omitempty"`
+	PidsLimit  *int64         `json:"PidsLimit,omitempty"`
+	Ulimits    []DockerUlimit `json:"Ulimits,omitempty"`
+}
+
+// DockerUlimit is a ulimit applied to the container processes
+type DockerUlimit struct {
+	Name string `json:"Name"`
+	Soft int64  `json:"Soft"`
+	Hard int64  `json:"Hard"`
+}
+
+// DockerPortBinding is the host side of a published port
+type DockerPortBinding struct {
+	HostIP   string `json:"HostIp"`
+	HostPort string `json:"HostPort"`
+}
+
+// DockerContainerConfig is the body of POST /containers/create
+type DockerContainerConfig struct {
+	Image        string           `json:"Image"`
+	Cmd          []string         `json:"Cmd,omitempty"`
+	Env          []string         `json:"Env,omitempty"`
+	WorkingDir   string           `json:"WorkingDir,omitempty"`
+	User         string           `json:"User,omitempty"`
+	Tty          bool             `json:"Tty"`
+	OpenStdin    bool             `json:"OpenStdin"`
+	StdinOnce    bool             `json:"StdinOnce"`
+	AttachStdin  bool             `json:"AttachStdin"`
+	AttachStdout bool             `json:"AttachStdout"`
+	AttachStderr bool             `json:"AttachStderr"`
+	HostConfig   DockerHostConfig `json:"HostConfig"`
+
+	// ExposedPorts are the published container ports, e.g. "3000/tcp"
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+}
+
+// DockerImage is the subset of GET /images/{name}/json used by sbox
+type DockerImage struct {
+	ID          string   `json:"Id"`
+	RepoTags    []string `json:"RepoTags"`
+	RepoDigests []string `json:"RepoDigests"`
+}
+
+// Ping checks that the daemon is reachable
+func (c *DockerClient) Ping() error {
+	resp, err := c.do(http.MethodGet, "/_ping", nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Info returns daemon information
+func (c *DockerClient) Info() (*DockerInfo, error) {
+	info := &DockerInfo{}
+	if err := c.doJSON(http.MethodGet, "/info", nil, nil, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// ListContainers lists containers whose name matches the nameFilter regular
+// expression (all containers when empty). Stopped containers are included when all is true.
+func (c *DockerClient) ListContainers(all bool, nameFilter string) ([]DockerContainerSummary, error) {
+	query := url.Values{}
+	if all {
+		query.Set("all", "1")
+	}
+	if nameFilter != "" {
+		filters, err := json.Marshal(map[string][]string{"name": {nameFilter}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode filters: %w", err)
+		}
+		query.Set("filters", string(filters))
+	}
+
+	var containers []DockerContainerSummary
+	if err := c.doJSON(http.MethodGet, "/containers/json", query, nil, &containers); err != nil {
+		return nil, err
+	}
+	return containers, nil
+}
+
+// InspectContainer returns container details. Returns nil if the container doesn't exist.
+func (c *DockerClient) InspectContainer(id string) (*DockerContainer, error) {
+	container := &DockerContainer{}
+	if err := c.doJSON(http.MethodGet, "/containers/"+url.PathEscape(id)+"/json", nil, nil, container); err != nil {
+		if IsDockerNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return container, nil
+}
+
+// CreateContainer creates a container and returns its ID
+func (c *DockerClient) CreateContainer(name string, config *DockerContainerConfig) (string, error) {
+	query := url.Values{}
+	if name != "" {
+		query.Set("name", name)
+	}
+
+	var created struct {
+		ID       string   `json:"Id"`
+		Warnings []string `json:"Warnings"`
+	}
+	if err := c.doJSON(http.MethodPost, "/containers/create", query, config, &created); err != nil {
+		return "", err
+	}
+	for _, warning := range created.Warnings {
+		zlog.Warn("docker create warning", zap.String("container", name), zap.String("warning", warning))
+	}
+	return created.ID, nil
+}
+
+// StartContainer starts a container. Starting a running container is not an error.
+func (c *DockerClient) StartContainer(id string) error {
+	return c.doNoContent(http.MethodPost, "/containers/"+url.PathEscape(id)+"/start", nil)
+}
+
+// StopContainer stops a container. Stopping a stopped container is not an error.
+func (c *DockerClient) StopContainer(id string) error {
+	return c.doNoContent(http.MethodPost, "/containers/"+url.PathEscape(id)+"/stop", nil)
+}
+
+// RemoveContainer removes a container, killing it first when force is true
+func (c *DockerClient) RemoveContainer(id string, force bool) error {
+	query := url.Values{}
+	if force {
+		query.Set("force", "1")
+	}
+	return c.doNoContent(http.MethodDelete, "/containers/"+url.PathEscape(id), query)
+}
+
+// WaitContainer blocks until the container stops and returns its exit code
+func (c *DockerClient) WaitContainer(id string) (int, error) {
+	var result struct {
+		StatusCode int `json:"StatusCode"`
+		Error      *struct {
+			Message string `json:"Message"`
+		} `json:"Error"`
+	}
+	if err := c.doJSON(http.MethodPost, "/containers/"+url.PathEscape(id)+"/wait", nil, nil, &result); err != nil {
+		return -1, err
+	}
+	if result.Error != nil && result.Error.Message != "" {
+		return result.StatusCode, fmt.Errorf("wait failed: %s", result.Error.Message)
+	}
+	return result.StatusCode, nil
+}
+
+// ResizeContainer resizes the TTY of a container
+func (c *DockerClient) ResizeContainer(id string, height, width uint16) error {
+	return c.doNoContent(http.MethodPost, "/containers/"+url.PathEscape(id)+"/resize", resizeQuery(height, width))
+}
+
+// AttachContainer attaches to the container's streams. The returned
+// connection carries the raw TTY stream, or multiplexed stdout/stderr frames
+// (see demuxDockerStream) when the container has no TTY.
+func (c *DockerClient) AttachContainer(id string, stdin bool) (*DockerHijackedConn, error) {
+	query := url.Values{"stream": {"1"}, "stdout": {"1"}, "stderr": {"1"}}
+	if stdin {
+		query.Set("stdin", "1")
+	}
+	return c.hijack(http.MethodPost, "/containers/"+url.PathEscape(id)+"/attach", query, nil)
+}
+
+// CreateExec prepares a command to run in a container and returns the exec ID
+func (c *DockerClient) CreateExec(containerID string, cmd []string, tty, stdin bool) (string, error) {
+	body := map[string]any{
+		"Cmd":          cmd,
+		"Tty":          tty,
+		"AttachStdin":  stdin,
+		"AttachStdout": true,
+		"AttachStderr": true,
+	}
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := c.doJSON(http.MethodPost, "/containers/"+url.PathEscape(containerID)+"/exec", nil, body, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// StartExec starts an exec instance and returns the hijacked connection to its streams
+func (c *DockerClient) StartExec(execID string, tty bool) (*DockerHijackedConn, error) {
+	return c.hijack(http.MethodPost, "/exec/"+url.PathEscape(execID)+"/start", nil, map[string]any{"Detach": false, "Tty": tty})
+}
+
+// ResizeExec resizes the TTY of an exec instance
+func (c *DockerClient) ResizeExec(execID string, height, width uint16) error {
+	return c.doNoContent(http.MethodPost, "/exec/"+url.PathEscape(execID)+"/resize", resizeQuery(height, width))
+}
+
+// InspectExec returns the exit code of an exec instance (-1 while still running)
+func (c *DockerClient) InspectExec(execID string) (int, error) {
+	var result struct {
+		Running  bool `json:"Running"`
+		ExitCode int  `json:"ExitCode"`
+	}
+	if err := c.doJSON(http.MethodGet, "/exec/"+url.PathEscape(execID)+"/json", nil, nil, &result); err != nil {
+		return -1, err
+	}
+	if result.Running {
+		return -1, nil
+	}
+	return result.ExitCode, nil
+}
+
+// Exec runs a command in a container without a TTY and returns its combined
+// output and exit code
+func (c *DockerClient) Exec(containerID string, cmd []string) (string, int, error) {
+	execID, err := c.CreateExec(containerID, cmd, false, false)
+	if err != nil {
+		return "", -1, fmt.Errorf("failed to create exec: %w", err)
+	}
+
+	conn, err := c.StartExec(execID, false)
+	if err != nil {
+		return "", -1, fmt.Errorf("failed to start exec: %w", err)
+	}
+	defer conn.Close()
+
+	var output bytes.Buffer
+	if err := demuxDockerStream(conn.Reader, &output, &output); err != nil {
+		return output.String(), -1, fmt.Errorf("failed to read exec output: %w", err)
+	}
+
+	exitCode, err := c.InspectExec(execID)
+	if err != nil {
+		return output.String(), -1, fmt.Errorf("failed to inspect exec: %w", err)
+	}
+	return output.String(), exitCode, nil
+}
+
+// VolumeExists reports whether a named volume exists
+func (c *DockerClient) VolumeExists(name string) (bool, error) {
+	resp, err := c.do(http.MethodGet, "/volumes/"+url.PathEscape(name), nil, nil)
+	if err != nil {
+		if IsDockerNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// CreateVolume creates a named volume
+func (c *DockerClient) CreateVolume(name string) error {
+	return c.doJSON(http.MethodPost, "/volumes/create", nil, map[string]string{"Name": name}, nil)
+}
+
+// RemoveVolume removes a named volume
+func (c *DockerClient) RemoveVolume(name string) error {
+	return c.doNoContent(http.MethodDelete, "/volumes/"+url.PathEscape(name), nil)
+}
+
+// InspectImage returns image details. Returns nil if the image doesn't exist locally.
+func (c *DockerClient) InspectImage(ref string) (*DockerImage, error) {
+	image := &DockerImage{}
+	if err := c.doJSON(http.MethodGet, "/images/"+ref+"/json", nil, nil, image); err != nil {
+		if IsDockerNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return image, nil
+}
+
+// ListImages lists local images matching a reference filter (e.g. "sbox-template:*")
+func (c *DockerClient) ListImages(reference string) ([]DockerImage, error) {
+	query := url.Values{}
+	if reference != "" {
+		filters, err := json.Marshal(map[string][]string{"reference": {reference}})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode filters: %w", err)
+		}
+		query.Set("filters", string(filters))
+	}
+
+	var images []DockerImage
+	if err := c.doJSON(http.MethodGet, "/images/json", query, nil, &images); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// RemoveImage removes a local image by reference or ID
+func (c *DockerClient) RemoveImage(ref string) error {
+	resp, err := c.do(http.MethodDelete, "/images/"+ref, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// PullImage pulls an image, writing one progress line per layer status change to out
+func (c *DockerClient) PullImage(ref string, out io.Writer) error {
+	resp, err := c.do(http.MethodPost, "/images/create", url.Values{"fromImage": {ref}}, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	lastStatus := make(map[string]string)
+	for {
+		var message struct {
+			ID     string `json:"id"`
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		}
+		if err := decoder.Decode(&message); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return fmt.Errorf("failed to read pull progress: %w", err)
+		}
+		if message.Error != "" {
+			return fmt.Errorf("pull failed: %s", message.Error)
+		}
+
+		// Skip repeated "Downloading"/"Extracting" progress updates of the same layer
+		if out == nil || lastStatus[message.ID] == message.Status {
+			continue
+		}
+		lastStatus[message.ID] = message.Status
+		if message.ID != "" {
+			fmt.Fprintf(out, "%s: %s\n", message.ID, message.Status)
+		} else {
+			fmt.Fprintln(out, message.Status)
+		}
+	}
+}
+
+// url builds the versioned API URL for path
+func (c *DockerClient) url(path string, query url.Values) string {
+	u := "http://docker/v" + DockerAPIVersion + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// do sends a request and returns the response, converting error statuses to DockerAPIError
+func (c *DockerClient) do(method, path string, query url.Values, body any) (*http.Response, error) {
+	req, err := c.newRequest(method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach docker daemon at %s: %w", c.Host, err)
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		return nil, readDockerAPIError(resp)
+	}
+
+	return resp, nil
+}
+
+// doJSON sends a request and decodes the JSON response into out (if not nil)
+func (c *DockerClient) doJSON(method, path string, query url.Values, body, out any) error {
+	resp, err := c.do(method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode docker response for %s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+// doNoContent sends a request whose response has no body. 304 Not Modified
+// (already started/stopped) is treated as success.
+func (c *DockerClient) doNoContent(method, path string, query url.Values) error {
+	resp, err := c.do(method, path, query, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *DockerClient) newRequest(method, path string, query url.Values, body any) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request body: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.url(path, query), reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create docker request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// DockerHijackedConn is a raw connection taken over from an attach or exec
+// request. Reads must go through Reader, which may hold buffered data.
+type DockerHijackedConn struct {
+	net.Conn
+	Reader *bufio.Reader
+}
+
+// CloseWrite signals the end of stdin to the daemon
+func (h *DockerHijackedConn) CloseWrite() error {
+	if conn, ok := h.Conn.(interface{ CloseWrite() error }); ok {
+		return conn.CloseWrite()
+	}
+	return nil
+}
+
+// hijack sends a request asking the daemon to upgrade the connection to a raw stream
+func (c *DockerClient) hijack(method, path string, query url.Values, body any) (*DockerHijackedConn, error) {
+	req, err := c.newRequest(method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	conn, err := net.Dial(c.network, c.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach docker daemon at %s: %w", c.Host, err)
+	}
+
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send docker request: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read docker response: %w", err)
+	}
+	if resp.StatusCode >= 400 {
+		defer conn.Close()
+		return nil, readDockerAPIError(resp)
+	}
+
+	return &DockerHijackedConn{Conn: conn, Reader: reader}, nil
+}
+
+// readDockerAPIError converts an error response to a DockerAPIError
+func readDockerAPIError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	var payload struct {
+		Message string `json:"message"`
+	}
+	message := strings.TrimSpace(string(data))
+	if err := json.Unmarshal(data, &payload); err == nil && payload.Message != "" {
+		message = payload.Message
+	}
+	return &DockerAPIError{StatusCode: resp.StatusCode, Message: message}
+}
+
+func resizeQuery(height, width uint16) url.Values {
+	return url.Values{
+		"h": {strconv.Itoa(int(height))},
+		"w": {strconv.Itoa(int(width))},
+	}
+}
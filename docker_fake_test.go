@@ -0,0 +1,967 @@
+package sbox
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Hermetic fakes of the container engine used by the backend tests:
+//
+//   - fakeDockerDaemon serves the subset of the Engine API used by DockerClient
+//     on a unix socket and keeps container, volume and image state in memory.
+//   - fakeDockerCLI replaces execCommand so `docker sandbox ...`, `docker build`
+//     and `docker buildx` run this test binary instead (see
+//     TestFakeDockerCLIProcess), which records the invocation and keeps the
+//     sandbox state in a directory.
+
+// fakeContainer is a container known to fakeDockerDaemon
+type fakeContainer struct {
+	ID       string
+	Name     string
+	Config   DockerContainerConfig
+	Status   string // created, running or exited
+	ExitCode int
+
+	// Output is streamed to attached clients once the container runs, after
+	// which the container exits with ExitCode
+	Output string
+
+	// Networks maps the networks the container was connected to to its address
+	Networks map[string]string
+
+	started chan struct{}
+	exited  chan struct{}
+}
+
+func (c *fakeContainer) start() {
+	if c.Status == "running" {
+		return
+	}
+	c.Status = "running"
+	close(c.started)
+}
+
+func (c *fakeContainer) exit(code int) {
+	if c.Status != "running" {
+		return
+	}
+	c.Status = "exited"
+	c.ExitCode = code
+	close(c.exited)
+
+	// Fresh channels for the next start
+	c.started = make(chan struct{})
+	c.exited = make(chan struct{})
+}
+
+type fakeExec struct {
+	ContainerID string
+	Cmd         []string
+	Stdin       bool
+	ExitCode    int
+	done        bool
+
+	// Input is what the client sent on the attached stdin
+	Input string
+}
+
+// fakeDockerDaemon simulates a Docker daemon for DockerClient
+type fakeDockerDaemon struct {
+	// Socket is the unix socket the daemon listens on
+	Socket string
+
+	// Client is a DockerClient connected to the daemon
+	Client *DockerClient
+
+	mu         sync.Mutex
+	containers []*fakeContainer
+	volumes    map[string]bool
+	networks   map[string]bool // name -> internal
+	images     map[string]bool
+	execs      map[string]*fakeExec
+	calls      []string
+	nextID     int
+
+	// output and exitCode are used by the containers created from now on
+	output   string
+	exitCode int
+
+	// exitAfterExec makes started containers run until an exec of this sbox
+	// command completed, see ExitAfterExec
+	exitAfterExec string
+
+	// execExitCodes are the exit codes of the execs of sbox commands, see FailExec
+	execExitCodes map[string]int
+}
+
+// newFakeDockerDaemon starts a fake daemon and points SBOX_DOCKER_SOCKET at it,
+// so every DockerClient created by the code under test talks to it
+func newFakeDockerDaemon(t *testing.T) *fakeDockerDaemon {
+	t.Helper()
+
+	// Unix socket paths are limited to ~104 characters, t.TempDir() can be longer
+	dir, err := os.MkdirTemp("", "sbox-docker")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	d := &fakeDockerDaemon{
+		Socket:   filepath.Join(dir, "docker.sock"),
+		volumes:  make(map[string]bool),
+		networks: make(map[string]bool),
+		images:   make(map[string]bool),
+		execs:    make(map[string]*fakeExec),
+
+		execExitCodes: make(map[string]int),
+	}
+
+	listener, err := net.Listen("unix", d.Socket)
+	require.NoError(t, err)
+
+	server := &http.Server{Handler: d}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+
+	t.Setenv(DockerSocketEnvVar, d.Socket)
+	d.Client, err = NewDockerClientWithHost("unix://" + d.Socket)
+	require.NoError(t, err)
+
+	return d
+}
+
+// SetRunResult sets the output and exit code of the containers created from now on
+func (d *fakeDockerDaemon) SetRunResult(output string, exitCode int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.output = output
+	d.exitCode = exitCode
+}
+
+// ExitAfterExec keeps started containers running until an exec of the given
+// sbox command completed (or a timeout), like an entrypoint waiting for sbox
+func (d *fakeDockerDaemon) ExitAfterExec(command string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.exitAfterExec = command
+}
+
+// FailExec makes the execs of the given sbox command exit with exitCode
+func (d *fakeDockerDaemon) FailExec(command string, exitCode int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.execExitCodes[command] = exitCode
+}
+
+// AddContainer registers an existing container
+func (d *fakeDockerDaemon) AddContainer(name string, tty bool, status string) *fakeContainer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c := d.newContainer(name, DockerContainerConfig{Image: "sbox-template:existing", Tty: tty})
+	if status == "running" {
+		c.start()
+	} else {
+		c.Status = status
+	}
+	return c
+}
+
+// AddImage registers a local image
+func (d *fakeDockerDaemon) AddImage(ref string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.images[ref] = true
+}
+
+// Container returns the container with the given name, nil if it doesn't exist
+func (d *fakeDockerDaemon) Container(name string) *fakeContainer {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.find(name)
+}
+
+// Execs returns a copy of the exec instances created for a command
+func (d *fakeDockerDaemon) Execs(command string) []fakeExec {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var execs []fakeExec
+	for _, e := range d.execs {
+		if len(e.Cmd) > 1 && e.Cmd[1] == command {
+			execs = append(execs, *e)
+		}
+	}
+	return execs
+}
+
+// HasVolume reports whether a volume exists
+func (d *fakeDockerDaemon) HasVolume(name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.volumes[name]
+}
+
+// Network reports whether a network exists and whether it is internal
+func (d *fakeDockerDaemon) Network(name string) (exists, internal bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	internal, exists = d.networks[name]
+	return exists, internal
+}
+
+// Calls returns the API calls received so far as "METHOD /path" (without the
+// version prefix), excluding read-only calls when mutatingOnly is true
+func (d *fakeDockerDaemon) Calls(mutatingOnly bool) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var calls []string
+	for _, call := range d.calls {
+		if mutatingOnly && strings.HasPrefix(call, "GET ") {
+			continue
+		}
+		calls = append(calls, call)
+	}
+	return calls
+}
+
+func (d *fakeDockerDaemon) newContainer(name string, config DockerContainerConfig) *fakeContainer {
+	d.nextID++
+	c := &fakeContainer{
+		ID:       fmt.Sprintf("%012x%052x", d.nextID, d.nextID), // unique short IDs
+		Name:     name,
+		Config:   config,
+		Status:   "created",
+		ExitCode: d.exitCode,
+		Output:   d.output,
+		started:  make(chan struct{}),
+		exited:   make(chan struct{}),
+	}
+	d.containers = append(d.containers, c)
+	return c
+}
+
+func (d *fakeDockerDaemon) find(idOrName string) *fakeContainer {
+	for _, c := range d.containers {
+		if c.ID == idOrName || c.Name == idOrName || (len(idOrName) >= 12 && strings.HasPrefix(c.ID, idOrName)) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (d *fakeDockerDaemon) remove(c *fakeContainer) {
+	for i, existing := range d.containers {
+		if existing == c {
+			d.containers = append(d.containers[:i], d.containers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (d *fakeDockerDaemon) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v"+DockerAPIVersion)
+
+	d.mu.Lock()
+	d.calls = append(d.calls, r.Method+" "+path)
+	d.mu.Unlock()
+
+	switch {
+	case path == "/_ping":
+		io.WriteString(w, "OK")
+	case path == "/info":
+		writeFakeJSON(w, http.StatusOK, map[string]string{"Architecture": "x86_64"})
+	case path == "/containers/json":
+		d.listContainers(w, r)
+	case path == "/containers/create":
+		d.createContainer(w, r)
+	case strings.HasPrefix(path, "/containers/"):
+		idOrName, action, _ := strings.Cut(strings.TrimPrefix(path, "/containers/"), "/")
+		d.containerAction(w, r, idOrName, action)
+	case strings.HasPrefix(path, "/exec/"):
+		id, action, _ := strings.Cut(strings.TrimPrefix(path, "/exec/"), "/")
+		d.execAction(w, r, id, action)
+	case path == "/volumes/create":
+		var body struct{ Name string }
+		json.NewDecoder(r.Body).Decode(&body)
+		d.mu.Lock()
+		d.volumes[body.Name] = true
+		d.mu.Unlock()
+		writeFakeJSON(w, http.StatusCreated, map[string]string{"Name": body.Name})
+	case strings.HasPrefix(path, "/volumes/"):
+		d.volumeAction(w, r, strings.TrimPrefix(path, "/volumes/"))
+	case path == "/networks/create":
+		var body struct {
+			Name     string
+			Internal bool
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		d.mu.Lock()
+		d.networks[body.Name] = body.Internal
+		d.mu.Unlock()
+		writeFakeJSON(w, http.StatusCreated, map[string]string{"Id": "net-" + body.Name})
+	case strings.HasPrefix(path, "/networks/"):
+		name, action, _ := strings.Cut(strings.TrimPrefix(path, "/networks/"), "/")
+		d.networkAction(w, r, name, action)
+	case path == "/images/json":
+		d.mu.Lock()
+		var images []DockerImage
+		for ref := range d.images {
+			images = append(images, DockerImage{ID: "sha256:" + ref, RepoTags: []string{ref}})
+		}
+		d.mu.Unlock()
+		writeFakeJSON(w, http.StatusOK, images)
+	case path == "/images/create":
+		d.AddImage(r.URL.Query().Get("fromImage"))
+		io.WriteString(w, `{"status":"Pulled"}`)
+	case strings.HasPrefix(path, "/images/"):
+		d.imageAction(w, r, strings.TrimPrefix(path, "/images/"))
+	default:
+		writeFakeError(w, http.StatusNotFound, "page not found")
+	}
+}
+
+func (d *fakeDockerDaemon) listContainers(w http.ResponseWriter, r *http.Request) {
+	var nameFilter *regexp.Regexp
+	if filters := r.URL.Query().Get("filters"); filters != "" {
+		var parsed map[string][]string
+		if err := json.Unmarshal([]byte(filters), &parsed); err != nil {
+			writeFakeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if names := parsed["name"]; len(names) > 0 {
+			nameFilter = regexp.MustCompile(names[0])
+		}
+	}
+	all := r.URL.Query().Get("all") == "1"
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	summaries := []DockerContainerSummary{}
+	for _, c := range d.containers {
+		if !all && c.Status != "running" {
+			continue
+		}
+		if nameFilter != nil && !nameFilter.MatchString("/"+c.Name) {
+			continue
+		}
+		summaries = append(summaries, DockerContainerSummary{
+			ID:    c.ID,
+			Names: []string{"/" + c.Name},
+			Image: c.Config.Image,
+			State: c.Status,
+		})
+	}
+	writeFakeJSON(w, http.StatusOK, summaries)
+}
+
+func (d *fakeDockerDaemon) createContainer(w http.ResponseWriter, r *http.Request) {
+	var config DockerContainerConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeFakeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	name := r.URL.Query().Get("name")
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if name != "" && d.find(name) != nil {
+		writeFakeError(w, http.StatusConflict, fmt.Sprintf("Conflict. The container name %q is already in use", "/"+name))
+		return
+	}
+	c := d.newContainer(name, config)
+	writeFakeJSON(w, http.StatusCreated, map[string]any{"Id": c.ID, "Warnings": []string{}})
+}
+
+func (d *fakeDockerDaemon) containerAction(w http.ResponseWriter, r *http.Request, idOrName, action string) {
+	d.mu.Lock()
+	c := d.find(idOrName)
+	if c == nil {
+		d.mu.Unlock()
+		writeFakeError(w, http.StatusNotFound, "No such container: "+idOrName)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && action == "json":
+		inspect := &DockerContainer{ID: c.ID, Name: "/" + c.Name, Image: c.Config.Image}
+		inspect.State.Status = c.Status
+		inspect.State.Running = c.Status == "running"
+		inspect.State.ExitCode = c.ExitCode
+		inspect.Config.Tty = c.Config.Tty
+		inspect.Config.Image = c.Config.Image
+		inspect.Config.Env = c.Config.Env
+		inspect.HostConfig.NetworkMode = c.Config.HostConfig.NetworkMode
+		inspect.HostConfig.Dns = c.Config.HostConfig.Dns
+		inspect.HostConfig.PortBindings = c.Config.HostConfig.PortBindings
+		inspect.HostConfig.ShmSize = c.Config.HostConfig.ShmSize
+		inspect.HostConfig.DockerResources = c.Config.HostConfig.DockerResources
+		inspect.NetworkSettings.Networks = make(map[string]DockerEndpointSettings)
+		for network, ip := range c.Networks {
+			inspect.NetworkSettings.Networks[network] = DockerEndpointSettings{IPAddress: ip}
+		}
+		for _, bind := range c.Config.HostConfig.Binds {
+			parts := strings.Split(bind, ":")
+			mountType := "bind"
+			if !strings.HasPrefix(parts[0], "/") {
+				mountType = "volume"
+			}
+			readOnly := len(parts) > 2 && slices.Contains(strings.Split(parts[2], ","), "ro")
+			inspect.Mounts = append(inspect.Mounts, DockerMount{Type: mountType, Source: parts[0], Destination: parts[1], RW: !readOnly})
+		}
+		d.mu.Unlock()
+		writeFakeJSON(w, http.StatusOK, inspect)
+
+	case r.Method == http.MethodDelete && action == "":
+		if c.Status == "running" && r.URL.Query().Get("force") != "1" {
+			d.mu.Unlock()
+			writeFakeError(w, http.StatusConflict, "cannot remove a running container")
+			return
+		}
+		c.exit(137)
+		close(c.exited) // releases clients attached to a container that never started
+		d.remove(c)
+		d.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+
+	case action == "start":
+		wasRunning := c.Status == "running"
+		c.start()
+		d.mu.Unlock()
+		if wasRunning {
+			w.WriteHeader(http.StatusNotModified)
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+
+	case action == "stop":
+		c.exit(143)
+		d.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+
+	case action == "kill":
+		code := 137
+		if r.URL.Query().Get("signal") == "SIGINT" {
+			code = 130
+		}
+		c.exit(code)
+		d.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+
+	case action == "resize":
+		d.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+
+	case action == "attach":
+		started, exited, tty := c.started, c.exited, c.Config.Tty
+		d.mu.Unlock()
+		d.attach(w, c, started, exited, tty)
+
+	case action == "exec":
+		if c.Status != "running" {
+			d.mu.Unlock()
+			writeFakeError(w, http.StatusConflict, "container is not running")
+			return
+		}
+		var body struct {
+			Cmd         []string
+			AttachStdin bool
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		d.nextID++
+		id := fmt.Sprintf("exec%d", d.nextID)
+		e := &fakeExec{ContainerID: c.ID, Cmd: body.Cmd, Stdin: body.AttachStdin}
+		if len(body.Cmd) > 1 {
+			e.ExitCode = d.execExitCodes[body.Cmd[1]]
+		}
+		d.execs[id] = e
+		d.mu.Unlock()
+		writeFakeJSON(w, http.StatusCreated, map[string]string{"Id": id})
+
+	default:
+		d.mu.Unlock()
+		writeFakeError(w, http.StatusNotFound, "page not found")
+	}
+}
+
+func (d *fakeDockerDaemon) networkAction(w http.ResponseWriter, r *http.Request, name, action string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	internal, exists := d.networks[name]
+	if !exists {
+		writeFakeError(w, http.StatusNotFound, "network "+name+" not found")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && action == "":
+		writeFakeJSON(w, http.StatusOK, DockerNetwork{ID: "net-" + name, Name: name, Internal: internal})
+
+	case r.Method == http.MethodDelete && action == "":
+		for _, c := range d.containers {
+			if _, ok := c.Networks[name]; ok || c.Config.HostConfig.NetworkMode == name {
+				writeFakeError(w, http.StatusForbidden, "network "+name+" has active endpoints")
+				return
+			}
+		}
+		delete(d.networks, name)
+		w.WriteHeader(http.StatusNoContent)
+
+	case action == "connect":
+		var body struct{ Container string }
+		json.NewDecoder(r.Body).Decode(&body)
+		c := d.find(body.Container)
+		if c == nil {
+			writeFakeError(w, http.StatusNotFound, "No such container: "+body.Container)
+			return
+		}
+		if c.Networks == nil {
+			c.Networks = make(map[string]string)
+		}
+		c.Networks[name] = fmt.Sprintf("172.30.0.%d", len(c.Networks)+2)
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		writeFakeError(w, http.StatusNotFound, "page not found")
+	}
+}
+
+// attach streams the container output once it runs, then exits the container
+func (d *fakeDockerDaemon) attach(w http.ResponseWriter, c *fakeContainer, started, exited chan struct{}, tty bool) {
+	conn := hijackFake(w)
+	if conn == nil {
+		return
+	}
+	defer conn.Close()
+
+	select {
+	case <-started:
+	case <-exited:
+		return
+	}
+
+	d.mu.Lock()
+	output, exitCode, waitFor := c.Output, c.ExitCode, d.exitAfterExec
+	d.mu.Unlock()
+
+	if waitFor != "" {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) && !d.execCompleted(c.ID, waitFor) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	writeFakeStream(conn, output, tty)
+
+	d.mu.Lock()
+	c.exit(exitCode)
+	d.mu.Unlock()
+}
+
+// execCompleted reports whether an exec of an sbox command completed in a container
+func (d *fakeDockerDaemon) execCompleted(containerID, command string) bool {
+	for _, e := range d.Execs(command) {
+		if e.ContainerID == containerID && e.done {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *fakeDockerDaemon) execAction(w http.ResponseWriter, r *http.Request, id, action string) {
+	d.mu.Lock()
+	e := d.execs[id]
+	if e == nil {
+		d.mu.Unlock()
+		writeFakeError(w, http.StatusNotFound, "No such exec instance: "+id)
+		return
+	}
+
+	switch action {
+	case "start":
+		var body struct{ Tty bool }
+		json.NewDecoder(r.Body).Decode(&body)
+		stdin := e.Stdin
+		d.mu.Unlock()
+
+		conn := hijackFake(w)
+		if conn == nil {
+			return
+		}
+		writeFakeStream(conn, "exec: "+strings.Join(e.Cmd, " ")+"\n", body.Tty)
+		if stdin {
+			// Attached stdin is echoed back until the client closes it
+			input, _ := io.ReadAll(conn)
+			writeFakeStream(conn, string(input), body.Tty)
+			d.mu.Lock()
+			e.Input = string(input)
+			d.mu.Unlock()
+		}
+		d.mu.Lock()
+		e.done = true
+		d.mu.Unlock()
+		conn.Close()
+	case "json":
+		running, exitCode := !e.done, e.ExitCode
+		d.mu.Unlock()
+		writeFakeJSON(w, http.StatusOK, map[string]any{"Running": running, "ExitCode": exitCode})
+	default:
+		d.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (d *fakeDockerDaemon) volumeAction(w http.ResponseWriter, r *http.Request, name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.volumes[name] {
+		writeFakeError(w, http.StatusNotFound, "get "+name+": no such volume")
+		return
+	}
+	if r.Method == http.MethodDelete {
+		delete(d.volumes, name)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeFakeJSON(w, http.StatusOK, map[string]string{"Name": name})
+}
+
+func (d *fakeDockerDaemon) imageAction(w http.ResponseWriter, r *http.Request, ref string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ref = strings.TrimSuffix(ref, "/json")
+	if !d.images[ref] {
+		writeFakeError(w, http.StatusNotFound, "No such image: "+ref)
+		return
+	}
+	if r.Method == http.MethodDelete {
+		delete(d.images, ref)
+		writeFakeJSON(w, http.StatusOK, []map[string]string{{"Untagged": ref}})
+		return
+	}
+	writeFakeJSON(w, http.StatusOK, DockerImage{ID: "sha256:" + ref, RepoTags: []string{ref}})
+}
+
+// hijackFake takes over the connection of an attach or exec start request
+func hijackFake(w http.ResponseWriter) net.Conn {
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		writeFakeError(w, http.StatusInternalServerError, err.Error())
+		return nil
+	}
+	io.WriteString(conn, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	return &fakeHijackedConn{Conn: conn, reader: rw.Reader}
+}
+
+// fakeHijackedConn reads through the server buffer, which may already hold
+// client data sent right after the request
+type fakeHijackedConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (c *fakeHijackedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// writeFakeStream writes output raw when tty is set, as a stdout frame otherwise
+func writeFakeStream(w io.Writer, output string, tty bool) {
+	if output == "" {
+		return
+	}
+	if tty {
+		io.WriteString(w, output)
+		return
+	}
+	header := make([]byte, 8)
+	header[0] = 1
+	binary.BigEndian.PutUint32(header[4:], uint32(len(output)))
+	w.Write(append(header, output...))
+}
+
+func writeFakeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
+
+func writeFakeError(w http.ResponseWriter, status int, message string) {
+	writeFakeJSON(w, status, map[string]string{"message": message})
+}
+
+// fakeDockerDirEnvVar tells the test binary to act as the fake docker CLI,
+// keeping its state in the given directory
+const fakeDockerDirEnvVar = "SBOX_FAKE_DOCKER_DIR"
+
+// fakeSandbox is a sandbox known to fakeDockerCLI
+type fakeSandbox struct {
+	Name      string `json:"name"`
+	Agent     string `json:"agent"`
+	Status    string `json:"status"`
+	Workspace string `json:"workspace"`
+	Template  string `json:"template"`
+}
+
+// fakeDockerCLI makes execCommand run the test binary as a fake docker CLI
+type fakeDockerCLI struct {
+	dir string
+}
+
+// newFakeDockerCLI replaces execCommand for the duration of the test
+func newFakeDockerCLI(t *testing.T) *fakeDockerCLI {
+	t.Helper()
+
+	f := &fakeDockerCLI{dir: t.TempDir()}
+
+	previous := execCommand
+	execCommand = func(name string, args ...string) *exec.Cmd {
+		cmdArgs := append([]string{"-test.run=^TestFakeDockerCLIProcess$", "--", name}, args...)
+		cmd := exec.Command(os.Args[0], cmdArgs...)
+		cmd.Env = append(os.Environ(), fakeDockerDirEnvVar+"="+f.dir)
+		return cmd
+	}
+	t.Cleanup(func() { execCommand = previous })
+
+	return f
+}
+
+// Calls returns the commands run so far, e.g. "docker sandbox ls"
+func (f *fakeDockerCLI) Calls() []string {
+	data, err := os.ReadFile(filepath.Join(f.dir, "calls.log"))
+	if err != nil {
+		return nil
+	}
+	return strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+}
+
+// Sandboxes returns the current sandboxes
+func (f *fakeDockerCLI) Sandboxes() []fakeSandbox {
+	return loadFakeSandboxes(f.dir)
+}
+
+// AddSandbox registers an existing sandbox
+func (f *fakeDockerCLI) AddSandbox(sandbox fakeSandbox) {
+	saveFakeSandboxes(f.dir, append(loadFakeSandboxes(f.dir), sandbox))
+}
+
+// FailOn makes commands starting with prefix (e.g. "docker build") fail with stderr
+func (f *fakeDockerCLI) FailOn(prefix, stderr string) {
+	path := filepath.Join(f.dir, "failures.json")
+	failures := map[string]string{}
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &failures)
+	}
+	failures[prefix] = stderr
+	data, _ := json.Marshal(failures)
+	os.WriteFile(path, data, 0644)
+}
+
+// AddContext registers a Docker context, printed by 'docker context inspect'
+func (f *fakeDockerCLI) AddContext(name, host string) {
+	path := filepath.Join(f.dir, "contexts.json")
+	contexts := map[string]string{}
+	if data, err := os.ReadFile(path); err == nil {
+		json.Unmarshal(data, &contexts)
+	}
+	contexts[name] = host
+	data, _ := json.Marshal(contexts)
+	os.WriteFile(path, data, 0644)
+}
+
+// TestFakeDockerCLIProcess is not a real test: it is the entry point of the
+// fake docker CLI when the test binary is run by newFakeDockerCLI's execCommand.
+func TestFakeDockerCLIProcess(t *testing.T) {
+	dir := os.Getenv(fakeDockerDirEnvVar)
+	if dir == "" {
+		return
+	}
+
+	args := os.Args
+	for i, arg := range args {
+		if arg == "--" {
+			args = args[i+1:]
+			break
+		}
+	}
+	os.Exit(runFakeDockerCLI(dir, args, os.Stdin, os.Stdout, os.Stderr))
+}
+
+// runFakeDockerCLI executes a docker (or podman) command line against the state in dir
+func runFakeDockerCLI(dir string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	command := strings.Join(args, " ")
+
+	log, err := os.OpenFile(filepath.Join(dir, "calls.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err == nil {
+		fmt.Fprintln(log, command)
+		log.Close()
+	}
+
+	failures := map[string]string{}
+	if data, err := os.ReadFile(filepath.Join(dir, "failures.json")); err == nil {
+		json.Unmarshal(data, &failures)
+	}
+	for prefix, message := range failures {
+		if strings.HasPrefix(command, prefix) {
+			fmt.Fprintln(stderr, message)
+			return 1
+		}
+	}
+
+	if len(args) < 2 {
+		return 0
+	}
+
+	switch args[1] {
+	case "build":
+		fmt.Fprintln(stdout, "Successfully built")
+		return 0
+	case "buildx":
+		// buildx imagetools inspect <ref> --format {{.Manifest.Digest}}
+		if len(args) > 4 {
+			fmt.Fprintf(stdout, "sha256:%064x\n", len(args[4]))
+		}
+		return 0
+	case "sandbox":
+		return runFakeSandboxCLI(dir, args[2:], stdin, stdout, stderr)
+	case "context":
+		// context inspect <name>
+		contexts := map[string]string{}
+		if data, err := os.ReadFile(filepath.Join(dir, "contexts.json")); err == nil {
+			json.Unmarshal(data, &contexts)
+		}
+		host, ok := contexts[args[len(args)-1]]
+		if !ok {
+			fmt.Fprintf(stderr, "context %q does not exist\n", args[len(args)-1])
+			return 1
+		}
+		fmt.Fprintf(stdout, `[{"Name": %q, "Endpoints": {"docker": {"Host": %q, "SkipTLSVerify": false}}, "TLSMaterial": {}}]`+"\n", args[len(args)-1], host)
+		return 0
+	}
+	return 0
+}
+
+func runFakeSandboxCLI(dir string, args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) > 0 && args[0] == "--debug" {
+		args = args[1:]
+	}
+	if len(args) == 0 {
+		return 0
+	}
+
+	sandboxes := loadFakeSandboxes(dir)
+	find := func(name string) *fakeSandbox {
+		for i := range sandboxes {
+			if sandboxes[i].Name == name {
+				return &sandboxes[i]
+			}
+		}
+		return nil
+	}
+
+	switch args[0] {
+	case "ls":
+		fmt.Fprintf(stdout, "%-32s%-12s%-10s%s\n", "SANDBOX", "AGENT", "STATUS", "WORKSPACE")
+		for _, sb := range sandboxes {
+			fmt.Fprintf(stdout, "%-32s%-12s%-10s%s\n", sb.Name, sb.Agent, sb.Status, sb.Workspace)
+		}
+		return 0
+
+	case "create":
+		// create --name <name> [--template <image>] <agent> <workspace>
+		sandbox := fakeSandbox{Status: "stopped"}
+		var positional []string
+		for i := 1; i < len(args); i++ {
+			switch args[i] {
+			case "--name":
+				i++
+				sandbox.Name = args[i]
+			case "--template":
+				i++
+				sandbox.Template = args[i]
+			default:
+				positional = append(positional, args[i])
+			}
+		}
+		if len(positional) == 2 {
+			sandbox.Agent, sandbox.Workspace = positional[0], positional[1]
+		}
+		if find(sandbox.Name) != nil {
+			fmt.Fprintf(stderr, "sandbox %s already exists\n", sandbox.Name)
+			return 1
+		}
+		saveFakeSandboxes(dir, append(sandboxes, sandbox))
+		return 0
+
+	case "run", "stop", "rm", "exec":
+		var name string
+		for _, arg := range args[1:] {
+			if !strings.HasPrefix(arg, "-") {
+				name = arg
+				break
+			}
+		}
+		sandbox := find(name)
+		if sandbox == nil {
+			fmt.Fprintf(stderr, "sandbox %s not found\n", name)
+			return 1
+		}
+
+		switch args[0] {
+		case "run":
+			sandbox.Status = "running"
+			fmt.Fprintf(stdout, "sandbox %s session\n", name)
+		case "stop":
+			sandbox.Status = "stopped"
+		case "rm":
+			*sandbox = fakeSandbox{}
+		case "exec":
+			// exec -i echoes its stdin back
+			if slices.Contains(args[1:], "-i") {
+				io.Copy(stdout, stdin)
+			}
+		}
+
+		var kept []fakeSandbox
+		for _, sb := range sandboxes {
+			if sb.Name != "" {
+				kept = append(kept, sb)
+			}
+		}
+		saveFakeSandboxes(dir, kept)
+		return 0
+	}
+
+	return 0
+}
+
+func loadFakeSandboxes(dir string) []fakeSandbox {
+	var sandboxes []fakeSandbox
+	if data, err := os.ReadFile(filepath.Join(dir, "sandboxes.json")); err == nil {
+		json.Unmarshal(data, &sandboxes)
+	}
+	return sandboxes
+}
+
+func saveFakeSandboxes(dir string, sandboxes []fakeSandbox) {
+	data, _ := json.Marshal(sandboxes)
+	os.WriteFile(filepath.Join(dir, "sandboxes.json"), data, 0644)
+}
@@ -0,0 +1,63 @@
+package sbox
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// DockerNetwork is the subset of GET /networks/{id} used by sbox
+type DockerNetwork struct {
+	ID       string `json:"Id"`
+	Name     string `json:"Name"`
+	Internal bool   `json:"Internal"`
+}
+
+// DockerEndpointSettings describes a container's attachment to a network
+type DockerEndpointSettings struct {
+	IPAddress string   `json:"IPAddress,omitempty"`
+	Aliases   []string `json:"Aliases,omitempty"`
+}
+
+// InspectNetwork returns network details. Returns nil if the network doesn't exist.
+func (c *DockerClient) InspectNetwork(name string) (*DockerNetwork, error) {
+	network := &DockerNetwork{}
+	if err := c.doJSON(http.MethodGet, "/networks/"+url.PathEscape(name), nil, nil, network); err != nil {
+		if IsDockerNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return network, nil
+}
+
+// CreateNetwork creates a bridge network. Containers on an internal network
+// can only reach each other, there is no route to the outside.
+func (c *DockerClient) CreateNetwork(name string, internal bool) (string, error) {
+	body := map[string]any{
+		"Name":     name,
+		"Driver":   "bridge",
+		"Internal": internal,
+	}
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := c.doJSON(http.MethodPost, "/networks/create", nil, body, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// ConnectNetwork attaches a container to a network, reachable by the given aliases
+func (c *DockerClient) ConnectNetwork(network, containerID string, aliases []string) error {
+	body := map[string]any{
+		"Container":      containerID,
+		"EndpointConfig": DockerEndpointSettings{Aliases: aliases},
+	}
+	return c.doJSON(http.MethodPost, "/networks/"+url.PathEscape(network)+"/connect", nil, body, nil)
+}
+
+// RemoveNetwork removes a network
+func (c *DockerClient) RemoveNetwork(name string) error {
+	return c.doNoContent(http.MethodDelete, "/networks/"+url.PathEscape(name), nil)
+}
@@ -20,7 +20,7 @@ func (d Duration) MarshalYAML() (any, error) {
 func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
 	var s string
 	if err := value.Decode(&s); err != nil {
-		return fmt.Errorf("duration must be a string: %w", err)
+		return fmt.Errorf("line %d: duration must be a string: %w", value.Line, err)
 	}
 
 	// "0" is a special case: zero duration (infinite wait in startup-delay context)
@@ -31,7 +31,7 @@ func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
 
 	parsed, err := time.ParseDuration(s)
 	if err != nil {
-		return fmt.Errorf("invalid duration %q: %w", s, err)
+		return fmt.Errorf("line %d: invalid duration %q: %w", value.Line, s, err)
 	}
 
 	d.Duration = parsed
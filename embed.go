@@ -14,14 +14,27 @@ var SandboxBackendContextMD string
 //go:embed embedded/container_backend.md
 var ContainerBackendContextMD string
 
+// PodmanBackendContextMD contains instructions for Claude about the rootless Podman environment.
+//
+//go:embed embedded/podman_backend.md
+var PodmanBackendContextMD string
+
 // GetBackendContextMD returns the appropriate context markdown for the given backend type.
 func GetBackendContextMD(backend BackendType) string {
 	switch backend {
 	case BackendContainer:
 		return ContainerBackendContextMD
+	case BackendPodman:
+		return PodmanBackendContextMD
 	case BackendSandbox:
 		return SandboxBackendContextMD
 	default:
 		return SandboxBackendContextMD
 	}
 }
+
+// SboxFileSchemaJSON is the JSON Schema of sbox.yaml, for editor completion
+// and validation (printed by `sbox config schema`).
+//
+//go:embed embedded/sbox.schema.json
+var SboxFileSchemaJSON string
@@ -108,8 +108,27 @@ type EntrypointConfig struct {
 	MaxIterations     int  `yaml:"max_iterations,omitempty"`
 	LoopConfirmations int  `yaml:"loop_confirmations,omitempty"`
 
+	// LoopVerify are the commands gating the goal completion in loop mode
+	LoopVerify []string `yaml:"loop_verify,omitempty"`
+
+	// Loop budgets, the loop stops once one is reached (zero is unlimited)
+	LoopMaxCost     float64   `yaml:"loop_max_cost,omitempty"`
+	LoopMaxTokens   int       `yaml:"loop_max_tokens,omitempty"`
+	LoopMaxDuration *Duration `yaml:"loop_max_duration,omitempty"`
+
+	// LoopRunID identifies the loop run, its state is in .sbox/loops/<id>, where
+	// the progress is saved after each iteration
+	LoopRunID string `yaml:"loop_run_id,omitempty"`
+
+	// LoopCheckpoint records the workspace as a git commit after each iteration
+	LoopCheckpoint bool `yaml:"loop_checkpoint,omitempty"`
+
 	// Developer contains developer-oriented settings for debugging and development
 	Developer *DeveloperSettings `yaml:"developer,omitempty"`
+
+	// SecretEnvs are the names of the secret env vars delivered by sbox in
+	// SecretEnvFile after the sandbox start, rather than through .sbox/env
+	SecretEnvs []string `yaml:"secret_envs,omitempty"`
 }
 
 // DeveloperSettings contains developer-oriented settings for debugging and development.
@@ -638,7 +657,7 @@ func RunEntrypoint(args []string) error {
 
 	// Load environment variables
 	elog.Info("loading environment variables")
-	if err := loadEntrypointEnv(workspaceDir); err != nil {
+	if err := loadEntrypointEnv(workspaceDir, config.SecretEnvs); err != nil {
 		elog.Error("failed to load environment", "error", err)
 		return fmt.Errorf("failed to load environment: %w", err)
 	}
@@ -687,7 +706,8 @@ func RunEntrypoint(args []string) error {
 		// Agent-specific flags for prompt mode, then positional prompt last
 		args = append(spec.PromptArgs(), args...)
 		args = append(args, config.Prompt)
-		return runAgentWithStreamTransformer(AgentType(agentType), args, pluginDirs)
+		_, _, err := runAgentWithStreamTransformer(AgentType(agentType), args, pluginDirs, nil)
+		return err
 	}
 
 	// For OpenCode, if no args provided, pass the workspace directory
@@ -844,14 +864,25 @@ func maybeExecDevBinary(workspaceDir string, args []string) error {
 	return fmt.Errorf("failed to exec dev binary %s: %w", devBinaryPath, err)
 }
 
-// loadEntrypointEnv reads .sbox/env and sets env vars in the current process.
-// It also attempts to write exports to the persistent env file for login shells.
-func loadEntrypointEnv(workspaceDir string) error {
+// loadEntrypointEnv reads .sbox/env and sets env vars in the current process,
+// along with the secret env vars delivered in SecretEnvFile when secretEnvs
+// are expected. It also attempts to write exports to the persistent env file
+// for login shells.
+func loadEntrypointEnv(workspaceDir string, secretEnvs []string) error {
 	envs, err := ReadEntrypointEnv(workspaceDir)
 	if err != nil {
 		return err
 	}
 
+	if len(secretEnvs) > 0 {
+		// sbox delivers them on every start, the agent must not run without them
+		secrets, err := readSecretEnvFile(SecretEnvFile, secretEnvWaitTimeout)
+		if err != nil {
+			return fmt.Errorf("secret environment variables %s not loaded: %w", strings.Join(secretEnvs, ", "), err)
+		}
+		envs = append(envs, secrets...)
+	}
+
 	if len(envs) == 0 {
 		zlog.Debug("no environment variables to load")
 		return nil
@@ -1166,13 +1197,15 @@ func ensureAgentShim(spec AgentSpec, realBinaryPath string) error {
 // runAgentWithStreamTransformer spawns the agent as a subprocess and pipes its
 // stdout through a stream printer. Used in loop mode and single prompt mode
 // where the agent outputs JSON and we want to display human-readable progress.
-func runAgentWithStreamTransformer(agentType AgentType, args []string, pluginDirs []string) error {
+// The raw stream is copied to transcript when not nil. Returns the usage and
+// the activity reported by the agent stream, even when it failed.
+func runAgentWithStreamTransformer(agentType AgentType, args []string, pluginDirs []string, transcript io.Writer) (AgentUsage, AgentActivity, error) {
 	spec := GetAgentSpec(agentType)
 
 	binaryPath, err := spec.FindBinary()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "\nERROR: %s binary not found in the sandbox.\n", spec.BinaryName())
-		return fmt.Errorf("failed to find %s: %w", spec.BinaryName(), err)
+		return AgentUsage{}, AgentActivity{}, fmt.Errorf("failed to find %s: %w", spec.BinaryName(), err)
 	}
 
 	argv := spec.ExecArgs(pluginDirs)
@@ -1188,11 +1221,11 @@ func runAgentWithStreamTransformer(agentType AgentType, args []string, pluginDir
 
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return fmt.Errorf("failed to create stdout pipe: %w", err)
+		return AgentUsage{}, AgentActivity{}, fmt.Errorf("failed to create stdout pipe: %w", err)
 	}
 
 	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start agent: %w", err)
+		return AgentUsage{}, AgentActivity{}, fmt.Errorf("failed to start agent: %w", err)
 	}
 
 	printer := spec.NewStreamPrinter(os.Stdout)
@@ -1200,6 +1233,9 @@ func runAgentWithStreamTransformer(agentType AgentType, args []string, pluginDir
 	scanner := bufio.NewScanner(stdout)
 	scanner.Buffer(make([]byte, 1024*1024), 1024*1024) // 1MB buffer for large JSON lines
 	for scanner.Scan() {
+		if transcript != nil {
+			fmt.Fprintln(transcript, scanner.Text())
+		}
 		printer.ProcessLine(scanner.Text())
 	}
 
@@ -1209,7 +1245,11 @@ func runAgentWithStreamTransformer(agentType AgentType, args []string, pluginDir
 		}
 	}
 
-	return cmd.Wait()
+	var usage AgentUsage
+	var activity AgentActivity
+	usage.CostUSD, usage.Tokens, usage.Turns = printer.Usage()
+	activity.Tools, activity.FilesEdited = printer.Activity()
+	return usage, activity, cmd.Wait()
 }
 
 // LoopPromptSuffix is appended to the user's prompt to instruct the agent
@@ -1233,7 +1273,8 @@ You are running inside an automated loop managed by 'sbox loop'. Your ultimate g
 `
 
 // runLoop runs the agent in a loop inside the container until the goal is
-// confirmed complete the required number of consecutive times, or max iterations is exceeded.
+// confirmed complete the required number of consecutive times, or max iterations is exceeded,
+// or a budget is reached.
 func runLoop(config *EntrypointConfig, agentType AgentType, baseArgs []string, pluginDirs []string, workspaceDir string) error {
 	ui := DefaultUI
 	completionFile := filepath.Join(workspaceDir, ".sbox", LoopCompletionFile)
@@ -1244,17 +1285,70 @@ func runLoop(config *EntrypointConfig, agentType AgentType, baseArgs []string, p
 		requiredConfirmations = 2
 	}
 
-	completionCount := 0
-	iteration := 0
+	// Verification commands gate the completion, their failures are fed back
+	if len(config.LoopVerify) > 0 {
+		fullPrompt += loopChecksPrompt(config.LoopVerify)
+	}
+
+	// Budgets are checked between iterations, a running agent isn't interrupted
+	budget := LoopBudget{MaxCostUSD: config.LoopMaxCost, MaxTokens: config.LoopMaxTokens}
+	if config.LoopMaxDuration != nil {
+		budget.MaxDuration = config.LoopMaxDuration.Duration
+	}
+
+	// The run state holds the progress of a resumed run, it is saved after
+	// each iteration so the run can be resumed after an interruption
+	run, err := LoadLoopRun(workspaceDir, config.LoopRunID)
+	if err != nil {
+		elog.Warn("failed to load loop run, starting a new one", "error", err)
+		run = NewLoopRun()
+	}
+	if run.Iteration > 0 {
+		ui.Resumed(run)
+	}
+
+	start := time.Now()
+	elapsedBefore := run.Elapsed
+	elapsed := func() time.Duration {
+		return elapsedBefore + time.Since(start)
+	}
+	saveRun := func(status LoopStatus) {
+		run.Elapsed = elapsed()
+		run.UpdatedAt = time.Now()
+		if status != LoopStatusRunning {
+			run.Finish(status)
+		}
+		if err := run.Save(workspaceDir); err != nil {
+			ui.Warn("Failed to save loop run state: %s", err)
+		}
+		if _, err := WriteLoopReport(workspaceDir, run); err != nil {
+			ui.Warn("Failed to write loop report: %s", err)
+		}
+	}
+	defer func() {
+		ui.LoopSummary(run.Iteration, run.Usage, elapsed())
+	}()
+
+	// Checkpoints start with the workspace before the first iteration
+	if config.LoopCheckpoint && run.Checkpoint(run.Iteration) == nil {
+		checkpointLoop(ui, run, workspaceDir, run.Iteration)
+	}
 
 	for {
-		iteration++
+		if reason := budget.Exceeded(run.Usage, elapsed()); reason != "" {
+			ui.BudgetReached(reason)
+			saveRun(LoopStatusBudget)
+			return nil
+		}
 
-		if config.MaxIterations > 0 && iteration > config.MaxIterations {
+		if config.MaxIterations > 0 && run.Iteration >= config.MaxIterations {
 			ui.MaxReached(config.MaxIterations)
+			saveRun(LoopStatusMaxIterations)
 			return nil
 		}
 
+		iteration := run.Iteration + 1
+
 		// Remove completion file before each run
 		os.Remove(completionFile)
 
@@ -1263,27 +1357,82 @@ func runLoop(config *EntrypointConfig, agentType AgentType, baseArgs []string, p
 		if iteration > 1 {
 			iterationPrompt = fmt.Sprintf("%s\n\n**Iteration %d**: This is loop iteration #%d. The goal has not yet been confirmed as complete. Continue working toward it.\n", fullPrompt, iteration, iteration)
 		}
+		iterationPrompt += run.ChecksFeedback
 
-		ui.Iteration(iteration, completionCount)
+		ui.Iteration(iteration, run.CompletionStreak)
 
 		// Build args for this iteration: agent-specific prompt flags, then prompt last
 		spec := GetAgentSpec(agentType)
 		args := append(spec.PromptArgs(), baseArgs...)
 		args = append(args, iterationPrompt)
 
-		if err := runAgentWithStreamTransformer(agentType, args, pluginDirs); err != nil {
+		// The raw agent stream is kept for the report and debugging
+		iterationStart := time.Now()
+		transcript, err := createLoopTranscript(workspaceDir, run.ID, iteration)
+		if err != nil {
+			ui.Warn("Failed to save transcript of iteration %d: %s", iteration, err)
+		}
+
+		iterationUsage, activity, err := runAgentWithStreamTransformer(agentType, args, pluginDirs, transcript)
+		if transcript != nil {
+			transcript.Close()
+		}
+		run.Usage.Add(iterationUsage)
+
+		record := LoopIteration{
+			Iteration:   iteration,
+			StartedAt:   iterationStart,
+			Usage:       iterationUsage,
+			Tools:       activity.Tools,
+			FilesEdited: workspaceRelativePaths(workspaceDir, activity.FilesEdited),
+		}
+		if err != nil {
+			record.Duration = time.Since(iterationStart)
+			record.Error = err.Error()
+			run.AddIteration(record)
 			ui.AgentError(err)
+			saveRun(LoopStatusFailed)
 			return fmt.Errorf("loop stopped: agent exited with error: %w", err)
 		}
 
+		// Run the verification commands, whatever the agent thinks
+		checksPassed := true
+		if len(config.LoopVerify) > 0 {
+			var results []LoopCheckResult
+			for _, check := range config.LoopVerify {
+				result := runLoopCheck(check, workspaceDir)
+				ui.Check(result)
+				results = append(results, result)
+				checksPassed = checksPassed && result.Passed()
+			}
+			run.ChecksFeedback = loopChecksFeedback(results)
+			record.ChecksPassed = &checksPassed
+		}
+
 		// Check for completion file
 		content, err := os.ReadFile(completionFile)
-		if err == nil && len(strings.TrimSpace(string(content))) > 0 {
-			completionCount++
-			ui.Completed(completionCount, requiredConfirmations)
+		completed := err == nil && len(strings.TrimSpace(string(content))) > 0
+		if completed {
+			record.Completion = strings.TrimSpace(string(content))
+		}
 
-			if completionCount >= requiredConfirmations {
+		record.Duration = time.Since(iterationStart)
+		run.AddIteration(record)
+		run.Iteration = iteration
+		if config.LoopCheckpoint {
+			checkpointLoop(ui, run, workspaceDir, iteration)
+		}
+
+		if completed && !checksPassed {
+			run.CompletionStreak = 0
+			ui.ChecksFailed()
+		} else if completed {
+			run.CompletionStreak++
+			ui.Completed(run.CompletionStreak, requiredConfirmations)
+
+			if run.CompletionStreak >= requiredConfirmations {
 				ui.Confirmed(iteration)
+				saveRun(LoopStatusCompleted)
 				return nil
 			}
 
@@ -1292,12 +1441,31 @@ func runLoop(config *EntrypointConfig, agentType AgentType, baseArgs []string, p
 				ui.Reconfirming()
 			}
 		} else {
-			completionCount = 0
+			run.CompletionStreak = 0
 			ui.Continuing()
 		}
+
+		saveRun(LoopStatusRunning)
 	}
 }
 
+// checkpointLoop records the workspace after an iteration in the loop run. A
+// failure is reported but doesn't stop the loop.
+func checkpointLoop(ui *UI, run *LoopRun, workspaceDir string, iteration int) {
+	checkpoint, err := createLoopCheckpoint(workspaceDir, run.ID, iteration)
+	if err != nil {
+		ui.Warn("Failed to create checkpoint of iteration %d: %s", iteration, err)
+		return
+	}
+
+	run.AddCheckpoint(checkpoint)
+	if err := run.Save(workspaceDir); err != nil {
+		ui.Warn("Failed to record checkpoint of iteration %d: %s", iteration, err)
+		return
+	}
+	ui.Checkpoint(checkpoint)
+}
+
 // copyDir recursively copies a directory
 func copyDir(src, dst string) error {
 	srcInfo, err := os.Stat(src)
@@ -1463,8 +1631,10 @@ func enforceJSONField(path string, key string, value any) error {
 
 // PrepareSboxDirectory populates the .sbox/ directory in the workspace with
 // plugins, agents, env vars, CLAUDE.md, and the entrypoint config. This is called by
-// `sbox run` before starting the sandbox.
-func PrepareSboxDirectory(workspaceDir string, config *Config, globalEnvs, projectEnvs, sboxFileEnvs []string, backend BackendType, agent AgentType, opts BackendOptions) error {
+// `sbox run` before starting the sandbox. Returns the secret env vars (KEY=value),
+// which are not written to the workspace and must be delivered by the backend
+// once the sandbox is started (see RunReceiveSecrets).
+func PrepareSboxDirectory(workspaceDir string, config *Config, globalEnvs, projectEnvs, sboxFileEnvs []string, backend BackendType, agent AgentType, opts BackendOptions) ([]string, error) {
 	sboxDir := filepath.Join(workspaceDir, ".sbox")
 
 	zlog.Info("preparing .sbox directory",
@@ -1475,13 +1645,18 @@ func PrepareSboxDirectory(workspaceDir string, config *Config, globalEnvs, proje
 
 	// Create .sbox directory
 	if err := os.MkdirAll(sboxDir, 0755); err != nil {
-		return fmt.Errorf("failed to create .sbox directory: %w", err)
+		return nil, fmt.Errorf("failed to create .sbox directory: %w", err)
 	}
 
 	// Prepare merged CLAUDE.md file with backend-specific context
 	if err := prepareRules(workspaceDir, sboxDir, backend); err != nil {
 		zlog.Warn("failed to prepare rules file", zap.Error(err))
 		// Continue - rules file is optional
+	} else if backend != BackendSandbox && opts.ProjectConfig != nil {
+		// Tell the agent about the network policy enforced by the container backends
+		if err := appendRules(sboxDir, NetworkPolicyContextMD(opts.ProjectConfig.Network)); err != nil {
+			zlog.Warn("failed to add network policy to rules file", zap.Error(err))
+		}
 	}
 
 	entrypointConfig := &EntrypointConfig{
@@ -1490,6 +1665,14 @@ func PrepareSboxDirectory(workspaceDir string, config *Config, globalEnvs, proje
 		LoopMode:          opts.LoopMode,
 		MaxIterations:     opts.MaxIterations,
 		LoopConfirmations: opts.LoopConfirmations,
+		LoopVerify:        opts.LoopVerify,
+		LoopMaxCost:       opts.LoopBudget.MaxCostUSD,
+		LoopMaxTokens:     opts.LoopBudget.MaxTokens,
+		LoopRunID:         opts.LoopRunID,
+		LoopCheckpoint:    opts.LoopCheckpoint,
+	}
+	if opts.LoopBudget.MaxDuration > 0 {
+		entrypointConfig.LoopMaxDuration = &Duration{Duration: opts.LoopBudget.MaxDuration}
 	}
 
 	// Copy developer settings from backend options
@@ -1547,23 +1730,65 @@ func PrepareSboxDirectory(workspaceDir string, config *Config, globalEnvs, proje
 		}
 	}
 
+	// Prepare environment variables
+	resolvedEnvs, err := resolveEnvs(globalEnvs, projectEnvs, sboxFileEnvs)
+	if err != nil {
+		return nil, err
+	}
+
+	// The credential set selected for the project, then the vault secrets
+	// (sbox secret set, sbox auth), fill in the variables the configs don't set
+	var credentialSecretNames []string
+	if name := ResolveCredentialSetName(config, opts.ProjectConfig); name != "" {
+		credentialEnvs, secretNames, err := LoadCredentialEnvs(config, name)
+		if err != nil {
+			return nil, err
+		}
+		resolvedEnvs, _ = fillEnvs(resolvedEnvs, credentialEnvs)
+		credentialSecretNames = secretNames
+		zlog.Info("using credential set", zap.String("name", name))
+	}
+
+	vaultEnvs, err := LoadVaultEnvs(config, workspaceDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load secret vault: %w", err)
+	}
+	resolvedEnvs, vaultNames := fillEnvs(resolvedEnvs, vaultEnvs)
+
+	// Sandboxes have no container config, so the forwarded SSH agent socket
+	// is set through the env file (the container backends set it directly)
+	if backend == BackendSandbox && ResolveSSHSettings(config, opts.ProjectConfig).Mode == SSHModeAgent && HostSSHAgentSocket() != "" {
+		resolvedEnvs = append(resolvedEnvs, "SSH_AUTH_SOCK="+SSHAgentSocketPath)
+	}
+
+	// Secrets stay out of the workspace: the entrypoint waits for the backend
+	// to deliver them in the sandbox, only their names are written here.
+	// Values resolved by a secret provider or from the vault are secrets
+	// whatever their name, like the secrets of the credential set.
+	secretNames := append(ResolveSecretEnvs(config, opts.ProjectConfig), SecretRefEnvNames(globalEnvs, projectEnvs, sboxFileEnvs)...)
+	secretNames = append(secretNames, credentialSecretNames...)
+	secretNames = append(secretNames, vaultNames...)
+	plainEnvs, secretEnvs := SplitSecretEnvs(resolvedEnvs, secretNames)
+	for _, env := range secretEnvs {
+		entrypointConfig.SecretEnvs = append(entrypointConfig.SecretEnvs, EnvName(env))
+	}
+
 	// Write entrypoint config
 	if err := WriteEntrypointConfig(workspaceDir, entrypointConfig); err != nil {
-		return fmt.Errorf("failed to write entrypoint config: %w", err)
+		return nil, fmt.Errorf("failed to write entrypoint config: %w", err)
 	}
 
-	// Prepare and write environment variables
-	resolvedEnvs := resolveEnvs(globalEnvs, projectEnvs, sboxFileEnvs)
-	if err := WriteEntrypointEnv(workspaceDir, resolvedEnvs); err != nil {
-		return fmt.Errorf("failed to write env file: %w", err)
+	if err := WriteEntrypointEnv(workspaceDir, plainEnvs); err != nil {
+		return nil, fmt.Errorf("failed to write env file: %w", err)
 	}
 
 	zlog.Info("prepared .sbox directory",
 		zap.Int("plugins", len(entrypointConfig.Plugins)),
 		zap.Int("agents", len(entrypointConfig.Agents)),
-		zap.Int("envs", len(resolvedEnvs)))
+		zap.Int("envs", len(plainEnvs)),
+		zap.Int("secret_envs", len(secretEnvs)))
 
-	return nil
+	return secretEnvs, nil
 }
 
 // preparePlugins copies installed plugins to .sbox/plugins/
@@ -1885,16 +2110,36 @@ func prepareOpencodeAuth(sboxDir string) error {
 }
 
 // resolveEnvs merges and resolves environment variables from all sources.
-// Passthrough variables (NAME without =) are resolved from the current environment.
+// Passthrough variables (NAME without =) are resolved from the current environment,
+// secret:// values through their secret provider (see ParseSecretRef), host
+// access providers being rejected in sbox.yaml (see ValidateSboxFileEnvSpec).
 // Returns a slice of KEY=value strings ready to write to .sbox/env.
-func resolveEnvs(globalEnvs, projectEnvs, sboxFileEnvs []string) []string {
+func resolveEnvs(globalEnvs, projectEnvs, sboxFileEnvs []string) ([]string, error) {
+	for _, spec := range sboxFileEnvs {
+		if err := ValidateSboxFileEnvSpec(spec); err != nil {
+			return nil, err
+		}
+	}
+
 	merged, _ := MergeEnvs(globalEnvs, projectEnvs, sboxFileEnvs)
 
 	var resolved []string
 	for _, env := range merged {
 		if idx := strings.Index(env, "="); idx >= 0 {
-			// Already has value
-			resolved = append(resolved, env)
+			name, value := env[:idx], env[idx+1:]
+			if !IsSecretRef(value) {
+				// Already has value
+				resolved = append(resolved, env)
+				continue
+			}
+
+			secret, err := ResolveSecretRef(value)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve secret for %s: %w", name, err)
+			}
+			resolved = append(resolved, name+"="+secret)
+			zlog.Debug("resolved secret env",
+				zap.String("name", name))
 		} else {
 			// Passthrough - resolve from host environment
 			value := os.Getenv(env)
@@ -1909,7 +2154,21 @@ func resolveEnvs(globalEnvs, projectEnvs, sboxFileEnvs []string) []string {
 		}
 	}
 
-	return resolved
+	return resolved, nil
+}
+
+// fillEnvs appends to envs the KEY=value entries of extra whose variable is
+// not already set. Returns the updated envs and the names of the added ones.
+func fillEnvs(envs, extra []string) ([]string, []string) {
+	var added []string
+	for _, env := range extra {
+		name := EnvName(env)
+		if !slices.ContainsFunc(envs, func(existing string) bool { return EnvName(existing) == name }) {
+			envs = append(envs, env)
+			added = append(added, name)
+		}
+	}
+	return envs, added
 }
 
 // SaveAgentCache saves the agent's config state to .sbox/ for persistence across recreations.
@@ -2125,3 +2384,21 @@ func prepareRules(workspaceDir, sboxDir string, backend BackendType) error {
 
 	return nil
 }
+
+// appendRules appends a section to .sbox/CLAUDE.md. Empty sections are ignored.
+func appendRules(sboxDir, section string) error {
+	if section == "" {
+		return nil
+	}
+
+	file, err := os.OpenFile(filepath.Join(sboxDir, "CLAUDE.md"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open rules file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString("\n" + section); err != nil {
+		return fmt.Errorf("failed to append to rules file: %w", err)
+	}
+	return nil
+}
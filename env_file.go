@@ -0,0 +1,238 @@
+package sbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// InterpolateEnv expands ${VAR}, ${VAR:-default} (default when VAR is unset
+// or empty) and ${VAR-default} (default when VAR is unset) references with
+// lookup. $${ escapes a literal ${, and a $ not followed by { is kept as-is.
+func InterpolateEnv(value string, lookup func(string) (string, bool)) (string, error) {
+	if !strings.Contains(value, "${") {
+		return value, nil
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' {
+			out.WriteByte(value[i])
+			continue
+		}
+		if strings.HasPrefix(value[i:], "$${") {
+			out.WriteString("${")
+			i += 2
+			continue
+		}
+		if !strings.HasPrefix(value[i:], "${") {
+			out.WriteByte('$')
+			continue
+		}
+
+		end := strings.IndexByte(value[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated variable reference in %q", value)
+		}
+		expr := value[i+2 : i+end]
+		i += end
+
+		name, fallback, mode := expr, "", ""
+		if idx := strings.Index(expr, ":-"); idx >= 0 {
+			name, fallback, mode = expr[:idx], expr[idx+2:], ":-"
+		} else if idx := strings.Index(expr, "-"); idx >= 0 {
+			name, fallback, mode = expr[:idx], expr[idx+1:], "-"
+		}
+		if !isEnvVarName(name) {
+			return "", fmt.Errorf("invalid variable reference ${%s} in %q", expr, value)
+		}
+
+		resolved, found := lookup(name)
+		switch {
+		case mode == ":-" && resolved == "":
+			resolved = fallback
+		case mode == "-" && !found:
+			resolved = fallback
+		}
+		out.WriteString(resolved)
+	}
+	return out.String(), nil
+}
+
+// isEnvVarName reports whether name is a valid environment variable name
+func isEnvVarName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		if c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (i > 0 && c >= '0' && c <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// interpolateYAMLNode expands the variable references of every scalar value
+// of a YAML document, so any string field of sbox.yaml can use them. Plain
+// scalars get their tag resolved again, so ${CPUS:-4} still decodes as a number.
+func interpolateYAMLNode(node *yaml.Node, lookup func(string) (string, bool)) error {
+	if node.Kind == yaml.ScalarNode {
+		if !strings.Contains(node.Value, "${") {
+			return nil
+		}
+		value, err := InterpolateEnv(node.Value, lookup)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		node.Value = value
+		if node.Style == 0 {
+			node.Tag = ""
+		}
+		return nil
+	}
+
+	for i, child := range node.Content {
+		// Keys of mappings are field names, never interpolated
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			continue
+		}
+		if err := interpolateYAMLNode(child, lookup); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseEnvFile reads a .env file: NAME=VALUE lines, with optional "export "
+// prefixes, # comments, and single (literal) or double quoted values.
+// Unquoted and double quoted values are interpolated with the host environment.
+func ParseEnvFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read env file: %w", err)
+	}
+
+	var envs []string
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(strings.TrimSuffix(line, "\r"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		name, value, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !isEnvVarName(name) {
+			return nil, fmt.Errorf("%s:%d: expected NAME=VALUE", path, i+1)
+		}
+
+		value = strings.TrimSpace(value)
+		interpolate := true
+		switch {
+		case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+			value = value[1 : len(value)-1]
+			interpolate = false
+		case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+			value = strings.NewReplacer(`\n`, "\n", `\"`, `"`, `\\`, `\`).Replace(value[1 : len(value)-1])
+		default:
+			// Unquoted values end at an inline comment
+			if idx := strings.Index(value, " #"); idx >= 0 {
+				value = strings.TrimSpace(value[:idx])
+			}
+		}
+		if interpolate {
+			if value, err = InterpolateEnv(value, os.LookupEnv); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+			}
+		}
+		envs = append(envs, name+"="+value)
+	}
+	return envs, nil
+}
+
+// loadEnvFiles loads the env_files of a sbox.yaml file, resolved relative to
+// its directory, and puts their variables before its envs (which override
+// them). Records the file each variable came from.
+//
+// sbox.yaml can be edited by the agent, the files must be in its directory
+// (symlinks included) so that it can't read host files through them.
+func loadEnvFiles(location *SboxFileLocation) error {
+	config := location.Config
+	if len(config.EnvFiles) == 0 {
+		return nil
+	}
+
+	dir, err := filepath.EvalSymlinks(location.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s directory: %w", SboxFileName, err)
+	}
+
+	var fileEnvs []string
+	location.EnvFileSources = map[string]string{}
+	for _, envFile := range config.EnvFiles {
+		path, err := ResolveVolumePath(envFile, location.Dir)
+		if err != nil {
+			return fmt.Errorf("failed to resolve env file path: %w", err)
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(location.Dir, path)
+		}
+		path, err = filepath.EvalSymlinks(path)
+		if err != nil {
+			return fmt.Errorf("failed to load env file %s: %w", envFile, err)
+		}
+		if rel, err := filepath.Rel(dir, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("env file %s is outside of the %s directory %s", envFile, SboxFileName, location.Dir)
+		}
+
+		envs, err := ParseEnvFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to load env file %s: %w", envFile, err)
+		}
+		for _, env := range envs {
+			name := EnvName(env)
+			fileEnvs = removeEnv(fileEnvs, name)
+			fileEnvs = append(fileEnvs, env)
+			location.EnvFileSources[name] = envFile
+		}
+
+		zlog.Debug("loaded env file", zap.String("path", path), zap.Int("envs", len(envs)))
+	}
+
+	for _, env := range config.Envs {
+		name := EnvName(env)
+		fileEnvs = removeEnv(fileEnvs, name)
+		delete(location.EnvFileSources, name)
+	}
+	config.Envs = append(fileEnvs, config.Envs...)
+	return nil
+}
+
+// removeEnv removes the variable name from envs
+func removeEnv(envs []string, name string) []string {
+	kept := envs[:0]
+	for _, env := range envs {
+		if EnvName(env) != name {
+			kept = append(kept, env)
+		}
+	}
+	return kept
+}
+
+// LabelEnvSources labels the variables of resolved that come from an
+// env_files entry of the sbox.yaml file with that file, e.g. ".sbox (.env)"
+func (l *SboxFileLocation) LabelEnvSources(resolved []ResolvedEnv) {
+	if l == nil {
+		return
+	}
+	for i, r := range resolved {
+		if file, ok := l.EnvFileSources[EnvName(r.Spec)]; ok && r.Source == ".sbox" {
+			resolved[i].Source = fmt.Sprintf(".sbox (%s)", file)
+		}
+	}
+}
@@ -141,7 +141,7 @@ USER agent
 
 # Set default command
 CMD ["bash"]
-`, profile.DockerfileSnippet)
+`, profile.DockerfileArgs(nil)+profile.DockerfileSnippet)
 
 	if err := os.WriteFile(dockerfilePath, []byte(dockerfile), 0644); err != nil {
 		t.Fatalf("Failed to write Dockerfile: %v", err)
@@ -205,6 +205,7 @@ func TestIntegration_MultipleProfiles(t *testing.T) {
 		if !ok {
 			t.Fatalf("Profile %q not found", name)
 		}
+		snippets.WriteString(profile.DockerfileArgs(nil))
 		snippets.WriteString(profile.DockerfileSnippet)
 		snippets.WriteString("\n")
 	}
@@ -291,6 +292,7 @@ func TestIntegration_SubstreamsProfile(t *testing.T) {
 			t.Fatalf("Profile %q not found", name)
 		}
 		snippets.WriteString(fmt.Sprintf("# Profile: %s\n", name))
+		snippets.WriteString(profile.DockerfileArgs(nil))
 		snippets.WriteString(profile.DockerfileSnippet)
 		snippets.WriteString("\n")
 	}
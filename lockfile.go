@@ -0,0 +1,332 @@
+package sbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// LockFileName is the name of the lock file written next to sbox.yaml
+const LockFileName = "sbox.lock"
+
+// LockFileVersion is the current version of the lock file format
+const LockFileVersion = 1
+
+// LockFile pins the images referenced by a generated template Dockerfile to
+// their digests so that every teammate builds the same template image.
+type LockFile struct {
+	// Version is the lock file format version
+	Version int `yaml:"version"`
+
+	// TemplateHash is the template hash (without pins) the lock was generated for.
+	// A different hash means profiles, agent or sbox version changed since locking.
+	TemplateHash string `yaml:"template_hash"`
+
+	// Agent is the agent the lock was generated for
+	Agent string `yaml:"agent"`
+
+	// Profiles are the profile references the lock was generated for
+	Profiles []string `yaml:"profiles,omitempty"`
+
+	// Images maps each image reference found in the Dockerfile to its pinned
+	// form, e.g. "debian:bookworm" -> "debian:bookworm@sha256:..."
+	Images map[string]string `yaml:"images"`
+
+	// Path is the file the lock was loaded from
+	Path string `yaml:"-"`
+}
+
+// LockFilePath returns where the lock file lives for a workspace: next to
+// sbox.yaml when there is one, at the workspace root otherwise.
+func LockFilePath(workspaceDir string, sboxFile *SboxFileLocation) (string, error) {
+	if sboxFile != nil && sboxFile.Dir != "" {
+		return filepath.Join(sboxFile.Dir, LockFileName), nil
+	}
+
+	absPath, err := filepath.Abs(workspaceDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	return filepath.Join(absPath, LockFileName), nil
+}
+
+// LoadLockFile reads a lock file. Returns nil without error if it doesn't exist.
+func LoadLockFile(path string) (*LockFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read lock file: %w", err)
+	}
+
+	lock := &LockFile{}
+	if err := yaml.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("failed to parse lock file %s: %w", path, err)
+	}
+	if lock.Version > LockFileVersion {
+		return nil, fmt.Errorf("lock file %s has version %d, this sbox only supports up to %d; upgrade sbox", path, lock.Version, LockFileVersion)
+	}
+	lock.Path = path
+
+	zlog.Debug("loaded lock file",
+		zap.String("path", path),
+		zap.String("template_hash", lock.TemplateHash),
+		zap.Int("images", len(lock.Images)))
+
+	return lock, nil
+}
+
+// SaveLockFile writes the lock file to path
+func SaveLockFile(path string, lock *LockFile) error {
+	data, err := yaml.Marshal(lock)
+	if err != nil {
+		return fmt.Errorf("failed to serialize lock file: %w", err)
+	}
+
+	header := "# Generated by `sbox profile lock`, do not edit.\n# Pins template images to digests, commit it alongside sbox.yaml.\n"
+	if err := os.WriteFile(path, append([]byte(header), data...), 0644); err != nil {
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+
+	lock.Path = path
+	zlog.Debug("saved lock file", zap.String("path", path))
+	return nil
+}
+
+// GenerateLockFile resolves every image referenced by the builder's Dockerfile
+// to its digest. The builder's own lock, if any, is ignored.
+func GenerateLockFile(tb *TemplateBuilder, resolve func(ref string) (string, error)) (*LockFile, error) {
+	unlocked := *tb
+	unlocked.Lock = nil
+
+	dockerfile, err := unlocked.GenerateDockerfile(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate Dockerfile: %w", err)
+	}
+
+	lock := &LockFile{
+		Version:      LockFileVersion,
+		TemplateHash: unlocked.TemplateHash(),
+		Agent:        string(unlocked.Agent),
+		Profiles:     unlocked.Profiles,
+		Images:       make(map[string]string),
+	}
+
+	for _, ref := range DockerfileImageRefs(dockerfile) {
+		digest, err := resolve(ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve digest of %s: %w", ref, err)
+		}
+		lock.Images[ref] = ref + "@" + digest
+	}
+
+	return lock, nil
+}
+
+// Pin returns the pinned form of an image reference, or the reference itself
+// if the lock doesn't know it.
+func (l *LockFile) Pin(ref string) string {
+	if l == nil {
+		return ref
+	}
+	if pinned, ok := l.Images[ref]; ok {
+		return pinned
+	}
+	return ref
+}
+
+// IsStale reports whether the lock no longer matches the builder: the template
+// inputs changed or the Dockerfile references an image that isn't pinned.
+func (l *LockFile) IsStale(tb *TemplateBuilder) bool {
+	unlocked := *tb
+	unlocked.Lock = nil
+
+	if l.TemplateHash != unlocked.TemplateHash() {
+		return true
+	}
+
+	dockerfile, err := unlocked.GenerateDockerfile(nil)
+	if err != nil {
+		return true
+	}
+	for _, ref := range DockerfileImageRefs(dockerfile) {
+		if _, ok := l.Images[ref]; !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// digest returns a short hash of the pinned images, used in the template hash
+func (l *LockFile) digest() string {
+	refs := make([]string, 0, len(l.Images))
+	for _, pinned := range l.Images {
+		refs = append(refs, pinned)
+	}
+	sort.Strings(refs)
+
+	hash := sha256.Sum256([]byte(strings.Join(refs, ",")))
+	return hex.EncodeToString(hash[:])[:12]
+}
+
+// CheckLockFile warns when the workspace's sbox.lock is stale for the given
+// backend options. Missing lock files are ignored. Like `sbox profile lock`,
+// it only considers the configured profiles: session --profile values aren't
+// part of the lock and must not make it look stale.
+func CheckLockFile(opts BackendOptions) {
+	agentType := AgentType(opts.ProjectConfig.Agent)
+
+	builder, err := NewWorkspaceTemplateBuilder(opts.Config, opts.WorkspaceDir, opts.SboxFile, opts.ProjectConfig.Profiles, agentType)
+	if err != nil {
+		zlog.Debug("failed to check lock file", zap.Error(err))
+		return
+	}
+
+	if builder.Lock != nil && builder.Lock.IsStale(builder) {
+		DefaultUI.Warn("%s is stale (profiles, agent or sbox version changed), run 'sbox profile lock' to update it", builder.Lock.Path)
+	}
+}
+
+// ResolveImageDigest returns the registry digest of an image reference.
+// It queries the registry through `docker buildx imagetools` and falls back
+// to the repo digests of the local image.
+func ResolveImageDigest(ref string) (string, error) {
+	cmd := execCommand("docker", "buildx", "imagetools", "inspect", ref, "--format", "{{.Manifest.Digest}}")
+	output, err := cmd.Output()
+	if err == nil {
+		digest := strings.TrimSpace(string(output))
+		if strings.HasPrefix(digest, "sha256:") {
+			return digest, nil
+		}
+	}
+	zlog.Debug("imagetools inspect failed, falling back to local image", zap.String("image", ref), zap.Error(err))
+
+	client, err := NewDockerClient()
+	if err != nil {
+		return "", fmt.Errorf("failed to create docker client: %w", err)
+	}
+	image, err := client.InspectImage(ref)
+	if err != nil {
+		return "", fmt.Errorf("image not found in registry or locally: %w", err)
+	}
+	if image == nil {
+		return "", fmt.Errorf("image not found in registry or locally")
+	}
+
+	repo := imageRepository(ref)
+	for _, repoDigest := range image.RepoDigests {
+		name, digest, ok := strings.Cut(repoDigest, "@")
+		if ok && (name == repo || strings.HasSuffix(name, "/"+repo)) {
+			return digest, nil
+		}
+	}
+	return "", fmt.Errorf("no repo digest found for local image %s", ref)
+}
+
+// imageRepository strips the tag and digest from an image reference
+func imageRepository(ref string) string {
+	ref, _, _ = strings.Cut(ref, "@")
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		ref = ref[:i]
+	}
+	return ref
+}
+
+// DockerfileImageRefs returns the external images referenced by FROM and
+// COPY --from instructions, in order of appearance and without duplicates.
+// Build stage names, scratch, digest-pinned images and references using
+// build variables are skipped.
+func DockerfileImageRefs(dockerfile string) []string {
+	seen := make(map[string]bool)
+	var refs []string
+	rewriteDockerfileImages(dockerfile, func(ref string) string {
+		if !seen[ref] {
+			seen[ref] = true
+			refs = append(refs, ref)
+		}
+		return ref
+	})
+	return refs
+}
+
+// PinDockerfileImages rewrites FROM and COPY --from image references using the lock
+func PinDockerfileImages(dockerfile string, lock *LockFile) string {
+	if lock == nil {
+		return dockerfile
+	}
+	return rewriteDockerfileImages(dockerfile, lock.Pin)
+}
+
+// rewriteDockerfileImages calls fn for each external image reference in the
+// Dockerfile and replaces the reference with its result.
+func rewriteDockerfileImages(dockerfile string, fn func(ref string) string) string {
+	stages := make(map[string]bool)
+	lines := strings.Split(dockerfile, "\n")
+
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "FROM":
+			idx := 1
+			for idx < len(fields) && strings.HasPrefix(fields[idx], "--") {
+				idx++
+			}
+			if idx >= len(fields) {
+				continue
+			}
+			if idx+2 < len(fields) && strings.EqualFold(fields[idx+1], "AS") {
+				stages[strings.ToLower(fields[idx+2])] = true
+			}
+			if ref := fields[idx]; isExternalImage(ref, stages) {
+				fields[idx] = fn(ref)
+				lines[i] = rewriteLine(line, fields)
+			}
+
+		case "COPY":
+			for idx := 1; idx < len(fields) && strings.HasPrefix(fields[idx], "--"); idx++ {
+				ref, ok := strings.CutPrefix(fields[idx], "--from=")
+				if ok && isExternalImage(ref, stages) {
+					fields[idx] = "--from=" + fn(ref)
+					lines[i] = rewriteLine(line, fields)
+				}
+			}
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// isExternalImage reports whether ref is an image to pin rather than a stage
+// name, stage index, scratch, an already pinned image or a variable
+func isExternalImage(ref string, stages map[string]bool) bool {
+	if stages[strings.ToLower(ref)] || ref == "scratch" {
+		return false
+	}
+	if strings.Contains(ref, "@") || strings.Contains(ref, "$") {
+		return false
+	}
+	for _, r := range ref {
+		if r < '0' || r > '9' {
+			return true
+		}
+	}
+	return false
+}
+
+// rewriteLine rebuilds an instruction line, preserving its indentation
+func rewriteLine(line string, fields []string) string {
+	indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+	return indent + strings.Join(fields, " ")
+}
@@ -1,5 +1,124 @@
 package sbox
 
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
 // LoopCompletionFile is the file the agent writes to signal goal completion
 // inside `.sbox/`. The file must contain content describing the completed goal.
 const LoopCompletionFile = "loop.completion"
+
+// loopCheckOutputLimit bounds the output of a failed verification command
+// fed back to the agent, keeping its end where errors usually are
+const loopCheckOutputLimit = 4000
+
+// LoopConfig holds the `sbox loop` settings of a sbox.yaml file
+type LoopConfig struct {
+	// Verify are shell commands run in the sandbox after each iteration. The
+	// goal only counts as complete when they all exit 0.
+	Verify []string `yaml:"verify,omitempty"`
+}
+
+// ResolveLoopVerify determines the verification commands of `sbox loop`:
+// the --verify flags when given, the sbox.yaml `loop.verify` ones otherwise
+func ResolveLoopVerify(cliValues []string, sboxFile *SboxFileLocation) []string {
+	if len(cliValues) > 0 {
+		return cliValues
+	}
+	if sboxFile != nil && sboxFile.Config != nil && sboxFile.Config.Loop != nil {
+		return sboxFile.Config.Loop.Verify
+	}
+	return nil
+}
+
+// LoopCheckResult is the outcome of a loop verification command
+type LoopCheckResult struct {
+	Command  string
+	ExitCode int
+	Output   string
+	Duration time.Duration
+}
+
+// Passed reports whether the command exited 0
+func (r LoopCheckResult) Passed() bool {
+	return r.ExitCode == 0
+}
+
+// runLoopCheck runs a verification command through the shell in the
+// workspace directory
+func runLoopCheck(command, workspaceDir string) LoopCheckResult {
+	start := time.Now()
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = workspaceDir
+	output, err := cmd.CombinedOutput()
+
+	result := LoopCheckResult{Command: command, Output: string(output), Duration: time.Since(start)}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitCode()
+		} else {
+			result.ExitCode = -1
+			result.Output += err.Error()
+		}
+	}
+	return result
+}
+
+// LoopBudget bounds the usage of a loop, zero values are unlimited
+type LoopBudget struct {
+	MaxCostUSD  float64       `yaml:"max_cost_usd,omitempty"`
+	MaxTokens   int           `yaml:"max_tokens,omitempty"`
+	MaxDuration time.Duration `yaml:"max_duration,omitempty"`
+}
+
+// Exceeded describes the first budget reached by the usage of a loop running
+// for elapsed, empty when none is
+func (b LoopBudget) Exceeded(usage AgentUsage, elapsed time.Duration) string {
+	switch {
+	case b.MaxCostUSD > 0 && usage.CostUSD >= b.MaxCostUSD:
+		return fmt.Sprintf("cost budget of $%.2f reached ($%.4f)", b.MaxCostUSD, usage.CostUSD)
+	case b.MaxTokens > 0 && usage.Tokens >= b.MaxTokens:
+		return fmt.Sprintf("token budget of %d reached (%d tokens)", b.MaxTokens, usage.Tokens)
+	case b.MaxDuration > 0 && elapsed >= b.MaxDuration:
+		return fmt.Sprintf("duration budget of %s reached (%s)", b.MaxDuration, elapsed.Round(time.Second))
+	}
+	return ""
+}
+
+// loopChecksPrompt tells the agent about the verification commands gating
+// the goal completion
+func loopChecksPrompt(checks []string) string {
+	var builder strings.Builder
+	builder.WriteString("\n**Verification:** the goal is only considered complete once these commands, run from the workspace root after your run, all succeed (exit code 0):\n")
+	for _, check := range checks {
+		fmt.Fprintf(&builder, "- `%s`\n", check)
+	}
+	return builder.String()
+}
+
+// loopChecksFeedback describes the failed verification commands of an
+// iteration for the next one, empty when they all passed
+func loopChecksFeedback(results []LoopCheckResult) string {
+	var builder strings.Builder
+	for _, result := range results {
+		if result.Passed() {
+			continue
+		}
+
+		output := strings.TrimSpace(result.Output)
+		if len(output) > loopCheckOutputLimit {
+			output = "[...]\n" + output[len(output)-loopCheckOutputLimit:]
+		}
+		fmt.Fprintf(&builder, "\n`%s` failed with exit code %d:\n```\n%s\n```\n", result.Command, result.ExitCode, output)
+	}
+
+	if builder.Len() == 0 {
+		return ""
+	}
+	return "\n**Failed verification:** after the previous iteration, these verification commands failed. Fix them before considering the goal complete.\n" + builder.String()
+}
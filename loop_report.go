@@ -0,0 +1,245 @@
+package sbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// LoopReportMarkdownFile and LoopReportJSONFile are the reports of a loop
+	// run, in its directory, updated after each iteration
+	LoopReportMarkdownFile = "report.md"
+	LoopReportJSONFile     = "report.json"
+)
+
+// LoopTranscriptFile returns the name of the file holding the raw agent stream
+// of an iteration, in the directory of its loop run
+func LoopTranscriptFile(iteration int) string {
+	return fmt.Sprintf("iter-%d.jsonl", iteration)
+}
+
+// LoopReport summarizes a loop run and its iterations
+type LoopReport struct {
+	RunID          string                `json:"run_id"`
+	Status         LoopStatus            `json:"status"`
+	Prompt         string                `json:"prompt"`
+	Agent          string                `json:"agent,omitempty"`
+	StartedAt      time.Time             `json:"started_at"`
+	FinishedAt     *time.Time            `json:"finished_at,omitempty"`
+	ElapsedSeconds float64               `json:"elapsed_seconds"`
+	IterationCount int                   `json:"iteration_count"`
+	CostUSD        float64               `json:"cost_usd"`
+	Tokens         int                   `json:"tokens"`
+	Turns          int                   `json:"turns"`
+	Iterations     []LoopIterationReport `json:"iterations"`
+}
+
+// LoopIterationReport summarizes an iteration of a loop run
+type LoopIterationReport struct {
+	Iteration       int            `json:"iteration"`
+	StartedAt       time.Time      `json:"started_at"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	Turns           int            `json:"turns"`
+	Tokens          int            `json:"tokens"`
+	CostUSD         float64        `json:"cost_usd"`
+	Tools           map[string]int `json:"tools,omitempty"`
+	FilesEdited     []string       `json:"files_edited,omitempty"`
+	ChecksPassed    *bool          `json:"checks_passed,omitempty"`
+	Completion      string         `json:"completion,omitempty"`
+	Error           string         `json:"error,omitempty"`
+	Checkpoint      string         `json:"checkpoint,omitempty"`
+	Transcript      string         `json:"transcript"`
+}
+
+// NewLoopReport builds the report of a loop run from its state
+func NewLoopReport(run *LoopRun) *LoopReport {
+	report := &LoopReport{
+		RunID:          run.ID,
+		Status:         run.Status,
+		Prompt:         run.Prompt,
+		Agent:          run.Agent,
+		StartedAt:      run.StartedAt,
+		FinishedAt:     run.FinishedAt,
+		ElapsedSeconds: run.Elapsed.Seconds(),
+		IterationCount: run.Iteration,
+		CostUSD:        run.Usage.CostUSD,
+		Tokens:         run.Usage.Tokens,
+		Turns:          run.Usage.Turns,
+	}
+
+	for _, iteration := range run.Iterations {
+		iterationReport := LoopIterationReport{
+			Iteration:       iteration.Iteration,
+			StartedAt:       iteration.StartedAt,
+			DurationSeconds: iteration.Duration.Seconds(),
+			Turns:           iteration.Usage.Turns,
+			Tokens:          iteration.Usage.Tokens,
+			CostUSD:         iteration.Usage.CostUSD,
+			Tools:           iteration.Tools,
+			FilesEdited:     iteration.FilesEdited,
+			ChecksPassed:    iteration.ChecksPassed,
+			Completion:      iteration.Completion,
+			Error:           iteration.Error,
+			Transcript:      LoopTranscriptFile(iteration.Iteration),
+		}
+		if checkpoint := run.Checkpoint(iteration.Iteration); checkpoint != nil {
+			iterationReport.Checkpoint = checkpoint.Ref
+		}
+		report.Iterations = append(report.Iterations, iterationReport)
+	}
+	return report
+}
+
+// Markdown renders the report as a markdown document
+func (r *LoopReport) Markdown() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "# sbox loop run %s\n\n", r.RunID)
+	fmt.Fprintf(&builder, "> %s\n\n", strings.ReplaceAll(strings.TrimSpace(r.Prompt), "\n", "\n> "))
+
+	fmt.Fprintf(&builder, "- **Status:** %s\n", r.Status)
+	if r.Agent != "" {
+		fmt.Fprintf(&builder, "- **Agent:** %s\n", r.Agent)
+	}
+	fmt.Fprintf(&builder, "- **Started:** %s\n", r.StartedAt.Format(time.RFC3339))
+	if r.FinishedAt != nil {
+		fmt.Fprintf(&builder, "- **Finished:** %s\n", r.FinishedAt.Format(time.RFC3339))
+	}
+	fmt.Fprintf(&builder, "- **Iterations:** %d\n", r.IterationCount)
+	fmt.Fprintf(&builder, "- **Duration:** %s\n", formatSeconds(r.ElapsedSeconds))
+	fmt.Fprintf(&builder, "- **Usage:** %d turns, %d tokens, $%.4f\n", r.Turns, r.Tokens, r.CostUSD)
+
+	if len(r.Iterations) == 0 {
+		return builder.String()
+	}
+
+	builder.WriteString("\n| # | Duration | Turns | Tokens | Cost | Files edited | Checks | Completed |\n")
+	builder.WriteString("|---|---|---|---|---|---|---|---|\n")
+	for _, iteration := range r.Iterations {
+		fmt.Fprintf(&builder, "| %d | %s | %d | %d | $%.4f | %d | %s | %s |\n",
+			iteration.Iteration,
+			formatSeconds(iteration.DurationSeconds),
+			iteration.Turns,
+			iteration.Tokens,
+			iteration.CostUSD,
+			len(iteration.FilesEdited),
+			iteration.checksStatus(),
+			yesNo(iteration.Completion != ""))
+	}
+
+	for _, iteration := range r.Iterations {
+		fmt.Fprintf(&builder, "\n## Iteration %d\n\n", iteration.Iteration)
+		if iteration.Error != "" {
+			fmt.Fprintf(&builder, "- **Error:** %s\n", iteration.Error)
+		}
+		if len(iteration.Tools) > 0 {
+			fmt.Fprintf(&builder, "- **Tools:** %s\n", formatToolCounts(iteration.Tools))
+		}
+		if len(iteration.FilesEdited) > 0 {
+			fmt.Fprintf(&builder, "- **Files edited:** `%s`\n", strings.Join(iteration.FilesEdited, "`, `"))
+		}
+		if iteration.Checkpoint != "" {
+			fmt.Fprintf(&builder, "- **Checkpoint:** `%s`\n", iteration.Checkpoint)
+		}
+		fmt.Fprintf(&builder, "- **Transcript:** [%s](%s)\n", iteration.Transcript, iteration.Transcript)
+		if iteration.Completion != "" {
+			fmt.Fprintf(&builder, "\nCompletion:\n\n> %s\n", strings.ReplaceAll(iteration.Completion, "\n", "\n> "))
+		}
+	}
+	return builder.String()
+}
+
+// checksStatus describes the outcome of the verification commands
+func (r LoopIterationReport) checksStatus() string {
+	if r.ChecksPassed == nil {
+		return "-"
+	}
+	if *r.ChecksPassed {
+		return "passed"
+	}
+	return "failed"
+}
+
+// WriteLoopReport writes the markdown and JSON reports of a loop run in its
+// directory. Returns the report.
+func WriteLoopReport(workspaceDir string, run *LoopRun) (*LoopReport, error) {
+	report := NewLoopReport(run)
+	dir := loopRunDir(workspaceDir, run.ID)
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal loop report: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, LoopReportJSONFile), append(data, '\n'), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write loop report: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, LoopReportMarkdownFile), []byte(report.Markdown()), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write loop report: %w", err)
+	}
+	return report, nil
+}
+
+// createLoopTranscript creates the transcript file of an iteration, replacing
+// the one of an iteration run again after an interruption
+func createLoopTranscript(workspaceDir, runID string, iteration int) (io.WriteCloser, error) {
+	dir := loopRunDir(workspaceDir, runID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create loop run directory: %w", err)
+	}
+
+	file, err := os.Create(filepath.Join(dir, LoopTranscriptFile(iteration)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transcript: %w", err)
+	}
+	return file, nil
+}
+
+// workspaceRelativePaths makes the paths under the workspace relative to it
+func workspaceRelativePaths(workspaceDir string, paths []string) []string {
+	var relative []string
+	for _, path := range paths {
+		if rel, err := filepath.Rel(workspaceDir, path); err == nil && filepath.IsAbs(path) && !strings.HasPrefix(rel, "..") {
+			path = rel
+		}
+		relative = append(relative, path)
+	}
+	return relative
+}
+
+// formatToolCounts lists tools by decreasing number of calls
+func formatToolCounts(tools map[string]int) string {
+	names := make([]string, 0, len(tools))
+	for name := range tools {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if tools[names[i]] != tools[names[j]] {
+			return tools[names[i]] > tools[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf("%s ×%d", name, tools[name])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// formatSeconds formats a duration in seconds, rounded to the second
+func formatSeconds(seconds float64) string {
+	return (time.Duration(seconds * float64(time.Second))).Round(time.Second).String()
+}
+
+// yesNo formats a boolean for the report tables
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,753 @@
+package sbox
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// LoopRunsDir is the directory of the workspace .sbox directory holding the
+// `sbox loop` runs, one sub-directory per run ID
+const LoopRunsDir = "loops"
+
+// loopRunFile is the state file of a loop run, in its directory
+const loopRunFile = "run.yaml"
+
+// loopSettingsFile is the settings file of a loop run, in its directory of
+// the sbox data directory
+const loopSettingsFile = "settings.yaml"
+
+// loopCheckpointRefPrefix is the git ref namespace of the loop checkpoints,
+// followed by the run ID and the iteration
+const loopCheckpointRefPrefix = "refs/sbox/loop/"
+
+// LoopStatus is the status of a loop run
+type LoopStatus string
+
+const (
+	LoopStatusRunning       LoopStatus = "running"
+	LoopStatusCompleted     LoopStatus = "completed"
+	LoopStatusMaxIterations LoopStatus = "max_iterations"
+	LoopStatusBudget        LoopStatus = "budget_reached"
+	LoopStatusFailed        LoopStatus = "failed"
+	LoopStatusInterrupted   LoopStatus = "interrupted"
+)
+
+// LoopRun is the state of a `sbox loop` run, saved in
+// .sbox/loops/<id>/run.yaml: its settings and its progress, updated by the
+// entrypoint after each iteration. The workspace being writable by the agent,
+// the settings a run is resumed with are the ones saved in the sbox data
+// directory (see SaveLoopSettings), run.yaml only holding a copy for the
+// report.
+type LoopRun struct {
+	ID     string     `yaml:"id"`
+	Status LoopStatus `yaml:"status"`
+
+	LoopSettings `yaml:",inline"`
+
+	// Progress: completed iterations, consecutive goal completions, feedback
+	// of the failed verification commands for the next iteration, and usage
+	// and time spent running iterations
+	Iteration        int           `yaml:"iteration"`
+	CompletionStreak int           `yaml:"completion_streak"`
+	ChecksFeedback   string        `yaml:"checks_feedback,omitempty"`
+	Usage            AgentUsage    `yaml:"usage"`
+	Elapsed          time.Duration `yaml:"elapsed"`
+
+	StartedAt  time.Time  `yaml:"started_at"`
+	UpdatedAt  time.Time  `yaml:"updated_at"`
+	FinishedAt *time.Time `yaml:"finished_at,omitempty"`
+
+	Iterations  []LoopIteration  `yaml:"iterations,omitempty"`
+	Checkpoints []LoopCheckpoint `yaml:"checkpoints,omitempty"`
+}
+
+// LoopSettings are the settings of a loop run, kept when it is resumed
+type LoopSettings struct {
+	Prompt        string     `yaml:"prompt"`
+	Backend       string     `yaml:"backend,omitempty"`
+	Agent         string     `yaml:"agent,omitempty"`
+	MaxIterations int        `yaml:"max_iterations,omitempty"`
+	Confirmations int        `yaml:"confirmations,omitempty"`
+	Verify        []string   `yaml:"verify,omitempty"`
+	Budget        LoopBudget `yaml:"budget,omitempty"`
+	Checkpointing bool       `yaml:"checkpointing,omitempty"`
+}
+
+// LoopIteration is the record of an iteration of a loop run, its agent stream
+// being saved in .sbox/loops/<id>/iter-<n>.jsonl
+type LoopIteration struct {
+	Iteration int           `yaml:"iteration"`
+	StartedAt time.Time     `yaml:"started_at"`
+	Duration  time.Duration `yaml:"duration"`
+	Usage     AgentUsage    `yaml:"usage"`
+
+	// Tools are the number of calls of each tool, FilesEdited the files
+	// edited relative to the workspace
+	Tools       map[string]int `yaml:"tools,omitempty"`
+	FilesEdited []string       `yaml:"files_edited,omitempty"`
+
+	// ChecksPassed is nil without verification commands
+	ChecksPassed *bool `yaml:"checks_passed,omitempty"`
+
+	// Completion is the content of the completion file written by the agent
+	Completion string `yaml:"completion,omitempty"`
+
+	// Error is the agent failure stopping the loop
+	Error string `yaml:"error,omitempty"`
+}
+
+// LoopCheckpoint is the git commit recording the workspace after a loop
+// iteration, iteration 0 being the workspace before the first one
+type LoopCheckpoint struct {
+	Iteration int       `yaml:"iteration"`
+	Ref       string    `yaml:"ref"`
+	Commit    string    `yaml:"commit"`
+	CreatedAt time.Time `yaml:"created_at"`
+}
+
+// ShortCommit returns the abbreviated commit of the checkpoint
+func (c LoopCheckpoint) ShortCommit() string {
+	if len(c.Commit) > 12 {
+		return c.Commit[:12]
+	}
+	return c.Commit
+}
+
+// NewLoopRun creates the state of a new loop run, with an ID sorting by start
+// time
+func NewLoopRun() *LoopRun {
+	now := time.Now()
+	suffix := make([]byte, 2)
+	rand.Read(suffix)
+
+	return &LoopRun{
+		ID:        now.Format("20060102-150405") + "-" + hex.EncodeToString(suffix),
+		Status:    LoopStatusRunning,
+		StartedAt: now,
+		UpdatedAt: now,
+	}
+}
+
+// loopRunDir returns the directory of a loop run
+func loopRunDir(workspaceDir, id string) string {
+	return filepath.Join(workspaceDir, ".sbox", LoopRunsDir, id)
+}
+
+// LoadLoopRun loads the state of a loop run of the workspace
+func LoadLoopRun(workspaceDir, id string) (*LoopRun, error) {
+	data, err := os.ReadFile(filepath.Join(loopRunDir(workspaceDir, id), loopRunFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("loop run %s not found: %w", id, err)
+		}
+		return nil, fmt.Errorf("failed to read loop run %s: %w", id, err)
+	}
+
+	var run LoopRun
+	if err := yaml.Unmarshal(data, &run); err != nil {
+		return nil, fmt.Errorf("failed to parse loop run %s: %w", id, err)
+	}
+	return &run, nil
+}
+
+// LatestLoopRun loads the state of the last loop run of the workspace
+func LatestLoopRun(workspaceDir string) (*LoopRun, error) {
+	entries, err := os.ReadDir(filepath.Join(workspaceDir, ".sbox", LoopRunsDir))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to list loop runs: %w", err)
+	}
+
+	var ids []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			ids = append(ids, entry.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+
+	for _, id := range ids {
+		if run, err := LoadLoopRun(workspaceDir, id); err == nil {
+			return run, nil
+		}
+	}
+	return nil, fmt.Errorf("no sbox loop run found in %s", workspaceDir)
+}
+
+// Save writes the state of the loop run
+func (r *LoopRun) Save(workspaceDir string) error {
+	data, err := yaml.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to marshal loop run: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(loopRunDir(workspaceDir, r.ID), loopRunFile), data, 0644); err != nil {
+		return fmt.Errorf("failed to write loop run: %w", err)
+	}
+	return nil
+}
+
+// loopSettingsPath returns the settings file of a loop run, in the sbox data
+// directory
+func loopSettingsPath(dataDir, workspaceDir, id string) (string, error) {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return "", fmt.Errorf("invalid loop run ID %q", id)
+	}
+
+	_, projectID, err := projectIdentity(dataDir, workspaceDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, "projects", projectID, LoopRunsDir, id, loopSettingsFile), nil
+}
+
+// SaveLoopSettings writes the settings of the loop run in the sbox data
+// directory, out of reach of the agent
+func SaveLoopSettings(dataDir, workspaceDir string, run *LoopRun) error {
+	path, err := loopSettingsPath(dataDir, workspaceDir, run.ID)
+	if err != nil {
+		return err
+	}
+
+	data, err := yaml.Marshal(run.LoopSettings)
+	if err != nil {
+		return fmt.Errorf("failed to marshal loop settings: %w", err)
+	}
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write loop settings: %w", err)
+	}
+	return nil
+}
+
+// LoadLoopSettings loads the settings of a loop run saved by
+// SaveLoopSettings
+func LoadLoopSettings(dataDir, workspaceDir, id string) (*LoopSettings, error) {
+	path, err := loopSettingsPath(dataDir, workspaceDir, id)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("settings of loop run %s not found in %s, start a new run", id, filepath.Dir(path))
+		}
+		return nil, fmt.Errorf("failed to read settings of loop run %s: %w", id, err)
+	}
+
+	var settings LoopSettings
+	if err := yaml.Unmarshal(data, &settings); err != nil {
+		return nil, fmt.Errorf("failed to parse settings of loop run %s: %w", id, err)
+	}
+	return &settings, nil
+}
+
+// Finish records the final status of the loop run
+func (r *LoopRun) Finish(status LoopStatus) {
+	now := time.Now()
+	r.Status = status
+	r.UpdatedAt = now
+	r.FinishedAt = &now
+}
+
+// Resume prepares a stopped loop run to be continued. Completed runs can't be
+// resumed.
+func (r *LoopRun) Resume() error {
+	if r.Status == LoopStatusCompleted {
+		return fmt.Errorf("loop run %s already completed", r.ID)
+	}
+
+	r.Status = LoopStatusRunning
+	r.UpdatedAt = time.Now()
+	r.FinishedAt = nil
+	return nil
+}
+
+// AddIteration records an iteration, replacing the one run again after an
+// interruption
+func (r *LoopRun) AddIteration(iteration LoopIteration) {
+	for i := range r.Iterations {
+		if r.Iterations[i].Iteration == iteration.Iteration {
+			r.Iterations[i] = iteration
+			return
+		}
+	}
+	r.Iterations = append(r.Iterations, iteration)
+}
+
+// AddCheckpoint records the checkpoint of an iteration, replacing the one of
+// an iteration run again after an interruption
+func (r *LoopRun) AddCheckpoint(checkpoint LoopCheckpoint) {
+	for i := range r.Checkpoints {
+		if r.Checkpoints[i].Iteration == checkpoint.Iteration {
+			r.Checkpoints[i] = checkpoint
+			return
+		}
+	}
+	r.Checkpoints = append(r.Checkpoints, checkpoint)
+}
+
+// Checkpoint returns the checkpoint of an iteration, nil when there is none
+func (r *LoopRun) Checkpoint(iteration int) *LoopCheckpoint {
+	for i := range r.Checkpoints {
+		if r.Checkpoints[i].Iteration == iteration {
+			return &r.Checkpoints[i]
+		}
+	}
+	return nil
+}
+
+// ValidateLoopCheckpoint checks that the workspace is a git repository, where
+// loop checkpoints can be created
+func ValidateLoopCheckpoint(workspaceDir string) error {
+	if _, err := runGit(workspaceDir, nil, "rev-parse", "--git-dir"); err != nil {
+		return fmt.Errorf("--checkpoint requires the workspace to be a git repository: %w", err)
+	}
+	return nil
+}
+
+// loopCheckpointRef returns the ref of the checkpoint of a loop iteration
+func loopCheckpointRef(runID string, iteration int) string {
+	return loopCheckpointRefPrefix + runID + "/" + strconv.Itoa(iteration)
+}
+
+// createLoopCheckpoint records the workspace after an iteration as a commit
+// referenced by refs/sbox/loop/<run-id>/<iteration>. The branch, the index
+// and the files of the workspace are left untouched. It is run by the
+// entrypoint, inside the sandbox.
+func createLoopCheckpoint(workspaceDir, runID string, iteration int) (LoopCheckpoint, error) {
+	commit, err := gitSnapshot(workspaceDir, sandboxGitEnv, fmt.Sprintf("sbox loop %s: iteration %d", runID, iteration))
+	if err != nil {
+		return LoopCheckpoint{}, err
+	}
+
+	ref := loopCheckpointRef(runID, iteration)
+	if _, err := runGit(workspaceDir, sandboxGitEnv, "update-ref", ref, commit); err != nil {
+		return LoopCheckpoint{}, err
+	}
+	return LoopCheckpoint{Iteration: iteration, Ref: ref, Commit: commit, CreatedAt: time.Now()}, nil
+}
+
+// RollbackLoop restores the working tree of the workspace to the checkpoint of
+// an iteration of a loop run: files are restored to their content at the
+// checkpoint and the ones created since are removed. The branch, the index and
+// the .sbox directory are left untouched. The state before the rollback is
+// kept as a commit referenced by the returned ref.
+//
+// It runs on the host, in a repository the agent can write to: the commit
+// restored is the one of the checkpoint ref, which must match the run state,
+// and files are read and written by sbox rather than through git filters.
+func RollbackLoop(workspaceDir string, run *LoopRun, iteration int) (string, error) {
+	checkpoint := run.Checkpoint(iteration)
+	if checkpoint == nil {
+		return "", fmt.Errorf("loop run %s has no checkpoint for iteration %d", run.ID, iteration)
+	}
+
+	ref := loopCheckpointRef(run.ID, iteration)
+	commit, err := runGit(workspaceDir, nil, "rev-parse", "--verify", "--quiet", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("checkpoint %s not found in the repository: %w", ref, err)
+	}
+	if commit != checkpoint.Commit {
+		return "", fmt.Errorf("checkpoint %s points to %s but loop run %s recorded %s, refusing to roll back", ref, commit, run.ID, checkpoint.Commit)
+	}
+
+	backup, err := gitSnapshot(workspaceDir, nil, fmt.Sprintf("sbox loop %s: before rollback to iteration %d", run.ID, iteration))
+	if err != nil {
+		return "", fmt.Errorf("failed to save the current state: %w", err)
+	}
+	backupRef := loopCheckpointRefPrefix + run.ID + "/before-rollback"
+	if _, err := runGit(workspaceDir, nil, "update-ref", backupRef, backup); err != nil {
+		return "", err
+	}
+
+	// Files created since the checkpoint
+	added, err := gitOutput(workspaceDir, nil, nil, "diff", "--name-only", "-z", "--no-renames", "--no-ext-diff", "--no-textconv", "--relative", "--diff-filter=A", commit, backup, "--", ".")
+	if err != nil {
+		return "", err
+	}
+	for _, path := range splitNul(added) {
+		// Not the file of the checkpoint, but one the symlink leads to
+		if hasSymlinkLeadingPath(workspaceDir, path) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(workspaceDir, path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+	}
+
+	if err := gitRestoreTree(workspaceDir, commit); err != nil {
+		return "", fmt.Errorf("failed to restore checkpoint: %w", err)
+	}
+
+	zlog.Info("rolled back loop",
+		zap.String("run_id", run.ID),
+		zap.Int("iteration", iteration),
+		zap.String("commit", commit))
+	return backupRef, nil
+}
+
+// gitSnapshot commits the files of the workspace, as they are on disk, on top
+// of HEAD without touching the branch or the index. The workspace .sbox
+// directory is left out. Returns the commit.
+func gitSnapshot(workspaceDir string, env []string, message string) (string, error) {
+	head, _ := runGit(workspaceDir, env, "rev-parse", "--verify", "--quiet", "HEAD")
+
+	var commit string
+	err := withTemporaryIndex(env, func(env []string) error {
+		if head != "" {
+			// Keeps the tracked files matching .gitignore
+			if _, err := runGit(workspaceDir, env, "read-tree", head); err != nil {
+				return err
+			}
+		}
+		if err := gitAddAll(workspaceDir, env); err != nil {
+			return err
+		}
+		tree, err := runGit(workspaceDir, env, "write-tree")
+		if err != nil {
+			return err
+		}
+
+		args := []string{"commit-tree", "--no-gpg-sign", tree, "-m", message}
+		if head != "" {
+			args = append(args, "-p", head)
+		}
+		commit, err = runGit(workspaceDir, env, args...)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to snapshot workspace: %w", err)
+	}
+	return commit, nil
+}
+
+// gitAddAll stages the files of the workspace as they are on disk, like `git
+// add --all` but storing their content as is: no clean filter or attribute
+// is applied. The .sbox directory is left out, and paths leading through a
+// symlink are staged as removed.
+func gitAddAll(workspaceDir string, env []string) error {
+	prefix, err := runGit(workspaceDir, env, "rev-parse", "--show-prefix")
+	if err != nil {
+		return err
+	}
+	// Entries removed with mode 0 still need an object name of the right size
+	nullObject := strings.Repeat("0", 40)
+	if format, _ := runGit(workspaceDir, env, "rev-parse", "--show-object-format"); format == "sha256" {
+		nullObject = strings.Repeat("0", 64)
+	}
+	listed, err := gitOutput(workspaceDir, env, nil, "ls-files", "-z", "--full-name", "--cached", "--others", "--exclude-standard", "--", ".", ":(exclude).sbox")
+	if err != nil {
+		return err
+	}
+
+	var info bytes.Buffer
+	var files, modes, fullNames []string
+	seen := make(map[string]bool)
+	for _, fullName := range splitNul(listed) {
+		if seen[fullName] {
+			continue
+		}
+		seen[fullName] = true
+
+		path := strings.TrimPrefix(fullName, prefix)
+		stat, err := os.Lstat(filepath.Join(workspaceDir, path))
+		switch {
+		case err != nil || hasSymlinkLeadingPath(workspaceDir, path):
+			fmt.Fprintf(&info, "0 %s\t%s\x00", nullObject, fullName)
+		case stat.Mode().IsRegular():
+			mode := "100644"
+			if stat.Mode()&0111 != 0 {
+				mode = "100755"
+			}
+			files, modes, fullNames = append(files, path), append(modes, mode), append(fullNames, fullName)
+		case stat.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(filepath.Join(workspaceDir, path))
+			if err != nil {
+				return fmt.Errorf("failed to read symlink %s: %w", path, err)
+			}
+			object, err := runGitInput(workspaceDir, env, strings.NewReader(target), "hash-object", "-w", "--stdin")
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&info, "120000 %s\t%s\x00", object, fullName)
+		}
+		// Directories are submodules, their entry is kept
+	}
+
+	if len(files) > 0 {
+		var paths strings.Builder
+		for _, path := range files {
+			// Relative paths are resolved from the top of the repository
+			paths.WriteString(gitQuotePath(filepath.Join(workspaceDir, path)) + "\n")
+		}
+		objects, err := runGitInput(workspaceDir, env, strings.NewReader(paths.String()), "hash-object", "-w", "--no-filters", "--stdin-paths")
+		if err != nil {
+			return err
+		}
+		for i, object := range strings.Fields(objects) {
+			fmt.Fprintf(&info, "%s %s\t%s\x00", modes[i], object, fullNames[i])
+		}
+	}
+
+	_, err = gitOutput(workspaceDir, env, &info, "update-index", "-z", "--index-info")
+	return err
+}
+
+// gitRestoreTree writes the files of the workspace as they are in a commit,
+// with their content as is: no smudge filter or attribute is applied. The
+// .sbox directory is left out. Files are written by sbox, replacing the
+// symlinks met on their path rather than following them.
+func gitRestoreTree(workspaceDir, commit string) error {
+	prefix, err := runGit(workspaceDir, nil, "rev-parse", "--show-prefix")
+	if err != nil {
+		return err
+	}
+	listed, err := gitOutput(workspaceDir, nil, nil, "ls-tree", "-r", "-z", "--full-name", commit, "--", ".")
+	if err != nil {
+		return err
+	}
+
+	type treeFile struct{ mode, path string }
+	var objects strings.Builder
+	var files []treeFile
+	for _, entry := range splitNul(listed) {
+		// <mode> SP <type> SP <object> TAB <path>
+		meta, fullName, ok := strings.Cut(entry, "\t")
+		fields := strings.Fields(meta)
+		if !ok || len(fields) != 3 || fields[1] != "blob" {
+			continue
+		}
+		path := strings.TrimPrefix(fullName, prefix)
+		if path == ".sbox" || strings.HasPrefix(path, ".sbox/") {
+			continue
+		}
+		files = append(files, treeFile{mode: fields[0], path: path})
+		objects.WriteString(fields[2] + "\n")
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	cmd := gitCommand(workspaceDir, nil, "cat-file", "--batch")
+	cmd.Stdin = strings.NewReader(objects.String())
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("git cat-file: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("git cat-file: %w", err)
+	}
+
+	// <object> SP <type> SP <size> LF <content> LF
+	reader := bufio.NewReader(stdout)
+	for _, file := range files {
+		header, err := reader.ReadString('\n')
+		if err != nil {
+			cmd.Wait()
+			return fmt.Errorf("git cat-file: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		fields := strings.Fields(header)
+		if len(fields) != 3 {
+			cmd.Wait()
+			return fmt.Errorf("git cat-file: unexpected output %q", strings.TrimSpace(header))
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil {
+			cmd.Wait()
+			return fmt.Errorf("git cat-file: unexpected output %q", strings.TrimSpace(header))
+		}
+		content := make([]byte, size+1)
+		if _, err := io.ReadFull(reader, content); err != nil {
+			cmd.Wait()
+			return fmt.Errorf("git cat-file: %w", err)
+		}
+
+		if err := restoreWorkspaceFile(workspaceDir, file.path, file.mode, content[:size]); err != nil {
+			cmd.Wait()
+			return err
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("git cat-file: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// restoreWorkspaceFile writes a file of the workspace, replacing what is
+// there, including directories or symlinks on its path
+func restoreWorkspaceFile(workspaceDir, path, mode string, content []byte) error {
+	dir := workspaceDir
+	for _, part := range strings.Split(filepath.Dir(filepath.FromSlash(path)), string(filepath.Separator)) {
+		if part == "." {
+			break
+		}
+		dir = filepath.Join(dir, part)
+		stat, err := os.Lstat(dir)
+		if err == nil && stat.IsDir() {
+			continue
+		}
+		if err == nil {
+			if err := os.Remove(dir); err != nil {
+				return fmt.Errorf("failed to restore %s: %w", path, err)
+			}
+		}
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", path, err)
+		}
+	}
+
+	target := filepath.Join(workspaceDir, path)
+	if err := os.RemoveAll(target); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", path, err)
+	}
+	if mode == "120000" {
+		if err := os.Symlink(string(content), target); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", path, err)
+		}
+		return nil
+	}
+
+	perm := os.FileMode(0644)
+	if mode == "100755" {
+		perm = 0755
+	}
+	// O_EXCL doesn't follow a symlink created meanwhile
+	file, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return fmt.Errorf("failed to restore %s: %w", path, err)
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to restore %s: %w", path, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to restore %s: %w", path, err)
+	}
+	return nil
+}
+
+// hasSymlinkLeadingPath reports whether a directory on the path of a
+// workspace file is a symlink (or not a directory)
+func hasSymlinkLeadingPath(workspaceDir, path string) bool {
+	dir := workspaceDir
+	for _, part := range strings.Split(filepath.Dir(filepath.FromSlash(path)), string(filepath.Separator)) {
+		if part == "." {
+			return false
+		}
+		dir = filepath.Join(dir, part)
+		stat, err := os.Lstat(dir)
+		if err != nil {
+			return false
+		}
+		if !stat.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// gitQuotePath quotes a path for the git commands reading one path per line
+// when it holds a newline or starts with a quote
+func gitQuotePath(path string) string {
+	if !strings.Contains(path, "\n") && !strings.HasPrefix(path, `"`) {
+		return path
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + replacer.Replace(path) + `"`
+}
+
+// splitNul splits the NUL-terminated output of a git -z command
+func splitNul(output string) []string {
+	var parts []string
+	for _, part := range strings.Split(output, "\x00") {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return parts
+}
+
+// withTemporaryIndex runs fn with the environment making git use a temporary
+// index file, so the index of the workspace is left untouched
+func withTemporaryIndex(env []string, fn func(env []string) error) error {
+	dir, err := os.MkdirTemp("", "sbox-index-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	return fn(append(slices.Clone(env), "GIT_INDEX_FILE="+filepath.Join(dir, "index")))
+}
+
+// sandboxGitEnv lets git use the workspace inside the sandbox, where it may be
+// owned by another user. It is never used on the host, where the ownership
+// check is the guard against repositories set up by someone else.
+var sandboxGitEnv = []string{"GIT_CONFIG_COUNT=1", "GIT_CONFIG_KEY_0=safe.directory", "GIT_CONFIG_VALUE_0=*"}
+
+// gitSafeConfig turns off what git could run from the configuration of the
+// workspace repository, which the agent can edit: hooks (update-ref runs the
+// reference-transaction one), fsmonitor, commit signing and automatic
+// maintenance. Filters are avoided by reading and writing file contents
+// with plumbing commands that don't apply them.
+var gitSafeConfig = []string{
+	"-c", "core.hooksPath=/dev/null",
+	"-c", "core.fsmonitor=false",
+	"-c", "commit.gpgSign=false",
+	"-c", "gc.auto=0",
+	"-c", "maintenance.auto=false",
+}
+
+// gitCommand prepares a git command run in the workspace. Commits are made by
+// sbox whatever the git identity configured.
+func gitCommand(workspaceDir string, env []string, args ...string) *exec.Cmd {
+	cmd := exec.Command("git", slices.Concat([]string{"-C", workspaceDir}, gitSafeConfig, args)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=sbox", "GIT_AUTHOR_EMAIL=sbox@localhost",
+		"GIT_COMMITTER_NAME=sbox", "GIT_COMMITTER_EMAIL=sbox@localhost")
+	cmd.Env = append(cmd.Env, env...)
+	return cmd
+}
+
+// gitOutput runs a git command in the workspace, with stdin as input if not
+// nil, and returns its raw output
+func gitOutput(workspaceDir string, env []string, stdin io.Reader, args ...string) (string, error) {
+	cmd := gitCommand(workspaceDir, env, args...)
+	cmd.Stdin = stdin
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(output), nil
+}
+
+// runGit runs a git command in the workspace and returns its trimmed output,
+// for the commands printing an object name or a path
+func runGit(workspaceDir string, env []string, args ...string) (string, error) {
+	return runGitInput(workspaceDir, env, nil, args...)
+}
+
+// runGitInput is runGit with stdin as input
+func runGitInput(workspaceDir string, env []string, stdin io.Reader, args ...string) (string, error) {
+	output, err := gitOutput(workspaceDir, env, stdin, args...)
+	return strings.TrimSpace(output), err
+}
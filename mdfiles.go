@@ -1,8 +1,6 @@
 package sbox
 
 import (
-	"crypto/sha256"
-	"encoding/base64"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -149,18 +147,17 @@ func ConcatenateMDFiles(files []string, backend BackendType) (string, error) {
 	return result, nil
 }
 
-// ProjectHash computes a unique short hash for a project based on its absolute path.
-// Returns a URL-safe base64 encoded hash (12 chars) suitable for directory names.
+// ProjectHash returns the ID of the project of a workspace, keying its data
+// in the sbox data directory: the one recorded in .sbox/project-id, or the
+// hash of its absolute path (12 hex chars) for projects not registered yet.
 func ProjectHash(workspaceDir string) (string, error) {
-	absPath, err := filepath.Abs(workspaceDir)
+	config, err := LoadConfig()
 	if err != nil {
-		return "", fmt.Errorf("failed to get absolute path: %w", err)
+		return "", fmt.Errorf("failed to load config: %w", err)
 	}
 
-	hash := sha256.Sum256([]byte(absPath))
-	// Use URL-safe base64 encoding and take first 12 characters
-	encoded := base64.URLEncoding.EncodeToString(hash[:])
-	return encoded[:12], nil
+	_, id, err := projectIdentity(config.SboxDataDir, workspaceDir)
+	return id, err
 }
 
 // PrepareMDForSandbox discovers all CLAUDE.md and AGENTS.md files in the
@@ -0,0 +1,292 @@
+package sbox
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Network policy modes
+const (
+	// NetworkModeOpen gives the sandbox unrestricted network access (default)
+	NetworkModeOpen = "open"
+
+	// NetworkModeNone disables networking entirely
+	NetworkModeNone = "none"
+
+	// NetworkModeAllowlist only allows connections to the listed domains and CIDRs,
+	// through the sbox network proxy
+	NetworkModeAllowlist = "allowlist"
+)
+
+// ValidNetworkModes lists the accepted network policy modes
+var ValidNetworkModes = []string{NetworkModeOpen, NetworkModeNone, NetworkModeAllowlist}
+
+// Files of the network proxy
+const (
+	// NetworkPolicyFileName is the policy read (and reloaded on change) by the
+	// proxy, in the project directory of the sbox data directory (see
+	// NetworkPolicyDir)
+	NetworkPolicyFileName = "network-policy.yaml"
+
+	// NetworkLogFileName is where the proxy logs denied requests, in the
+	// .sbox directory
+	NetworkLogFileName = "network.log"
+)
+
+// NetworkPolicy controls the outbound network access of a sandbox
+type NetworkPolicy struct {
+	// Mode is "open" (default), "none" or "allowlist"
+	Mode string `yaml:"mode"`
+
+	// Allow lists the destinations reachable in allowlist mode: domains
+	// ("github.com", which also allows its subdomains, or "*.example.com"
+	// for subdomains only), IP addresses and CIDRs ("10.0.0.0/8")
+	Allow []string `yaml:"allow,omitempty"`
+}
+
+// EffectiveMode returns the policy mode, "open" when unset
+func (p *NetworkPolicy) EffectiveMode() string {
+	if p == nil || p.Mode == "" {
+		return NetworkModeOpen
+	}
+	return p.Mode
+}
+
+// Validate checks the mode and the allowlist entries
+func (p *NetworkPolicy) Validate() error {
+	if p == nil {
+		return nil
+	}
+
+	mode := p.EffectiveMode()
+	valid := false
+	for _, m := range ValidNetworkModes {
+		if mode == m {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return fmt.Errorf("invalid network mode %q (valid: %s)", p.Mode, strings.Join(ValidNetworkModes, ", "))
+	}
+
+	if mode != NetworkModeAllowlist && len(p.Allow) > 0 {
+		return fmt.Errorf("network allow list is only used with mode %q", NetworkModeAllowlist)
+	}
+
+	_, err := NewNetworkAllowlist(p.Allow)
+	return err
+}
+
+// Describe returns a one-line summary of the policy
+func (p *NetworkPolicy) Describe() string {
+	mode := p.EffectiveMode()
+	if mode != NetworkModeAllowlist {
+		return mode
+	}
+	return fmt.Sprintf("%s (%s)", mode, strings.Join(p.Allow, ", "))
+}
+
+// NetworkAllowlist matches destinations against the allow entries of a policy
+type NetworkAllowlist struct {
+	// domains allow the domain itself and its subdomains
+	domains []string
+
+	// wildcards ("*.example.com", stored as ".example.com") only allow subdomains
+	wildcards []string
+
+	prefixes []netip.Prefix
+}
+
+// NewNetworkAllowlist parses allow entries (domains, wildcard domains, IPs and CIDRs)
+func NewNetworkAllowlist(entries []string) (*NetworkAllowlist, error) {
+	allowlist := &NetworkAllowlist{}
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			allowlist.prefixes = append(allowlist.prefixes, prefix.Masked())
+			continue
+		}
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			allowlist.prefixes = append(allowlist.prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		if suffix, ok := strings.CutPrefix(entry, "*."); ok {
+			if !isValidDomain(suffix) {
+				return nil, fmt.Errorf("invalid network allow entry %q", entry)
+			}
+			allowlist.wildcards = append(allowlist.wildcards, "."+suffix)
+			continue
+		}
+		if !isValidDomain(entry) {
+			return nil, fmt.Errorf("invalid network allow entry %q (expected a domain, IP or CIDR)", entry)
+		}
+		allowlist.domains = append(allowlist.domains, strings.TrimSuffix(entry, "."))
+	}
+	return allowlist, nil
+}
+
+// AllowsDomain reports whether a host name matches a domain entry
+func (a *NetworkAllowlist) AllowsDomain(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, domain := range a.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	for _, suffix := range a.wildcards {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsIP reports whether an address is in an allowed CIDR
+func (a *NetworkAllowlist) AllowsIP(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range a.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPrefixes reports whether the allowlist contains IP or CIDR entries
+func (a *NetworkAllowlist) HasPrefixes() bool {
+	return len(a.prefixes) > 0
+}
+
+func isValidDomain(domain string) bool {
+	domain = strings.TrimSuffix(domain, ".")
+	if domain == "" || len(domain) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		for _, r := range label {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' && r != '_' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// NetworkPolicyDir returns the directory of the network policy of a project,
+// in the sbox data directory. It is only mounted (read-only) in the proxy
+// sidecar: the workspace is writable by the agent, which must not be able to
+// change its own policy.
+func NetworkPolicyDir(dataDir, workspaceDir string) (string, error) {
+	_, id, err := projectIdentity(dataDir, workspaceDir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dataDir, "projects", id, "network"), nil
+}
+
+// WriteNetworkPolicy writes the policy read by the network proxy to dir
+func WriteNetworkPolicy(dir string, policy *NetworkPolicy) error {
+	data, err := yaml.Marshal(policy)
+	if err != nil {
+		return fmt.Errorf("failed to serialize network policy: %w", err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create network policy directory: %w", err)
+	}
+	// Written in place, the proxy sees the change through its directory mount
+	path := filepath.Join(dir, NetworkPolicyFileName)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write network policy: %w", err)
+	}
+	return nil
+}
+
+// LoadNetworkPolicyFile reads a policy written by WriteNetworkPolicy
+func LoadNetworkPolicyFile(path string) (*NetworkPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read network policy: %w", err)
+	}
+
+	policy := &NetworkPolicy{}
+	if err := yaml.Unmarshal(data, policy); err != nil {
+		return nil, fmt.Errorf("failed to parse network policy: %w", err)
+	}
+	return policy, nil
+}
+
+// ReadNetworkLog returns the number of denied requests logged for a workspace
+// and the last (at most) n entries
+func ReadNetworkLog(workspaceDir string, n int) (int, []string, error) {
+	file, err := os.Open(filepath.Join(workspaceDir, ".sbox", NetworkLogFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil, nil
+		}
+		return 0, nil, fmt.Errorf("failed to open network log: %w", err)
+	}
+	defer file.Close()
+
+	count := 0
+	var last []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		count++
+		last = append(last, line)
+		if len(last) > n {
+			last = last[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, nil, fmt.Errorf("failed to read network log: %w", err)
+	}
+	return count, last, nil
+}
+
+// NetworkPolicyContextMD returns instructions describing the network policy
+// to the agent, or "" when the network is open
+func NetworkPolicyContextMD(policy *NetworkPolicy) string {
+	switch policy.EffectiveMode() {
+	case NetworkModeNone:
+		return "## Network Policy\n\n" +
+			"This sandbox has **no network access**. Do not try to download packages or reach external services; " +
+			"ask the user to change the `network:` policy in `sbox.yaml` if the task requires it.\n"
+	case NetworkModeAllowlist:
+		var sb strings.Builder
+		sb.WriteString("## Network Policy\n\n")
+		sb.WriteString("Outbound network access is restricted to an allowlist, enforced by the sbox network proxy ")
+		sb.WriteString("(`HTTP_PROXY`/`HTTPS_PROXY` are set, tools that ignore them cannot reach the network). Allowed destinations:\n\n")
+		for _, entry := range policy.Allow {
+			sb.WriteString("- `" + entry + "`\n")
+		}
+		sb.WriteString("\nRequests to anything else are denied and logged to `.sbox/network.log`. ")
+		sb.WriteString("If the task requires another destination, ask the user to add it to the `network.allow` list of `sbox.yaml`.\n")
+		return sb.String()
+	}
+	return ""
+}
@@ -0,0 +1,429 @@
+package sbox
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Network proxy defaults, as seen from the sandbox container
+const (
+	// NetworkProxyAlias is the proxy's host name on the sandbox network
+	NetworkProxyAlias = "sbox-proxy"
+
+	// NetworkProxyPort is the HTTP(S) proxy port
+	NetworkProxyPort = 3128
+)
+
+var errNetworkDenied = errors.New("destination not allowed by the sbox network policy")
+
+// NetworkProxyOptions configures RunNetworkProxy
+type NetworkProxyOptions struct {
+	// HTTPAddr is the HTTP(S) proxy listen address, e.g. ":3128"
+	HTTPAddr string
+
+	// DNSAddr is the DNS listen address, e.g. ":53". Empty disables the DNS proxy.
+	DNSAddr string
+
+	// DNSUpstream is the resolver allowed queries are forwarded to (host:port).
+	// Defaults to the first nameserver of /etc/resolv.conf.
+	DNSUpstream string
+
+	// PolicyPath is the network policy file, reloaded when it changes
+	PolicyPath string
+
+	// LogPath is the file denied requests are appended to
+	LogPath string
+}
+
+// networkProxy is an HTTP(S) forward proxy and DNS forwarder only letting
+// through destinations matching the network policy allowlist
+type networkProxy struct {
+	opts NetworkProxyOptions
+
+	mu            sync.Mutex
+	allowlist     *NetworkAllowlist
+	policyModTime time.Time
+
+	logMu     sync.Mutex
+	transport *http.Transport
+}
+
+// RunNetworkProxy runs the sbox network proxy until one of its listeners fails.
+// It is the process of the network sidecar container of allowlist policies.
+func RunNetworkProxy(opts NetworkProxyOptions) error {
+	p, err := newNetworkProxy(opts)
+	if err != nil {
+		return err
+	}
+
+	errCh := make(chan error, 2)
+	go func() {
+		zlog.Info("network proxy listening", zap.String("addr", opts.HTTPAddr))
+		errCh <- http.ListenAndServe(opts.HTTPAddr, p)
+	}()
+	if opts.DNSAddr != "" {
+		go func() {
+			errCh <- p.serveDNS()
+		}()
+	}
+	return <-errCh
+}
+
+// newNetworkProxy creates a proxy, failing if the policy can't be loaded
+func newNetworkProxy(opts NetworkProxyOptions) (*networkProxy, error) {
+	p := &networkProxy{opts: opts}
+	if _, err := p.currentAllowlist(); err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	p.transport = &http.Transport{
+		// Every new connection is checked against the current policy
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			dialAddr, err := p.allowedDialAddr(addr)
+			if err != nil {
+				return nil, err
+			}
+			return dialer.DialContext(ctx, network, dialAddr)
+		},
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	return p, nil
+}
+
+// currentAllowlist returns the allowlist, reloading the policy file if it changed.
+// A policy that fails to load keeps the previous allowlist in place.
+func (p *networkProxy) currentAllowlist() (*NetworkAllowlist, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	info, err := os.Stat(p.opts.PolicyPath)
+	if err != nil {
+		if p.allowlist != nil {
+			return p.allowlist, nil
+		}
+		return nil, fmt.Errorf("failed to read network policy: %w", err)
+	}
+	if p.allowlist != nil && info.ModTime().Equal(p.policyModTime) {
+		return p.allowlist, nil
+	}
+
+	policy, err := LoadNetworkPolicyFile(p.opts.PolicyPath)
+	if err == nil {
+		err = policy.Validate()
+	}
+	var allowlist *NetworkAllowlist
+	if err == nil {
+		allowlist, err = NewNetworkAllowlist(policy.Allow)
+	}
+	if err != nil {
+		if p.allowlist != nil {
+			zlog.Warn("failed to reload network policy, keeping the previous one", zap.Error(err))
+			return p.allowlist, nil
+		}
+		return nil, err
+	}
+
+	zlog.Info("loaded network policy", zap.Strings("allow", policy.Allow))
+	p.allowlist = allowlist
+	p.policyModTime = info.ModTime()
+	return allowlist, nil
+}
+
+// allowedDialAddr returns the address to dial for a host:port destination.
+// Host names only allowed through a CIDR entry are resolved here and the
+// matching address is dialed, so the answer can't change between check and use.
+func (p *networkProxy) allowedDialAddr(hostport string) (string, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return "", fmt.Errorf("invalid destination %q: %w", hostport, err)
+	}
+
+	allowlist, err := p.currentAllowlist()
+	if err != nil {
+		return "", err
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if allowlist.AllowsIP(ip) {
+			return hostport, nil
+		}
+		return "", errNetworkDenied
+	}
+
+	if allowlist.AllowsDomain(host) {
+		return hostport, nil
+	}
+	if allowlist.HasPrefixes() {
+		ips, err := net.LookupIP(host)
+		if err == nil {
+			for _, ip := range ips {
+				if allowlist.AllowsIP(ip) {
+					return net.JoinHostPort(ip.String(), port), nil
+				}
+			}
+		}
+	}
+	return "", errNetworkDenied
+}
+
+// deny logs a denied request to the network log
+func (p *networkProxy) deny(kind, target string) {
+	zlog.Info("network request denied", zap.String("kind", kind), zap.String("target", target))
+	if p.opts.LogPath == "" {
+		return
+	}
+
+	p.logMu.Lock()
+	defer p.logMu.Unlock()
+
+	file, err := os.OpenFile(p.opts.LogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		zlog.Warn("failed to open network log", zap.Error(err))
+		return
+	}
+	defer file.Close()
+	fmt.Fprintf(file, "%s DENY %s %s\n", time.Now().UTC().Format(time.RFC3339), kind, target)
+}
+
+// ServeHTTP handles CONNECT tunnels (HTTPS) and absolute-URL requests (HTTP)
+func (p *networkProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.handleConnect(w, r)
+		return
+	}
+
+	if r.URL.Host == "" {
+		http.Error(w, "sbox network proxy: only proxy requests are served", http.StatusBadRequest)
+		return
+	}
+
+	hostport := r.URL.Host
+	if _, _, err := net.SplitHostPort(hostport); err != nil {
+		port := "80"
+		if r.URL.Scheme == "https" {
+			port = "443"
+		}
+		hostport = net.JoinHostPort(r.URL.Hostname(), port)
+	}
+	if _, err := p.allowedDialAddr(hostport); err != nil {
+		p.deny("HTTP", r.Method+" "+r.URL.Scheme+"://"+r.URL.Host+r.URL.Path)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	outReq := r.Clone(r.Context())
+	outReq.RequestURI = ""
+	removeHopHeaders(outReq.Header)
+
+	resp, err := p.transport.RoundTrip(outReq)
+	if err != nil {
+		http.Error(w, "sbox network proxy: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	removeHopHeaders(resp.Header)
+	for name, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// handleConnect opens a TCP tunnel to an allowed destination
+func (p *networkProxy) handleConnect(w http.ResponseWriter, r *http.Request) {
+	dialAddr, err := p.allowedDialAddr(r.Host)
+	if err != nil {
+		p.deny("CONNECT", r.Host)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", dialAddr, 30*time.Second)
+	if err != nil {
+		http.Error(w, "sbox network proxy: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "sbox network proxy: tunneling not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+
+	if _, err := io.WriteString(client, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		client.Close()
+		upstream.Close()
+		return
+	}
+	tunnel(client, buffered.Reader, upstream)
+}
+
+// tunnel copies data both ways until either side closes
+func tunnel(client net.Conn, clientReader *bufio.Reader, upstream net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		_, _ = io.Copy(upstream, clientReader)
+		if tcp, ok := upstream.(*net.TCPConn); ok {
+			_ = tcp.CloseWrite()
+		}
+		done <- struct{}{}
+	}()
+	go func() {
+		_, _ = io.Copy(client, upstream)
+		if tcp, ok := client.(*net.TCPConn); ok {
+			_ = tcp.CloseWrite()
+		}
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+	client.Close()
+	upstream.Close()
+}
+
+// hopHeaders are the headers that apply to a single connection and must not be forwarded
+var hopHeaders = []string{
+	"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate",
+	"Proxy-Authorization", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+func removeHopHeaders(header http.Header) {
+	for _, name := range strings.Split(header.Get("Connection"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			header.Del(name)
+		}
+	}
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+}
+
+// serveDNS answers queries for allowed domains by forwarding them upstream
+// and refuses every other name with NXDOMAIN
+func (p *networkProxy) serveDNS() error {
+	upstream := p.opts.DNSUpstream
+	if upstream == "" {
+		var err error
+		if upstream, err = resolvConfNameserver("/etc/resolv.conf"); err != nil {
+			return err
+		}
+	}
+
+	conn, err := net.ListenPacket("udp", p.opts.DNSAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for DNS: %w", err)
+	}
+	defer conn.Close()
+	zlog.Info("network DNS proxy listening", zap.String("addr", p.opts.DNSAddr), zap.String("upstream", upstream))
+
+	buf := make([]byte, 4096)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return fmt.Errorf("failed to read DNS query: %w", err)
+		}
+		query := append([]byte(nil), buf[:n]...)
+		go p.handleDNS(conn, addr, query, upstream)
+	}
+}
+
+func (p *networkProxy) handleDNS(conn net.PacketConn, addr net.Addr, query []byte, upstream string) {
+	reply, err := p.dnsReply(query, upstream)
+	if err != nil {
+		zlog.Debug("failed to answer DNS query", zap.Error(err))
+		return
+	}
+	_, _ = conn.WriteTo(reply, addr)
+}
+
+// dnsReply forwards an allowed query upstream or builds an NXDOMAIN answer
+func (p *networkProxy) dnsReply(query []byte, upstream string) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS query: %w", err)
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS question: %w", err)
+	}
+
+	allowlist, err := p.currentAllowlist()
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSuffix(question.Name.String(), ".")
+	if !allowlist.AllowsDomain(name) {
+		p.deny("DNS", name)
+		reply := dnsmessage.Message{
+			Header: dnsmessage.Header{
+				ID:                 header.ID,
+				Response:           true,
+				OpCode:             header.OpCode,
+				RecursionDesired:   header.RecursionDesired,
+				RecursionAvailable: true,
+				RCode:              dnsmessage.RCodeNameError,
+			},
+			Questions: []dnsmessage.Question{question},
+		}
+		return reply.Pack()
+	}
+
+	upstreamConn, err := net.DialTimeout("udp", upstream, 5*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach DNS upstream: %w", err)
+	}
+	defer upstreamConn.Close()
+	_ = upstreamConn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	if _, err := upstreamConn.Write(query); err != nil {
+		return nil, fmt.Errorf("failed to forward DNS query: %w", err)
+	}
+	reply := make([]byte, 4096)
+	n, err := upstreamConn.Read(reply)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read DNS upstream reply: %w", err)
+	}
+	return reply[:n], nil
+}
+
+// resolvConfNameserver returns the first nameserver of a resolv.conf file as host:port
+func resolvConfNameserver(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			return net.JoinHostPort(fields[1], "53"), nil
+		}
+	}
+	return "", fmt.Errorf("no nameserver found in %s", path)
+}
@@ -129,6 +129,10 @@ type StreamPrinter struct {
 	totalTokens int
 	totalCost   float64
 	steps       int
+
+	// Finished tool calls and edited files
+	tools       map[string]int
+	filesEdited []string
 }
 
 // newStreamStyle returns a glamour style customized for sbox stream output.
@@ -256,6 +260,10 @@ func (p *StreamPrinter) handleToolUse(event *streamEvent) bool {
 		fmt.Fprintf(p.w, "%s%s\n", dotStyle.Render(dot), toolStyle.Render(toolName))
 	}
 
+	if part.State.Status == "completed" || part.State.Status == "error" {
+		p.recordTool(part)
+	}
+
 	// Print tool result if completed
 	if part.State.Status == "completed" {
 		p.printToolResult(part)
@@ -328,6 +336,42 @@ func (p *StreamPrinter) handleStepFinish(event *streamEvent) bool {
 	return false
 }
 
+// Activity returns the number of calls of each tool and the files edited, in
+// order, of the tool_use events processed so far.
+func (p *StreamPrinter) Activity() (map[string]int, []string) {
+	return p.tools, p.filesEdited
+}
+
+func (p *StreamPrinter) recordTool(part *eventPart) {
+	if p.tools == nil {
+		p.tools = make(map[string]int)
+	}
+	p.tools[displayName(part.Tool)]++
+
+	if part.State.Status != "completed" || (part.Tool != "edit" && part.Tool != "write") {
+		return
+	}
+	path := part.State.Input.FilePath
+	if path == "" {
+		path = part.State.Input.Path
+	}
+	if path == "" {
+		return
+	}
+	for _, edited := range p.filesEdited {
+		if edited == path {
+			return
+		}
+	}
+	p.filesEdited = append(p.filesEdited, path)
+}
+
+// Usage returns the cost in USD, the tokens and the steps of the step_finish
+// events processed so far.
+func (p *StreamPrinter) Usage() (float64, int, int) {
+	return p.totalCost, p.totalTokens, p.steps
+}
+
 // printMarkdown renders text as markdown using glamour, with a ● prefix on the first line.
 func (p *StreamPrinter) printMarkdown(text string) {
 	if p.md == nil {
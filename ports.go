@@ -0,0 +1,249 @@
+package sbox
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// DefaultPortHostIP is the host address ports are published on when the
+// mapping doesn't specify one, so dev servers aren't exposed to the network
+const DefaultPortHostIP = "127.0.0.1"
+
+// PortMapping publishes a container port on the host
+type PortMapping struct {
+	// HostIP is the host address to listen on (DefaultPortHostIP when unset)
+	HostIP string
+
+	// HostPort is the host port
+	HostPort int
+
+	// ContainerPort is the port inside the container
+	ContainerPort int
+
+	// Protocol is "tcp" or "udp"
+	Protocol string
+}
+
+// ParsePortMapping parses a port mapping in the `docker run -p` format:
+// [host_ip:][host_port:]container_port[/protocol]. A single port publishes
+// the container port on the same host port, IPv6 host addresses are
+// written in brackets ("[::1]:8080:80").
+func ParsePortMapping(spec string) (PortMapping, error) {
+	mapping := PortMapping{HostIP: DefaultPortHostIP, Protocol: "tcp"}
+
+	rest := strings.TrimSpace(spec)
+	if base, protocol, ok := strings.Cut(rest, "/"); ok {
+		protocol = strings.ToLower(protocol)
+		if protocol != "tcp" && protocol != "udp" {
+			return PortMapping{}, fmt.Errorf("invalid port mapping %q: protocol must be tcp or udp", spec)
+		}
+		rest, mapping.Protocol = base, protocol
+	}
+
+	if strings.HasPrefix(rest, "[") {
+		end := strings.Index(rest, "]:")
+		if end < 0 {
+			return PortMapping{}, fmt.Errorf("invalid port mapping %q: expected [host_ip]:host_port:container_port", spec)
+		}
+		mapping.HostIP, rest = rest[1:end], rest[end+2:]
+		if !strings.Contains(rest, ":") {
+			return PortMapping{}, fmt.Errorf("invalid port mapping %q: expected [host_ip]:host_port:container_port", spec)
+		}
+	}
+
+	parts := strings.Split(rest, ":")
+	switch len(parts) {
+	case 1:
+		parts = []string{parts[0], parts[0]}
+	case 2:
+	case 3:
+		mapping.HostIP, parts = parts[0], parts[1:]
+	default:
+		return PortMapping{}, fmt.Errorf("invalid port mapping %q: expected [host_ip:][host_port:]container_port[/protocol]", spec)
+	}
+
+	if net.ParseIP(mapping.HostIP) == nil {
+		return PortMapping{}, fmt.Errorf("invalid port mapping %q: invalid host address %q", spec, mapping.HostIP)
+	}
+
+	var err error
+	if mapping.HostPort, err = parsePort(parts[0]); err != nil {
+		return PortMapping{}, fmt.Errorf("invalid port mapping %q: %w", spec, err)
+	}
+	if mapping.ContainerPort, err = parsePort(parts[1]); err != nil {
+		return PortMapping{}, fmt.Errorf("invalid port mapping %q: %w", spec, err)
+	}
+	return mapping, nil
+}
+
+// ParsePortMappings parses port mappings, rejecting host ports used twice
+func ParsePortMappings(specs []string) ([]PortMapping, error) {
+	var mappings []PortMapping
+	seen := make(map[string]string)
+	for _, spec := range specs {
+		mapping, err := ParsePortMapping(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		key := fmt.Sprintf("%s/%d/%s", mapping.HostIP, mapping.HostPort, mapping.Protocol)
+		if previous, ok := seen[key]; ok {
+			return nil, fmt.Errorf("port mappings %q and %q use the same host port", previous, spec)
+		}
+		seen[key] = spec
+		mappings = append(mappings, mapping)
+	}
+	return mappings, nil
+}
+
+// String returns the mapping in the `docker run -p` format
+func (p PortMapping) String() string {
+	hostIP := p.HostIP
+	if strings.Contains(hostIP, ":") {
+		hostIP = "[" + hostIP + "]"
+	}
+	return fmt.Sprintf("%s:%d:%d/%s", hostIP, p.HostPort, p.ContainerPort, p.Protocol)
+}
+
+// HostAddr returns the host listen address of the mapping
+func (p PortMapping) HostAddr() string {
+	return net.JoinHostPort(p.HostIP, strconv.Itoa(p.HostPort))
+}
+
+func parsePort(value string) (int, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port %q", value)
+	}
+	return port, nil
+}
+
+// ResolvePorts returns the ports to publish for a project: the ones of the
+// project config (including sbox.yaml) followed by the command line ones
+func ResolvePorts(projectConfig *ProjectConfig, cliPorts []string) ([]PortMapping, error) {
+	var specs []string
+	if projectConfig != nil {
+		specs = append(specs, projectConfig.Ports...)
+	}
+	return ParsePortMappings(append(specs, cliPorts...))
+}
+
+// dockerPortBindings returns the exposed ports and host port bindings
+// publishing mappings (the equivalent of `docker run -p`)
+func dockerPortBindings(mappings []PortMapping) (map[string]struct{}, map[string][]DockerPortBinding) {
+	if len(mappings) == 0 {
+		return nil, nil
+	}
+
+	exposed := make(map[string]struct{})
+	bindings := make(map[string][]DockerPortBinding)
+	for _, mapping := range mappings {
+		port := fmt.Sprintf("%d/%s", mapping.ContainerPort, mapping.Protocol)
+		exposed[port] = struct{}{}
+		bindings[port] = append(bindings[port], DockerPortBinding{
+			HostIP:   mapping.HostIP,
+			HostPort: strconv.Itoa(mapping.HostPort),
+		})
+	}
+	return exposed, bindings
+}
+
+// portRelayCommand is the command relaying a forwarded connection inside the sandbox
+func portRelayCommand(port int) []string {
+	return []string{"sbox", "port-relay", strconv.Itoa(port)}
+}
+
+// portRelay connects a host connection to a port inside the sandbox
+type portRelay func(conn net.Conn, port int) error
+
+// servePortForwards listens on the host side of each mapping and relays every
+// accepted connection with relay, until a listener fails
+func servePortForwards(mappings []PortMapping, relay portRelay) error {
+	if len(mappings) == 0 {
+		return fmt.Errorf("no port to forward")
+	}
+
+	var listeners []net.Listener
+	for _, mapping := range mappings {
+		if mapping.Protocol != "tcp" {
+			closeListeners(listeners)
+			return fmt.Errorf("cannot forward %s: only tcp ports can be forwarded", mapping)
+		}
+
+		listener, err := net.Listen("tcp", mapping.HostAddr())
+		if err != nil {
+			closeListeners(listeners)
+			return fmt.Errorf("failed to listen on %s: %w", mapping.HostAddr(), err)
+		}
+		listeners = append(listeners, listener)
+		DefaultUI.Status("Forwarding %s -> %d", listener.Addr(), mapping.ContainerPort)
+	}
+	defer closeListeners(listeners)
+
+	errCh := make(chan error, len(listeners))
+	for i, listener := range listeners {
+		go func(listener net.Listener, port int) {
+			errCh <- servePortForward(listener, port, relay)
+		}(listener, mappings[i].ContainerPort)
+	}
+	return <-errCh
+}
+
+// servePortForward relays the connections accepted by listener until it is closed
+func servePortForward(listener net.Listener, port int, relay portRelay) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+
+		go func() {
+			defer conn.Close()
+			zlog.Debug("forwarding connection", zap.Stringer("from", conn.RemoteAddr()), zap.Int("port", port))
+			if err := relay(conn, port); err != nil {
+				zlog.Warn("port forward failed", zap.Int("port", port), zap.Error(err))
+			}
+		}()
+	}
+}
+
+func closeListeners(listeners []net.Listener) {
+	for _, listener := range listeners {
+		listener.Close()
+	}
+}
+
+// RunPortRelay connects to a local port and pipes it to in and out. It runs
+// inside the sandbox (`sbox port-relay`), started through docker exec by
+// `sbox port-forward` for each forwarded connection.
+func RunPortRelay(port int, in io.Reader, out io.Writer) error {
+	conn, err := net.Dial("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+	if err != nil {
+		return fmt.Errorf("failed to connect to port %d: %w", port, err)
+	}
+	defer conn.Close()
+
+	// The relay ends with the connection; the host side closing its end only
+	// closes the write side, so the response can still be read
+	go func() {
+		_, _ = io.Copy(conn, in)
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			_ = tcpConn.CloseWrite()
+		}
+	}()
+
+	_, err = io.Copy(out, conn)
+	if err != nil && !isClosedConnError(err) {
+		return fmt.Errorf("failed to relay port %d: %w", port, err)
+	}
+	return nil
+}
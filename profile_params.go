@@ -0,0 +1,279 @@
+package sbox
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Profile parameter types
+const (
+	// ProfileParamString accepts any value made of safe characters
+	ProfileParamString = "string"
+
+	// ProfileParamVersion accepts "latest" or a version number like "1.23.2" or "v1.2.0-rc1"
+	ProfileParamVersion = "version"
+
+	// ProfileParamBool accepts "true" or "false"
+	ProfileParamBool = "bool"
+)
+
+// ProfileVersionParam is the parameter set by the `name@version` shorthand
+const ProfileVersionParam = "version"
+
+// ProfileParam declares a parameter accepted by a profile. Resolved values are
+// exposed to the profile's Dockerfile snippet as build ARGs named
+// <PROFILE>_<PARAM> (e.g. GO_VERSION).
+type ProfileParam struct {
+	// Name is the parameter name used in profile references (e.g. "version")
+	Name string `yaml:"name"`
+
+	// Description provides a human-readable explanation of the parameter
+	Description string `yaml:"description"`
+
+	// Type is one of "string" (default), "version" or "bool"
+	Type string `yaml:"type"`
+
+	// Default is the value used when the parameter is not set.
+	// A parameter without default must be provided by every reference.
+	Default string `yaml:"default"`
+}
+
+var (
+	profileNameRegex    = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+	profileParamRegex   = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+	profileValueRegex   = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
+	profileVersionRegex = regexp.MustCompile(`^v?[0-9]+(\.[0-9]+)*([-+][0-9A-Za-z.-]+)?$`)
+)
+
+// ProfileRef is a reference to a profile with optional parameter values, as
+// written in sbox.yaml, project config or the --profile flag.
+//
+// Format: name[@version][:key=value...]
+//
+// Examples: "go", "go@1.23.2", "rust:toolchain=nightly", "javascript:pnpm_version=9.1.0:yarn_version=1.22.22"
+//
+// Parameters are separated by ':' rather than ',' so references can be passed
+// through comma-separated flags like --profile.
+type ProfileRef struct {
+	// Name is the referenced profile name
+	Name string
+
+	// Params are the explicitly set parameter values
+	Params map[string]string
+}
+
+// ParseProfileRef parses a profile reference string
+func ParseProfileRef(ref string) (ProfileRef, error) {
+	parts := strings.Split(ref, ":")
+	name, version, hasVersion := strings.Cut(parts[0], "@")
+
+	if !profileNameRegex.MatchString(name) {
+		return ProfileRef{}, fmt.Errorf("invalid profile reference %q: invalid profile name", ref)
+	}
+
+	result := ProfileRef{Name: name, Params: map[string]string{}}
+
+	if hasVersion {
+		if version == "" {
+			return ProfileRef{}, fmt.Errorf("invalid profile reference %q: empty version", ref)
+		}
+		result.Params[ProfileVersionParam] = version
+	}
+
+	for _, pair := range parts[1:] {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return ProfileRef{}, fmt.Errorf("invalid profile reference %q: expected key=value, got %q", ref, pair)
+		}
+		if _, exists := result.Params[key]; exists {
+			return ProfileRef{}, fmt.Errorf("invalid profile reference %q: parameter %q set more than once", ref, key)
+		}
+		result.Params[key] = value
+	}
+
+	return result, nil
+}
+
+// ProfileRefName returns the profile name of a reference without validating it
+func ProfileRefName(ref string) string {
+	name, _, _ := strings.Cut(ref, ":")
+	name, _, _ = strings.Cut(name, "@")
+	return name
+}
+
+// String returns the canonical form of the reference
+func (r ProfileRef) String() string {
+	if len(r.Params) == 0 {
+		return r.Name
+	}
+	return r.Name + ":" + strings.ReplaceAll(formatProfileParams(r.Params), ",", ":")
+}
+
+// Param returns the declaration of the named parameter
+func (p Profile) Param(name string) (ProfileParam, bool) {
+	for _, param := range p.Params {
+		if param.Name == name {
+			return param, true
+		}
+	}
+	return ProfileParam{}, false
+}
+
+// ResolveParams merges the given values with the parameter defaults, validating
+// names and values. The result holds a value for every declared parameter.
+func (p Profile) ResolveParams(values map[string]string) (map[string]string, error) {
+	for name := range values {
+		if _, ok := p.Param(name); !ok {
+			if len(p.Params) == 0 {
+				return nil, fmt.Errorf("profile %q does not accept parameters (got %q)", p.Name, name)
+			}
+			return nil, fmt.Errorf("profile %q has no parameter %q (available: %s)", p.Name, name, strings.Join(p.paramNames(), ", "))
+		}
+	}
+
+	resolved := make(map[string]string, len(p.Params))
+	for _, param := range p.Params {
+		value, ok := values[param.Name]
+		if !ok {
+			value = param.Default
+		}
+		if value == "" {
+			return nil, fmt.Errorf("profile %q requires parameter %q", p.Name, param.Name)
+		}
+		if err := param.Validate(value); err != nil {
+			return nil, fmt.Errorf("profile %q: %w", p.Name, err)
+		}
+		resolved[param.Name] = value
+	}
+
+	return resolved, nil
+}
+
+// Validate checks that value is acceptable for this parameter's type
+func (p ProfileParam) Validate(value string) error {
+	if !profileValueRegex.MatchString(value) {
+		return fmt.Errorf("invalid value %q for parameter %q: only letters, digits and . _ + - are allowed", value, p.Name)
+	}
+
+	switch p.Type {
+	case "", ProfileParamString:
+	case ProfileParamVersion:
+		if value != "latest" && !profileVersionRegex.MatchString(value) {
+			return fmt.Errorf("invalid value %q for parameter %q: expected a version like 1.2.3 or latest", value, p.Name)
+		}
+	case ProfileParamBool:
+		if value != "true" && value != "false" {
+			return fmt.Errorf("invalid value %q for parameter %q: expected true or false", value, p.Name)
+		}
+	default:
+		return fmt.Errorf("parameter %q has unknown type %q", p.Name, p.Type)
+	}
+
+	return nil
+}
+
+// ArgName returns the build ARG name for one of the profile's parameters
+func (p Profile) ArgName(param string) string {
+	name := strings.ToUpper(p.Name + "_" + param)
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// DockerfileArgs returns the ARG declarations for the profile's parameters.
+// Missing values fall back to the parameter defaults.
+func (p Profile) DockerfileArgs(values map[string]string) string {
+	var sb strings.Builder
+	for _, param := range p.Params {
+		sb.WriteString(fmt.Sprintf("ARG %s=%s\n", p.ArgName(param.Name), param.value(values)))
+	}
+	return sb.String()
+}
+
+// RenderDockerfileSnippet returns the snippet with the parameter ARGs used in
+// FROM and COPY --from image references replaced by their values: builders
+// don't expand build variables there, and the lock file only pins concrete
+// references.
+func (p Profile) RenderDockerfileSnippet(values map[string]string) string {
+	if len(p.Params) == 0 {
+		return p.DockerfileSnippet
+	}
+
+	var pairs []string
+	for _, param := range p.Params {
+		pairs = append(pairs, "${"+p.ArgName(param.Name)+"}", param.value(values))
+	}
+	replacer := strings.NewReplacer(pairs...)
+
+	lines := strings.Split(p.DockerfileSnippet, "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && (strings.EqualFold(fields[0], "FROM") || strings.EqualFold(fields[0], "COPY")) {
+			lines[i] = replacer.Replace(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// value returns the parameter value from values, or its default
+func (p ProfileParam) value(values map[string]string) string {
+	if value, ok := values[p.Name]; ok {
+		return value
+	}
+	return p.Default
+}
+
+// validateParams checks the parameter declarations of a profile
+func (p Profile) validateParams() error {
+	seen := make(map[string]bool)
+	for _, param := range p.Params {
+		if !profileParamRegex.MatchString(param.Name) {
+			return fmt.Errorf("profile %q: invalid parameter name %q", p.Name, param.Name)
+		}
+		if seen[param.Name] {
+			return fmt.Errorf("profile %q: parameter %q declared more than once", p.Name, param.Name)
+		}
+		seen[param.Name] = true
+
+		switch param.Type {
+		case "", ProfileParamString, ProfileParamVersion, ProfileParamBool:
+		default:
+			return fmt.Errorf("profile %q: parameter %q has unknown type %q", p.Name, param.Name, param.Type)
+		}
+
+		if param.Default != "" {
+			if err := param.Validate(param.Default); err != nil {
+				return fmt.Errorf("profile %q: default: %w", p.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (p Profile) paramNames() []string {
+	names := make([]string, 0, len(p.Params))
+	for _, param := range p.Params {
+		names = append(names, param.Name)
+	}
+	return names
+}
+
+// formatProfileParams formats parameter values as a sorted key=value list
+func formatProfileParams(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+params[key])
+	}
+	return strings.Join(pairs, ",")
+}
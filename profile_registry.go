@@ -0,0 +1,273 @@
+package sbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// Profile sources, from lowest to highest precedence
+const (
+	ProfileSourceBuiltin = "builtin"
+	ProfileSourceGlobal  = "global"
+	ProfileSourceProject = "project"
+)
+
+// ProfilesDirName is the name of the directory holding user-defined profiles,
+// both in the sbox data directory (~/.config/sbox/profiles/) and in the
+// workspace (.sbox/profiles/).
+const ProfilesDirName = "profiles"
+
+// ProfileFile is the on-disk YAML format of a user-defined profile.
+//
+// Example (~/.config/sbox/profiles/protoc.yaml):
+//
+//	name: protoc
+//	description: Protocol buffers compiler
+//	dependencies: [bash-utils]
+//	params:
+//	  - name: version
+//	    type: version
+//	    default: "28.3"
+//	dockerfile_snippet_file: ./protoc.Dockerfile
+//
+// The snippet reads parameter values from build ARGs, here ${PROTOC_VERSION}.
+type ProfileFile struct {
+	// Name is the profile name, defaults to the file name without extension
+	Name string `yaml:"name"`
+
+	// Description provides a human-readable explanation of what this profile provides
+	Description string `yaml:"description"`
+
+	// Dependencies lists other profiles that must be installed before this one
+	Dependencies []string `yaml:"dependencies"`
+
+	// Params declares the parameters accepted by the profile
+	Params []ProfileParam `yaml:"params"`
+
+	// DockerfileSnippet contains the Dockerfile commands inline
+	DockerfileSnippet string `yaml:"dockerfile_snippet"`
+
+	// DockerfileSnippetFile is a path to a file containing the Dockerfile commands.
+	// Relative paths are resolved against the directory of the profile file.
+	DockerfileSnippetFile string `yaml:"dockerfile_snippet_file"`
+
+	// Override must be set to replace a profile of the same name defined by a
+	// lower precedence source (builtin < global < project).
+	Override bool `yaml:"override"`
+}
+
+// ProfileRegistry holds the set of profiles available to a workspace: the
+// built-in profiles merged with user-defined ones.
+type ProfileRegistry struct {
+	profiles map[string]Profile
+}
+
+// NewProfileRegistry creates a registry containing only the built-in profiles
+func NewProfileRegistry() *ProfileRegistry {
+	profiles := make(map[string]Profile, len(BuiltinProfiles))
+	for name, profile := range BuiltinProfiles {
+		profile.Source = ProfileSourceBuiltin
+		profiles[name] = profile
+	}
+	return &ProfileRegistry{profiles: profiles}
+}
+
+// LoadProfileRegistry builds the profile registry for a workspace.
+// Profiles are loaded in order of increasing precedence:
+//  1. Built-in profiles
+//  2. Global profiles from <sbox_data_dir>/profiles/
+//  3. Project profiles from <workspace>/.sbox/profiles/
+//  4. Project profiles from the sbox.yaml `profiles_dir` setting
+//
+// A profile replacing one of the same name from an earlier source must set
+// `override: true`, otherwise loading fails.
+func LoadProfileRegistry(config *Config, workspaceDir string, sboxFile *SboxFileLocation) (*ProfileRegistry, error) {
+	registry := NewProfileRegistry()
+
+	if config != nil && config.SboxDataDir != "" {
+		if err := registry.LoadDir(filepath.Join(config.SboxDataDir, ProfilesDirName), ProfileSourceGlobal); err != nil {
+			return nil, err
+		}
+	}
+
+	if workspaceDir != "" {
+		absPath, err := filepath.Abs(workspaceDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path: %w", err)
+		}
+		if err := registry.LoadDir(filepath.Join(absPath, ".sbox", ProfilesDirName), ProfileSourceProject); err != nil {
+			return nil, err
+		}
+	}
+
+	if sboxFile != nil && sboxFile.Config != nil && sboxFile.Config.ProfilesDir != "" {
+		dir, err := ResolveVolumePath(sboxFile.Config.ProfilesDir, sboxFile.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve profiles_dir: %w", err)
+		}
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(sboxFile.Dir, dir)
+		}
+		if err := registry.LoadDir(dir, ProfileSourceProject); err != nil {
+			return nil, err
+		}
+	}
+
+	return registry, nil
+}
+
+// LoadDir loads all *.yaml and *.yml profile files from dir into the registry.
+// A missing directory is not an error.
+func (r *ProfileRegistry) LoadDir(dir, source string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read profiles directory %s: %w", dir, err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(entry.Name())
+		if ext != ".yaml" && ext != ".yml" {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		profile, override, err := loadProfileFile(path)
+		if err != nil {
+			return err
+		}
+		profile.Source = source
+
+		if err := r.Add(profile, override); err != nil {
+			return fmt.Errorf("profile file %s: %w", path, err)
+		}
+
+		zlog.Debug("loaded user-defined profile",
+			zap.String("name", profile.Name),
+			zap.String("source", source),
+			zap.String("path", path))
+	}
+
+	return nil
+}
+
+// Add registers a profile. If a profile of the same name already exists,
+// override must be true for it to be replaced.
+func (r *ProfileRegistry) Add(profile Profile, override bool) error {
+	if existing, ok := r.profiles[profile.Name]; ok && !override {
+		return fmt.Errorf("profile %q is already defined (%s); set 'override: true' to replace it", profile.Name, existing.Source)
+	}
+	r.profiles[profile.Name] = profile
+	return nil
+}
+
+// Get retrieves a profile by name
+func (r *ProfileRegistry) Get(name string) (Profile, bool) {
+	profile, ok := r.profiles[name]
+	return profile, ok
+}
+
+// List returns a sorted list of all profile names in the registry
+func (r *ProfileRegistry) List() []string {
+	names := make([]string, 0, len(r.profiles))
+	for name := range r.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadProfileFile parses a single profile YAML file. Returns the profile and
+// whether it declared `override: true`.
+func loadProfileFile(path string) (Profile, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Profile{}, false, fmt.Errorf("failed to read profile file %s: %w", path, err)
+	}
+
+	var file ProfileFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return Profile{}, false, fmt.Errorf("failed to parse profile file %s: %w", path, err)
+	}
+
+	name := file.Name
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if !profileNameRegex.MatchString(name) {
+		return Profile{}, false, fmt.Errorf("profile file %s: invalid profile name %q", path, name)
+	}
+
+	snippet := file.DockerfileSnippet
+	if file.DockerfileSnippetFile != "" {
+		if snippet != "" {
+			return Profile{}, false, fmt.Errorf("profile file %s: dockerfile_snippet and dockerfile_snippet_file are mutually exclusive", path)
+		}
+
+		snippetPath, err := ResolveVolumePath(file.DockerfileSnippetFile, filepath.Dir(path))
+		if err != nil {
+			return Profile{}, false, fmt.Errorf("profile file %s: failed to resolve snippet path: %w", path, err)
+		}
+		if !filepath.IsAbs(snippetPath) {
+			snippetPath = filepath.Join(filepath.Dir(path), snippetPath)
+		}
+
+		content, err := os.ReadFile(snippetPath)
+		if err != nil {
+			return Profile{}, false, fmt.Errorf("profile file %s: failed to read snippet file: %w", path, err)
+		}
+		snippet = string(content)
+	}
+
+	if strings.TrimSpace(snippet) == "" {
+		return Profile{}, false, fmt.Errorf("profile file %s: one of dockerfile_snippet or dockerfile_snippet_file is required", path)
+	}
+	if !strings.HasSuffix(snippet, "\n") {
+		snippet += "\n"
+	}
+
+	profile := Profile{
+		Name:              name,
+		Description:       file.Description,
+		Dependencies:      file.Dependencies,
+		DockerfileSnippet: snippet,
+		Params:            file.Params,
+		Path:              path,
+	}
+	if err := profile.validateParams(); err != nil {
+		return Profile{}, false, fmt.Errorf("profile file %s: %w", path, err)
+	}
+
+	return profile, file.Override, nil
+}
+
+// hashKey returns the string identifying this profile in the template hash.
+// Built-in profiles are identified by name only (their content is tied to the
+// sbox version); user-defined profiles also include a digest of their content
+// so that editing a profile triggers a rebuild.
+func (p Profile) hashKey() string {
+	if p.Source == ProfileSourceBuiltin || p.Source == "" {
+		return p.Name
+	}
+
+	h := sha256.New()
+	h.Write([]byte(p.DockerfileSnippet))
+	for _, dep := range p.Dependencies {
+		h.Write([]byte{0})
+		h.Write([]byte(dep))
+	}
+	return p.Name + "@" + hex.EncodeToString(h.Sum(nil))[:12]
+}
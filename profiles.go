@@ -13,18 +13,35 @@ type Profile struct {
 
 	// DockerfileSnippet contains the Dockerfile commands to install this profile's tools
 	DockerfileSnippet string
+
+	// Params are the parameters accepted by this profile, exposed to the
+	// snippet as build ARGs (see ProfileParam)
+	Params []ProfileParam
+
+	// Source indicates where this profile was defined ("builtin", "global", "project")
+	Source string
+
+	// Path is the file the profile was loaded from (empty for built-in profiles)
+	Path string
 }
 
 // BuiltinProfiles contains all available built-in profiles
 var BuiltinProfiles = map[string]Profile{
 	"go": {
 		Name:        "go",
-		Description: "Go programming language toolchain (latest stable version)",
+		Description: "Go programming language toolchain",
+		Params: []ProfileParam{
+			{Name: "version", Type: ProfileParamVersion, Default: "1.24.4", Description: "Go release to install"},
+		},
 		DockerfileSnippet: `# Go toolchain
 RUN apt-get update && apt-get install -y wget && \
-    wget -q https://go.dev/dl/go1.24.4.linux-${GO_ARCH}.tar.gz && \
-    tar -C /usr/local -xzf go1.24.4.linux-${GO_ARCH}.tar.gz && \
-    rm go1.24.4.linux-${GO_ARCH}.tar.gz && \
+    GO_RELEASE=${GO_VERSION#v} && \
+    if [ "$GO_RELEASE" = "latest" ]; then \
+        GO_RELEASE=$(wget -qO- "https://go.dev/VERSION?m=text" | head -n1 | sed 's/^go//'); \
+    fi && \
+    wget -q https://go.dev/dl/go${GO_RELEASE}.linux-${GO_ARCH}.tar.gz && \
+    tar -C /usr/local -xzf go${GO_RELEASE}.linux-${GO_ARCH}.tar.gz && \
+    rm go${GO_RELEASE}.linux-${GO_ARCH}.tar.gz && \
     apt-get clean && rm -rf /var/lib/apt/lists/*
 
 ENV PATH="/usr/local/go/bin:${PATH}"
@@ -34,14 +51,17 @@ ENV PATH="${GOPATH}/bin:${PATH}"
 	},
 	"rust": {
 		Name:        "rust",
-		Description: "Rust programming language toolchain (stable)",
+		Description: "Rust programming language toolchain",
+		Params: []ProfileParam{
+			{Name: "toolchain", Type: ProfileParamString, Default: "stable", Description: "rustup toolchain to install (stable, beta, nightly, 1.79.0, ...)"},
+		},
 		DockerfileSnippet: `# Rust toolchain (installed system-wide for all users)
 ENV RUSTUP_HOME="/usr/local/rustup"
 ENV CARGO_HOME="/usr/local/cargo"
 ENV PATH="/usr/local/cargo/bin:${PATH}"
 
 RUN apt-get update && apt-get install -y curl build-essential && \
-    curl --proto '=https' --tlsv1.2 -sSf https://sh.rustup.rs | sh -s -- -y --no-modify-path && \
+    curl --proto '=https' --tlsv1.2 -sSf https://sh.rustup.rs | sh -s -- -y --no-modify-path --default-toolchain ${RUST_TOOLCHAIN} && \
     chmod -R a+rwx /usr/local/rustup /usr/local/cargo && \
     apt-get clean && rm -rf /var/lib/apt/lists/*
 `,
@@ -66,6 +86,9 @@ RUN apt-get update && apt-get install -y \
 	"bash-utils": {
 		Name:        "bash-utils",
 		Description: "Common shell utilities (jq, yq, curl, wget, git)",
+		Params: []ProfileParam{
+			{Name: "yq_version", Type: ProfileParamVersion, Default: "latest", Description: "yq release to install"},
+		},
 		DockerfileSnippet: `# Bash utilities
 RUN apt-get update && apt-get install -y \
     jq \
@@ -78,7 +101,13 @@ RUN apt-get update && apt-get install -y \
     tree \
     zip \
     unzip && \
-    wget -qO /usr/local/bin/yq https://github.com/mikefarah/yq/releases/latest/download/yq_linux_${YQ_ARCH} && \
+    YQ_VERSION=${BASH_UTILS_YQ_VERSION#v} && \
+    if [ "$YQ_VERSION" = "latest" ]; then \
+        YQ_RELEASE=latest/download; \
+    else \
+        YQ_RELEASE=download/v${YQ_VERSION}; \
+    fi && \
+    wget -qO /usr/local/bin/yq https://github.com/mikefarah/yq/releases/${YQ_RELEASE}/yq_linux_${YQ_ARCH} && \
     chmod +x /usr/local/bin/yq && \
     apt-get clean && rm -rf /var/lib/apt/lists/*
 `,
@@ -87,17 +116,32 @@ RUN apt-get update && apt-get install -y \
 		Name:         "substreams",
 		Description:  "Substreams and Firehose Core CLI tools for blockchain data",
 		Dependencies: []string{"rust"},
+		Params: []ProfileParam{
+			{Name: "substreams_version", Type: ProfileParamVersion, Default: "latest", Description: "substreams image tag to copy the CLI from (latest, v1.16.4, ...)"},
+			{Name: "firecore_version", Type: ProfileParamVersion, Default: "latest", Description: "firehose-core image tag to copy the CLI from (latest, v1.10.1, ...)"},
+			{Name: "buf_version", Type: ProfileParamVersion, Default: "latest", Description: "buf release to install"},
+			{Name: "protoc_version", Type: ProfileParamVersion, Default: "latest", Description: "protoc release to install"},
+		},
 		DockerfileSnippet: `# Substreams CLI (from official Docker image)
-COPY --from=ghcr.io/streamingfast/substreams:latest /app/substreams /usr/local/bin/substreams
+COPY --from=ghcr.io/streamingfast/substreams:${SUBSTREAMS_SUBSTREAMS_VERSION} /app/substreams /usr/local/bin/substreams
 
 # Firehose Core CLI (from official Docker image)
-COPY --from=ghcr.io/streamingfast/firehose-core:latest /app/firecore /usr/local/bin/firecore
+COPY --from=ghcr.io/streamingfast/firehose-core:${SUBSTREAMS_FIRECORE_VERSION} /app/firecore /usr/local/bin/firecore
 
 # buf CLI and protoc (protobuf compiler)
 RUN apt-get update && apt-get install -y curl unzip && \
-    curl -sSL "https://github.com/bufbuild/buf/releases/latest/download/buf-$(uname -s)-$(uname -m)" -o /usr/local/bin/buf && \
+    BUF_VERSION=${SUBSTREAMS_BUF_VERSION#v} && \
+    if [ "$BUF_VERSION" = "latest" ]; then \
+        BUF_RELEASE=latest/download; \
+    else \
+        BUF_RELEASE=download/v${BUF_VERSION}; \
+    fi && \
+    curl -sSL "https://github.com/bufbuild/buf/releases/${BUF_RELEASE}/buf-$(uname -s)-$(uname -m)" -o /usr/local/bin/buf && \
     chmod +x /usr/local/bin/buf && \
-    PROTOC_VERSION=$(curl -sSL https://api.github.com/repos/protocolbuffers/protobuf/releases/latest | grep '"tag_name"' | sed 's/.*"v\(.*\)".*/\1/') && \
+    PROTOC_VERSION=${SUBSTREAMS_PROTOC_VERSION#v} && \
+    if [ "$PROTOC_VERSION" = "latest" ]; then \
+        PROTOC_VERSION=$(curl -sSL https://api.github.com/repos/protocolbuffers/protobuf/releases/latest | grep '"tag_name"' | sed 's/.*"v\(.*\)".*/\1/'); \
+    fi && \
     curl -sSL "https://github.com/protocolbuffers/protobuf/releases/download/v${PROTOC_VERSION}/protoc-${PROTOC_VERSION}-linux-${PROTOC_ARCH}.zip" -o /tmp/protoc.zip && \
     unzip -o /tmp/protoc.zip -d /usr/local bin/protoc 'include/*' && \
     rm /tmp/protoc.zip && \
@@ -107,29 +151,43 @@ RUN apt-get update && apt-get install -y curl unzip && \
 	"javascript": {
 		Name:        "javascript",
 		Description: "JavaScript/TypeScript development tools (pnpm, yarn)",
+		Params: []ProfileParam{
+			{Name: "pnpm_version", Type: ProfileParamVersion, Default: "latest", Description: "pnpm release to install"},
+			{Name: "yarn_version", Type: ProfileParamVersion, Default: "latest", Description: "yarn release to install"},
+		},
 		DockerfileSnippet: `# JavaScript package managers (pnpm, yarn)
 # Note: Node.js and npm are already installed in the base image
-RUN npm install -g pnpm yarn
+RUN npm install -g pnpm@${JAVASCRIPT_PNPM_VERSION} yarn@${JAVASCRIPT_YARN_VERSION}
 `,
 	},
 	"firehose": {
 		Name:        "firehose",
 		Description: "Firehose CLI tools (substreams, firecore, fireeth, dummy-blockchain) and grpcurl for blockchain data streaming",
+		Params: []ProfileParam{
+			{Name: "substreams_version", Type: ProfileParamVersion, Default: "latest", Description: "substreams image tag to copy the CLI from"},
+			{Name: "firecore_version", Type: ProfileParamVersion, Default: "latest", Description: "firehose-core image tag to copy the CLI from"},
+			{Name: "fireeth_version", Type: ProfileParamVersion, Default: "latest", Description: "firehose-ethereum image tag to copy the CLI from"},
+			{Name: "dummy_blockchain_version", Type: ProfileParamVersion, Default: "latest", Description: "dummy-blockchain image tag to copy the binary from"},
+			{Name: "grpcurl_version", Type: ProfileParamVersion, Default: "latest", Description: "grpcurl release to install"},
+		},
 		DockerfileSnippet: `# Substreams CLI (from official Docker image)
-COPY --from=ghcr.io/streamingfast/substreams:latest /app/substreams /usr/local/bin/substreams
+COPY --from=ghcr.io/streamingfast/substreams:${FIREHOSE_SUBSTREAMS_VERSION} /app/substreams /usr/local/bin/substreams
 
 # Firehose Core CLI (from official Docker image)
-COPY --from=ghcr.io/streamingfast/firehose-core:latest /app/firecore /usr/local/bin/firecore
+COPY --from=ghcr.io/streamingfast/firehose-core:${FIREHOSE_FIRECORE_VERSION} /app/firecore /usr/local/bin/firecore
 
 # Firehose Ethereum CLI (from official Docker image)
-COPY --from=ghcr.io/streamingfast/firehose-ethereum:latest /app/fireeth /usr/local/bin/fireeth
+COPY --from=ghcr.io/streamingfast/firehose-ethereum:${FIREHOSE_FIREETH_VERSION} /app/fireeth /usr/local/bin/fireeth
 
 # Dummy Blockchain (from official Docker image)
-COPY --from=ghcr.io/streamingfast/dummy-blockchain:latest /app/dummy-blockchain /usr/local/bin/dummy-blockchain
+COPY --from=ghcr.io/streamingfast/dummy-blockchain:${FIREHOSE_DUMMY_BLOCKCHAIN_VERSION} /app/dummy-blockchain /usr/local/bin/dummy-blockchain
 
 # grpcurl CLI
 RUN apt-get update && apt-get install -y curl && \
-    GRPCURL_VERSION=$(curl -sSL https://api.github.com/repos/fullstorydev/grpcurl/releases/latest | grep '"tag_name"' | sed 's/.*"v\(.*\)".*/\1/') && \
+    GRPCURL_VERSION=${FIREHOSE_GRPCURL_VERSION#v} && \
+    if [ "$GRPCURL_VERSION" = "latest" ]; then \
+        GRPCURL_VERSION=$(curl -sSL https://api.github.com/repos/fullstorydev/grpcurl/releases/latest | grep '"tag_name"' | sed 's/.*"v\(.*\)".*/\1/'); \
+    fi && \
     curl -sSL "https://github.com/fullstorydev/grpcurl/releases/download/v${GRPCURL_VERSION}/grpcurl_${GRPCURL_VERSION}_linux_${GRPCURL_ARCH}.tar.gz" -o /tmp/grpcurl.tar.gz && \
     tar -xzf /tmp/grpcurl.tar.gz -C /usr/local/bin grpcurl && \
     rm /tmp/grpcurl.tar.gz && \
@@ -165,17 +223,14 @@ RUN apt-get update && apt-get install -y \
 	},
 }
 
-// GetProfile retrieves a profile by name
+// GetProfile retrieves a built-in profile by name.
+// Use LoadProfileRegistry to also include user-defined profiles.
 func GetProfile(name string) (Profile, bool) {
 	profile, ok := BuiltinProfiles[name]
 	return profile, ok
 }
 
-// ListProfiles returns a sorted list of all available profile names
+// ListProfiles returns a sorted list of all built-in profile names
 func ListProfiles() []string {
-	names := make([]string, 0, len(BuiltinProfiles))
-	for name := range BuiltinProfiles {
-		names = append(names, name)
-	}
-	return names
+	return NewProfileRegistry().List()
 }
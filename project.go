@@ -0,0 +1,337 @@
+package sbox
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v3"
+)
+
+// ProjectIDFileName is the file of the workspace .sbox directory recording
+// the project ID, so the project keeps its config, volumes and secrets when
+// the workspace is moved or renamed
+const ProjectIDFileName = "project-id"
+
+var projectIDRegex = regexp.MustCompile(`^[0-9a-f]{12}$`)
+
+// projectPathHash computes the project hash of a workspace (first 12 chars of
+// the SHA256 of its absolute path). It is the ID of projects registered at
+// that path.
+func projectPathHash(workspacePath string) (string, string, error) {
+	absPath, err := filepath.Abs(workspacePath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	hash := sha256.Sum256([]byte(absPath))
+	return absPath, hex.EncodeToString(hash[:])[:12], nil
+}
+
+// readProjectID returns the project ID recorded in a workspace, empty when
+// there is none (or it isn't a valid ID)
+func readProjectID(absPath string) string {
+	data, err := os.ReadFile(filepath.Join(absPath, ".sbox", ProjectIDFileName))
+	if err != nil {
+		return ""
+	}
+
+	id := strings.TrimSpace(string(data))
+	if !projectIDRegex.MatchString(id) {
+		zlog.Debug("ignoring invalid project id", zap.String("workspace", absPath), zap.String("id", id))
+		return ""
+	}
+	return id
+}
+
+// writeProjectID records the project ID in a workspace
+func writeProjectID(absPath, id string) error {
+	if err := writeFileAtomic(filepath.Join(absPath, ".sbox", ProjectIDFileName), []byte(id+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write project id: %w", err)
+	}
+	return nil
+}
+
+// removeWorkspaceSboxDir removes the workspace .sbox directory but the
+// project ID: the workspace stays attached to its config, vault secrets and
+// network policy until the project data itself is removed
+func removeWorkspaceSboxDir(absPath string) error {
+	sboxDir := filepath.Join(absPath, ".sbox")
+	entries, err := os.ReadDir(sboxDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read .sbox directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.Name() == ProjectIDFileName {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(sboxDir, entry.Name())); err != nil {
+			return fmt.Errorf("failed to remove .sbox directory: %w", err)
+		}
+	}
+
+	// Only removed when it doesn't hold the project ID
+	_ = os.Remove(sboxDir)
+	return nil
+}
+
+// recordedWorkspacePath returns the workspace path recorded in the config of
+// a project, empty when the project has no config
+func recordedWorkspacePath(dataDir, id string) string {
+	data, err := os.ReadFile(filepath.Join(dataDir, "projects", id, "config.yaml"))
+	if err != nil {
+		return ""
+	}
+
+	var recorded struct {
+		WorkspacePath string `yaml:"workspace_path"`
+	}
+	if err := yaml.Unmarshal(data, &recorded); err != nil {
+		return ""
+	}
+	return recorded.WorkspacePath
+}
+
+// projectIdentity returns the absolute path and the project ID of a
+// workspace. The ID is the one recorded in the workspace .sbox/project-id
+// file, or the hash of the workspace path for workspaces without one.
+//
+// Copies of a workspace carry its project ID: when the workspace recorded
+// for the ID still exists, is another directory and holds the same ID, the
+// copy gets its own ID instead.
+func projectIdentity(dataDir, workspacePath string) (string, string, error) {
+	absPath, pathHash, err := projectPathHash(workspacePath)
+	if err != nil {
+		return "", "", err
+	}
+
+	id := readProjectID(absPath)
+	if id == "" {
+		return absPath, pathHash, nil
+	}
+
+	recorded := recordedWorkspacePath(dataDir, id)
+	if recorded != "" && recorded != absPath && readProjectID(recorded) == id && !sameDirectory(recorded, absPath) {
+		zlog.Debug("workspace is a copy of another project",
+			zap.String("workspace", absPath),
+			zap.String("project_id", id),
+			zap.String("original", recorded))
+		return absPath, pathHash, nil
+	}
+	return absPath, id, nil
+}
+
+// sameDirectory reports whether two paths are the same directory (through
+// symbolic links or bind mounts)
+func sameDirectory(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
+
+// ProjectMovedFrom returns the previous path of a project whose workspace
+// was moved: the path recorded in its config when it is another workspace
+// that no longer holds the project ID. Returns an empty string otherwise.
+func ProjectMovedFrom(workspacePath string, projectConfig *ProjectConfig) string {
+	absPath, err := filepath.Abs(workspacePath)
+	if err != nil {
+		return ""
+	}
+
+	recorded := projectConfig.WorkspacePath
+	if recorded == "" || recorded == absPath {
+		return ""
+	}
+
+	id := readProjectID(absPath)
+	if id == "" || readProjectID(recorded) == id {
+		return ""
+	}
+	return recorded
+}
+
+// workspaceFingerprint identifies the git repository of a workspace by its
+// root commits, to spot checkouts moved without their .sbox directory.
+// Returns an empty string outside of git repositories.
+func workspaceFingerprint(absPath string) string {
+	output, err := exec.Command("git", "-C", absPath, "rev-list", "--max-parents=0", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+
+	roots := strings.Fields(string(output))
+	if len(roots) == 0 {
+		return ""
+	}
+
+	hash := sha256.Sum256([]byte(strings.Join(roots, ",")))
+	return hex.EncodeToString(hash[:])[:12]
+}
+
+// MoveProject moves the project known at oldPath to the workspace at
+// newPath, which must exist: the workspace gets the project ID, so it finds
+// the project config, volumes and secrets, and the sandbox or container
+// created for oldPath is removed, to be recreated by the next 'sbox run'. A
+// running one, or another project already registered at newPath, is only
+// removed with force. Returns the moved project config and the removed
+// container, if any.
+func (s *ConfigStore) MoveProject(oldPath, newPath string, force bool) (*ProjectConfig, *ContainerInfo, error) {
+	oldAbs, err := filepath.Abs(oldPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	newAbs, err := filepath.Abs(newPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	if oldAbs == newAbs {
+		return nil, nil, fmt.Errorf("project is already at %s", newAbs)
+	}
+	if info, err := os.Stat(newAbs); err != nil || !info.IsDir() {
+		return nil, nil, fmt.Errorf("workspace %s does not exist", newAbs)
+	}
+
+	dataDir := s.config.SboxDataDir
+	projects, err := listProjects(dataDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var project *ProjectInfo
+	for i := range projects {
+		if projects[i].WorkspacePath == oldAbs {
+			project = &projects[i]
+			break
+		}
+	}
+	if project == nil {
+		return nil, nil, fmt.Errorf("no sbox project known at %s", oldAbs)
+	}
+
+	_, currentID, err := projectIdentity(dataDir, newAbs)
+	if err != nil {
+		return nil, nil, err
+	}
+	if currentID != project.Hash && recordedWorkspacePath(dataDir, currentID) != "" {
+		// Likely registered by a 'sbox run' in the new workspace, before the move
+		if !force {
+			return nil, nil, fmt.Errorf("%s is already a sbox project, move with --force to replace it", newAbs)
+		}
+		if err := s.removeProject(currentID, newAbs); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	removed, err := s.removeMovedContainer(oldAbs, project.Config, force)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	configPath := filepath.Join(dataDir, "projects", project.Hash, "config.yaml")
+	unlock, err := lockFile(configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	projectConfig, err := loadProjectConfigByID(dataDir, project.Hash)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load project config: %w", err)
+	}
+
+	if err := writeProjectID(newAbs, project.Hash); err != nil {
+		return nil, nil, err
+	}
+	if readProjectID(oldAbs) == project.Hash && !sameDirectory(oldAbs, newAbs) {
+		// The old workspace still exists, it is no longer the project
+		if err := os.Remove(filepath.Join(oldAbs, ".sbox", ProjectIDFileName)); err != nil {
+			return nil, nil, fmt.Errorf("failed to remove project id of %s: %w", oldAbs, err)
+		}
+	}
+
+	projectConfig.WorkspacePath = newAbs
+	projectConfig.Fingerprint = ""
+	if err := saveProjectConfigByID(dataDir, project.Hash, newAbs, projectConfig); err != nil {
+		return nil, nil, err
+	}
+
+	zlog.Info("moved project",
+		zap.String("project_id", project.Hash),
+		zap.String("from", oldAbs),
+		zap.String("to", newAbs))
+
+	return projectConfig, removed, nil
+}
+
+// removeProject removes a project replaced by a moved one, with its sandbox
+// or container
+func (s *ConfigStore) removeProject(id, absPath string) error {
+	projectConfig, err := loadProjectConfigByID(s.config.SboxDataDir, id)
+	if err != nil {
+		return fmt.Errorf("failed to load project config: %w", err)
+	}
+	if _, err := s.removeMovedContainer(absPath, projectConfig, true); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(filepath.Join(s.config.SboxDataDir, "projects", id)); err != nil {
+		return fmt.Errorf("failed to remove project directory: %w", err)
+	}
+
+	zlog.Info("removed replaced project", zap.String("project_id", id), zap.String("workspace", absPath))
+	return nil
+}
+
+// removeMovedContainer removes the sandbox or container created for the old
+// path of a moved project, its mounts pointing to that path
+func (s *ConfigStore) removeMovedContainer(oldAbs string, projectConfig *ProjectConfig, force bool) (*ContainerInfo, error) {
+	backend, err := GetBackend(projectConfig.Backend, s.config)
+	if err != nil {
+		return nil, err
+	}
+
+	name := projectConfig.SandboxName
+	if name == "" {
+		if name, err = GenerateSandboxName(oldAbs, AgentType(projectConfig.Agent)); err != nil {
+			return nil, err
+		}
+	}
+
+	containers, err := backend.List()
+	if err != nil {
+		zlog.Warn("failed to list containers of moved project", zap.Error(err))
+		DefaultUI.Warn("Could not look for the %s of %s (%s), remove it manually if it exists", backend.Name(), oldAbs, err)
+		return nil, nil
+	}
+
+	for _, container := range containers {
+		if container.Name != name {
+			continue
+		}
+		if container.Status == "running" && !force {
+			return nil, fmt.Errorf("%s '%s' of %s is running, stop it first or move with --force", backend.Name(), name, oldAbs)
+		}
+		if err := backend.Remove(container.ID); err != nil {
+			return nil, fmt.Errorf("failed to remove %s '%s': %w", backend.Name(), name, err)
+		}
+		return &container, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,282 @@
+package sbox
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ResourceLimits caps the resources available to a sandbox or container.
+// Zero values mean "no limit" (the engine default).
+type ResourceLimits struct {
+	// CPUs is the number of CPUs the container may use, e.g. 2 or 1.5
+	CPUs float64 `yaml:"cpus,omitempty"`
+
+	// Memory is the memory limit, e.g. "4g" or "512m"
+	Memory string `yaml:"memory,omitempty"`
+
+	// MemorySwap is the memory plus swap limit, e.g. "8g", or "-1" for unlimited swap
+	MemorySwap string `yaml:"memory_swap,omitempty"`
+
+	// PidsLimit is the maximum number of processes, or -1 for unlimited
+	PidsLimit int64 `yaml:"pids_limit,omitempty"`
+
+	// ShmSize is the size of /dev/shm, e.g. "1g"
+	ShmSize string `yaml:"shm_size,omitempty"`
+
+	// Ulimits maps a ulimit name to "soft[:hard]", e.g. nofile: "1024:4096"
+	Ulimits map[string]string `yaml:"ulimits,omitempty"`
+}
+
+// IsEmpty reports whether no limit is set
+func (r *ResourceLimits) IsEmpty() bool {
+	return r == nil || (r.CPUs == 0 && r.Memory == "" && r.MemorySwap == "" &&
+		r.PidsLimit == 0 && r.ShmSize == "" && len(r.Ulimits) == 0)
+}
+
+// MergeResourceLimits returns base with every limit set in override replacing
+// the base one. Ulimits are merged by name. Either argument may be nil.
+func MergeResourceLimits(base, override *ResourceLimits) *ResourceLimits {
+	merged := &ResourceLimits{}
+	for _, r := range []*ResourceLimits{base, override} {
+		if r == nil {
+			continue
+		}
+		if r.CPUs != 0 {
+			merged.CPUs = r.CPUs
+		}
+		if r.Memory != "" {
+			merged.Memory = r.Memory
+		}
+		if r.MemorySwap != "" {
+			merged.MemorySwap = r.MemorySwap
+		}
+		if r.PidsLimit != 0 {
+			merged.PidsLimit = r.PidsLimit
+		}
+		if r.ShmSize != "" {
+			merged.ShmSize = r.ShmSize
+		}
+		for name, value := range r.Ulimits {
+			if merged.Ulimits == nil {
+				merged.Ulimits = make(map[string]string)
+			}
+			merged.Ulimits[name] = value
+		}
+	}
+	return merged
+}
+
+// ResolveResourceLimits returns the resource limits for a project: the global
+// config limits overridden by the project ones (which already include sbox.yaml
+// when the project config went through MergeProjectConfig).
+func ResolveResourceLimits(config *Config, projectConfig *ProjectConfig) *ResourceLimits {
+	var global, project *ResourceLimits
+	if config != nil {
+		global = config.Resources
+	}
+	if projectConfig != nil {
+		project = projectConfig.Resources
+	}
+	return MergeResourceLimits(global, project)
+}
+
+// Validate checks that every limit can be converted to engine values
+func (r *ResourceLimits) Validate() error {
+	return r.ApplyToHostConfig(&DockerHostConfig{})
+}
+
+// ApplyToHostConfig sets the Engine API host config fields for the limits
+// (the equivalent of `docker run --cpus --memory --pids-limit ...`)
+func (r *ResourceLimits) ApplyToHostConfig(hostConfig *DockerHostConfig) error {
+	if r == nil {
+		return nil
+	}
+
+	var res DockerResources
+	if r.CPUs < 0 {
+		return fmt.Errorf("invalid cpus %v: must be positive", r.CPUs)
+	}
+	res.NanoCpus = int64(r.CPUs * 1e9)
+
+	var err error
+	if res.Memory, err = parseResourceSize("memory", r.Memory, false); err != nil {
+		return err
+	}
+	if res.MemorySwap, err = parseResourceSize("memory_swap", r.MemorySwap, true); err != nil {
+		return err
+	}
+	if res.MemorySwap > 0 && res.Memory > 0 && res.MemorySwap < res.Memory {
+		return fmt.Errorf("invalid memory_swap %q: must be at least memory (%s)", r.MemorySwap, r.Memory)
+	}
+	if res.MemorySwap != 0 && res.Memory == 0 {
+		return fmt.Errorf("invalid memory_swap %q: requires memory to be set", r.MemorySwap)
+	}
+	shmSize, err := parseResourceSize("shm_size", r.ShmSize, false)
+	if err != nil {
+		return err
+	}
+
+	if r.PidsLimit < -1 {
+		return fmt.Errorf("invalid pids_limit %d: must be positive or -1 for unlimited", r.PidsLimit)
+	}
+	if r.PidsLimit != 0 {
+		limit := r.PidsLimit
+		res.PidsLimit = &limit
+	}
+
+	for _, name := range sortedKeys(r.Ulimits) {
+		ulimit, err := parseUlimit(name, r.Ulimits[name])
+		if err != nil {
+			return err
+		}
+		res.Ulimits = append(res.Ulimits, ulimit)
+	}
+
+	hostConfig.DockerResources = res
+	hostConfig.ShmSize = shmSize
+	return nil
+}
+
+// dockerResourcesMatch reports whether an inspected container has the wanted
+// limits. The engine fills in defaults for an unset swap or /dev/shm size and
+// reports an unlimited pids limit as 0 or null, so those are compared loosely.
+func dockerResourcesMatch(got DockerResources, gotShmSize int64, want DockerResources, wantShmSize int64) bool {
+	if got.NanoCpus != want.NanoCpus || got.Memory != want.Memory {
+		return false
+	}
+	if want.MemorySwap != 0 && got.MemorySwap != want.MemorySwap {
+		return false
+	}
+	if wantShmSize != 0 && gotShmSize != wantShmSize {
+		return false
+	}
+	if pidsLimitValue(got.PidsLimit) != pidsLimitValue(want.PidsLimit) {
+		return false
+	}
+
+	byName := func(a, b DockerUlimit) int { return strings.Compare(a.Name, b.Name) }
+	gotUlimits := slices.SortedFunc(slices.Values(got.Ulimits), byName)
+	wantUlimits := slices.SortedFunc(slices.Values(want.Ulimits), byName)
+	return slices.Equal(gotUlimits, wantUlimits)
+}
+
+// pidsLimitValue returns a pids limit with every unlimited form as 0
+func pidsLimitValue(limit *int64) int64 {
+	if limit == nil || *limit < 0 {
+		return 0
+	}
+	return *limit
+}
+
+// Describe returns the set limits as "name=value" strings, in a stable order
+func (r *ResourceLimits) Describe() []string {
+	if r == nil {
+		return nil
+	}
+
+	var parts []string
+	if r.CPUs != 0 {
+		parts = append(parts, "cpus="+strconv.FormatFloat(r.CPUs, 'f', -1, 64))
+	}
+	if r.Memory != "" {
+		parts = append(parts, "memory="+r.Memory)
+	}
+	if r.MemorySwap != "" {
+		parts = append(parts, "memory_swap="+r.MemorySwap)
+	}
+	if r.PidsLimit != 0 {
+		parts = append(parts, fmt.Sprintf("pids_limit=%d", r.PidsLimit))
+	}
+	if r.ShmSize != "" {
+		parts = append(parts, "shm_size="+r.ShmSize)
+	}
+	for _, name := range sortedKeys(r.Ulimits) {
+		parts = append(parts, fmt.Sprintf("ulimit %s=%s", name, r.Ulimits[name]))
+	}
+	return parts
+}
+
+// parseResourceSize parses a size like "512m" or "4g" (binary units, as
+// `docker run --memory`). allowUnlimited accepts "-1".
+func parseResourceSize(field, value string, allowUnlimited bool) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if allowUnlimited && value == "-1" {
+		return -1, nil
+	}
+
+	size, err := ParseByteSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", field, value, err)
+	}
+	return size, nil
+}
+
+// ParseByteSize parses a human readable size with an optional b, k, m, g or t
+// suffix (optionally followed by "b"), using binary multiples: "512m" is
+// 512 MiB. A plain number is a size in bytes.
+func ParseByteSize(value string) (int64, error) {
+	s := strings.ToLower(strings.TrimSpace(value))
+
+	multiplier := int64(1)
+	for i, unit := range []string{"k", "m", "g", "t"} {
+		if trimmed, ok := cutUnitSuffix(s, unit); ok {
+			s, multiplier = trimmed, int64(1)<<(10*(i+1))
+			break
+		}
+	}
+	if multiplier == 1 {
+		s = strings.TrimSuffix(s, "b")
+	}
+
+	number, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || number <= 0 {
+		return 0, fmt.Errorf("expected a positive size like 512m or 4g")
+	}
+	return int64(number * float64(multiplier)), nil
+}
+
+// cutUnitSuffix strips unit, unit+"b" or unit+"ib" from the end of s
+func cutUnitSuffix(s, unit string) (string, bool) {
+	for _, suffix := range []string{unit + "ib", unit + "b", unit} {
+		if trimmed, ok := strings.CutSuffix(s, suffix); ok {
+			return trimmed, true
+		}
+	}
+	return s, false
+}
+
+// parseUlimit parses a "soft[:hard]" ulimit value; a single value sets both
+func parseUlimit(name, value string) (DockerUlimit, error) {
+	softValue, hardValue, hasHard := strings.Cut(strings.TrimSpace(value), ":")
+	if !hasHard {
+		hardValue = softValue
+	}
+
+	soft, err := strconv.ParseInt(softValue, 10, 64)
+	if err != nil {
+		return DockerUlimit{}, fmt.Errorf("invalid ulimit %s %q: expected soft[:hard]", name, value)
+	}
+	hard, err := strconv.ParseInt(hardValue, 10, 64)
+	if err != nil {
+		return DockerUlimit{}, fmt.Errorf("invalid ulimit %s %q: expected soft[:hard]", name, value)
+	}
+	if hard != -1 && (soft == -1 || soft > hard) {
+		return DockerUlimit{}, fmt.Errorf("invalid ulimit %s %q: soft limit exceeds hard limit", name, value)
+	}
+
+	return DockerUlimit{Name: name, Soft: soft, Hard: hard}, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
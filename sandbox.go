@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
@@ -83,7 +82,10 @@ func BuildSandboxCommands(opts SandboxOptions) (*SandboxCommands, error) {
 	// Build template image name if profiles are specified
 	var templateImage string
 	if len(allProfiles) > 0 {
-		builder := NewTemplateBuilder(opts.Config, allProfiles, agentType)
+		builder, err := NewWorkspaceTemplateBuilder(opts.Config, opts.WorkspaceDir, opts.SboxFile, allProfiles, agentType)
+		if err != nil {
+			return nil, err
+		}
 		templateImage = builder.ImageName()
 	}
 
@@ -145,7 +147,7 @@ func RunSandbox(opts SandboxOptions) error {
 	if opts.SboxFile != nil && opts.SboxFile.Config != nil {
 		sboxFileEnvs = opts.SboxFile.Config.Envs
 	}
-	if err := PrepareSboxDirectory(opts.WorkspaceDir, opts.Config, opts.Config.Envs, opts.ProjectConfig.Envs, sboxFileEnvs, BackendSandbox, agentType, BackendOptions{}); err != nil {
+	if _, err := PrepareSboxDirectory(opts.WorkspaceDir, opts.Config, opts.Config.Envs, opts.ProjectConfig.Envs, sboxFileEnvs, BackendSandbox, agentType, BackendOptions{}); err != nil {
 		return fmt.Errorf("failed to prepare .sbox directory: %w", err)
 	}
 
@@ -159,7 +161,10 @@ func RunSandbox(opts SandboxOptions) error {
 	if existingSandbox == nil {
 		// Build custom template only when creating a new sandbox
 		// Template is now always required for sbox entrypoint
-		builder := NewTemplateBuilder(opts.Config, allProfiles, agentType)
+		builder, err := NewWorkspaceTemplateBuilder(opts.Config, opts.WorkspaceDir, opts.SboxFile, allProfiles, agentType)
+		if err != nil {
+			return err
+		}
 		templateImage, err := builder.Build(opts.ForceRebuild)
 		if err != nil {
 			return fmt.Errorf("failed to build custom template: %w", err)
@@ -204,7 +209,7 @@ func RunSandbox(opts SandboxOptions) error {
 		zap.Bool("debug", opts.Debug))
 
 	// Execute docker sandbox run
-	cmd := exec.Command("docker", args...)
+	cmd := execCommand("docker", args...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -274,14 +279,16 @@ func buildVolumeMounts(opts SandboxOptions, claudeMDPath string, claudeFlags *Cl
 	// Volume mounts don't work with Docker sandbox MicroVMs
 	_ = claudeMDPath // Unused, kept for backwards compatibility in function signature
 
-	// Mount ~/.ssh if it exists
+	// Mount ~/.ssh if it exists and the "keys" SSH mode is used
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get home directory: %w", err)
 	}
 	sshPath := filepath.Join(homeDir, ".ssh")
-	if _, err := os.Stat(sshPath); err == nil {
-		mounts = append(mounts, "-v", fmt.Sprintf("%s:/home/agent/.ssh:ro", sshPath))
+	if ResolveSSHSettings(opts.Config, opts.ProjectConfig).Mode != SSHModeKeys {
+		zlog.Debug("SSH keys mode not enabled, skipping SSH directory", zap.String("path", sshPath))
+	} else if _, err := os.Stat(sshPath); err == nil {
+		mounts = append(mounts, "-v", fmt.Sprintf("%s:%s:ro", sshPath, sshKeysPath))
 		zlog.Debug("mounting SSH directory", zap.String("path", sshPath))
 	} else {
 		zlog.Debug("SSH directory not found, skipping", zap.String("path", sshPath))
@@ -347,10 +354,13 @@ func GetSandboxContainerName(workspaceDir string) (string, error) {
 	return absPath, nil
 }
 
+// dockerEnvFile is the file Docker creates at the root of containers
+var dockerEnvFile = "/.dockerenv"
+
 // IsInsideSandbox checks if we're currently running inside a Docker sandbox container
 func IsInsideSandbox() bool {
 	// Check for /.dockerenv file (exists in Docker containers)
-	if _, err := os.Stat("/.dockerenv"); err == nil {
+	if _, err := os.Stat(dockerEnvFile); err == nil {
 		return true
 	}
 
@@ -443,7 +453,7 @@ func ConnectShell(workspaceDir string) error {
 		zap.String("workspace", absPath))
 
 	// Execute docker sandbox exec -it <sandbox> bash
-	cmd := exec.Command("docker", "sandbox", "exec", "-it", sandbox.ID, "bash")
+	cmd := execCommand("docker", "sandbox", "exec", "-it", sandbox.ID, "bash")
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -481,7 +491,7 @@ func StopSandbox(workspaceDir string, remove bool) (*SandboxContainer, error) {
 		zap.String("workspace", absPath))
 
 	// Stop the sandbox using docker sandbox stop
-	stopCmd := exec.Command("docker", "sandbox", "stop", sandbox.ID)
+	stopCmd := execCommand("docker", "sandbox", "stop", sandbox.ID)
 	var stderr bytes.Buffer
 	stopCmd.Stderr = &stderr
 
@@ -499,7 +509,7 @@ func StopSandbox(workspaceDir string, remove bool) (*SandboxContainer, error) {
 			zap.String("sandbox_id", sandbox.ID),
 			zap.String("sandbox_name", sandbox.Name))
 
-		rmCmd := exec.Command("docker", "sandbox", "rm", sandbox.ID)
+		rmCmd := execCommand("docker", "sandbox", "rm", sandbox.ID)
 		rmCmd.Stderr = &stderr
 
 		if err := rmCmd.Run(); err != nil {
@@ -516,7 +526,7 @@ func StopSandbox(workspaceDir string, remove bool) (*SandboxContainer, error) {
 
 // ListDockerSandboxes returns all Docker sandboxes from docker sandbox ls
 func ListDockerSandboxes() ([]DockerSandbox, error) {
-	cmd := exec.Command("docker", "sandbox", "ls")
+	cmd := execCommand("docker", "sandbox", "ls")
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
@@ -695,7 +705,7 @@ func CreateDockerSandbox(name string, workspaceDir string, templateImage string,
 		zap.Bool("debug", debug),
 		zap.Strings("args", args))
 
-	cmd := exec.Command("docker", args...)
+	cmd := execCommand("docker", args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
@@ -777,7 +787,7 @@ func RemoveDockerSandbox(sandboxID string) error {
 	zlog.Info("removing docker sandbox",
 		zap.String("sandbox_id", sandboxID))
 
-	cmd := exec.Command("docker", "sandbox", "rm", sandboxID)
+	cmd := execCommand("docker", "sandbox", "rm", sandboxID)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
@@ -796,7 +806,7 @@ func RemoveDockerSandboxByName(name string) error {
 	zlog.Info("removing docker sandbox by name",
 		zap.String("name", name))
 
-	cmd := exec.Command("docker", "sandbox", "rm", name)
+	cmd := execCommand("docker", "sandbox", "rm", name)
 	var stderr bytes.Buffer
 	cmd.Stderr = &stderr
 
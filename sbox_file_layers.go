@@ -0,0 +1,424 @@
+package sbox
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// PresetsDirName is the directory of the sbox data directory holding the
+// presets sbox.yaml files can extend by name
+const PresetsDirName = "presets"
+
+// presetNameRegex matches the names of presets
+var presetNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// ConfigOrigin is an effective value of a layered sbox.yaml file and the
+// file it came from
+type ConfigOrigin struct {
+	// Field is the sbox.yaml field, e.g. "profiles" or "resources.cpus"
+	Field string
+
+	// Value is the effective value, a single item for list fields
+	Value string
+
+	// Source is the path of the sbox.yaml file that set the value
+	Source string
+
+	// List is set for the items of list fields
+	List bool
+}
+
+// LoadSboxFile loads a sbox.yaml file with its layers: the parent sbox.yaml
+// files up to the repository root when it sets `inherit: true`, and the file
+// or preset it `extends`. Every file is validated (see ValidateConfigFile)
+// and the layers are merged, lowest precedence first:
+//
+//   - Lists are appended, an item replacing the inherited one with the same
+//     key: profiles by name, volumes by container path, envs by name
+//   - Scalars (backend, agent, docker_socket, ssh, network, ...) override the
+//     inherited value when set
+//   - Resource limits override the inherited ones limit by limit
+func LoadSboxFile(path string) (*SboxFileLocation, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	layers, err := loadSboxFileLayers(absPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	return mergeSboxFileLayers(layers), nil
+}
+
+// loadSboxFileLayers loads a sbox.yaml file preceded by the files it inherits
+// from and extends. stack holds the files being loaded to detect cycles.
+func loadSboxFileLayers(path string, stack []string) ([]*SboxFileLocation, error) {
+	if slices.Contains(stack, path) {
+		return nil, fmt.Errorf("%s extends itself: %s", path, strings.Join(append(stack, path), " -> "))
+	}
+	stack = append(stack, path)
+
+	location, _, err := loadSboxFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var layers []*SboxFileLocation
+	if location.Config.Inherit {
+		parentPath, err := findParentSboxFile(location.Dir)
+		if err != nil {
+			return nil, err
+		}
+		if parentPath != "" {
+			parentLayers, err := loadSboxFileLayers(parentPath, stack)
+			if err != nil {
+				return nil, err
+			}
+			layers = append(layers, parentLayers...)
+		}
+	}
+
+	if location.Config.Extends != "" {
+		extendsPath, err := ResolveExtendsPath(location.Config.Extends, location.Dir)
+		if err != nil {
+			return nil, fmt.Errorf("invalid extends in %s: %w", path, err)
+		}
+		if _, err := os.Stat(extendsPath); err != nil {
+			return nil, fmt.Errorf("invalid extends in %s: %w", path, err)
+		}
+		extendedLayers, err := loadSboxFileLayers(extendsPath, stack)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, extendedLayers...)
+	}
+
+	return append(layers, location), nil
+}
+
+// ResolveExtendsPath resolves the extends value of a sbox.yaml file: a path
+// (relative to baseDir, or starting with ~ or /), or the name of a preset in
+// ~/.config/sbox/presets/<name>.yaml
+func ResolveExtendsPath(extends, baseDir string) (string, error) {
+	isPath := strings.ContainsRune(extends, '/') || strings.HasPrefix(extends, "~") ||
+		strings.HasSuffix(extends, ".yaml") || strings.HasSuffix(extends, ".yml")
+	if isPath {
+		return resolveSboxFilePath(extends, baseDir)
+	}
+
+	if !presetNameRegex.MatchString(extends) {
+		return "", fmt.Errorf("invalid preset name %q", extends)
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".config", "sbox", PresetsDirName, extends+".yaml"), nil
+}
+
+// resolveSboxFilePath resolves a path of a sbox.yaml file relative to its
+// directory
+func resolveSboxFilePath(path, baseDir string) (string, error) {
+	resolved, err := ResolveVolumePath(path, baseDir)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(resolved) {
+		resolved = filepath.Join(baseDir, resolved)
+	}
+	return resolved, nil
+}
+
+// findParentSboxFile returns the closest sbox.yaml file above dir, without
+// going above the repository root (the directory holding .git). Empty when
+// there is none.
+func findParentSboxFile(dir string) (string, error) {
+	current := dir
+	for {
+		if _, err := os.Stat(filepath.Join(current, ".git")); err == nil {
+			return "", nil
+		}
+		parent := filepath.Dir(current)
+		if parent == current {
+			return "", nil
+		}
+		current = parent
+
+		path := filepath.Join(current, SboxFileName)
+		info, err := os.Stat(path)
+		if err == nil && !info.IsDir() {
+			return path, nil
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to check %s: %w", path, err)
+		}
+	}
+}
+
+// mergeSboxFileLayers merges loaded layers, lowest precedence first, into
+// the location of the last one
+func mergeSboxFileLayers(layers []*SboxFileLocation) *SboxFileLocation {
+	nearest := layers[len(layers)-1]
+	merged := &SboxFileLocation{
+		Path: nearest.Path,
+		Dir:  nearest.Dir,
+		Config: &SboxFileConfig{
+			Version: nearest.Config.Version,
+			Extends: nearest.Config.Extends,
+			Inherit: nearest.Config.Inherit,
+		},
+		origins: map[string]string{},
+	}
+
+	for _, layer := range layers {
+		merged.Layers = append(merged.Layers, layer.Path)
+		mergeSboxFileLayer(merged.Config, merged.origins, layer, layer == nearest)
+
+		for name, file := range layer.EnvFileSources {
+			if merged.EnvFileSources == nil {
+				merged.EnvFileSources = map[string]string{}
+			}
+			merged.EnvFileSources[name] = file
+		}
+		for _, env := range layer.Config.Envs {
+			if _, fromFile := layer.EnvFileSources[EnvName(env)]; !fromFile {
+				delete(merged.EnvFileSources, EnvName(env))
+			}
+		}
+	}
+
+	zlog.Debug("merged sbox.yaml layers",
+		zap.Strings("layers", merged.Layers),
+		zap.Strings("profiles", merged.Config.Profiles),
+		zap.Strings("volumes", merged.Config.Volumes))
+
+	return merged
+}
+
+// originKey is the key of a value in the origins of a location: the field,
+// and the key of the item for list fields
+func originKey(field, item string) string {
+	if item == "" {
+		return field
+	}
+	return field + "[" + item + "]"
+}
+
+// mergeSboxFileLayer merges a layer into config, recording the origin of the
+// values it sets. Relative paths of the layers other than the nearest one are
+// made absolute, as they are relative to their own file.
+func mergeSboxFileLayer(config *SboxFileConfig, origins map[string]string, layer *SboxFileLocation, nearest bool) {
+	source := layer.Config
+	path := layer.Path
+
+	for _, ref := range source.Profiles {
+		config.Profiles = mergeListItem(config.Profiles, ref, profileRefName, origins, "profiles", path)
+	}
+
+	for _, volume := range source.Volumes {
+		if hostPath, containerPath, readOnly, err := ParseVolumeSpec(volume); err == nil && !nearest &&
+			(strings.HasPrefix(hostPath, "./") || strings.HasPrefix(hostPath, "../")) {
+			volume = filepath.Join(layer.Dir, hostPath) + ":" + containerPath
+			if readOnly {
+				volume += ":ro"
+			}
+		}
+		config.Volumes = mergeListItem(config.Volumes, volume, volumeContainerPath, origins, "volumes", path)
+	}
+
+	for _, env := range source.Envs {
+		config.Envs = mergeListItem(config.Envs, env, EnvName, origins, "envs", path)
+	}
+	for _, envFile := range source.EnvFiles {
+		config.EnvFiles = append(config.EnvFiles, envFile)
+		origins[originKey("env_files", envFile)] = path
+	}
+	for _, port := range source.Ports {
+		config.Ports = mergeListItem(config.Ports, port, nil, origins, "ports", path)
+	}
+	for _, name := range source.SecretEnvs {
+		config.SecretEnvs = mergeListItem(config.SecretEnvs, name, nil, origins, "secret_envs", path)
+	}
+	if source.Loop != nil {
+		if config.Loop == nil {
+			config.Loop = &LoopConfig{}
+		}
+		for _, check := range source.Loop.Verify {
+			config.Loop.Verify = mergeListItem(config.Loop.Verify, check, nil, origins, "loop.verify", path)
+		}
+	}
+
+	mergeScalar(&config.DockerSocket, source.DockerSocket, origins, "docker_socket", path)
+	mergeScalar(&config.Backend, source.Backend, origins, "backend", path)
+	mergeScalar(&config.Agent, source.Agent, origins, "agent", path)
+	mergeScalar(&config.SSH, source.SSH, origins, "ssh", path)
+	mergeScalar(&config.Credentials, source.Credentials, origins, "credentials", path)
+
+	if source.ProfilesDir != "" {
+		config.ProfilesDir = source.ProfilesDir
+		if !nearest {
+			if dir, err := resolveSboxFilePath(source.ProfilesDir, layer.Dir); err == nil {
+				config.ProfilesDir = dir
+			}
+		}
+		origins["profiles_dir"] = path
+	}
+	if source.LoopConfirmations != 0 {
+		config.LoopConfirmations = source.LoopConfirmations
+		origins["loop_confirmations"] = path
+	}
+	if len(source.SSHAllowedKeys) > 0 {
+		config.SSHAllowedKeys = source.SSHAllowedKeys
+		origins["ssh_allowed_keys"] = path
+	}
+	if source.Network != nil {
+		config.Network = source.Network
+		origins["network"] = path
+	}
+
+	if r := source.Resources; r != nil {
+		config.Resources = MergeResourceLimits(config.Resources, r)
+		for field, set := range map[string]bool{
+			"cpus":        r.CPUs != 0,
+			"memory":      r.Memory != "",
+			"memory_swap": r.MemorySwap != "",
+			"pids_limit":  r.PidsLimit != 0,
+			"shm_size":    r.ShmSize != "",
+		} {
+			if set {
+				origins["resources."+field] = path
+			}
+		}
+		for name := range r.Ulimits {
+			origins["resources.ulimits."+name] = path
+		}
+	}
+}
+
+// mergeListItem appends item to list, replacing the item with the same key
+// in place. A nil key function compares whole items.
+func mergeListItem(list []string, item string, key func(string) string, origins map[string]string, field, source string) []string {
+	if key == nil {
+		key = func(s string) string { return s }
+	}
+	itemKey := key(item)
+	origins[originKey(field, itemKey)] = source
+
+	for i, existing := range list {
+		if key(existing) == itemKey {
+			list[i] = item
+			return list
+		}
+	}
+	return append(list, item)
+}
+
+// mergeScalar overrides a scalar value when set
+func mergeScalar(target *string, value string, origins map[string]string, field, source string) {
+	if value != "" {
+		*target = value
+		origins[field] = source
+	}
+}
+
+// profileRefName is the name of a profile reference, merging key of profiles
+func profileRefName(ref string) string {
+	if parsed, err := ParseProfileRef(ref); err == nil {
+		return parsed.Name
+	}
+	return ref
+}
+
+// volumeContainerPath is the container path of a volume, merging key of volumes
+func volumeContainerPath(spec string) string {
+	if _, containerPath, _, err := ParseVolumeSpec(spec); err == nil {
+		return containerPath
+	}
+	return spec
+}
+
+// Explain returns every effective value of the sbox.yaml file with the file
+// it came from, in sbox.yaml field order
+func (l *SboxFileLocation) Explain() []ConfigOrigin {
+	if l == nil || l.Config == nil {
+		return nil
+	}
+	config := l.Config
+
+	var values []ConfigOrigin
+	add := func(field, item, value string) {
+		source, ok := l.origins[originKey(field, item)]
+		if !ok {
+			source = l.Path
+		}
+		values = append(values, ConfigOrigin{Field: field, Value: value, Source: source, List: item != ""})
+	}
+	addScalar := func(field, value string) {
+		if value != "" {
+			add(field, "", value)
+		}
+	}
+
+	for _, ref := range config.Profiles {
+		add("profiles", profileRefName(ref), ref)
+	}
+	for _, volume := range config.Volumes {
+		add("volumes", volumeContainerPath(volume), volume)
+	}
+	addScalar("docker_socket", config.DockerSocket)
+	for _, env := range MaskSensitiveEnvs(config.Envs) {
+		add("envs", EnvName(env), env)
+	}
+	for _, envFile := range config.EnvFiles {
+		add("env_files", envFile, envFile)
+	}
+	addScalar("backend", config.Backend)
+	addScalar("agent", config.Agent)
+	if config.LoopConfirmations != 0 {
+		add("loop_confirmations", "", strconv.Itoa(config.LoopConfirmations))
+	}
+	if config.Loop != nil {
+		for _, check := range config.Loop.Verify {
+			add("loop.verify", check, check)
+		}
+	}
+	addScalar("profiles_dir", config.ProfilesDir)
+	if r := config.Resources; r != nil {
+		if r.CPUs != 0 {
+			add("resources.cpus", "", strconv.FormatFloat(r.CPUs, 'f', -1, 64))
+		}
+		addScalar("resources.memory", r.Memory)
+		addScalar("resources.memory_swap", r.MemorySwap)
+		if r.PidsLimit != 0 {
+			add("resources.pids_limit", "", strconv.FormatInt(r.PidsLimit, 10))
+		}
+		addScalar("resources.shm_size", r.ShmSize)
+		for _, name := range sortedKeys(r.Ulimits) {
+			add("resources.ulimits."+name, "", r.Ulimits[name])
+		}
+	}
+	if config.Network != nil {
+		add("network", "", config.Network.Describe())
+	}
+	for _, port := range config.Ports {
+		add("ports", port, port)
+	}
+	addScalar("ssh", config.SSH)
+	if len(config.SSHAllowedKeys) > 0 {
+		add("ssh_allowed_keys", "", strings.Join(config.SSHAllowedKeys, ", "))
+	}
+	for _, name := range config.SecretEnvs {
+		add("secret_envs", name, name)
+	}
+	addScalar("credentials", config.Credentials)
+
+	return values
+}
@@ -0,0 +1,152 @@
+package sbox
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// SecretEnvFile is where sbox delivers the secret environment variables inside
+// the sandbox. It lives on a tmpfs, is only readable by the agent user and is
+// removed by the entrypoint once loaded, so secrets never touch the workspace.
+const SecretEnvFile = "/dev/shm/sbox-secrets.env"
+
+// secretEnvWaitTimeout is how long the entrypoint waits for the secret
+// environment variables delivered by sbox after the sandbox start, and how
+// long sbox waits for the sandbox to run to deliver them
+var secretEnvWaitTimeout = 30 * time.Second
+
+// IsSecretEnv reports whether an environment variable holds a secret: its name
+// looks sensitive (see IsSensitiveEnvName) or it is listed in secretEnvs
+func IsSecretEnv(name string, secretEnvs []string) bool {
+	return IsSensitiveEnvName(name) || slices.Contains(secretEnvs, name)
+}
+
+// ResolveSecretEnvs returns the names of the environment variables explicitly
+// marked as secrets in the global and project configs (the project config
+// includes sbox.yaml once merged with MergeProjectConfig)
+func ResolveSecretEnvs(config *Config, projectConfig *ProjectConfig) []string {
+	var names []string
+	if config != nil {
+		names = append(names, config.SecretEnvs...)
+	}
+	if projectConfig != nil {
+		for _, name := range projectConfig.SecretEnvs {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// SplitSecretEnvs splits resolved KEY=value environment variables into the
+// plain ones and the secret ones
+func SplitSecretEnvs(envs []string, secretEnvs []string) (plain, secrets []string) {
+	for _, env := range envs {
+		if IsSecretEnv(EnvName(env), secretEnvs) {
+			secrets = append(secrets, env)
+		} else {
+			plain = append(plain, env)
+		}
+	}
+	return plain, secrets
+}
+
+// receiveSecretEnvsCommand is the command storing the secrets sent on its
+// stdin inside the sandbox
+func receiveSecretEnvsCommand() []string {
+	return []string{"sbox", "receive-secrets", "--file", SecretEnvFile}
+}
+
+// secretEnvsPayload is the content sent to `sbox receive-secrets`: a
+// NAME=<base64 value> line per KEY=value secret, so values can hold newlines
+// (e.g. a PEM key)
+func secretEnvsPayload(secrets []string) string {
+	var builder strings.Builder
+	for _, secret := range secrets {
+		name := EnvName(secret)
+		value := strings.TrimPrefix(secret, name+"=")
+		builder.WriteString(name + "=" + base64.StdEncoding.EncodeToString([]byte(value)) + "\n")
+	}
+	return builder.String()
+}
+
+// parseSecretEnvsPayload decodes a secretEnvsPayload into KEY=value entries
+func parseSecretEnvsPayload(data string) ([]string, error) {
+	var envs []string
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		name, encoded, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid secret entry for %s", name)
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret value for %s: %w", name, err)
+		}
+		envs = append(envs, name+"="+string(value))
+	}
+	return envs, nil
+}
+
+// RunReceiveSecrets writes the secret environment variables read from in to
+// path, readable only by the current user. It runs inside the sandbox
+// (`sbox receive-secrets`), started through docker exec when the sandbox starts.
+func RunReceiveSecrets(path string, in io.Reader) error {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return fmt.Errorf("failed to read secrets: %w", err)
+	}
+
+	// Write then rename, so the entrypoint never reads a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".sbox-secrets-*")
+	if err != nil {
+		return fmt.Errorf("failed to create secrets file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write secrets file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write secrets file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write secrets file: %w", err)
+	}
+	return nil
+}
+
+// readSecretEnvFile waits up to timeout for the secrets file delivered by
+// sbox, reads it and removes it. Returns the decoded KEY=value entries.
+func readSecretEnvFile(path string, timeout time.Duration) ([]string, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		data, err := os.ReadFile(path)
+		if err == nil {
+			if err := os.Remove(path); err != nil {
+				zlog.Warn("failed to remove secrets file")
+			}
+
+			return parseSecretEnvsPayload(string(data))
+		}
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read secrets file: %w", err)
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("secrets were not delivered within %s", timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
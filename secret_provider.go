@@ -0,0 +1,216 @@
+package sbox
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// SecretRefPrefix starts env values resolved by a secret provider at launch:
+// secret://<provider>/<reference>
+const SecretRefPrefix = "secret://"
+
+// SecretProvider resolves secret references of env values, so secrets are
+// fetched when a sandbox starts instead of being stored in the sbox config
+type SecretProvider interface {
+	// Name is the provider name used in secret://<name>/<reference>
+	Name() string
+
+	// Resolve returns the secret value of a reference
+	Resolve(ref string) (string, error)
+}
+
+// HostAccessSecretProvider is implemented by providers with arbitrary access
+// to the host, like running commands or reading any file. Their references
+// are only accepted from the global and project configs, not from sbox.yaml:
+// the workspace is writable by the agent, which could otherwise get commands
+// run on the host, or host files read into the sandbox, at the next launch.
+type HostAccessSecretProvider interface {
+	SecretProvider
+
+	// HostAccess reports whether the provider has arbitrary host access
+	HostAccess() bool
+}
+
+// secretProviders are the registered providers by name
+var secretProviders = map[string]SecretProvider{}
+
+// RegisterSecretProvider makes a provider available to secret:// env values,
+// replacing any provider registered with the same name
+func RegisterSecretProvider(provider SecretProvider) {
+	secretProviders[provider.Name()] = provider
+}
+
+// SecretProviderNames returns the names of the registered providers, sorted
+func SecretProviderNames() []string {
+	names := make([]string, 0, len(secretProviders))
+	for name := range secretProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterSecretProvider(passSecretProvider{})
+	RegisterSecretProvider(fileSecretProvider{})
+	RegisterSecretProvider(cmdSecretProvider{})
+}
+
+// IsSecretRef reports whether an env value is a secret:// reference
+func IsSecretRef(value string) bool {
+	return strings.HasPrefix(value, SecretRefPrefix)
+}
+
+// ParseSecretRef splits a secret://<provider>/<reference> value, checking
+// that the provider is registered
+func ParseSecretRef(value string) (SecretProvider, string, error) {
+	rest, ok := strings.CutPrefix(value, SecretRefPrefix)
+	if !ok {
+		return nil, "", fmt.Errorf("invalid secret reference %q: expected %s<provider>/<reference>", value, SecretRefPrefix)
+	}
+
+	name, ref, _ := strings.Cut(rest, "/")
+	provider, ok := secretProviders[name]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown secret provider %q in %q (available: %s)", name, value, strings.Join(SecretProviderNames(), ", "))
+	}
+	if ref == "" {
+		return nil, "", fmt.Errorf("invalid secret reference %q: missing reference after the provider", value)
+	}
+	return provider, ref, nil
+}
+
+// ResolveSecretRef returns the secret value of a secret:// reference
+func ResolveSecretRef(value string) (string, error) {
+	provider, ref, err := ParseSecretRef(value)
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := provider.Resolve(ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", value, err)
+	}
+	return secret, nil
+}
+
+// ValidateEnvSpec checks an env spec ("NAME" or "NAME=VALUE"), including the
+// secret reference of its value if any
+func ValidateEnvSpec(spec string) error {
+	name := EnvName(spec)
+	if name == "" {
+		return fmt.Errorf("invalid environment variable: %q", spec)
+	}
+	if value, ok := strings.CutPrefix(spec, name+"="); ok && IsSecretRef(value) {
+		if _, _, err := ParseSecretRef(value); err != nil {
+			return fmt.Errorf("invalid environment variable %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ValidateSboxFileEnvSpec checks an env spec of a sbox.yaml file like
+// ValidateEnvSpec, also rejecting the references of host access providers
+// (see HostAccessSecretProvider)
+func ValidateSboxFileEnvSpec(spec string) error {
+	if err := ValidateEnvSpec(spec); err != nil {
+		return err
+	}
+
+	name := EnvName(spec)
+	value, ok := strings.CutPrefix(spec, name+"=")
+	if !ok || !IsSecretRef(value) {
+		return nil
+	}
+	provider, _, err := ParseSecretRef(value)
+	if err != nil {
+		return err
+	}
+	if hostAccess, ok := provider.(HostAccessSecretProvider); ok && hostAccess.HostAccess() {
+		return fmt.Errorf("environment variable %s: %s%s/ references are not allowed in sbox.yaml, which the agent can edit, set it with 'sbox env add %s=...' instead", name, SecretRefPrefix, provider.Name(), name)
+	}
+	return nil
+}
+
+// SecretRefEnvNames returns the names of the env specs whose value is a
+// secret:// reference. Their resolved values are secrets whatever their name.
+func SecretRefEnvNames(specs ...[]string) []string {
+	var names []string
+	for _, list := range specs {
+		for _, spec := range list {
+			name := EnvName(spec)
+			if value, ok := strings.CutPrefix(spec, name+"="); ok && IsSecretRef(value) {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// passSecretProvider reads secrets from the pass password store
+// (secret://pass/github/token runs `pass show github/token`). Only the first
+// line is used, like `pass -c`.
+type passSecretProvider struct{}
+
+func (passSecretProvider) Name() string { return "pass" }
+
+func (passSecretProvider) Resolve(ref string) (string, error) {
+	output, err := runSecretCommand(exec.Command("pass", "show", ref))
+	if err != nil {
+		return "", err
+	}
+	line, _, _ := strings.Cut(output, "\n")
+	return line, nil
+}
+
+// fileSecretProvider reads secrets from files
+// (secret://file/~/.tokens/github), without the trailing newline
+type fileSecretProvider struct{}
+
+func (fileSecretProvider) Name() string { return "file" }
+
+func (fileSecretProvider) HostAccess() bool { return true }
+
+func (fileSecretProvider) Resolve(ref string) (string, error) {
+	data, err := os.ReadFile(expandPath(ref))
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// cmdSecretProvider runs a shell command and uses its output, without the
+// trailing newline (secret://cmd/op read op://vault/github/token)
+type cmdSecretProvider struct{}
+
+func (cmdSecretProvider) Name() string { return "cmd" }
+
+func (cmdSecretProvider) HostAccess() bool { return true }
+
+func (cmdSecretProvider) Resolve(ref string) (string, error) {
+	output, err := runSecretCommand(exec.Command("sh", "-c", ref))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(output, "\r\n"), nil
+}
+
+// runSecretCommand runs a command fetching a secret and returns its output.
+// Stdin is the terminal, so the command can prompt for a passphrase.
+func runSecretCommand(cmd *exec.Cmd) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("%s failed: %w: %s", cmd.Args[0], err, message)
+		}
+		return "", fmt.Errorf("%s failed: %w", cmd.Args[0], err)
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,317 @@
+package sbox
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// SSH access modes
+const (
+	// SSHModeAgent forwards the host SSH agent (SSH_AUTH_SOCK), private keys
+	// stay on the host (default)
+	SSHModeAgent = "agent"
+
+	// SSHModeKeys mounts the host ~/.ssh directory read-only
+	SSHModeKeys = "keys"
+
+	// SSHModeNone gives no SSH access
+	SSHModeNone = "none"
+)
+
+// DefaultSSHMode is the SSH mode when none is configured
+const DefaultSSHMode = SSHModeAgent
+
+// ValidSSHModes lists the accepted SSH modes
+var ValidSSHModes = []string{SSHModeAgent, SSHModeKeys, SSHModeNone}
+
+// SSHAgentSocketPath is where the forwarded SSH agent socket is available
+// inside the sandbox (SSH_AUTH_SOCK)
+const SSHAgentSocketPath = "/tmp/sbox-ssh/agent.sock"
+
+// maxAgentMessageSize bounds the size of an SSH agent protocol message
+const maxAgentMessageSize = 256 * 1024
+
+// SSHSettings is the resolved SSH configuration of a project
+type SSHSettings struct {
+	// Mode is "agent", "keys" or "none"
+	Mode string
+
+	// AllowedKeys restricts the keys exposed by the forwarded agent to these
+	// SHA256 fingerprints (as shown by `ssh-add -l`). Empty exposes all keys.
+	AllowedKeys []string
+}
+
+// ValidateSSHMode checks an SSH mode, empty meaning the default
+func ValidateSSHMode(mode string) error {
+	switch mode {
+	case "", SSHModeAgent, SSHModeKeys, SSHModeNone:
+		return nil
+	default:
+		return fmt.Errorf("invalid ssh mode %q, valid values: %v", mode, ValidSSHModes)
+	}
+}
+
+// ValidateSSHAllowedKeys checks that every entry is a SHA256 key fingerprint
+func ValidateSSHAllowedKeys(fingerprints []string) error {
+	for _, fingerprint := range fingerprints {
+		if !strings.HasPrefix(fingerprint, "SHA256:") || len(fingerprint) <= len("SHA256:") {
+			return fmt.Errorf("invalid ssh key fingerprint %q, expected SHA256:... as shown by 'ssh-add -l'", fingerprint)
+		}
+	}
+	return nil
+}
+
+// ResolveSSHSettings returns the SSH settings of a project: the project ones
+// (which include sbox.yaml when the project config went through
+// MergeProjectConfig), then the global ones, then the default agent mode.
+func ResolveSSHSettings(config *Config, projectConfig *ProjectConfig) SSHSettings {
+	settings := SSHSettings{Mode: DefaultSSHMode}
+	if config != nil {
+		if config.SSH != "" {
+			settings.Mode = config.SSH
+		}
+		if len(config.SSHAllowedKeys) > 0 {
+			settings.AllowedKeys = config.SSHAllowedKeys
+		}
+	}
+	if projectConfig != nil {
+		if projectConfig.SSH != "" {
+			settings.Mode = projectConfig.SSH
+		}
+		if len(projectConfig.SSHAllowedKeys) > 0 {
+			settings.AllowedKeys = projectConfig.SSHAllowedKeys
+		}
+	}
+	return settings
+}
+
+// Describe returns a one-line summary of the settings
+func (s SSHSettings) Describe() string {
+	if s.Mode == SSHModeAgent && len(s.AllowedKeys) > 0 {
+		return fmt.Sprintf("%s (keys: %s)", s.Mode, strings.Join(s.AllowedKeys, ", "))
+	}
+	return s.Mode
+}
+
+// HostSSHAgentSocket returns the host SSH agent socket (SSH_AUTH_SOCK), or ""
+// when there is no usable agent
+func HostSSHAgentSocket() string {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return ""
+	}
+	if info, err := os.Stat(socket); err != nil || info.Mode()&os.ModeSocket == 0 {
+		zlog.Debug("SSH_AUTH_SOCK is not a socket", zap.String("path", socket))
+		return ""
+	}
+	return socket
+}
+
+// newHostSSHAgent connects to the host SSH agent, filtered by the allowed keys
+func newHostSSHAgent(allowedKeys []string) (agent.Agent, io.Closer, error) {
+	socket := HostSSHAgentSocket()
+	if socket == "" {
+		return nil, nil, fmt.Errorf("no SSH agent is running on the host (SSH_AUTH_SOCK is not set)")
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to the SSH agent: %w", err)
+	}
+
+	upstream := agent.NewClient(conn)
+	if len(allowedKeys) == 0 {
+		return upstream, conn, nil
+	}
+	return newFilteringSSHAgent(upstream, allowedKeys), conn, nil
+}
+
+var errSSHKeyNotAllowed = errors.New("key not allowed by the sbox ssh_allowed_keys setting")
+
+// filteringSSHAgent exposes only the allowed keys of an agent, and refuses
+// any change to the agent (adding or removing keys, locking)
+type filteringSSHAgent struct {
+	upstream agent.ExtendedAgent
+	allowed  map[string]bool
+}
+
+func newFilteringSSHAgent(upstream agent.ExtendedAgent, fingerprints []string) *filteringSSHAgent {
+	allowed := make(map[string]bool, len(fingerprints))
+	for _, fingerprint := range fingerprints {
+		allowed[fingerprint] = true
+	}
+	return &filteringSSHAgent{upstream: upstream, allowed: allowed}
+}
+
+func (a *filteringSSHAgent) isAllowed(key ssh.PublicKey) bool {
+	return a.allowed[ssh.FingerprintSHA256(key)]
+}
+
+// List returns the allowed keys of the upstream agent
+func (a *filteringSSHAgent) List() ([]*agent.Key, error) {
+	keys, err := a.upstream.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var allowed []*agent.Key
+	for _, key := range keys {
+		if a.isAllowed(key) {
+			allowed = append(allowed, key)
+		}
+	}
+	return allowed, nil
+}
+
+// Sign signs with an allowed key
+func (a *filteringSSHAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+// SignWithFlags signs with an allowed key
+func (a *filteringSSHAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	if !a.isAllowed(key) {
+		zlog.Info("refused SSH agent signature", zap.String("fingerprint", ssh.FingerprintSHA256(key)))
+		return nil, errSSHKeyNotAllowed
+	}
+	return a.upstream.SignWithFlags(key, data, flags)
+}
+
+func (a *filteringSSHAgent) Add(agent.AddedKey) error       { return errSSHKeyNotAllowed }
+func (a *filteringSSHAgent) Remove(ssh.PublicKey) error     { return errSSHKeyNotAllowed }
+func (a *filteringSSHAgent) RemoveAll() error               { return errSSHKeyNotAllowed }
+func (a *filteringSSHAgent) Lock([]byte) error              { return errSSHKeyNotAllowed }
+func (a *filteringSSHAgent) Unlock([]byte) error            { return errSSHKeyNotAllowed }
+func (a *filteringSSHAgent) Signers() ([]ssh.Signer, error) { return nil, errSSHKeyNotAllowed }
+func (a *filteringSSHAgent) Extension(string, []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
+
+// serveSSHAgentRelay answers the agent requests read from a relay stream
+// (the stdout of `sbox ssh-agent-relay`) with the host agent, writing the
+// responses to the relay stdin. Returns when the stream ends.
+func serveSSHAgentRelay(requests io.Reader, responses io.Writer, allowedKeys []string) error {
+	hostAgent, closer, err := newHostSSHAgent(allowedKeys)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	stream := struct {
+		io.Reader
+		io.Writer
+	}{requests, responses}
+	if err := agent.ServeAgent(hostAgent, stream); err != nil && !errors.Is(err, io.EOF) && !isClosedConnError(err) {
+		return fmt.Errorf("ssh agent relay failed: %w", err)
+	}
+	return nil
+}
+
+// sshAgentRelayCommand is the command serving the forwarded agent socket inside the sandbox
+func sshAgentRelayCommand() []string {
+	return []string{"sbox", "ssh-agent-relay", "--socket", SSHAgentSocketPath}
+}
+
+// RunSSHAgentRelay serves an SSH agent on socketPath inside the sandbox,
+// relaying each request to out and reading its response from in. sbox answers
+// the requests with the host agent, see serveSSHAgentRelay. The agent
+// protocol is request/response, so clients are served one request at a time.
+// Returns when in is closed.
+func RunSSHAgentRelay(socketPath string, in io.Reader, out io.Writer) error {
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0700); err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	// A previous relay may have left its socket behind
+	_ = os.Remove(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", socketPath, err)
+	}
+	defer os.Remove(socketPath)
+
+	responses := make(chan []byte)
+	done := make(chan error, 1)
+	go func() {
+		for {
+			response, err := readAgentMessage(in)
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					err = nil
+				}
+				done <- err
+				listener.Close()
+				return
+			}
+			responses <- response
+		}
+	}()
+
+	var mu sync.Mutex
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return <-done
+			}
+			return fmt.Errorf("failed to accept agent connection: %w", err)
+		}
+
+		go func() {
+			defer conn.Close()
+			for {
+				request, err := readAgentMessage(conn)
+				if err != nil {
+					return
+				}
+
+				mu.Lock()
+				_, err = out.Write(request)
+				var response []byte
+				if err == nil {
+					response = <-responses
+				}
+				mu.Unlock()
+
+				if err != nil {
+					return
+				}
+				if _, err := conn.Write(response); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// readAgentMessage reads a length-prefixed SSH agent message, returning it
+// with its length prefix
+func readAgentMessage(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header)
+	if size > maxAgentMessageSize {
+		return nil, fmt.Errorf("ssh agent message too large (%d bytes)", size)
+	}
+
+	message := make([]byte, 4+size)
+	copy(message, header)
+	if _, err := io.ReadFull(r, message[4:]); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
@@ -26,6 +26,17 @@ type TemplateBuilder struct {
 	Config   *Config
 	Profiles []string
 	Agent    AgentType
+
+	// ProfileRegistry resolves profile names to their definitions.
+	// When nil, only built-in profiles are available.
+	ProfileRegistry *ProfileRegistry
+
+	// Lock pins the images referenced by the Dockerfile to digests (optional)
+	Lock *LockFile
+
+	// Backend selects the engine the image is built for. BackendPodman builds
+	// with `podman build` into Podman's image store; any other value uses Docker.
+	Backend BackendType
 }
 
 // TargetArch represents the target architecture for cross-compilation
@@ -47,9 +58,17 @@ type TargetArch struct {
 // GetTargetArch detects the target architecture from Docker's default platform.
 // This ensures we build for the same architecture that Docker will run containers on.
 func GetTargetArch() (*TargetArch, error) {
-	// Query Docker for its default architecture
-	cmd := exec.Command("docker", "info", "--format", "{{.Architecture}}")
-	output, err := cmd.Output()
+	return getTargetArch(NewDockerClient)
+}
+
+// getTargetArch detects the target architecture of the engine returned by newClient
+func getTargetArch(newClient func() (*DockerClient, error)) (*TargetArch, error) {
+	// Query the engine for its default architecture
+	var info *DockerInfo
+	client, err := newClient()
+	if err == nil {
+		info, err = client.Info()
+	}
 	if err != nil {
 		// Fallback to amd64 if Docker query fails
 		zlog.Warn("failed to detect Docker architecture, defaulting to amd64", zap.Error(err))
@@ -63,7 +82,7 @@ func GetTargetArch() (*TargetArch, error) {
 		}, nil
 	}
 
-	arch := strings.TrimSpace(string(output))
+	arch := strings.TrimSpace(info.Architecture)
 	zlog.Debug("detected Docker architecture", zap.String("arch", arch))
 
 	switch arch {
@@ -102,11 +121,61 @@ func NewTemplateBuilder(config *Config, profiles []string, agent AgentType) *Tem
 	}
 }
 
+// NewWorkspaceTemplateBuilder creates a template builder for a workspace, with
+// the workspace's user-defined profiles and sbox.lock pins (if any) loaded.
+func NewWorkspaceTemplateBuilder(config *Config, workspaceDir string, sboxFile *SboxFileLocation, profiles []string, agent AgentType) (*TemplateBuilder, error) {
+	registry, err := LoadProfileRegistry(config, workspaceDir, sboxFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load profiles: %w", err)
+	}
+
+	lockPath, err := LockFilePath(workspaceDir, sboxFile)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := LoadLockFile(lockPath)
+	if err != nil {
+		return nil, err
+	}
+
+	builder := NewTemplateBuilder(config, profiles, agent)
+	builder.ProfileRegistry = registry
+	builder.Lock = lock
+	return builder, nil
+}
+
+// registry returns the profile registry used by this builder
+func (tb *TemplateBuilder) registry() *ProfileRegistry {
+	if tb.ProfileRegistry == nil {
+		tb.ProfileRegistry = NewProfileRegistry()
+	}
+	return tb.ProfileRegistry
+}
+
 // TemplateHash computes a deterministic hash of the template configuration.
 // This includes profiles and sbox entrypoint image to ensure rebuilds when either changes.
+// User-defined profiles contribute a digest of their content so edits trigger a rebuild,
+// and resolved profile parameters are included so each version gets its own image.
+// Images pinned by the lock file, if any, are part of the hash as well.
 func (tb *TemplateBuilder) TemplateHash() string {
-	// Resolve all profiles including dependencies
-	resolved := tb.ResolveProfiles()
+	// Resolve all profiles including dependencies. Invalid parameters are
+	// reported by GenerateDockerfile, the hash then only reflects the references.
+	params, _ := tb.ResolveProfileParams()
+
+	var resolved []string
+	for _, name := range tb.ResolveProfiles() {
+		key := name
+		if profile, ok := tb.registry().Get(name); ok {
+			key = profile.hashKey()
+		}
+		if len(params[name]) > 0 {
+			key += "[" + formatProfileParams(params[name]) + "]"
+		}
+		resolved = append(resolved, key)
+	}
+	if params == nil {
+		resolved = append(resolved, tb.Profiles...)
+	}
 
 	// Sort profiles for deterministic hash
 	sort.Strings(resolved)
@@ -122,6 +191,9 @@ func (tb *TemplateBuilder) TemplateHash() string {
 		agentStr = string(DefaultAgent)
 	}
 	combined := strings.Join(resolved, ",") + ";" + entrypointImageStr + ";" + agentStr
+	if tb.Lock != nil {
+		combined += ";lock=" + tb.Lock.digest()
+	}
 	hash := sha256.Sum256([]byte(combined))
 	return hex.EncodeToString(hash[:])[:12]
 }
@@ -172,19 +244,21 @@ func (tb *TemplateBuilder) sboxVersion() string {
 	return version
 }
 
-// ResolveProfiles returns the full list of profiles including all dependencies.
-// Dependencies are listed before the profiles that depend on them.
+// ResolveProfiles returns the full list of profile names including all dependencies.
+// Dependencies are listed before the profiles that depend on them. Parameters in
+// profile references (e.g. "go@1.23.2") are stripped, see ResolveProfileParams.
 func (tb *TemplateBuilder) ResolveProfiles() []string {
 	seen := make(map[string]bool)
 	var result []string
 
-	var resolve func(name string)
-	resolve = func(name string) {
+	var resolve func(ref string)
+	resolve = func(ref string) {
+		name := ProfileRefName(ref)
 		if seen[name] {
 			return
 		}
 
-		profile, ok := GetProfile(name)
+		profile, ok := tb.registry().Get(name)
 		if !ok {
 			// Unknown profile, include it anyway (will error later)
 			seen[name] = true
@@ -209,19 +283,90 @@ func (tb *TemplateBuilder) ResolveProfiles() []string {
 	return result
 }
 
+// ResolveProfileParams returns the parameter values of every resolved profile,
+// keyed by profile name. Defaults are applied first, then parameters from
+// dependency references, then parameters from the builder's profile references
+// in order, so a later reference to the same profile wins.
+func (tb *TemplateBuilder) ResolveProfileParams() (map[string]map[string]string, error) {
+	explicit := make(map[string]map[string]string)
+	apply := func(refStr string) error {
+		ref, err := ParseProfileRef(refStr)
+		if err != nil {
+			return err
+		}
+		if explicit[ref.Name] == nil {
+			explicit[ref.Name] = make(map[string]string)
+		}
+		for key, value := range ref.Params {
+			explicit[ref.Name][key] = value
+		}
+		return nil
+	}
+
+	names := tb.ResolveProfiles()
+	for _, name := range names {
+		profile, ok := tb.registry().Get(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown profile: %s", name)
+		}
+		for _, dep := range profile.Dependencies {
+			if err := apply(dep); err != nil {
+				return nil, fmt.Errorf("profile %q dependency: %w", name, err)
+			}
+		}
+	}
+	for _, ref := range tb.Profiles {
+		if err := apply(ref); err != nil {
+			return nil, err
+		}
+	}
+
+	result := make(map[string]map[string]string, len(names))
+	for _, name := range names {
+		profile, _ := tb.registry().Get(name)
+		params, err := profile.ResolveParams(explicit[name])
+		if err != nil {
+			return nil, err
+		}
+		result[name] = params
+	}
+
+	return result, nil
+}
+
 // ImageName returns the Docker image name for this template configuration.
 // Always returns a custom sbox-template image name since we need the sbox entrypoint.
 func (tb *TemplateBuilder) ImageName() string {
 	return fmt.Sprintf("sbox-template:%s", tb.TemplateHash())
 }
 
+// engineClient returns the API client of the engine the image is built for
+func (tb *TemplateBuilder) engineClient() (*DockerClient, error) {
+	if tb.Backend == BackendPodman {
+		return NewPodmanClient()
+	}
+	return NewDockerClient()
+}
+
+// buildCommand returns the CLI used to build the image
+func (tb *TemplateBuilder) buildCommand() string {
+	if tb.Backend == BackendPodman {
+		return "podman"
+	}
+	return "docker"
+}
+
 // ImageExists checks if the custom image already exists
 func (tb *TemplateBuilder) ImageExists() bool {
 	imageName := tb.ImageName()
 
-	cmd := exec.Command("docker", "image", "inspect", imageName)
-	err := cmd.Run()
-	return err == nil
+	client, err := tb.engineClient()
+	if err != nil {
+		return false
+	}
+
+	image, err := client.InspectImage(imageName)
+	return err == nil && image != nil
 }
 
 // GenerateDockerfile creates a Dockerfile with sbox entrypoint and all selected profiles.
@@ -277,15 +422,25 @@ func (tb *TemplateBuilder) GenerateDockerfile(targetArch *TargetArch) (string, e
 	// Add profiles if any
 	resolvedProfiles := tb.ResolveProfiles()
 	if len(resolvedProfiles) > 0 {
+		params, err := tb.ResolveProfileParams()
+		if err != nil {
+			return "", err
+		}
+
 		for _, profileName := range resolvedProfiles {
-			profile, ok := GetProfile(profileName)
+			profile, ok := tb.registry().Get(profileName)
 			if !ok {
 				return "", fmt.Errorf("unknown profile: %s", profileName)
 			}
 
-			sb.WriteString(fmt.Sprintf("# Profile: %s\n", profileName))
+			if len(params[profileName]) > 0 {
+				sb.WriteString(fmt.Sprintf("# Profile: %s (%s)\n", profileName, formatProfileParams(params[profileName])))
+			} else {
+				sb.WriteString(fmt.Sprintf("# Profile: %s\n", profileName))
+			}
 			sb.WriteString(fmt.Sprintf("# %s\n", profile.Description))
-			sb.WriteString(profile.DockerfileSnippet)
+			sb.WriteString(profile.DockerfileArgs(params[profileName]))
+			sb.WriteString(profile.RenderDockerfileSnippet(params[profileName]))
 			sb.WriteString("\n")
 		}
 	}
@@ -335,7 +490,7 @@ RUN chmod +x /usr/local/bin/%s
 	sb.WriteString("# CMD for sbox entrypoint - docker sandbox may override this\n")
 	sb.WriteString("CMD [\"sbox\", \"entrypoint\"]\n")
 
-	return sb.String(), nil
+	return PinDockerfileImages(sb.String(), tb.Lock), nil
 }
 
 // Build builds the custom Docker image with sbox entrypoint and selected profiles.
@@ -357,7 +512,7 @@ func (tb *TemplateBuilder) Build(forceRebuild bool) (string, error) {
 	}
 
 	// Detect target architecture from Docker
-	targetArch, err := GetTargetArch()
+	targetArch, err := getTargetArch(tb.engineClient)
 	if err != nil {
 		return "", fmt.Errorf("failed to detect target architecture: %w", err)
 	}
@@ -375,13 +530,17 @@ func (tb *TemplateBuilder) Build(forceRebuild bool) (string, error) {
 
 	// When force rebuilding, pull the latest base image to get newest agent version
 	if forceRebuild {
-		baseTemplate := GetBaseTemplateForAgent(tb.Agent)
+		baseTemplate := tb.Lock.Pin(GetBaseTemplateForAgent(tb.Agent))
+		if tb.Backend == BackendPodman {
+			baseTemplate = qualifyImageRef(baseTemplate)
+		}
 		agentName := tb.Agent.Capitalize()
 		DefaultUI.Status("Pulling latest base image to get newest %s version", agentName)
-		pullCmd := exec.Command("docker", "pull", baseTemplate)
-		pullCmd.Stdout = os.Stdout
-		pullCmd.Stderr = os.Stderr
-		if err := pullCmd.Run(); err != nil {
+		client, err := tb.engineClient()
+		if err == nil {
+			err = client.PullImage(baseTemplate, os.Stdout)
+		}
+		if err != nil {
 			zlog.Warn("failed to pull base image, continuing with cached version",
 				zap.String("image", baseTemplate),
 				zap.Error(err))
@@ -410,6 +569,9 @@ func (tb *TemplateBuilder) Build(forceRebuild bool) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("failed to generate Dockerfile: %w", err)
 	}
+	if tb.Backend == BackendPodman {
+		dockerfile = rewriteDockerfileImages(dockerfile, qualifyImageRef)
+	}
 
 	dockerfilePath := filepath.Join(tempDir, "Dockerfile")
 	if err := os.WriteFile(dockerfilePath, []byte(dockerfile), 0644); err != nil {
@@ -420,14 +582,16 @@ func (tb *TemplateBuilder) Build(forceRebuild bool) (string, error) {
 		zap.String("path", dockerfilePath),
 		zap.Int("size", len(dockerfile)))
 
-	// Build the image with explicit platform
+	// Build the image with explicit platform. This goes through the docker (or
+	// podman) CLI rather than the Engine API: the Dockerfile relies on BuildKit
+	// features (heredocs) that the classic /build endpoint doesn't support.
 	buildArgs := []string{"build", "--platform", targetArch.DockerPlatform, "-t", imageName, "-f", dockerfilePath, tempDir}
-	cmd := exec.Command("docker", buildArgs...)
+	cmd := execCommand(tb.buildCommand(), buildArgs...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
 	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("docker build failed: %w", err)
+		return "", fmt.Errorf("%s build failed: %w", tb.buildCommand(), err)
 	}
 
 	zlog.Info("custom template image built successfully",
@@ -548,29 +712,60 @@ func isSboxSourceDir(dir string) bool {
 	return true
 }
 
-// CleanTemplates removes all cached sbox template images
+// CleanTemplates removes all cached sbox template images, from Docker and, when
+// its socket is available, from Podman's separate image store. Only fails when
+// neither engine could be cleaned.
 func CleanTemplates() error {
 	zlog.Info("cleaning cached template images")
 
-	// List all sbox-template images
-	cmd := exec.Command("docker", "images", "--filter", "reference=sbox-template:*", "--format", "{{.Repository}}:{{.Tag}}")
-	output, err := cmd.Output()
+	var dockerErr error
+	if client, err := NewDockerClient(); err != nil {
+		dockerErr = fmt.Errorf("failed to create docker client: %w", err)
+	} else {
+		dockerErr = cleanEngineTemplates(client)
+	}
+
+	podmanClient, err := NewPodmanClient()
+	if err != nil {
+		zlog.Debug("no podman socket, skipping podman template cleanup", zap.Error(err))
+		return dockerErr
+	}
+	podmanErr := cleanEngineTemplates(podmanClient)
+
+	switch {
+	case dockerErr != nil && podmanErr != nil:
+		return fmt.Errorf("%w (podman: %w)", dockerErr, podmanErr)
+	case dockerErr != nil:
+		// Podman-only setup: Docker being unavailable is not an error
+		zlog.Debug("docker template cleanup failed", zap.Error(dockerErr))
+	case podmanErr != nil:
+		zlog.Warn("failed to clean podman template images", zap.Error(podmanErr))
+	}
+	return nil
+}
+
+// cleanEngineTemplates removes the sbox template images of one engine
+func cleanEngineTemplates(client *DockerClient) error {
+	// List all images and keep the sbox-template ones: Podman's reference
+	// filter doesn't match the localhost/ prefix of locally built images
+	images, err := client.ListImages("")
 	if err != nil {
 		return fmt.Errorf("failed to list template images: %w", err)
 	}
 
-	images := strings.Split(strings.TrimSpace(string(output)), "\n")
 	for _, image := range images {
-		if image == "" {
-			continue
-		}
+		for _, tag := range image.RepoTags {
+			// Podman stores locally built images under localhost/
+			if !strings.HasPrefix(strings.TrimPrefix(tag, "localhost/"), "sbox-template:") {
+				continue
+			}
 
-		zlog.Debug("removing template image", zap.String("image", image))
-		rmCmd := exec.Command("docker", "rmi", image)
-		if err := rmCmd.Run(); err != nil {
-			zlog.Warn("failed to remove image",
-				zap.String("image", image),
-				zap.Error(err))
+			zlog.Debug("removing template image", zap.String("image", tag))
+			if err := client.RemoveImage(tag); err != nil {
+				zlog.Warn("failed to remove image",
+					zap.String("image", tag),
+					zap.Error(err))
+			}
 		}
 	}
 
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	lipgloss "charm.land/lipgloss/v2"
 )
@@ -73,6 +74,11 @@ func (u *UI) Blank() {
 
 // Loop-specific helpers
 
+// Resumed prints the progress of a resumed loop run.
+func (u *UI) Resumed(run *LoopRun) {
+	fmt.Fprintln(u.w, StyleDim.Render(fmt.Sprintf("Resuming loop run %s after iteration %d (%d consecutive completions)", run.ID, run.Iteration, run.CompletionStreak)))
+}
+
 // Iteration prints the loop iteration header.
 func (u *UI) Iteration(n, completions int) {
 	fmt.Fprintln(u.w)
@@ -100,11 +106,42 @@ func (u *UI) Reconfirming() {
 	fmt.Fprintln(u.w, StyleDim.Render("Re-running to confirm..."))
 }
 
+// Check prints the outcome of a loop verification command.
+func (u *UI) Check(result LoopCheckResult) {
+	duration := result.Duration.Round(100 * time.Millisecond)
+	if result.Passed() {
+		fmt.Fprintln(u.w, StyleSuccess.Render("✓ Check passed: ")+StyleDim.Render(fmt.Sprintf("%s (%s)", result.Command, duration)))
+		return
+	}
+	fmt.Fprintln(u.w, StyleError.Render(fmt.Sprintf("✗ Check failed (exit %d): ", result.ExitCode))+StyleDim.Render(fmt.Sprintf("%s (%s)", result.Command, duration)))
+}
+
+// ChecksFailed prints the status of a goal reported complete by the agent
+// while verification commands fail.
+func (u *UI) ChecksFailed() {
+	fmt.Fprintln(u.w, StyleWarn.Render("⚠ Goal reported complete but checks failed, continuing..."))
+}
+
+// Checkpoint prints the checkpoint recorded after a loop iteration.
+func (u *UI) Checkpoint(checkpoint LoopCheckpoint) {
+	fmt.Fprintln(u.w, StyleDim.Render(fmt.Sprintf("Checkpoint %d: %s (%s)", checkpoint.Iteration, checkpoint.Ref, checkpoint.ShortCommit())))
+}
+
 // MaxReached prints the max iterations exceeded message.
 func (u *UI) MaxReached(max int) {
 	fmt.Fprintln(u.w, StyleWarn.Render(fmt.Sprintf("⚠ Reached maximum iterations (%d)", max)))
 }
 
+// BudgetReached prints the loop budget exceeded message.
+func (u *UI) BudgetReached(reason string) {
+	fmt.Fprintln(u.w, StyleWarn.Render(fmt.Sprintf("⚠ Stopping loop: %s", reason)))
+}
+
+// LoopSummary prints the iterations and the usage of a finished loop.
+func (u *UI) LoopSummary(iterations int, usage AgentUsage, elapsed time.Duration) {
+	fmt.Fprintln(u.w, StyleDim.Render(fmt.Sprintf("Loop: %d iterations, %d turns, %d tokens, $%.4f, %s", iterations, usage.Turns, usage.Tokens, usage.CostUSD, elapsed.Round(time.Second))))
+}
+
 // AgentError prints an agent error message.
 func (u *UI) AgentError(err error) {
 	fmt.Fprintln(u.w, StyleError.Render(fmt.Sprintf("✗ Agent error: %s", err)))
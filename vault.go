@@ -0,0 +1,320 @@
+package sbox
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/term"
+)
+
+const (
+	// VaultFileName is the encrypted secret vault in the sbox data directory
+	VaultFileName = "secrets.vault"
+
+	// VaultKeyFileName is the random key encrypting the vault when it is not
+	// protected by a passphrase
+	VaultKeyFileName = "vault.key"
+
+	// VaultPassphraseEnv holds the vault passphrase. Setting it when the vault
+	// is created protects the vault with it instead of a key file.
+	VaultPassphraseEnv = "SBOX_VAULT_PASSPHRASE"
+
+	// VaultGlobalScope is the scope of secrets shared by all projects
+	VaultGlobalScope = "global"
+)
+
+// Vault key derivations
+const (
+	vaultKDFKeyFile = "keyfile"
+	vaultKDFScrypt  = "scrypt"
+)
+
+// vaultVersion is the version of the vault file format
+const vaultVersion = 1
+
+// vaultFile is the on-disk format of the vault. Data is the secretbox
+// encrypted JSON of the secrets by scope, so secret names are encrypted too.
+type vaultFile struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`
+	Salt    []byte `json:"salt,omitempty"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// Vault is the encrypted local secret store. Secrets are grouped by scope:
+// VaultGlobalScope or a project scope (see VaultProjectScope).
+type Vault struct {
+	path    string
+	keyPath string
+	kdf     string
+	salt    []byte
+	key     *[32]byte
+
+	secrets map[string]map[string]string
+}
+
+// readVaultPassphrase asks for the vault passphrase when VaultPassphraseEnv
+// is not set, replaced in tests
+var readVaultPassphrase = func() (string, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("the secret vault is protected by a passphrase, set %s", VaultPassphraseEnv)
+	}
+
+	fmt.Fprint(os.Stderr, "sbox vault passphrase: ")
+	passphrase, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return string(passphrase), nil
+}
+
+// VaultProjectScope returns the vault scope of a project, keyed like the
+// project config by the project ID
+func VaultProjectScope(workspacePath string) (string, error) {
+	id, err := ProjectHash(workspacePath)
+	if err != nil {
+		return "", err
+	}
+	return "project:" + id, nil
+}
+
+// VaultExists reports whether a vault was created in the sbox data directory
+func VaultExists(config *Config) bool {
+	_, err := os.Stat(filepath.Join(config.SboxDataDir, VaultFileName))
+	return err == nil
+}
+
+// OpenVault opens the secret vault of the sbox data directory, decrypting it
+// with the key file or the passphrase. A missing vault opens empty and is
+// created by Save.
+func OpenVault(config *Config) (*Vault, error) {
+	v := &Vault{
+		path:    filepath.Join(config.SboxDataDir, VaultFileName),
+		keyPath: filepath.Join(config.SboxDataDir, VaultKeyFileName),
+		secrets: map[string]map[string]string{},
+	}
+
+	data, err := os.ReadFile(v.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read secret vault: %w", err)
+		}
+
+		v.kdf = vaultKDFKeyFile
+		if os.Getenv(VaultPassphraseEnv) != "" {
+			v.kdf = vaultKDFScrypt
+		}
+		return v, nil
+	}
+
+	var file vaultFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse secret vault %s: %w", v.path, err)
+	}
+	if file.Version != vaultVersion {
+		return nil, fmt.Errorf("unsupported secret vault version %d", file.Version)
+	}
+	if len(file.Nonce) != 24 {
+		return nil, fmt.Errorf("invalid secret vault %s: bad nonce", v.path)
+	}
+
+	v.kdf = file.KDF
+	v.salt = file.Salt
+	if err := v.loadKey(); err != nil {
+		return nil, err
+	}
+
+	var nonce [24]byte
+	copy(nonce[:], file.Nonce)
+	plaintext, ok := secretbox.Open(nil, file.Data, &nonce, v.key)
+	if !ok {
+		return nil, fmt.Errorf("failed to decrypt secret vault %s: wrong passphrase or key file", v.path)
+	}
+	if err := json.Unmarshal(plaintext, &v.secrets); err != nil {
+		return nil, fmt.Errorf("failed to parse secret vault content: %w", err)
+	}
+
+	zlog.Debug("opened secret vault", zap.String("path", v.path), zap.String("kdf", v.kdf))
+	return v, nil
+}
+
+// loadKey loads the key of an existing vault
+func (v *Vault) loadKey() error {
+	switch v.kdf {
+	case vaultKDFKeyFile:
+		data, err := os.ReadFile(v.keyPath)
+		if err != nil {
+			return fmt.Errorf("failed to read secret vault key: %w", err)
+		}
+		if len(data) != 32 {
+			return fmt.Errorf("invalid secret vault key %s", v.keyPath)
+		}
+		v.key = new([32]byte)
+		copy(v.key[:], data)
+		return nil
+
+	case vaultKDFScrypt:
+		passphrase := os.Getenv(VaultPassphraseEnv)
+		if passphrase == "" {
+			var err error
+			if passphrase, err = readVaultPassphrase(); err != nil {
+				return err
+			}
+		}
+		return v.deriveKey(passphrase)
+
+	default:
+		return fmt.Errorf("unsupported secret vault key derivation %q", v.kdf)
+	}
+}
+
+// deriveKey derives the vault key from a passphrase
+func (v *Vault) deriveKey(passphrase string) error {
+	key, err := scrypt.Key([]byte(passphrase), v.salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return fmt.Errorf("failed to derive secret vault key: %w", err)
+	}
+	v.key = new([32]byte)
+	copy(v.key[:], key)
+	return nil
+}
+
+// createKey creates the key of a new vault: a random key file, or a random
+// salt for the passphrase
+func (v *Vault) createKey() error {
+	switch v.kdf {
+	case vaultKDFScrypt:
+		v.salt = make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, v.salt); err != nil {
+			return fmt.Errorf("failed to generate salt: %w", err)
+		}
+		return v.deriveKey(os.Getenv(VaultPassphraseEnv))
+
+	default:
+		v.key = new([32]byte)
+		if _, err := io.ReadFull(rand.Reader, v.key[:]); err != nil {
+			return fmt.Errorf("failed to generate secret vault key: %w", err)
+		}
+		if err := writeFileAtomic(v.keyPath, v.key[:], 0600); err != nil {
+			return fmt.Errorf("failed to write secret vault key: %w", err)
+		}
+		return nil
+	}
+}
+
+// Get returns a secret of a scope
+func (v *Vault) Get(scope, name string) (string, bool) {
+	value, ok := v.secrets[scope][name]
+	return value, ok
+}
+
+// Set stores a secret in a scope
+func (v *Vault) Set(scope, name, value string) {
+	if v.secrets[scope] == nil {
+		v.secrets[scope] = map[string]string{}
+	}
+	v.secrets[scope][name] = value
+}
+
+// Remove deletes a secret from a scope, reporting whether it existed
+func (v *Vault) Remove(scope, name string) bool {
+	if _, ok := v.secrets[scope][name]; !ok {
+		return false
+	}
+	delete(v.secrets[scope], name)
+	if len(v.secrets[scope]) == 0 {
+		delete(v.secrets, scope)
+	}
+	return true
+}
+
+// Names returns the sorted secret names of a scope
+func (v *Vault) Names(scope string) []string {
+	names := make([]string, 0, len(v.secrets[scope]))
+	for name := range v.secrets[scope] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Envs returns the secrets available to a project as KEY=value entries: the
+// global ones, overridden by the project ones
+func (v *Vault) Envs(projectScope string) []string {
+	var envs []string
+	for _, name := range v.Names(VaultGlobalScope) {
+		if _, ok := v.Get(projectScope, name); !ok {
+			envs = append(envs, name+"="+v.secrets[VaultGlobalScope][name])
+		}
+	}
+	for _, name := range v.Names(projectScope) {
+		envs = append(envs, name+"="+v.secrets[projectScope][name])
+	}
+	return envs
+}
+
+// Save encrypts and writes the vault
+func (v *Vault) Save() error {
+	if v.key == nil {
+		if err := v.createKey(); err != nil {
+			return err
+		}
+	}
+
+	plaintext, err := json.Marshal(v.secrets)
+	if err != nil {
+		return fmt.Errorf("failed to serialize secret vault: %w", err)
+	}
+
+	var nonce [24]byte
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	data, err := json.MarshalIndent(vaultFile{
+		Version: vaultVersion,
+		KDF:     v.kdf,
+		Salt:    v.salt,
+		Nonce:   nonce[:],
+		Data:    secretbox.Seal(nil, plaintext, &nonce, v.key),
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize secret vault: %w", err)
+	}
+
+	if err := writeFileAtomic(v.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write secret vault: %w", err)
+	}
+
+	zlog.Debug("saved secret vault", zap.String("path", v.path))
+	return nil
+}
+
+// LoadVaultEnvs returns the vault secrets available to a project as KEY=value
+// entries. Returns nothing without opening anything when there is no vault.
+func LoadVaultEnvs(config *Config, workspacePath string) ([]string, error) {
+	if !VaultExists(config) {
+		return nil, nil
+	}
+
+	scope, err := VaultProjectScope(workspacePath)
+	if err != nil {
+		return nil, err
+	}
+
+	vault, err := OpenVault(config)
+	if err != nil {
+		return nil, err
+	}
+	return vault.Envs(scope), nil
+}